@@ -0,0 +1,139 @@
+package main
+
+// --concurrency-sweep answers "how does latency change with load",
+// but finding the most load a node can take while keeping p90 under
+// some target still means squinting at a table.  With --target-p90,
+// an AIMD controller does it instead: every --adaptive-window, if the
+// reads that finished in that window had a p90 under the target, it
+// adds a worker, and if not, it halves the number of workers.  At the
+// end we print the trajectory and the highest concurrency that held
+// the target through the second half of the run.
+//
+// Reads are handed out like --concurrency-sweep's, working through the
+// file and wrapping around if it runs out.
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// adaptiveStep is one control interval.
+type adaptiveStep struct {
+	At          time.Duration `json:"atNs"` // since the start of the run
+	Concurrency int           `json:"concurrency"`
+	Reads       int           `json:"reads"`
+	P90         time.Duration `json:"p90Ns"`
+	Met         bool          `json:"met"`
+}
+
+// Run the adaptive controller until --adaptive-duration has passed,
+// and print the results.
+func runAdaptive(b *benchmark, target time.Duration) error {
+	size := *readsize
+	slots := b.filesize / size
+	if slots == 0 {
+		return fmt.Errorf("--readsize %d is bigger than the %d byte file", size, b.filesize)
+	}
+
+	start := time.Now()
+	deadline := start.Add(*adaptiveDuration)
+	var limit atomic.Int64
+	limit.Store(1)
+	var done atomic.Bool
+
+	var cursor int64
+	var wrapped bool
+	reads := sweepSource(&cursor, slots, 0, deadline, &wrapped)
+	next := func(worker int) (readRange, bool) {
+		// Workers above the current limit wait for it to
+		// come up.
+		for int64(worker) >= limit.Load() {
+			if done.Load() || time.Now().After(deadline) {
+				return readRange{}, false
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return reads(worker)
+	}
+
+	var windows []adaptiveStep
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		seen := 0
+		t := time.NewTicker(*adaptiveWindow)
+		defer t.Stop()
+		for !done.Load() {
+			<-t.C
+			if time.Now().After(deadline) {
+				// Any reads still going are stragglers.
+				return
+			}
+			b.mu.Lock()
+			lat := b.latencies[seen:]
+			seen = len(b.latencies)
+			b.mu.Unlock()
+
+			w := adaptiveStep{At: time.Since(start), Concurrency: int(limit.Load()), Reads: len(lat)}
+			w.P90 = computeLatencyStats(lat).P90
+			// A window where nothing finished is a miss.
+			w.Met = len(lat) > 0 && w.P90 <= target
+			windows = append(windows, w)
+
+			c := w.Concurrency
+			if w.Met {
+				c = min(c+1, *adaptiveMax)
+			} else {
+				c = max(1, c/2)
+			}
+			limit.Store(int64(c))
+			fmt.Printf("Adaptive: at %.1fs, concurrency %d, %d reads, p90 %.3fs -> concurrency %d\n", w.At.Seconds(), w.Concurrency, w.Reads, w.P90.Seconds(), c)
+		}
+	}()
+
+	_, err := b.execute("adaptive", *adaptiveMax, next)
+	done.Store(true)
+	wg.Wait()
+	if err != nil {
+		return err
+	}
+
+	if wrapped {
+		fmt.Printf("WARNING: the file wasn't big enough for every read to get fresh data, so later reads may have hit the cache\n")
+	}
+	printAdaptive(windows, target)
+	return nil
+}
+
+// Print the trajectory, and the highest concurrency that met the
+// target in every window at that level in the second half of the run.
+func printAdaptive(windows []adaptiveStep, target time.Duration) {
+	fmt.Printf("%10s %11s %8s %10s %5s\n", "seconds", "concurrency", "reads", "p90", "met")
+	for _, w := range windows {
+		fmt.Printf("%10.1f %11d %8d %10.3f %5t\n", w.At.Seconds(), w.Concurrency, w.Reads, w.P90.Seconds(), w.Met)
+	}
+
+	met := map[int]bool{}
+	missed := map[int]bool{}
+	for _, w := range windows[len(windows)/2:] {
+		if w.Met {
+			met[w.Concurrency] = true
+		} else {
+			missed[w.Concurrency] = true
+		}
+	}
+	safe := 0
+	for c := range met {
+		if !missed[c] {
+			safe = max(safe, c)
+		}
+	}
+	if safe == 0 {
+		fmt.Printf("No concurrency level consistently kept p90 under %s\n", target)
+		return
+	}
+	fmt.Printf("Sustained concurrency with p90 under %s: %d\n", target, safe)
+}
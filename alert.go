@@ -0,0 +1,165 @@
+package main
+
+// Canary runs from cron should page someone only when something is
+// actually wrong.  When a run exits with a failure (its gate, like
+// --fail-on-amplification, or stopping early on a full-body response
+// or a stall) or its findings include amplification or corrupt data,
+// --on-failure-exec runs a command through sh -c with the summary on
+// stdin, and --webhook-url POSTs the same summary.  The summary is the
+// --json result without the samples, plus a short message made from
+// --alert-template, in a "text" field so that a Slack incoming webhook
+// can take it as it is.  The command also gets the message in
+// S3TEST_ALERT_MESSAGE.
+//
+// A hook that fails or runs past --hook-timeout is reported, but
+// doesn't change the exit status; that's up to the gate.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const defaultAlertTemplate = `s3test run {{.RunID}} ({{.Mode}} reads of {{.Bucket}}/{{.File}} from {{.Endpoint}}): {{join .Reasons "; "}}`
+
+// alertPayload is what the hooks get.
+type alertPayload struct {
+	Text    string   `json:"text"`
+	Reasons []string `json:"reasons"`
+	Status  int      `json:"exitStatus"`
+	Result  *Result  `json:"result"` // without the samples
+}
+
+// Parse --alert-template.
+func parseAlertTemplate(text string) (*template.Template, error) {
+	return template.New("alert").Funcs(template.FuncMap{"join": strings.Join}).Parse(text)
+}
+
+// Return why `result`, which is exiting with `status`, is worth an
+// alert, or nothing if it isn't.
+func alertReasons(result *Result, status int) []string {
+	var reasons []string
+	switch status {
+	case 0:
+	case exitAmplification:
+		reasons = append(reasons, "failed --fail-on-amplification (exit status 3)")
+	case exitStall:
+		reasons = append(reasons, "stopped on a stalled read (exit status 4)")
+	case exitFullBody:
+		reasons = append(reasons, "stopped by --abort-on-full-body (exit status 5)")
+	default:
+		reasons = append(reasons, fmt.Sprintf("failed with exit status %d", status))
+	}
+	for _, f := range result.Findings {
+		if f.Alert {
+			reasons = append(reasons, f.Text)
+		}
+	}
+	return reasons
+}
+
+// Run the hooks for `result`, if it's worth an alert.
+func runAlertHooks(result *Result, status int) {
+	if *onFailureExec == "" && *webhookURL == "" {
+		return
+	}
+	reasons := alertReasons(result, status)
+	if len(reasons) == 0 {
+		return
+	}
+	summary := *result
+	summary.Samples = nil
+	p := &alertPayload{Reasons: reasons, Status: status, Result: &summary}
+
+	var msg strings.Builder
+	tmpl, err := parseAlertTemplate(*alertTemplate)
+	if err == nil {
+		err = tmpl.Execute(&msg, struct {
+			*Result
+			Reasons []string
+		}{&summary, reasons})
+	}
+	if err != nil {
+		fmt.Printf("Unable to format --alert-template: %v\n", err)
+		msg.Reset()
+		msg.WriteString(strings.Join(reasons, "; "))
+	}
+	p.Text = msg.String()
+	body, err := json.Marshal(p)
+	if err != nil {
+		fmt.Printf("Unable to encode the alert: %v\n", err)
+		return
+	}
+
+	fmt.Printf("Alert: %s\n", p.Text)
+	if *onFailureExec != "" {
+		if err := execHook(*onFailureExec, p.Text, body, *hookTimeout); err != nil {
+			fmt.Printf("--on-failure-exec failed: %v\n", err)
+		} else {
+			fmt.Printf("Ran --on-failure-exec\n")
+		}
+	}
+	if *webhookURL != "" {
+		if err := postHook(*webhookURL, body, *hookTimeout); err != nil {
+			fmt.Printf("--webhook-url failed: %v\n", err)
+		} else {
+			fmt.Printf("Posted the alert to %s\n", webhookHost(*webhookURL))
+		}
+	}
+}
+
+// Run `command` with `body` on stdin.
+func execHook(command, msg string, body []byte, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(), "S3TEST_ALERT_MESSAGE="+msg)
+	// Don't wait on anything the command left running with our
+	// stdout.
+	cmd.WaitDelay = time.Second
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		return fmt.Errorf("killed after --hook-timeout=%v", timeout)
+	}
+	return err
+}
+
+// POST `body` to `u`.
+func postHook(u string, body []byte, timeout time.Duration) error {
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Post(u, "application/json", bytes.NewReader(body))
+	if err != nil {
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			// Don't print the URL.
+			return fmt.Errorf("%s %s: %w", uerr.Op, webhookHost(u), uerr.Err)
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", webhookHost(u), resp.Status)
+	}
+	return nil
+}
+
+// Return where `u` goes, without the path or query, which usually
+// carry the webhook's token.
+func webhookHost(u string) string {
+	p, err := url.Parse(u)
+	if err != nil || p.Host == "" {
+		return "the webhook"
+	}
+	return p.Scheme + "://" + p.Host
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAlertReasons(t *testing.T) {
+	clean := &Result{}
+	if r := alertReasons(clean, 0); len(r) != 0 {
+		t.Errorf("a clean run: %q", r)
+	}
+	for status, want := range map[int]string{3: "--fail-on-amplification", 4: "stalled", 5: "--abort-on-full-body", 1: "exit status 1"} {
+		if r := alertReasons(clean, status); len(r) != 1 || !strings.Contains(r[0], want) {
+			t.Errorf("exit status %d: %q, want %q", status, r, want)
+		}
+	}
+	flagged := &Result{Findings: []finding{{Text: "fine"}, {Text: "corrupt", Alert: true}}}
+	if r := alertReasons(flagged, 0); len(r) != 1 || r[0] != "corrupt" {
+		t.Errorf("an alerting finding: %q", r)
+	}
+}
+
+// Both hooks fire when the run exits with a failure, even with no
+// findings at all.
+func TestAlertHooksFireOnFailure(t *testing.T) {
+	var posted alertPayload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(b, &posted); err != nil {
+			t.Errorf("the webhook got %q: %v", b, err)
+		}
+	}))
+	defer server.Close()
+	out := filepath.Join(t.TempDir(), "alert")
+
+	setFlag(t, webhookURL, server.URL)
+	setFlag(t, onFailureExec, "cat > "+out+"; echo \"$S3TEST_ALERT_MESSAGE\" >> "+out)
+	setFlag(t, hookTimeout, 10*time.Second)
+	setFlag(t, alertTemplate, defaultAlertTemplate)
+
+	result := &Result{RunInfo: RunInfo{RunID: "abc123", Mode: "getobject", Bucket: "b", File: "f", Endpoint: "http://s3"}, Samples: []*Sample{{}}}
+	runAlertHooks(result, 4)
+
+	if posted.Status != 4 || !strings.Contains(posted.Text, "abc123") || !strings.Contains(posted.Text, "stalled") {
+		t.Errorf("the webhook got %+v", posted)
+	}
+	if posted.Result == nil || posted.Result.Samples != nil {
+		t.Errorf("the webhook's result should be there, without samples: %+v", posted.Result)
+	}
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("--on-failure-exec didn't run: %v", err)
+	}
+	if !strings.Contains(string(b), `"exitStatus":4`) || !strings.Contains(string(b), "s3test run abc123") {
+		t.Errorf("--on-failure-exec got %s", b)
+	}
+	if len(result.Samples) != 1 {
+		t.Errorf("the hooks changed the result's samples")
+	}
+}
+
+func TestAlertHooksQuietOnSuccess(t *testing.T) {
+	called := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true }))
+	defer server.Close()
+	setFlag(t, webhookURL, server.URL)
+	setFlag(t, alertTemplate, defaultAlertTemplate)
+	runAlertHooks(&Result{}, 0)
+	if called {
+		t.Errorf("the webhook was called for a clean run")
+	}
+}
+
+func TestWebhookErrorsHideTheToken(t *testing.T) {
+	err := postHook("http://127.0.0.1:1/services/secret-token", nil, time.Second)
+	if err == nil || strings.Contains(err.Error(), "secret-token") {
+		t.Errorf("postHook to a closed port: %v", err)
+	}
+	if h := webhookHost("https://hooks.example.com/services/secret-token?x=1"); h != "https://hooks.example.com" {
+		t.Errorf("webhookHost = %q", h)
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+// The whole point of this tool is detecting read amplification, so
+// after the run we compare the byte ranges that went upstream (from
+// the recording transport) with the byte ranges that the benchmark
+// actually asked for.  A well-behaved client stack should request
+// exactly what we asked for: no extra bytes, no duplicate bytes, and
+// no un-ranged GETs.
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// byteRange is the half-open range [start, end).
+type byteRange struct {
+	start, end int64
+}
+
+// Sort and merge overlapping or adjacent ranges.
+func unionRanges(ranges []byteRange) []byteRange {
+	sorted := slices.Clone(ranges)
+	slices.SortFunc(sorted, func(a, b byteRange) int {
+		if a.start < b.start {
+			return -1
+		} else if a.start > b.start {
+			return 1
+		}
+		return 0
+	})
+
+	var out []byteRange
+	for _, r := range sorted {
+		if r.end <= r.start {
+			continue
+		}
+		if len(out) > 0 && r.start <= out[len(out)-1].end {
+			out[len(out)-1].end = max(out[len(out)-1].end, r.end)
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+// Total number of bytes covered by `ranges`, which may overlap.
+func rangeBytes(ranges []byteRange) int64 {
+	var n int64
+	for _, r := range ranges {
+		if r.end > r.start {
+			n += r.end - r.start
+		}
+	}
+	return n
+}
+
+// Number of bytes covered by both of the (already merged) range
+// lists.
+func intersectBytes(a, b []byteRange) int64 {
+	var n int64
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		lo := max(a[i].start, b[j].start)
+		hi := min(a[i].end, b[j].end)
+		if hi > lo {
+			n += hi - lo
+		}
+		if a[i].end < b[j].end {
+			i++
+		} else {
+			j++
+		}
+	}
+	return n
+}
+
+// Parse a Range header into byte ranges, given the object's size.
+// An empty header means the whole object.  Returns false if the
+// header can't be parsed.
+func parseRangeHeader(header string, size int64) ([]byteRange, bool) {
+	if header == "" {
+		return []byteRange{{0, size}}, true
+	}
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found {
+		return nil, false
+	}
+
+	var out []byteRange
+	for _, part := range strings.Split(spec, ",") {
+		first, last, found := strings.Cut(strings.TrimSpace(part), "-")
+		if !found {
+			return nil, false
+		}
+		switch {
+		case first == "":
+			// Suffix range: the last N bytes.
+			n, err := parseOffset(last)
+			if err != nil {
+				return nil, false
+			}
+			out = append(out, byteRange{size - min(n, size), size})
+		case last == "":
+			start, err := parseOffset(first)
+			if err != nil {
+				return nil, false
+			}
+			out = append(out, byteRange{start, max(start, size)})
+		default:
+			start, err1 := parseOffset(first)
+			end, err2 := parseOffset(last)
+			if err1 != nil || err2 != nil || end < start {
+				return nil, false
+			}
+			out = append(out, byteRange{start, min(end+1, size)})
+		}
+	}
+	return out, true
+}
+
+// amplificationReport compares what went upstream with what the
+// benchmark asked for.
+type amplificationReport struct {
+	LogicalBytes   int64 `json:"logicalBytes"`           // distinct bytes the benchmark asked for
+	RequestedBytes int64 `json:"requestedBytes"`         // bytes requested upstream, counting overlaps
+	ExtraBytes     int64 `json:"extraBytes"`             // requested upstream but never asked for
+	DuplicateBytes int64 `json:"duplicateBytes"`         // requested upstream more than once
+	ReceivedBytes  int64 `json:"receivedBytes"`          // body bytes actually read by the client
+	Requests       int   `json:"requests"`               // upstream GETs for the file
+	Unranged       int   `json:"unranged"`               // upstream GETs with no Range header
+	Unparseable    int   `json:"unparseable"`            // upstream GETs with a Range we didn't understand
+	FullBody       int   `json:"fullBody"`               // ranged GETs answered with more than the range, usually a 200
+	Redirected     int   `json:"redirected,omitempty"`   // GETs answered with a redirect, not counted above
+	RangeDropped   int   `json:"rangeDropped,omitempty"` // GETs that lost their Range following a redirect
+	Overdelivered  int64 `json:"overdelivered"`          // bytes sent past the end of a read's range; see overdelivery.go
+}
+
+// Ratio of bytes requested upstream to bytes the benchmark asked for.
+func (a *amplificationReport) Ratio() float64 {
+	if a.LogicalBytes == 0 {
+		return 0
+	}
+	return float64(a.RequestedBytes) / float64(a.LogicalBytes)
+}
+
+// Compare the GETs for `filename` recorded by `upstream` against the
+// `logical` reads.
+func analyzeAmplification(requests []*recordedRequest, filename string, filesize int64, logical []readRange) *amplificationReport {
+	report := &amplificationReport{}
+
+	var want []byteRange
+	for _, r := range logical {
+		want = append(want, byteRange{r.offset, r.offset + r.size})
+	}
+	want = unionRanges(want)
+	report.LogicalBytes = rangeBytes(want)
+
+	var got []byteRange
+	for _, req := range requests {
+		// A redirect may point somewhere with a different path.
+		if req.Method != http.MethodGet || !isObjectPath(req.origin().Path, filename) {
+			continue
+		}
+		if req.isRedirect() {
+			report.Redirected++
+			continue
+		}
+		if req.rangeDropped() {
+			report.RangeDropped++
+		}
+		report.Requests++
+		report.ReceivedBytes += req.Received()
+		if req.Range == "" {
+			report.Unranged++
+		}
+		if req.fullBody {
+			report.FullBody++
+		}
+		ranges, ok := parseRangeHeader(req.Range, filesize)
+		if !ok {
+			report.Unparseable++
+			continue
+		}
+		got = append(got, ranges...)
+	}
+
+	report.RequestedBytes = rangeBytes(got)
+	merged := unionRanges(got)
+	report.DuplicateBytes = report.RequestedBytes - rangeBytes(merged)
+	report.ExtraBytes = rangeBytes(merged) - intersectBytes(merged, want)
+
+	return report
+}
+
+// Does the URL path `path` refer to `filename`?  Handles both
+// path-style and virtual-hosted-style addressing.
+func isObjectPath(path, filename string) bool {
+	return path == "/"+*bucket+"/"+filename || path == "/"+filename
+}
+
+func (a *amplificationReport) print() {
+	fmt.Printf("Amplification: asked for %d bytes, requested %d bytes upstream in %d GETs (%.2fx), received %d bytes\n",
+		a.LogicalBytes, a.RequestedBytes, a.Requests, a.Ratio(), a.ReceivedBytes)
+	fmt.Printf("  extra bytes requested:     %d\n", a.ExtraBytes)
+	fmt.Printf("  duplicate bytes requested: %d\n", a.DuplicateBytes)
+	fmt.Printf("  GETs without a Range:      %d\n", a.Unranged)
+	if a.Unparseable > 0 {
+		fmt.Printf("  GETs with unparseable Range headers: %d\n", a.Unparseable)
+	}
+	if a.Overdelivered > 0 {
+		fmt.Printf("  overdelivered bytes:       %d\n", a.Overdelivered)
+	}
+	if a.Redirected > 0 {
+		fmt.Printf("  GETs answered with a redirect: %d\n", a.Redirected)
+	}
+	if a.RangeDropped > 0 {
+		fmt.Printf("  WARNING: %d GETs lost their Range header following a redirect, and asked for the whole object\n", a.RangeDropped)
+	}
+	if a.FullBody > 0 {
+		fmt.Printf("  WARNING: %d ranged GETs got more than they asked for, usually a 200 with the whole object; the server did far more work than it looks like from here\n", a.FullBody)
+	}
+}
@@ -0,0 +1,486 @@
+package main
+
+// `s3test analyze FILE...` summarizes results offline.  Each FILE can
+// be a --json result or a --jsonl log, which might have been
+// truncated mid-line if the run was killed, and which is reported as
+// incomplete if it has no "end" record.  With one file, it prints
+// that run's summary; with several, a table with one row per run,
+// and a warning if the runs aren't measuring the same thing.  The
+// files of a rotated --jsonl log count as one (see rotate.go).
+// --group-by combines runs with the same readsize, endpoint, or
+// client versions into one row.
+//
+// Percentiles are always recomputed from the raw samples when the
+// file has them, rather than trusting the stored summary, so results
+// written by older versions with different percentile math can still
+// be compared.
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/scottlaird/s3test/schema"
+)
+
+// Read all records from a JSONL file.  A final line that doesn't
+// parse is assumed to be the result of a crash and is ignored; bad
+// lines anywhere else are skipped with a warning.
+func readJSONL(filename string) ([]jsonlRecord, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []jsonlRecord
+	r := bufio.NewReader(f)
+	for lineNum := 1; ; lineNum++ {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 {
+			var rec jsonlRecord
+			if jerr := json.Unmarshal(line, &rec); jerr != nil {
+				// These go to stderr, so they don't end up
+				// in --format=csv or json output.
+				if err == io.EOF {
+					fmt.Fprintf(os.Stderr, "%s: ignoring truncated final line %d\n", filename, lineNum)
+				} else {
+					fmt.Fprintf(os.Stderr, "%s: skipping unparseable line %d: %v\n", filename, lineNum, jerr)
+				}
+			} else if verr := checkRecordVersion(rec); verr != nil {
+				return nil, fmt.Errorf("%s line %d: %w", filename, lineNum, verr)
+			} else {
+				records = append(records, rec)
+			}
+		}
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return records, err
+		}
+	}
+}
+
+// Refuse a record from a schema we don't understand.  Records from
+// before schemaVersion only have the major version.
+func checkRecordVersion(rec jsonlRecord) error {
+	if rec.SchemaVersion == "" && rec.Version > schema.Major {
+		return &schema.VersionError{Version: fmt.Sprintf("%d.0", rec.Version)}
+	}
+	return schema.Check(rec.SchemaVersion)
+}
+
+// analyzedRun is what we know about one run from its result file.
+type analyzedRun struct {
+	File     string
+	Run      *RunInfo // nil if the file didn't say
+	Bytes    int64
+	Duration time.Duration // not counting time spent paused
+	Paused   time.Duration
+	Reads    int
+	Errors   int
+
+	// Set for a --jsonl file whose last run has no "end" record.
+	Truncated bool
+
+	// Latencies of the successful reads, if the file has
+	// samples; otherwise the summary it stored.
+	latencies []time.Duration
+	stored    latencyStats
+}
+
+func (a *analyzedRun) hasSamples() bool {
+	return a.latencies != nil
+}
+
+func (a *analyzedRun) latency() latencyStats {
+	if a.hasSamples() {
+		return computeLatencyStats(a.latencies)
+	}
+	return a.stored
+}
+
+// Load a --json or --jsonl file.
+func loadRun(filename string) (*analyzedRun, error) {
+	if !strings.HasSuffix(filename, ".jsonl") {
+		result, err := loadResult(filename)
+		if err == nil {
+			return analyzeResult(filename, result), nil
+		}
+		var verr *schema.VersionError
+		if errors.As(err, &verr) {
+			return nil, err
+		}
+	}
+	records, err := readJSONL(filename)
+	if err != nil {
+		return nil, err
+	}
+	return analyzeRecords(filename, records), nil
+}
+
+// Load a rotated set of --jsonl files, in order, as one log.
+func loadRotated(files []string) (*analyzedRun, error) {
+	var records []jsonlRecord
+	for _, f := range files {
+		r, err := readJSONL(f)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, r...)
+	}
+	name, _, ok := parseRotatedName(files[len(files)-1])
+	if !ok {
+		name = files[len(files)-1]
+	}
+	fmt.Fprintf(os.Stderr, "%s: stitched together %d rotated files\n", name, len(files))
+	return analyzeRecords(name, records), nil
+}
+
+// Summarize a --json result.
+func analyzeResult(filename string, result *Result) *analyzedRun {
+	run := result.RunInfo
+	a := &analyzedRun{
+		File:     filename,
+		Run:      &run,
+		Bytes:    result.Bytes,
+		Duration: result.Duration,
+		Paused:   result.Paused,
+		Reads:    len(result.Samples),
+		Errors:   result.Errors,
+		stored:   result.Latency,
+	}
+	if len(result.Samples) > 0 {
+		a.latencies, a.Errors = sampleLatencies(result.Samples)
+	}
+	return a
+}
+
+// Summarize the records from a --jsonl file.
+func analyzeRecords(filename string, records []jsonlRecord) *analyzedRun {
+	a := &analyzedRun{File: filename}
+	var samples []*Sample
+	var pausedAt time.Time
+	for _, rec := range records {
+		switch {
+		case rec.Run != nil:
+			a.Run = rec.Run
+			a.Truncated = true
+		case rec.Type == "end":
+			a.Truncated = false
+		case rec.Sample != nil:
+			samples = append(samples, rec.Sample)
+		case rec.Type == "pause" && rec.Event != nil:
+			pausedAt = rec.Event.Time
+		case rec.Type == "resume" && rec.Event != nil && !pausedAt.IsZero():
+			a.Paused += rec.Event.Time.Sub(pausedAt)
+			pausedAt = time.Time{}
+		}
+	}
+	if len(samples) == 0 {
+		return a
+	}
+
+	first, last := samples[0].Start, samples[0].Start
+	for _, s := range samples {
+		a.Bytes += s.Bytes
+		if s.Start.Before(first) {
+			first = s.Start
+		}
+		if end := s.Start.Add(s.Duration); end.After(last) {
+			last = end
+		}
+	}
+	a.Reads = len(samples)
+	a.Duration = last.Sub(first) - a.Paused
+	a.latencies, a.Errors = sampleLatencies(samples)
+	return a
+}
+
+// Return the latencies of the successful samples, and the number that
+// failed.
+func sampleLatencies(samples []*Sample) ([]time.Duration, int) {
+	latencies := []time.Duration{}
+	errors := 0
+	for _, s := range samples {
+		if s.Err != "" {
+			errors++
+		} else {
+			latencies = append(latencies, s.Duration)
+		}
+	}
+	return latencies, errors
+}
+
+// Return the runs' value for --group-by `key`.
+func groupKey(a *analyzedRun, key string) string {
+	if a.Run == nil {
+		return "unknown"
+	}
+	switch key {
+	case "readsize":
+		return fmt.Sprint(a.Run.ReadSize)
+	case "endpoint":
+		return a.Run.Endpoint
+	case "version":
+		if e := a.Run.Environment; e != nil {
+			return fmt.Sprintf("%s sdk %s s3 %s s3fs %s", e.GoVersion, e.SDKVersion, e.S3Version, e.S3FSVersion)
+		}
+		return "unknown"
+	}
+	return a.File
+}
+
+// Describe what a run measured, for checking that runs are
+// comparable.
+func fingerprint(a *analyzedRun) string {
+	if a.Run == nil {
+		return "unknown"
+	}
+	pattern := a.Run.Pattern
+	if pattern == "" {
+		pattern = "?"
+	}
+	return fmt.Sprintf("%s/%s %s readsize %d via %s", a.Run.Bucket, a.Run.File, pattern, a.Run.ReadSize, a.Run.Mode)
+}
+
+// Return the ways in which `runs` aren't measuring the same thing.
+func incomparable(runs []*analyzedRun) []string {
+	var differences []string
+	differs := func(what string, value func(*RunInfo) string) {
+		seen := map[string]bool{}
+		for _, a := range runs {
+			if a.Run != nil {
+				seen[value(a.Run)] = true
+			}
+		}
+		if len(seen) > 1 {
+			differences = append(differences, what)
+		}
+	}
+	differs("file", func(r *RunInfo) string { return r.Bucket + "/" + r.File })
+	differs("pattern", func(r *RunInfo) string { return r.Pattern })
+	differs("readsize", func(r *RunInfo) string { return fmt.Sprint(r.ReadSize) })
+	differs("proxy", func(r *RunInfo) string {
+		if r.Environment == nil {
+			return ""
+		}
+		return r.Environment.Proxy
+	})
+	return differences
+}
+
+// analysisRow is one line of the analyze table: a run, or a group of
+// runs with --group-by.
+type analysisRow struct {
+	Name        string        `json:"name"`
+	Runs        int           `json:"runs"`
+	Fingerprint string        `json:"fingerprint"`
+	Bytes       int64         `json:"bytes"`
+	Duration    time.Duration `json:"durationNs"`
+	Mbps        float64       `json:"mbps"`
+	Reads       int           `json:"reads"`
+	Errors      int           `json:"errors"`
+	Latency     latencyStats  `json:"latency"`
+
+	// Whether the percentiles came from raw samples for every
+	// run, rather than from stored summaries.
+	FromSamples bool `json:"fromSamples"`
+
+	// Whether any of the runs' --jsonl files were missing their
+	// "end" record.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// Combine `runs` into one row.  Percentiles are pooled across the
+// runs' samples, so a group's p90 is the p90 of all of its reads.
+func combineRuns(name string, runs []*analyzedRun) analysisRow {
+	row := analysisRow{Name: name, Runs: len(runs), FromSamples: true}
+	var fingerprints []string
+	var latencies []time.Duration
+	for _, a := range runs {
+		row.Bytes += a.Bytes
+		row.Duration += a.Duration
+		row.Reads += a.Reads
+		row.Errors += a.Errors
+		latencies = append(latencies, a.latencies...)
+		row.FromSamples = row.FromSamples && a.hasSamples()
+		row.Truncated = row.Truncated || a.Truncated
+		if f := fingerprint(a); !slices.Contains(fingerprints, f) {
+			fingerprints = append(fingerprints, f)
+		}
+	}
+	row.Fingerprint = strings.Join(fingerprints, "; ")
+	row.Mbps = mbps(row.Bytes, row.Duration)
+	if len(runs) == 1 && !row.FromSamples {
+		row.Latency = runs[0].stored
+	} else {
+		row.Latency = computeLatencyStats(latencies)
+	}
+	return row
+}
+
+// Print a single run in detail.
+func printRunDetail(a *analyzedRun) {
+	if r := a.Run; r != nil {
+		fmt.Printf("Run %s: %s/%s via %s at %s, readsize %d, %s cache\n", r.RunID, r.Bucket, r.File, r.Mode, r.Endpoint, r.ReadSize, r.Cache)
+	}
+	if a.Truncated {
+		fmt.Printf("WARNING: %s has no end record, so the run didn't finish: it was killed or aborted (or written by an older s3test)\n", a.File)
+	}
+	if a.Reads == 0 && !a.hasSamples() && a.Bytes == 0 {
+		fmt.Printf("No samples\n")
+		return
+	}
+	if a.Paused > 0 {
+		fmt.Printf("Paused for %.3f seconds, which isn't counted below\n", a.Paused.Seconds())
+	}
+	fmt.Printf("Read %s in %.3f seconds at %s (%d reads, %d errors)\n", units.bytes(a.Bytes), a.Duration.Seconds(), units.rate(a.Bytes, a.Duration), a.Reads, a.Errors)
+	source := ""
+	if !a.hasSamples() {
+		source = " (stored summary; the file has no samples)"
+	}
+	fmt.Printf("Reads: %s%s\n", a.latency(), source)
+}
+
+func printAnalysisTable(rows []analysisRow) {
+	fmt.Printf("%-24s %4s %14s %8s %8s %8s %6s  %s\n", "run", "runs", units.rateUnit(), "p50", "p90", "p99", "errors", "fingerprint")
+	for _, r := range rows {
+		note := ""
+		if !r.FromSamples {
+			note = " (stored percentiles)"
+		}
+		if r.Truncated {
+			note += " (truncated)"
+		}
+		fmt.Printf("%-24s %4d %14.3f %7.3fs %7.3fs %7.3fs %6d  %s%s\n", r.Name, r.Runs, units.rateValue(r.Bytes, r.Duration), r.Latency.P50.Seconds(), r.Latency.P90.Seconds(), r.Latency.P99.Seconds(), r.Errors, r.Fingerprint, note)
+	}
+}
+
+func writeAnalysisCSV(w io.Writer, rows []analysisRow) error {
+	c := csv.NewWriter(w)
+	c.Write([]string{"name", "runs", "fingerprint", "bytes", "seconds", "mbps", "reads", "errors", "p50", "p90", "p99", "from_samples", "truncated", "schema_version"})
+	for _, r := range rows {
+		c.Write([]string{
+			r.Name, fmt.Sprint(r.Runs), r.Fingerprint, fmt.Sprint(r.Bytes),
+			fmt.Sprintf("%.3f", r.Duration.Seconds()), fmt.Sprintf("%.3f", r.Mbps),
+			fmt.Sprint(r.Reads), fmt.Sprint(r.Errors),
+			fmt.Sprintf("%.6f", r.Latency.P50.Seconds()), fmt.Sprintf("%.6f", r.Latency.P90.Seconds()), fmt.Sprintf("%.6f", r.Latency.P99.Seconds()),
+			fmt.Sprint(r.FromSamples), fmt.Sprint(r.Truncated), schema.Version,
+		})
+	}
+	c.Flush()
+	return c.Error()
+}
+
+// Run the analyze subcommand.
+func runAnalyze(args []string) int {
+	fs := flag.NewFlagSet("analyze", flag.ContinueOnError)
+	format := fs.String("format", "table", "output format: table, csv, or json")
+	groupBy := fs.String("group-by", "", "combine runs with the same readsize, endpoint, or version into one row")
+	fs.Usage = func() {
+		fmt.Printf("Usage: s3test analyze [--format=table|csv|json] [--group-by=readsize|endpoint|version] FILE...\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 1
+	}
+	if *format != "table" && *format != "csv" && *format != "json" {
+		fmt.Printf("Unknown --format %q; use table, csv, or json\n", *format)
+		return 1
+	}
+	if *groupBy != "" && *groupBy != "readsize" && *groupBy != "endpoint" && *groupBy != "version" {
+		fmt.Printf("Unknown --group-by %q; use readsize, endpoint, or version\n", *groupBy)
+		return 1
+	}
+
+	groups, err := analyzeFileGroups(fs.Args())
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return 1
+	}
+	var runs []*analyzedRun
+	for _, files := range groups {
+		var a *analyzedRun
+		var err error
+		if len(files) == 1 {
+			a, err = loadRun(files[0])
+		} else {
+			a, err = loadRotated(files)
+		}
+		if err != nil {
+			fmt.Printf("Unable to read %s: %v\n", files[0], err)
+			return 1
+		}
+		runs = append(runs, a)
+	}
+
+	if len(runs) == 1 && *format == "table" && *groupBy == "" {
+		printRunDetail(runs[0])
+		return 0
+	}
+
+	var rows []analysisRow
+	if *groupBy == "" {
+		for _, a := range runs {
+			name := a.File
+			if a.Run != nil && a.Run.RunID != "" {
+				name = a.Run.RunID
+			}
+			rows = append(rows, combineRuns(name, []*analyzedRun{a}))
+		}
+	} else {
+		var keys []string
+		groups := map[string][]*analyzedRun{}
+		for _, a := range runs {
+			k := groupKey(a, *groupBy)
+			if _, ok := groups[k]; !ok {
+				keys = append(keys, k)
+			}
+			groups[k] = append(groups[k], a)
+		}
+		for _, k := range keys {
+			rows = append(rows, combineRuns(k, groups[k]))
+		}
+	}
+
+	differences := incomparable(runs)
+	switch *format {
+	case "table":
+		printAnalysisTable(rows)
+		if len(differences) > 0 {
+			fmt.Printf("WARNING: these runs aren't directly comparable; they differ in %s\n", strings.Join(differences, ", "))
+		}
+	case "csv":
+		if err := writeAnalysisCSV(os.Stdout, rows); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err := enc.Encode(struct {
+			SchemaVersion string        `json:"schemaVersion"`
+			Rows          []analysisRow `json:"rows"`
+			Incomparable  []string      `json:"incomparable,omitempty"`
+		}{schema.Version, rows, differences})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+	}
+	return 0
+}
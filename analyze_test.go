@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/scottlaird/s3test/schema"
+)
+
+// analyze reads the schema package's golden files from every version
+// it supports, and refuses the ones from a newer major version rather
+// than guessing at them.
+func TestAnalyzeFixtures(t *testing.T) {
+	for _, tc := range []struct {
+		file      string
+		truncated bool
+	}{
+		{"result-pre.json", false},
+		{"result-1.0.json", false},
+		{"result-1.3.json", false},
+		// Logs from before there was an "end" record look
+		// truncated.
+		{"log-pre.jsonl", true},
+		{"log-1.0.jsonl", false},
+		{"log-1.3.jsonl", false},
+	} {
+		a, err := loadRun("schema/testdata/" + tc.file)
+		if err != nil {
+			t.Errorf("%s: %v", tc.file, err)
+			continue
+		}
+		if a.Run == nil || a.Run.ReadSize != 512<<10 || a.Bytes != 1<<20 || a.Reads != 2 || a.Errors != 0 || a.Truncated != tc.truncated {
+			t.Errorf("%s: %+v", tc.file, a)
+		}
+		if l := a.latency(); l.Count != 2 || l.P50 <= 0 {
+			t.Errorf("%s: latency %+v", tc.file, l)
+		}
+	}
+
+	for _, file := range []string{"result-2.0.json", "log-2.0.jsonl"} {
+		_, err := loadRun("schema/testdata/" + file)
+		var verr *schema.VersionError
+		if !errors.As(err, &verr) || !strings.Contains(err.Error(), "use a newer s3test") {
+			t.Errorf("%s: got %v, want a VersionError", file, err)
+		}
+	}
+}
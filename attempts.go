@@ -0,0 +1,162 @@
+package main
+
+// The SDK silently retries failed requests, so a single "slow read"
+// might really be three attempts, two of which got a 500 from the
+// gateway.  This middleware records every attempt the SDK makes so
+// that we can tell the difference.
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Operation is one logical SDK call (GetObject, HeadObject, ...),
+// along with each attempt that the SDK made to complete it.
+type Operation struct {
+	Name     string    `json:"name"`
+	Attempts []Attempt `json:"attempts"`
+}
+
+// Attempt is a single HTTP request made by the SDK's retry loop.
+type Attempt struct {
+	StatusCode int           `json:"status"`
+	Duration   time.Duration `json:"durationNs"`
+	Err        string        `json:"error,omitempty"`
+
+	// What the server's Retry-After header asked for, if anything.
+	RetryAfter time.Duration `json:"retryAfterNs,omitempty"`
+}
+
+// attemptTotals keeps running totals of every recorded operation
+// for the summary.
+type attemptTotals struct {
+	mu         sync.Mutex
+	operations int
+	attempts   int
+	retried    int
+}
+
+var attempts attemptTotals
+
+func (t *attemptTotals) add(op Operation) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.operations++
+	t.attempts += len(op.Attempts)
+	if len(op.Attempts) > 1 {
+		t.retried++
+	}
+}
+
+// opCollector gathers the operations made with a particular context,
+// so that they can be attached to the right sample even when several
+// reads are running at once.
+type opCollector struct {
+	mu  sync.Mutex
+	ops []Operation
+}
+
+// Return a context that collects the SDK operations made with it.
+func collectOperations(ctx context.Context) (context.Context, *opCollector) {
+	c := &opCollector{}
+	return context.WithValue(ctx, collectorKey{}, c), c
+}
+
+// Return the operations collected so far.
+func (c *opCollector) Operations() []Operation {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Operation(nil), c.ops...)
+}
+
+type operationKey struct{}
+type collectorKey struct{}
+type noRecordingKey struct{}
+
+// Return a context whose SDK calls aren't recorded, for background
+// work that shouldn't be attributed to any read.
+func withoutRecording(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noRecordingKey{}, true)
+}
+
+// Add the recording middleware to an SDK client's stack.  Use this
+// in s3.Options.APIOptions.
+func recordAttempts(stack *middleware.Stack) error {
+	err := stack.Initialize.Add(middleware.InitializeMiddlewareFunc("RecordOperation",
+		func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+			op := &Operation{Name: middleware.GetOperationName(ctx)}
+			ctx = context.WithValue(ctx, operationKey{}, op)
+			out, md, err := next.HandleInitialize(ctx, in)
+			if ctx.Value(noRecordingKey{}) != nil {
+				return out, md, err
+			}
+			if len(op.Attempts) > 0 { // presigning doesn't send anything
+				attempts.add(*op)
+				if c, ok := ctx.Value(collectorKey{}).(*opCollector); ok {
+					c.mu.Lock()
+					c.ops = append(c.ops, *op)
+					c.mu.Unlock()
+				}
+			}
+			return out, md, err
+		}), middleware.Before)
+	if err != nil {
+		return err
+	}
+
+	// This goes immediately after the retry middleware, so it
+	// runs once per attempt.  Presigning doesn't have a retry
+	// middleware, or any attempts.
+	if _, ok := stack.Finalize.Get("Retry"); !ok {
+		return nil
+	}
+	return stack.Finalize.Insert(middleware.FinalizeMiddlewareFunc("RecordAttempt",
+		func(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+			start := time.Now()
+			out, md, err := next.HandleFinalize(ctx, in)
+
+			a := Attempt{Duration: time.Since(start)}
+			if resp, ok := awsmiddleware.GetRawResponse(md).(*smithyhttp.Response); ok {
+				a.StatusCode = resp.StatusCode
+			}
+			if err != nil {
+				var re *smithyhttp.ResponseError
+				if errors.As(err, &re) {
+					a.StatusCode = re.HTTPStatusCode()
+					if re.Response != nil {
+						a.RetryAfter, _ = retryAfterOf(re.Response.Response)
+					}
+				}
+				a.Err = err.Error()
+			}
+			attempt := 1
+			if op, ok := ctx.Value(operationKey{}).(*Operation); ok {
+				op.Attempts = append(op.Attempts, a)
+				attempt = len(op.Attempts)
+			}
+			if tracingEnabled && err != nil {
+				name := "attempt failed"
+				if errors.Is(err, context.DeadlineExceeded) {
+					name = "timeout"
+				}
+				trace.SpanFromContext(ctx).AddEvent(name, trace.WithAttributes(
+					attribute.String("operation", middleware.GetOperationName(ctx)),
+					attribute.Int("attempt", attempt),
+					attribute.Int("http.status_code", a.StatusCode),
+					attribute.String("error", a.Err)))
+			} else if tracingEnabled && attempt > 1 {
+				trace.SpanFromContext(ctx).AddEvent("retry succeeded", trace.WithAttributes(
+					attribute.String("operation", middleware.GetOperationName(ctx)),
+					attribute.Int("attempt", attempt)))
+			}
+			return out, md, err
+		}), "Retry", middleware.After)
+}
@@ -0,0 +1,415 @@
+package main
+
+// The benchmark can read through several different client stacks, to
+// help narrow down which layer is responsible for extra traffic:
+//
+//   - s3fs: Open()+Seek()+Read() through github.com/jszwec/s3fs, the
+//     way Caddy does it.  This is the default.
+//   - getobject: a single ranged GetObject per read via the AWS SDK.
+//   - http: a plain ranged HTTP GET, with no SDK at all.  The bucket
+//     needs to allow anonymous reads.
+//   - presigned: like http, but with a presigned URL from the SDK.
+//   - fullobject: one GetObject for the whole file, with no Range at
+//     all, for comparison with the ranged modes.
+//   - filer-grpc: straight to the volume servers, using the filer's
+//     gRPC API to find the chunks.  See filergrpc.go.
+//   - localfs: os.Open()+Seek()+Read() on a local file, as a baseline.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/jszwec/s3fs/v2"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// Backend is one way of reading a byte range from an object.
+type Backend interface {
+	// Return a reader positioned at `offset` in `filename`, which
+	// should be good for at least `size` bytes.  Phase timings and
+	// the HTTP status (if visible) are recorded in `sample`.
+	Open(ctx context.Context, filename string, offset, size int64, sample *Sample) (io.ReadCloser, error)
+}
+
+// Create the backend for `mode`, reading from `t` with `client`.  If
+// `etag` is non-empty, every read is made conditional on it, using
+// If-Match for SDK reads and If-Range for plain HTTP reads.
+func newBackend(mode string, client *s3.Client, t target, etag string) (Backend, error) {
+	switch mode {
+	case "s3fs":
+		if *s3fsPartSize > 0 {
+			return &partBackend{client: client, bucket: t.Bucket, part: *s3fsPartSize}, nil
+		}
+		return &s3fsBackend{client: client, bucket: t.Bucket}, nil
+	case "getobject":
+		return &getObjectBackend{client: client, bucket: t.Bucket, etag: etag}, nil
+	case "http":
+		return &httpBackend{target: t, etag: etag}, nil
+	case "presigned":
+		return &httpBackend{presign: s3.NewPresignClient(client), target: t, etag: etag}, nil
+	case "getobject-v1":
+		return newSDKV1Backend(t, etag)
+	case "fullobject":
+		return &fullObjectBackend{client: client, bucket: t.Bucket, etag: etag}, nil
+	case "filer-grpc":
+		return newFilerGRPCBackend(*filerGRPCAddr, *filerBucketDir, t.Bucket)
+	case "localfs":
+		return &localFSBackend{direct: *directIO}, nil
+	}
+	return nil, fmt.Errorf("unknown --mode %q; use s3fs, getobject, getobject-v1, http, presigned, fullobject, filer-grpc, or localfs", mode)
+}
+
+// Does `mode` read through the S3 API?
+func usesS3(mode string) bool {
+	return mode != "localfs" && mode != "filer-grpc"
+}
+
+type s3fsBackend struct {
+	client *s3.Client
+	bucket string
+}
+
+// s3fs calls the SDK with context.Background(), which would hide
+// each read's SDK calls from our tracing and attempt recording.
+// contextClient substitutes a context of our choosing.
+type contextClient struct {
+	client *s3.Client
+	ctx    context.Context
+}
+
+func (c *contextClient) HeadObject(_ context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	return c.client.HeadObject(c.ctx, params, optFns...)
+}
+
+func (c *contextClient) ListObjects(_ context.Context, params *s3.ListObjectsInput, optFns ...func(*s3.Options)) (*s3.ListObjectsOutput, error) {
+	return c.client.ListObjects(c.ctx, params, optFns...)
+}
+
+func (c *contextClient) GetObject(_ context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	return c.client.GetObject(c.ctx, params, optFns...)
+}
+
+func (b *s3fsBackend) Open(ctx context.Context, filename string, offset, size int64, sample *Sample) (io.ReadCloser, error) {
+	// A new S3FS per read is cheap, and lets us give it a
+	// client bound to this read's context.
+	cl := &contextClient{client: b.client}
+	var fs *s3fs.S3FS
+	if *noSeek {
+		fs = s3fs.New(cl, b.bucket)
+	} else {
+		fs = s3fs.New(cl, b.bucket, s3fs.WithReadSeeker)
+	}
+
+	start := time.Now()
+	phaseCtx, endPhase := startPhase(ctx, "Open")
+	cl.ctx = phaseCtx
+	f, err := fs.Open(filename)
+	endPhase()
+	sample.addPhase("open", time.Since(start))
+	if err != nil {
+		return nil, err
+	}
+
+	// s3fs caches the size from the GetObject response, and
+	// Seek() is going to ask for it anyway.
+	if info, err := f.Stat(); err == nil {
+		sample.ObjectSize = info.Size()
+	}
+
+	if *noSeek {
+		phaseCtx, endPhase = startPhase(ctx, "Discard")
+		cl.ctx = phaseCtx
+		err = skipTo(f, offset, sample)
+		endPhase()
+		cl.ctx = ctx
+		if err != nil {
+			f.Close()
+			return nil, &readError{Phase: "seek", Err: err}
+		}
+		return f, nil
+	}
+
+	// fs.Open() returns a fs.File, which is an interface that
+	// doesn't include `Seek`, although with WithReadSeeker the
+	// underlying implementation does support it.
+	fSeek, ok := f.(io.ReadSeekCloser)
+	if !ok {
+		f.Close()
+		return nil, errNoSeek
+	}
+
+	start = time.Now()
+	phaseCtx, endPhase = startPhase(ctx, "Seek")
+	cl.ctx = phaseCtx
+	_, err = fSeek.Seek(offset, io.SeekStart)
+	endPhase()
+	sample.addPhase("seek", time.Since(start))
+	if err != nil {
+		f.Close()
+		return nil, &readError{Phase: "seek", Err: err}
+	}
+
+	if *checkPosition {
+		return newPositionChecker(fSeek, offset, sample), nil
+	}
+	return f, nil
+}
+
+type getObjectBackend struct {
+	client *s3.Client
+	bucket string
+	etag   string
+}
+
+func (b *getObjectBackend) Open(ctx context.Context, filename string, offset, size int64, sample *Sample) (io.ReadCloser, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(filename),
+		Range:  aws.String(rangeHeader(offset, size)),
+	}
+	if b.etag != "" {
+		input.IfMatch = aws.String(b.etag)
+	}
+
+	start := time.Now()
+	ctx, endPhase := startPhase(ctx, "GetObject")
+	out, err := b.client.GetObject(ctx, input)
+	endPhase()
+	sample.addPhase("getobject", time.Since(start))
+	if err != nil {
+		sample.Status = statusOf(err)
+		return nil, err
+	}
+	if resp, ok := awsmiddleware.GetRawResponse(out.ResultMetadata).(*smithyhttp.Response); ok {
+		sample.Status = resp.StatusCode
+	}
+	if total, ok := contentRangeSize(aws.ToString(out.ContentRange)); ok {
+		sample.ObjectSize = total
+	}
+	if problem := sseC.check(out.SSECustomerAlgorithm, out.SSECustomerKeyMD5); problem != "" {
+		sample.warn(problem)
+	}
+
+	if *verifyChecksums {
+		return newChecksumReader(out.Body, rawHeader(out.ResultMetadata), aws.ToInt64(out.ContentLength), sample.ObjectSize == aws.ToInt64(out.ContentLength), sample), nil
+	}
+	return out.Body, nil
+}
+
+// fullObjectBackend only reads whole objects, so the schedule for it
+// is always a single read starting at 0.
+type fullObjectBackend struct {
+	client *s3.Client
+	bucket string
+	etag   string
+}
+
+func (b *fullObjectBackend) Open(ctx context.Context, filename string, offset, size int64, sample *Sample) (io.ReadCloser, error) {
+	if offset != 0 {
+		return nil, fmt.Errorf("fullobject mode can't read from offset %d", offset)
+	}
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(filename),
+	}
+	if b.etag != "" {
+		input.IfMatch = aws.String(b.etag)
+	}
+
+	start := time.Now()
+	ctx, endPhase := startPhase(ctx, "GetObject")
+	out, err := b.client.GetObject(ctx, input)
+	endPhase()
+	sample.addPhase("getobject", time.Since(start))
+	if err != nil {
+		sample.Status = statusOf(err)
+		return nil, err
+	}
+	if resp, ok := awsmiddleware.GetRawResponse(out.ResultMetadata).(*smithyhttp.Response); ok {
+		sample.Status = resp.StatusCode
+	}
+	sample.ObjectSize = aws.ToInt64(out.ContentLength)
+	if problem := sseC.check(out.SSECustomerAlgorithm, out.SSECustomerKeyMD5); problem != "" {
+		sample.warn(problem)
+	}
+
+	if *verifyChecksums {
+		return newChecksumReader(out.Body, rawHeader(out.ResultMetadata), sample.ObjectSize, true, sample), nil
+	}
+	return out.Body, nil
+}
+
+type httpBackend struct {
+	presign *s3.PresignClient // nil for unsigned requests
+	target  target
+	etag    string
+}
+
+func (b *httpBackend) Open(ctx context.Context, filename string, offset, size int64, sample *Sample) (io.ReadCloser, error) {
+	u := b.target.objectURL(filename)
+
+	if b.presign != nil {
+		start := time.Now()
+		req, err := b.presign.PresignGetObject(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(b.target.Bucket),
+			Key:    aws.String(filename),
+		})
+		sample.addPhase("presign", time.Since(start))
+		if err != nil {
+			return nil, err
+		}
+		u = req.URL
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", rangeHeader(offset, size))
+	if b.etag != "" {
+		req.Header.Set("If-Range", b.etag)
+	}
+	setReadIDHeader(ctx, req.Header)
+	if *verifyChecksums {
+		req.Header.Set(checksumModeHeader, "ENABLED")
+	}
+
+	var resp *http.Response
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		phaseCtx, endPhase := startPhase(ctx, "GET")
+		if tracingEnabled {
+			propagation.TraceContext{}.Inject(phaseCtx, propagation.HeaderCarrier(req.Header))
+		}
+		resp, err = b.target.httpClient().Do(req)
+		endPhase()
+		sample.addPhase("get", time.Since(start))
+		if err != nil {
+			return nil, err
+		}
+
+		d, ok := retryAfterOf(resp)
+		if !ok {
+			break
+		}
+		sample.Backpressure++
+		if attempt == httpMaxAttempts {
+			break
+		}
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+		sample.BackpressureWait += obeyRetryAfter(ctx, d)
+	}
+	sample.Status = resp.StatusCode
+	if total, ok := contentRangeSize(resp.Header.Get("Content-Range")); ok {
+		sample.ObjectSize = total
+	}
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return nil, &httpStatusError{StatusCode: resp.StatusCode, Body: string(msg)}
+	}
+
+	if *verifyChecksums {
+		return newChecksumReader(resp.Body, resp.Header, resp.ContentLength, resp.StatusCode == http.StatusOK || sample.ObjectSize == resp.ContentLength, sample), nil
+	}
+	return resp.Body, nil
+}
+
+// Return the HTTP headers of the response behind an SDK result.
+func rawHeader(md middleware.Metadata) http.Header {
+	if resp, ok := awsmiddleware.GetRawResponse(md).(*smithyhttp.Response); ok {
+		return resp.Header
+	}
+	return nil
+}
+
+// httpStatusError is returned by the http backends for non-2xx
+// responses.
+type httpStatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("HTTP %d: %s", e.StatusCode, strings.TrimSpace(e.Body))
+}
+
+// Return the HTTP status code behind `err`, or 0 if there isn't one.
+func statusOf(err error) int {
+	var re *smithyhttp.ResponseError
+	if errors.As(err, &re) {
+		return re.HTTPStatusCode()
+	}
+	var he *httpStatusError
+	if errors.As(err, &he) {
+		return he.StatusCode
+	}
+	return 0
+}
+
+func rangeHeader(offset, size int64) string {
+	return fmt.Sprintf("bytes=%d-%d", offset, offset+size-1)
+}
+
+// Return "path-style" or "virtual-hosted-style", per --path-style.
+func addressingStyle() string {
+	return addressingStyleOf(*pathStyle)
+}
+
+func addressingStyleOf(pathStyle bool) string {
+	if pathStyle {
+		return "path-style"
+	}
+	return "virtual-hosted-style"
+}
+
+// Return the unsigned URL for `key` in the default target.
+func objectURL(key string) string {
+	return defaultTarget().objectURL(key)
+}
+
+// Return the unsigned URL for `key` in `t`, using its addressing
+// style.
+func (t target) objectURL(key string) string {
+	if t.pathStyle() {
+		return strings.TrimSuffix(t.Endpoint, "/") + "/" + url.PathEscape(t.Bucket) + "/" + escapeKey(key)
+	}
+	u, err := url.Parse(t.Endpoint)
+	if err != nil {
+		return t.Endpoint + "/" + escapeKey(key)
+	}
+	u.Host = t.Bucket + "." + u.Host
+	return strings.TrimSuffix(u.String(), "/") + "/" + escapeKey(key)
+}
+
+// Escape `key` for a URL path the way S3 does when signing: everything
+// but unreserved characters and '/' is percent-encoded.  In
+// particular, '+' becomes %2B, since some gateways decode a bare '+'
+// as a space.
+func escapeKey(key string) string {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '.', c == '_', c == '~', c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
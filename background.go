@@ -0,0 +1,120 @@
+package main
+
+// Under real load, streaming reads compete with listings and stats
+// from everyone else.  --background-metadata issues HeadObject and
+// ListObjectsV2 calls from a separate goroutine while the read
+// benchmark runs, so we can see whether metadata latency collapses
+// when data reads are hammering the filer.
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// metadataSample is one timed background metadata call.
+type metadataSample struct {
+	Op       string        `json:"op"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"durationNs"`
+	Err      string        `json:"error,omitempty"`
+}
+
+// backgroundMetadata runs metadata calls at a fixed rate until
+// stopped.
+type backgroundMetadata struct {
+	cancel context.CancelFunc
+	done   sync.WaitGroup
+
+	mu      sync.Mutex
+	samples []metadataSample
+}
+
+// Start issuing `rate` metadata calls per second against `filename`
+// and its directory, alternating HeadObject and ListObjectsV2.
+func startBackgroundMetadata(ctx context.Context, client *s3.Client, filename string, rate float64) *backgroundMetadata {
+	ctx, cancel := context.WithCancel(withoutRecording(ctx))
+	bg := &backgroundMetadata{cancel: cancel}
+
+	prefix := path.Dir(filename) + "/"
+	if prefix == "./" {
+		prefix = ""
+	}
+
+	bg.done.Add(1)
+	go func() {
+		defer bg.done.Done()
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / rate))
+		defer ticker.Stop()
+
+		for i := 0; ; i++ {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			s := metadataSample{Start: time.Now()}
+			var err error
+			if i%2 == 0 {
+				s.Op = "HeadObject"
+				_, err = client.HeadObject(ctx, &s3.HeadObjectInput{
+					Bucket: bucket,
+					Key:    aws.String(filename),
+				})
+			} else {
+				s.Op = "ListObjectsV2"
+				_, err = client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+					Bucket:  bucket,
+					Prefix:  aws.String(prefix),
+					MaxKeys: aws.Int32(100),
+				})
+			}
+			s.Duration = time.Since(s.Start)
+			if err != nil {
+				if ctx.Err() != nil {
+					return // stopped mid-call; don't count it
+				}
+				s.Err = err.Error()
+			}
+
+			bg.mu.Lock()
+			bg.samples = append(bg.samples, s)
+			bg.mu.Unlock()
+		}
+	}()
+
+	return bg
+}
+
+// Stop the background calls and wait for the goroutine to exit.
+func (bg *backgroundMetadata) stop() {
+	bg.cancel()
+	bg.done.Wait()
+}
+
+func (bg *backgroundMetadata) report() {
+	bg.mu.Lock()
+	defer bg.mu.Unlock()
+
+	for _, op := range []string{"HeadObject", "ListObjectsV2"} {
+		var durations []time.Duration
+		errors := 0
+		for _, s := range bg.samples {
+			if s.Op != op {
+				continue
+			}
+			if s.Err != "" {
+				errors++
+				continue
+			}
+			durations = append(durations, s.Duration)
+		}
+		fmt.Printf("Background %s: %s, %d errors\n", op, computeLatencyStats(durations), errors)
+	}
+}
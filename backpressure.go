@@ -0,0 +1,125 @@
+package main
+
+// When SeaweedFS (or AWS) sheds load, it answers 503 SlowDown with a
+// Retry-After header.  The SDK's standard retryer ignores Retry-After
+// and uses its own backoff, so we wrap it to sleep for as long as the
+// server asked (up to --max-retry-after) before retrying.  The plain
+// HTTP backends do the same thing by hand.  --ignore-retry-after
+// retries on the SDK's schedule (or immediately, for the HTTP
+// backends) instead, to see whether being polite changes anything.
+//
+// Each sample records how long it spent obeying Retry-After, so the
+// summary can say how much of the run was spent being told to wait
+// rather than actually transferring data.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// How many times the HTTP backends try a request that keeps getting
+// Retry-After, matching the SDK's default.
+const httpMaxAttempts = 3
+
+// Parse a Retry-After header, which is either a number of seconds or
+// an HTTP date.
+func parseRetryAfter(h string, now time.Time) (time.Duration, bool) {
+	if h == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(h); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(h); err == nil {
+		return max(0, t.Sub(now)), true
+	}
+	return 0, false
+}
+
+// Return the Retry-After from a 503 or 429 response.
+func retryAfterOf(resp *http.Response) (time.Duration, bool) {
+	if resp == nil || (resp.StatusCode != http.StatusServiceUnavailable && resp.StatusCode != http.StatusTooManyRequests) {
+		return 0, false
+	}
+	return parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+}
+
+// How long we'll actually wait when the server asks for `d`.
+func retryAfterWait(d time.Duration) time.Duration {
+	if *ignoreRetryAfter {
+		return 0
+	}
+	return min(d, *maxRetryAfter)
+}
+
+// Sleep for the Retry-After `d`, unless we're ignoring it.  Returns
+// how long we waited.
+func obeyRetryAfter(ctx context.Context, d time.Duration) time.Duration {
+	wait := retryAfterWait(d)
+	if wait <= 0 {
+		return 0
+	}
+	select {
+	case <-time.After(wait):
+	case <-ctx.Done():
+	}
+	return wait
+}
+
+// backpressureRetryer is the SDK's retryer, except that it waits as
+// long as Retry-After says to.
+type backpressureRetryer struct {
+	aws.RetryerV2
+}
+
+func (r *backpressureRetryer) RetryDelay(attempt int, opErr error) (time.Duration, error) {
+	var re *smithyhttp.ResponseError
+	if errors.As(opErr, &re) && re.Response != nil {
+		if d, ok := retryAfterOf(re.Response.Response); ok && !*ignoreRetryAfter {
+			return retryAfterWait(d), nil
+		}
+	}
+	return r.RetryerV2.RetryDelay(attempt, opErr)
+}
+
+// Total time the SDK spent waiting on Retry-After for `ops`: every
+// attempt that asked us to wait and was followed by another attempt.
+func backpressureWait(ops []Operation) time.Duration {
+	var total time.Duration
+	for _, op := range ops {
+		for i, a := range op.Attempts {
+			if i < len(op.Attempts)-1 && a.RetryAfter > 0 {
+				total += retryAfterWait(a.RetryAfter)
+			}
+		}
+	}
+	return total
+}
+
+// Print how much of the run was spent obeying Retry-After, if the
+// server ever sent one.
+func reportBackpressure(samples []*Sample) {
+	var asked int
+	var wait, total time.Duration
+	for _, s := range samples {
+		asked += s.Backpressure
+		wait += s.BackpressureWait
+		total += s.Duration
+	}
+	if asked == 0 {
+		return
+	}
+	how := "obeying it"
+	if *ignoreRetryAfter {
+		how = "ignoring it (--ignore-retry-after)"
+	}
+	fmt.Printf("Backpressure: %d responses asked us to back off; %s, we waited %.3fs vs %.3fs transferring (%.1f%% of read time)\n",
+		asked, how, wait.Seconds(), (total - wait).Seconds(), 100*wait.Seconds()/max(total.Seconds(), 1e-9))
+}
@@ -0,0 +1,152 @@
+package main
+
+// Benchmarks of the core read patterns, so client-side changes (a new
+// SDK version, buffer pooling) can be compared with benchstat:
+//
+//	go test -run=NONE -bench=Read -count=10 > old.txt
+//	... change something ...
+//	go test -run=NONE -bench=Read -count=10 > new.txt
+//	benchstat old.txt new.txt
+//
+// Each case has a sub-benchmark per read size.  SequentialRead and
+// RandomRead use --mode, and OpenSeekRead always goes through s3fs, to
+// measure its Open()+Seek() overhead.  DrainReadFull, DrainCopy, and
+// DrainDiscard are sequential reads through readFrom()'s drain code
+// with each --drain strategy, which mostly differ in allocations; see
+// drain.go.  Each op is one read, and bytes/op is the read size, so
+// MB/s is the throughput.
+//
+// By default the reads go to an in-memory object served by a local
+// HTTP server, which isolates the client.  To benchmark against a real
+// endpoint, set S3TEST_BENCH_FILE to the object to read, and the usual
+// S3TEST_ENDPOINT, S3TEST_BUCKET, S3TEST_MODE, and so on.
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// benchCase is one benchmark: a name and how to pick each op's offset.
+type benchCase struct {
+	name    string
+	mode    string
+	offsets func(blocks int64) func() int64
+	drain   string // if set, drain each read this way instead of with io.ReadFull
+}
+
+// Read blocks in order.
+func sequentialOffsets(blocks int64) func() int64 {
+	var i int64
+	return func() int64 {
+		i++
+		return (i - 1) % blocks
+	}
+}
+
+var benchCases = []benchCase{
+	{"SequentialRead", "", sequentialOffsets, ""},
+	{"RandomRead", "", func(blocks int64) func() int64 {
+		rng := rand.New(rand.NewPCG(1, 2))
+		return func() int64 { return rng.Int64N(blocks) }
+	}, ""},
+	{"OpenSeekRead", "s3fs", func(blocks int64) func() int64 {
+		rng := rand.New(rand.NewPCG(3, 4))
+		return func() int64 { return rng.Int64N(blocks) }
+	}, ""},
+	{"DrainReadFull", "", sequentialOffsets, "readfull"},
+	{"DrainCopy", "", sequentialOffsets, "copy"},
+	{"DrainDiscard", "", sequentialOffsets, "discard"},
+}
+
+var benchReadSizes = []int64{64 << 10, 256 << 10, 1 << 20}
+
+// The size of the in-memory object.
+const benchObjectSize = 16 << 20
+
+// Return the file to read, its size, and an S3 client for it: the
+// S3TEST_BENCH_FILE on the configured endpoint, if it's set, or else an
+// in-memory object on a local server.
+func benchTarget(b *testing.B) (string, int64, *s3.Client) {
+	ctx := context.Background()
+	if filename := os.Getenv("S3TEST_BENCH_FILE"); filename != "" {
+		if err := applyFlagEnv(flag.CommandLine, os.LookupEnv); err != nil {
+			b.Fatal(err)
+		}
+		fsys, client, err := connect(ctx)
+		if err != nil {
+			b.Fatal(err)
+		}
+		discovery, err := discoverSize(ctx, fsys, client, filename, *sizeFrom)
+		if err != nil {
+			b.Fatalf("unable to get the size of %s: %v", filename, err)
+		}
+		return filename, discovery.Size, client
+	}
+
+	data := make([]byte, benchObjectSize)
+	rand.NewChaCha8([32]byte{}).Read(data)
+	client := fakeS3(b, map[string][]byte{"big.mp4": data})
+	return "big.mp4", benchObjectSize, client
+}
+
+func BenchmarkRead(b *testing.B) {
+	ctx := context.Background()
+	filename, filesize, client := benchTarget(b)
+	for _, c := range benchCases {
+		b.Run(c.name, func(b *testing.B) {
+			m := c.mode
+			if m == "" {
+				m = *mode
+			}
+			backend, err := newBackend(m, client, defaultTarget(), "")
+			if err != nil {
+				b.Fatalf("unable to set up --mode=%s: %v", m, err)
+			}
+			for _, size := range benchReadSizes {
+				if size > filesize {
+					continue
+				}
+				b.Run(fmt.Sprintf("readsize=%d", size), func(b *testing.B) {
+					benchReads(ctx, b, backend, filename, size, c.offsets(filesize/size), c.drain)
+				})
+			}
+		})
+	}
+}
+
+// Read `size` bytes at block offsets from `next`, b.N times, draining
+// them with `strategy` if it's set.
+func benchReads(ctx context.Context, b *testing.B, backend Backend, filename string, size int64, next func() int64, strategy string) {
+	var buf []byte
+	if strategy == "" {
+		buf = make([]byte, size)
+	}
+	b.SetBytes(size)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		offset := next() * size
+		f, err := backend.Open(ctx, filename, offset, size, &Sample{})
+		if err != nil {
+			b.Fatal(err)
+		}
+		if strategy == "" {
+			_, err = io.ReadFull(f, buf)
+		} else {
+			stall := newStallWatch(f)
+			err = drain(&drainReader{r: f, offset: offset, limit: size, stall: stall, sample: &Sample{}}, strategy)
+			stall.stop()
+		}
+		f.Close()
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
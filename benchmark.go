@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// benchmark holds the state shared by every read in a run.
+type benchmark struct {
+	ctx       context.Context
+	client    *s3.Client
+	backend   Backend
+	filename  string
+	filesize  int64
+	discovery *sizeDiscovery
+	result    *Result
+	jsonl     *jsonlWriter
+	stream    *fifoStream
+	tui       *tui // nil without --tui
+	pass      int  // with --passes
+	cond      conditionalResults
+
+	mu           sync.Mutex
+	asked        []readRange // the reads we actually attempted
+	latencies    []time.Duration
+	totalBytes   int64
+	retriedReads int
+	serverSize   int64 // the size the server reported, if it disagrees with `filesize`
+	stopped      bool
+	failure      error
+
+	// See change.go.
+	startETag  string
+	clampTo    *int64
+	rechecking int // looks at the object in progress, without b.mu
+
+	// See control.go.
+	paused      bool
+	pausedAt    time.Time
+	pausedTotal time.Duration
+	unpaused    *sync.Cond
+}
+
+// readSource hands out the next read for `worker`, returning false
+// when that worker is done.
+type readSource func(worker int) (readRange, bool)
+
+// Run reads from `next` on `concurrency` workers until they're all
+// done, or until a read fails in a way that should stop the run.
+// Samples are tagged with `label`, and the ones from this call are
+// returned.
+func (b *benchmark) execute(label string, concurrency int, next readSource) ([]*Sample, error) {
+	var wg sync.WaitGroup
+	var samples []*Sample
+
+	for w := range concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				b.waitWhilePaused()
+				r, ok := next(w)
+				if !ok || b.isStopped() {
+					return
+				}
+				if r, ok = b.clamp(r); !ok {
+					continue
+				}
+				gateWait := inflight.acquire(r.size)
+				b.tui.begin(w, r)
+				sample, err := readFrom(b.ctx, b.backend, b.filename, r.offset, r.size, b.filesize)
+				b.tui.end(w, sample)
+				inflight.release(r.size)
+				sample.GateWait = gateWait
+				sample.Worker = w
+				sample.Label = label
+				sample.Pass = b.pass
+
+				b.mu.Lock()
+				samples = append(samples, sample)
+				b.record(r, sample, err)
+				b.mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	return samples, b.failure
+}
+
+// Run every phase of `sched` in turn.  With --mutate-during-run, the
+// object is overwritten once half of the reads have been handed out.
+// Returns the samples from each phase, keyed by label.
+func (b *benchmark) runSchedule(sched *schedule) (map[string][]*Sample, error) {
+	samples := make(map[string][]*Sample)
+	mutateAt := int64(-1)
+	if *mutateDuring {
+		mutateAt = int64(len(sched.Reads) / 2)
+	}
+	var handedOut atomic.Int64
+
+	for _, phase := range sched.phases() {
+		next := scheduleSource(phase, sched.Concurrency)
+		if sched.Timed {
+			next = timedSource(phase, *replaySpeed)
+		}
+		if sched.Pacing != nil {
+			next = sched.Pacing.source(next, sched.Concurrency)
+		}
+		if mutateAt >= 0 {
+			inner := next
+			next = func(worker int) (readRange, bool) {
+				if handedOut.Add(1)-1 == mutateAt {
+					b.mutate()
+				}
+				return inner(worker)
+			}
+		}
+
+		label := phase[0].Label
+		s, err := b.execute(label, sched.Concurrency, next)
+		samples[label] = append(samples[label], s...)
+		if err != nil {
+			return samples, err
+		}
+	}
+
+	return samples, nil
+}
+
+// Overwrite the object, for --mutate-during-run.
+func (b *benchmark) mutate() {
+	if err := mutateObject(b.ctx, b.client, b.filename, b.filesize); err != nil {
+		panic(err)
+	}
+	b.mu.Lock()
+	b.cond.mutated = true
+	b.mu.Unlock()
+}
+
+func (b *benchmark) isStopped() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.stopped
+}
+
+// Record a finished read, and decide whether its error (if any) should
+// stop the run.  Must be called with b.mu held, which it lets go of
+// while it looks at the object again after a read that ran off the
+// end.
+func (b *benchmark) record(r readRange, sample *Sample, err error) {
+	b.asked = append(b.asked, r)
+	b.result.Samples = append(b.result.Samples, sample)
+	b.stream.send(jsonlRecord{Type: "sample", Sample: sample})
+	if b.jsonl != nil {
+		if werr := b.jsonl.write(jsonlRecord{Type: "sample", Sample: sample}); werr != nil {
+			b.fail(werr)
+			return
+		}
+	}
+	if *conditional {
+		b.cond.add(sample.Status)
+	}
+	if sample.ObjectSize > 0 && sample.ObjectSize != b.filesize && b.serverSize == 0 {
+		b.serverSize = sample.ObjectSize
+		method := "discovery"
+		if b.discovery != nil {
+			method = b.discovery.Method
+		}
+		fmt.Printf("WARNING: file size is %d bytes via %s, but the server says it's %d bytes\n", b.filesize, method, b.serverSize)
+	}
+
+	if sample.FullBody && *abortOnFullBody {
+		fmt.Printf("Stopping: the server sent more than the range asked for at offset %d (--abort-on-full-body)\n", r.offset)
+		b.result.Errors++
+		b.fail(errFullBody)
+		return
+	}
+	if errors.Is(err, errProductionLimit) {
+		// Not the server's fault, so not an error.
+		if !b.stopped {
+			fmt.Printf("Stopping: %v\n", err)
+		}
+		b.stopped = true
+		return
+	}
+	if err != nil {
+		b.result.Errors++
+		if len(b.result.SizeRechecks)+b.rechecking < maxSizeRechecks && b.outOfRange(r, sample, err) {
+			// Looking at the object again is a round trip, so the
+			// other workers mustn't wait for it behind b.mu.
+			b.rechecking++
+			b.mu.Unlock()
+			size, etag, serr := b.statObject()
+			b.mu.Lock()
+			b.rechecking--
+			if serr != nil {
+				fmt.Printf("Unable to check whether %s changed: %v\n", b.filename, serr)
+			} else if c := b.checkChange(r, size, etag); c != nil {
+				if b.result.ObjectChange == nil {
+					b.objectChanged(c)
+					return
+				}
+				fmt.Printf("WARNING: after the failed read at offset %d, the object is %s; it was %s at the start of the run\n", r.offset, describeObject(c.NewSize, c.NewETag), describeObject(c.OldSize, c.OldETag))
+			}
+		}
+		if b.serverSize > 0 && r.offset+r.size > b.serverSize {
+			if !b.stopped {
+				fmt.Printf("Stopping at offset %d, which is past the end of the %d byte object\n", r.offset, b.serverSize)
+			}
+			b.stopped = true
+			return
+		}
+		if sample.Stalled {
+			if *continueOnStall {
+				fmt.Printf("Read at offset %d stalled; carrying on (--continue-on-stall)\n", r.offset)
+				return
+			}
+			fmt.Printf("Stopping at offset %d: %v; use --continue-on-stall to carry on\n", r.offset, err)
+		}
+		if *conditional && sample.Status == http.StatusPreconditionFailed {
+			fmt.Printf("Read at offset %d failed its precondition\n", r.offset)
+			return
+		}
+		b.fail(err)
+		return
+	}
+
+	b.totalBytes += sample.Bytes
+	if err := production.check(); err != nil && !b.stopped {
+		fmt.Printf("Stopping: %v\n", err)
+		b.stopped = true
+	}
+	b.latencies = append(b.latencies, sample.Duration)
+	if sample.Attempts() > len(sample.Ops) {
+		b.retriedReads++
+	}
+}
+
+// Stop the run because of `err`.  Must be called with b.mu held.
+func (b *benchmark) fail(err error) {
+	if b.failure == nil {
+		b.failure = err
+	}
+	b.stopped = true
+}
@@ -0,0 +1,129 @@
+package main
+
+// On some files only part of the file is slow, usually because it
+// lives on different volumes.  --bisect probes latency at a handful of
+// evenly spaced offsets, then binary-searches between the first pair
+// of neighbors where one is fast and the other is slow, and reports
+// the offset where things change.  Each probe is repeated --probes
+// times and summarized by its median, to reduce noise.
+//
+// A probe is slow if its median is over --slow-threshold, or, if that
+// isn't set, over --slow-factor times the fastest initial probe.
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Number of evenly spaced offsets probed before bisecting.
+const bisectInitialProbes = 9
+
+// bisectProbe is one row of the evidence table.
+type bisectProbe struct {
+	offset int64
+	stats  latencyStats
+	slow   bool
+}
+
+// Measure `probes` reads of `size` bytes at `offset`.
+func probeLatency(ctx context.Context, backend Backend, filename string, offset, size, filesize int64, probes int) (latencyStats, error) {
+	var lat []time.Duration
+	for range probes {
+		sample, err := readFrom(ctx, backend, filename, offset, size, filesize)
+		if err != nil {
+			return latencyStats{}, err
+		}
+		lat = append(lat, sample.Duration)
+	}
+	return computeLatencyStats(lat), nil
+}
+
+// Find where `filename` switches between fast and slow reads.
+func runBisect(ctx context.Context, backend Backend, filename string, filesize int64) error {
+	size := *readsize
+	if size > filesize {
+		return fmt.Errorf("--readsize %d is bigger than the %d byte file", size, filesize)
+	}
+	// Probe offsets are multiples of `size`, up to the last full read.
+	last := (filesize - size) / size
+
+	var probes []bisectProbe
+	probe := func(slot int64) (bisectProbe, error) {
+		stats, err := probeLatency(ctx, backend, filename, slot*size, size, filesize, *bisectProbes)
+		p := bisectProbe{offset: slot * size, stats: stats}
+		probes = append(probes, p)
+		return p, err
+	}
+
+	var slots []int64
+	for i := range int64(bisectInitialProbes) {
+		slot := last * i / (bisectInitialProbes - 1)
+		if len(slots) == 0 || slots[len(slots)-1] != slot {
+			slots = append(slots, slot)
+		}
+	}
+	for _, slot := range slots {
+		if _, err := probe(slot); err != nil {
+			return err
+		}
+	}
+
+	threshold := *slowThreshold
+	if threshold == 0 {
+		fastest := slices.MinFunc(probes, func(a, b bisectProbe) int { return int(a.stats.P50 - b.stats.P50) })
+		threshold = time.Duration(float64(fastest.stats.P50) * *slowFactor)
+	}
+	isSlow := func(p bisectProbe) bool { return p.stats.P50 > threshold }
+
+	// Find the first pair of neighbors that disagree.
+	lo, hi := -1, -1
+	for i := 1; i < len(slots); i++ {
+		if isSlow(probes[i-1]) != isSlow(probes[i]) {
+			lo, hi = i-1, i
+			break
+		}
+	}
+
+	var boundary int64
+	found := lo >= 0
+	if found {
+		loSlot, hiSlot := slots[lo], slots[hi]
+		loSlow := isSlow(probes[lo])
+		for hiSlot-loSlot > 1 {
+			mid := loSlot + (hiSlot-loSlot)/2
+			p, err := probe(mid)
+			if err != nil {
+				return err
+			}
+			if isSlow(p) == loSlow {
+				loSlot = mid
+			} else {
+				hiSlot = mid
+			}
+		}
+		boundary = hiSlot * size
+	}
+
+	slices.SortFunc(probes, func(a, b bisectProbe) int { return cmp.Compare(a.offset, b.offset) })
+	fmt.Printf("%14s %10s %10s %10s %6s\n", "offset", "p50", "min", "max", "")
+	for _, p := range probes {
+		verdict := "fast"
+		if isSlow(p) {
+			verdict = "slow"
+		}
+		fmt.Printf("%14d %10s %10s %10s %6s\n", p.offset, p.stats.P50.Round(time.Microsecond), p.stats.Min.Round(time.Microsecond), p.stats.Max.Round(time.Microsecond), verdict)
+	}
+	fmt.Printf("Slow threshold: %s\n", threshold.Round(time.Microsecond))
+
+	if !found {
+		fmt.Printf("No transition found; every probe was %s\n", map[bool]string{true: "slow", false: "fast"}[isSlow(probes[0])])
+		return nil
+	}
+	chunk := *chunkSize
+	aligned := (boundary + chunk/2) / chunk * chunk
+	fmt.Printf("Reads change speed at offset %d (within %d bytes); nearest %d byte chunk boundary is %d\n", boundary, size, chunk, aligned)
+	return nil
+}
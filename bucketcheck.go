@@ -0,0 +1,155 @@
+package main
+
+// A typo in --endpoint or --bucket used to show up as a panic with an
+// SDK error three layers deep, often after the SDK had spent a while
+// retrying.  So before the benchmark starts, we HeadBucket and then
+// read the first byte of the file, without retries, and turn the
+// usual failures into a plain explanation and an exit status that
+// wrapper scripts can act on:
+//
+//	10  the endpoint's hostname doesn't resolve
+//	11  the connection was refused
+//	12  TLS problems, including http vs https mismatches
+//	13  403: the credentials were rejected
+//	14  404: no such bucket
+//	15  404: no such key
+//	16  the other addressing style works; see --path-style
+//	17  no answer at all before the timeout
+//	18  we couldn't reach the --socks5 proxy
+//	19  the --socks5 proxy couldn't reach the endpoint
+//	20  the object is archived or tiered off; see storageclass.go
+//
+// Anything else exits with 1.  The ranged GET isn't recorded, so it
+// doesn't show up in the amplification or HEAD:GET reports.
+// --no-preflight skips all of this, for servers where HeadBucket is
+// broken.
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+)
+
+const (
+	exitDNS        = 10
+	exitRefused    = 11
+	exitTLS        = 12
+	exitForbidden  = 13
+	exitNoBucket   = 14
+	exitNoKey      = 15
+	exitAddressing = 16
+	exitNoAnswer   = 17
+	exitNoProxy    = 18
+	exitProxyHop   = 19
+	exitArchived   = 20
+
+	preflightTimeout = 30 * time.Second
+)
+
+// preflightFailure explains why the endpoint, bucket, or key isn't
+// usable.
+type preflightFailure struct {
+	status int // the exit status
+	msg    string
+}
+
+func (f *preflightFailure) Error() string {
+	return f.msg
+}
+
+// Don't let the SDK retry preflight requests; we want the first answer.
+func noRetries(o *s3.Options) {
+	o.Retryer = aws.NopRetryer{}
+}
+
+// Check that the bucket and `filename` can be reached with `client`,
+// returning nil if they can.
+func checkBucket(ctx context.Context, client *s3.Client, filename string) *preflightFailure {
+	ctx, cancel := context.WithTimeout(withoutRecording(ctx), preflightTimeout)
+	defer cancel()
+
+	_, err := client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: bucket}, noRetries)
+	if err != nil {
+		f := classifyPreflight(err, "bucket", filename)
+		if f.status == exitNoBucket || f.status == exitDNS || statusOf(err) == http.StatusMovedPermanently || statusOf(err) == http.StatusBadRequest {
+			// Try the other addressing style before blaming
+			// the bucket name.
+			other := s3.New(client.Options(), func(o *s3.Options) { o.UsePathStyle = !*pathStyle })
+			if _, otherErr := other.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: bucket}, noRetries); otherErr == nil {
+				return &preflightFailure{exitAddressing, fmt.Sprintf("HeadBucket failed with --path-style=%v (%v), but works with --path-style=%v", *pathStyle, err, !*pathStyle)}
+			}
+		}
+		return f
+	}
+
+	out, err := client.GetObject(unrecorded(ctx), &s3.GetObjectInput{
+		Bucket: bucket,
+		Key:    aws.String(filename),
+		Range:  aws.String("bytes=0-0"),
+	}, noRetries)
+	if err != nil {
+		if statusOf(err) == http.StatusRequestedRangeNotSatisfiable {
+			// It exists, but it's empty.
+			return nil
+		}
+		return classifyPreflight(err, "key", filename)
+	}
+	out.Body.Close()
+	return nil
+}
+
+// Explain `err` from a preflight request for the bucket or the key
+// `filename`.
+func classifyPreflight(err error, what, filename string) *preflightFailure {
+	var dnsErr *net.DNSError
+	var recordErr tls.RecordHeaderError
+	var certErr *tls.CertificateVerificationError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var apiErr smithy.APIError
+	var proxyErr *socksProxyError
+	var hopErr *socksTargetError
+	code := ""
+	if errors.As(err, &apiErr) {
+		code = apiErr.ErrorCode()
+	}
+
+	switch {
+	// These come first: the proxy refusing us isn't the endpoint
+	// refusing us.
+	case errors.As(err, &proxyErr):
+		return &preflightFailure{exitNoProxy, fmt.Sprintf("Unable to connect: %v; check --socks5", proxyErr)}
+	case errors.As(err, &hopErr):
+		return &preflightFailure{exitProxyHop, fmt.Sprintf("We reached the proxy, but %v; check --endpoint, and that the proxy allows it", hopErr)}
+	case errors.As(err, &dnsErr):
+		return &preflightFailure{exitDNS, fmt.Sprintf("Unable to resolve %s: %v; check --endpoint", dnsErr.Name, dnsErr.Err)}
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return &preflightFailure{exitRefused, fmt.Sprintf("Connection to %s refused; check the host and port in --endpoint", *endpoint)}
+	case errors.As(err, &recordErr), strings.Contains(err.Error(), "server gave HTTP response to HTTPS client"):
+		return &preflightFailure{exitTLS, fmt.Sprintf("%s doesn't speak TLS; try http:// instead of https:// in --endpoint", *endpoint)}
+	case errors.As(err, &certErr), errors.As(err, &authorityErr), errors.As(err, &hostnameErr):
+		return &preflightFailure{exitTLS, fmt.Sprintf("TLS certificate problem talking to %s: %v", *endpoint, err)}
+	case errors.Is(err, context.DeadlineExceeded):
+		return &preflightFailure{exitNoAnswer, fmt.Sprintf("No answer from %s within %s; check --endpoint and any firewalls", *endpoint, preflightTimeout)}
+	case statusOf(err) == http.StatusForbidden:
+		return &preflightFailure{exitForbidden, fmt.Sprintf("Access to the %s was denied (403); check the credentials, e.g. AWS_ACCESS_KEY_ID or AWS_PROFILE", what)}
+	case code == "NoSuchBucket", statusOf(err) == http.StatusNotFound && what == "bucket":
+		return &preflightFailure{exitNoBucket, fmt.Sprintf("Bucket %q doesn't exist on %s; check --bucket", *bucket, *endpoint)}
+	case code == "NoSuchKey", statusOf(err) == http.StatusNotFound:
+		return &preflightFailure{exitNoKey, fmt.Sprintf("%s isn't in bucket %q; check the file name (%s)", filename, *bucket, objectURL(filename))}
+	case statusOf(err) == http.StatusMovedPermanently:
+		return &preflightFailure{exitAddressing, fmt.Sprintf("The server redirected the %s request (301); check --region and --path-style", what)}
+	}
+	return &preflightFailure{1, fmt.Sprintf("Preflight check of the %s failed: %v", what, err)}
+}
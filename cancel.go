@@ -0,0 +1,103 @@
+package main
+
+// Browsers abort range requests all the time, when someone seeks or
+// closes the tab.  With --cancel-after, each read closes its response
+// body after only part of the range, the way an abort would, and
+// moves on to the next read.  Whatever the server had already put on
+// the wire by then was wasted work.  We can't see the server side,
+// but the socket's byte counter at the moment we close the body, less
+// what the client actually read, is a lower bound on how much it sent
+// that nobody wanted.
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// cancelPoint is a parsed --cancel-after: either a byte count or a
+// percentage of each read.
+type cancelPoint struct {
+	bytes   int64
+	percent float64
+}
+
+// cancel is set from --cancel-after.
+var cancel cancelPoint
+
+// Parse --cancel-after, like "65536" or "25%".  An empty string
+// means never cancel.
+func parseCancelPoint(s string) (cancelPoint, error) {
+	if s == "" {
+		return cancelPoint{}, nil
+	}
+	if p, found := strings.CutSuffix(s, "%"); found {
+		f, err := strconv.ParseFloat(p, 64)
+		if err != nil || f <= 0 || f >= 100 {
+			return cancelPoint{}, fmt.Errorf("--cancel-after percentage %q must be between 0 and 100", s)
+		}
+		return cancelPoint{percent: f}, nil
+	}
+	n, err := parseOffset(s)
+	if err != nil || n == 0 {
+		return cancelPoint{}, fmt.Errorf("--cancel-after %q must be a byte count or a percentage", s)
+	}
+	return cancelPoint{bytes: n}, nil
+}
+
+func (c cancelPoint) enabled() bool {
+	return c.bytes > 0 || c.percent > 0
+}
+
+// Return how many bytes of a `size` byte read to drain before
+// cancelling, or 0 if we shouldn't cancel it.
+func (c cancelPoint) limit(size int64) int64 {
+	n := c.bytes
+	if c.percent > 0 {
+		n = max(1, int64(float64(size)*c.percent/100))
+	}
+	if n >= size {
+		return 0
+	}
+	return n
+}
+
+// Return the socket's total received byte count, if we can see it.
+func socketBytesReceived(conn net.Conn) (int64, bool) {
+	if tc, ok := conn.(*tls.Conn); ok {
+		conn = tc.NetConn()
+	}
+	info, ok := readTCPInfo(conn)
+	if !ok {
+		return 0, false
+	}
+	return int64(info.BytesReceived), true
+}
+
+// Summarize the requests whose bodies were closed before the end.
+func reportCancellation(requests []*recordedRequest) {
+	var cancelled, measured int
+	var read, wire int64
+	for _, r := range requests {
+		if !r.cancelled {
+			continue
+		}
+		cancelled++
+		if r.wireMeasured && r.wireEnd >= r.wireStart {
+			measured++
+			read += r.Received()
+			wire += r.wireEnd - r.wireStart
+		}
+	}
+	fmt.Printf("Cancelled %d responses before the end of their body\n", cancelled)
+	if measured == 0 {
+		fmt.Printf("  socket statistics weren't available, so we can't tell how much was wasted\n")
+		return
+	}
+	fmt.Printf("  %d of them measured: client read %s, socket had received %s by the time we closed\n", measured, units.bytes(read), units.bytes(wire))
+	if wire > read {
+		fmt.Printf("  %s arrived that nobody read (this includes response headers)\n", units.bytes(wire-read))
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+// "Is 3.2 Gbps at 16 MB reads actually good?" depends on the link,
+// and the people reading a bug report don't know what hardware it came
+// from.  So when we know the client's link speed (--link-speed, or
+// read from /sys/class/net on Linux for the interface that reaches the
+// endpoint), the summary gives throughput as a percentage of it as
+// well.
+//
+// Line rate is rarely reachable, so --net-baseline also measures a
+// practical ceiling after the run: one unranged GET of the whole
+// object, read as fast as we can for up to netBaselineTime, timed from
+// the first byte so the server's time to first byte doesn't count.
+// It runs after the reads so that it can't warm the cache for them.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// How long the --net-baseline GET reads for, at most.
+const netBaselineTime = 10 * time.Second
+
+// networkCeiling is what we compare throughput with.
+type networkCeiling struct {
+	LinkMbps          float64       `json:"linkMbps,omitempty"`
+	LinkSource        string        `json:"linkSource,omitempty"` // --link-speed, or the interface it came from
+	BaselineMbps      float64       `json:"baselineMbps,omitempty"`
+	BaselineBytes     int64         `json:"baselineBytes,omitempty"`
+	BaselineDuration  time.Duration `json:"baselineDurationNs,omitempty"`
+	PercentOfLink     float64       `json:"percentOfLink,omitempty"`     // for the run as a whole
+	PercentOfBaseline float64       `json:"percentOfBaseline,omitempty"` // likewise
+}
+
+// The ceiling for this run, if we know one.
+var ceiling *networkCeiling
+
+// Work out the link speed from --link-speed, or from the interface
+// that reaches `remote` (a host:port).  Returns nil if we can't tell.
+func findLinkSpeed(remote string) *networkCeiling {
+	if *linkSpeed > 0 {
+		return &networkCeiling{LinkMbps: *linkSpeed, LinkSource: "--link-speed"}
+	}
+	if remote == "" {
+		return nil
+	}
+	iface, err := routeInterface(remote)
+	if err != nil {
+		return nil
+	}
+	speed, err := interfaceSpeed(iface)
+	if err != nil || speed <= 0 {
+		return nil
+	}
+	return &networkCeiling{LinkMbps: speed, LinkSource: iface}
+}
+
+// Return the name of the interface that packets to `remote` go out on.
+func routeInterface(remote string) (string, error) {
+	// Connecting a UDP socket picks the route without sending
+	// anything.
+	conn, err := net.Dial("udp", remote)
+	if err != nil {
+		return "", err
+	}
+	local := conn.LocalAddr().(*net.UDPAddr).IP
+	conn.Close()
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return "", err
+	}
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			if ipnet, ok := a.(*net.IPNet); ok && ipnet.IP.Equal(local) {
+				return iface.Name, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no interface has the address %s", local)
+}
+
+// Read `filename` with one unranged GET for up to netBaselineTime,
+// and record the rate in `c`.
+func (c *networkCeiling) measureBaseline(ctx context.Context, client *s3.Client, filename string) error {
+	ctx, cancel := context.WithTimeout(unrecorded(withoutRecording(ctx)), netBaselineTime+time.Minute)
+	defer cancel()
+	out, err := client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: bucket,
+		Key:    aws.String(filename),
+	})
+	if err != nil {
+		return err
+	}
+	defer out.Body.Close()
+
+	start := time.Now()
+	buf := make([]byte, 1<<20)
+	for time.Since(start) < netBaselineTime {
+		n, err := out.Body.Read(buf)
+		c.BaselineBytes += int64(n)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	c.BaselineDuration = time.Since(start)
+	c.BaselineMbps = mbps(c.BaselineBytes, c.BaselineDuration)
+	return nil
+}
+
+// Set the run's percentages, for reading `bytes` in `d`.
+func (c *networkCeiling) finish(bytes int64, d time.Duration) {
+	rate := mbps(bytes, d)
+	if c.LinkMbps > 0 {
+		c.PercentOfLink = 100 * rate / c.LinkMbps
+	}
+	if c.BaselineMbps > 0 {
+		c.PercentOfBaseline = 100 * rate / c.BaselineMbps
+	}
+}
+
+// Describe the rate for reading `bytes` in `d` against the ceiling,
+// like " (32.1% of the link, 80.4% of the --net-baseline GET)", or ""
+// if there's nothing to compare with.
+func (c *networkCeiling) describe(bytes int64, d time.Duration) string {
+	if c == nil || d <= 0 {
+		return ""
+	}
+	rate := mbps(bytes, d)
+	var parts []string
+	if c.LinkMbps > 0 {
+		parts = append(parts, fmt.Sprintf("%.1f%% of the link", 100*rate/c.LinkMbps))
+	}
+	if c.BaselineMbps > 0 {
+		parts = append(parts, fmt.Sprintf("%.1f%% of the --net-baseline GET", 100*rate/c.BaselineMbps))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(parts, ", ") + ")"
+}
+
+// Describe the run's share of the link, like ", and 32.1% of the link",
+// or "" if we don't know the link speed.
+func (c *networkCeiling) linkShare() string {
+	if c.LinkMbps <= 0 {
+		return ""
+	}
+	return fmt.Sprintf(", and %.1f%% of the link", c.PercentOfLink)
+}
+
+// Print the ceilings themselves.
+func (c *networkCeiling) print() {
+	if c.LinkMbps > 0 {
+		fmt.Printf("Link speed: %s, from %s\n", units.rate(int64(c.LinkMbps*1e6/8), time.Second), c.LinkSource)
+	}
+	if c.BaselineDuration > 0 {
+		fmt.Printf("Network baseline: one unranged GET read %s in %.3fs at %s",
+			units.bytes(c.BaselineBytes), c.BaselineDuration.Seconds(), units.rate(c.BaselineBytes, c.BaselineDuration))
+		if c.LinkMbps > 0 {
+			fmt.Printf(" (%.1f%% of the link)", 100*c.BaselineMbps/c.LinkMbps)
+		}
+		fmt.Printf("\n")
+	}
+}
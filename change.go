@@ -0,0 +1,129 @@
+package main
+
+// If someone re-uploads the file during a long run, reads past the
+// new end start failing, and the results are a mix of two different
+// objects.  On the first read that fails in a way that smells like
+// that, we look at the object again, and if its size or ETag has
+// changed, we say so and either stop (--on-change=abort) or carry on
+// with the remaining reads trimmed to the new size
+// (--on-change=clamp).  Either way, the run is marked as
+// contaminated.
+//
+// Stat has been seen to report a stale, smaller size under load (see
+// statconsistency.go), so we look again after every out-of-range read,
+// up to maxSizeRechecks of them, and keep each answer in the results.
+// A size that disagrees with the start of the run and then agrees
+// again means Stat is unreliable, not that the object changed.
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// objectChange records that the object changed during the run.
+type objectChange struct {
+	OldSize int64  `json:"oldSize"`
+	NewSize int64  `json:"newSize"`
+	OldETag string `json:"oldEtag,omitempty"`
+	NewETag string `json:"newEtag,omitempty"`
+	Offset  int64  `json:"offset"` // of the read that noticed
+}
+
+// sizeRecheck is one look at the object after an out-of-range read.
+type sizeRecheck struct {
+	Offset int64     `json:"offset"` // of the read that failed
+	Time   time.Time `json:"time"`
+	Size   int64     `json:"size"`
+	ETag   string    `json:"etag,omitempty"`
+	Agrees bool      `json:"agrees"` // with the size and ETag at the start of the run
+}
+
+// How many times a run will look at the object again.
+const maxSizeRechecks = 20
+
+// Does this failed read look like it ran off the end of the object?
+func (b *benchmark) outOfRange(r readRange, sample *Sample, err error) bool {
+	return statusOf(err) == http.StatusRequestedRangeNotSatisfiable ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		(b.serverSize > 0 && r.offset+r.size > b.serverSize)
+}
+
+// Look at the object again, and return its size and ETag.  This is a
+// round trip to the server, so it must be called without b.mu held;
+// it only uses fields that don't change during the run.
+func (b *benchmark) statObject() (int64, string, error) {
+	switch *mode {
+	case "localfs":
+		info, err := os.Stat(b.filename)
+		if err != nil {
+			return 0, "", err
+		}
+		return info.Size(), "", nil
+	case "filer-grpc":
+		size, err := filerGRPCSize(b.ctx, *filerGRPCAddr, *filerBucketDir, *bucket, b.filename)
+		return size, "", err
+	}
+	head, err := b.client.HeadObject(withoutRecording(b.ctx), &s3.HeadObjectInput{
+		Bucket: bucket,
+		Key:    aws.String(b.filename),
+	})
+	if err != nil {
+		return 0, "", err
+	}
+	return aws.ToInt64(head.ContentLength), aws.ToString(head.ETag), nil
+}
+
+// Record what statObject saw after the failed read `r`, and return
+// what changed, or nil if nothing did.  Must be called with b.mu held.
+func (b *benchmark) checkChange(r readRange, size int64, etag string) *objectChange {
+	c := &objectChange{OldSize: b.filesize, OldETag: b.startETag, Offset: r.offset, NewSize: size, NewETag: etag}
+	agrees := c.NewSize == c.OldSize && (c.OldETag == "" || c.NewETag == c.OldETag)
+	b.result.SizeRechecks = append(b.result.SizeRechecks, sizeRecheck{Offset: r.offset, Time: time.Now(), Size: c.NewSize, ETag: c.NewETag, Agrees: agrees})
+	if agrees {
+		return nil
+	}
+	return c
+}
+
+// Deal with the object having changed, per --on-change.  Must be
+// called with b.mu held.
+func (b *benchmark) objectChanged(c *objectChange) {
+	b.result.ObjectChange = c
+	fmt.Printf("Object changed during run: it was %s, and now it's %s\n", describeObject(c.OldSize, c.OldETag), describeObject(c.NewSize, c.NewETag))
+	if *onChange == "clamp" {
+		fmt.Printf("Trimming the remaining reads to the new size\n")
+		b.clampTo = &c.NewSize
+		return
+	}
+	fmt.Printf("Stopping the run\n")
+	b.stopped = true
+}
+
+func describeObject(size int64, etag string) string {
+	if etag == "" {
+		return fmt.Sprintf("%d bytes", size)
+	}
+	return fmt.Sprintf("%d bytes with ETag %s", size, etag)
+}
+
+// Trim `r` to the object's new size, with --on-change=clamp.
+// Returns false if there's nothing left of it.
+func (b *benchmark) clamp(r readRange) (readRange, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.clampTo == nil {
+		return r, true
+	}
+	if r.offset >= *b.clampTo {
+		return r, false
+	}
+	r.size = min(r.size, *b.clampTo-r.offset)
+	return r, true
+}
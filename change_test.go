@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// The other workers carry on while a failed read looks at the object
+// again, and what it saw is recorded once it's back.
+func TestRecheckWithoutLock(t *testing.T) {
+	setFlag(t, mode, "getobject")
+	setFlag(t, onChange, "abort")
+	fake := fakeBucket(map[string][]byte{"big.mp4": make([]byte, 1000)})
+	heads := make(chan struct{})
+	release := make(chan struct{})
+	client := serveFakeS3(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			heads <- struct{}{}
+			<-release
+		}
+		fake.ServeHTTP(w, r)
+	}))
+	b := &benchmark{ctx: context.Background(), client: client, filename: "big.mp4", filesize: 2000, result: &Result{}}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.record(readRange{offset: 1500, size: 500}, &Sample{}, io.ErrUnexpectedEOF)
+	}()
+
+	select {
+	case <-heads:
+	case <-time.After(10 * time.Second):
+		t.Fatal("no HEAD")
+	}
+	if !b.mu.TryLock() {
+		t.Fatal("b.mu is held during the HEAD")
+	}
+	if b.rechecking != 1 {
+		t.Errorf("%d rechecks in progress, want 1", b.rechecking)
+	}
+	b.mu.Unlock()
+	close(release)
+	<-done
+
+	if len(b.result.SizeRechecks) != 1 || b.result.SizeRechecks[0].Size != 1000 || b.result.SizeRechecks[0].Agrees {
+		t.Errorf("rechecks %+v", b.result.SizeRechecks)
+	}
+	if c := b.result.ObjectChange; c == nil || c.OldSize != 2000 || c.NewSize != 1000 || c.Offset != 1500 {
+		t.Errorf("change %+v", c)
+	}
+	if !b.stopped || b.rechecking != 0 {
+		t.Errorf("stopped %v, %d rechecks in progress", b.stopped, b.rechecking)
+	}
+}
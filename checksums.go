@@ -0,0 +1,208 @@
+package main
+
+// Some gateways now return x-amz-checksum-* headers, including on
+// ranged GETs.  With --verify-checksums, we ask for them (with
+// x-amz-checksum-mode: ENABLED) and check them against the bytes we
+// actually received, which catches corruption that a byte count
+// never would.
+//
+// It's not always possible: a multipart object's composite checksum
+// ("...-3") can't be checked without the part boundaries, and a
+// gateway that sends the whole object's checksum with a range
+// response has told us nothing about the range.  We get the
+// object's own checksums with a HEAD at the start of the run so we
+// can tell that case apart from corruption.  Reads that can't be
+// checked are counted as unverified, never as passed.
+//
+// We do the checking ourselves rather than letting the SDK do it,
+// because the SDK would fail ranged reads that carry the
+// full-object checksum.
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"hash/crc64"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// The possible values of Sample.Checksum, other than "".
+const (
+	checksumVerified     = "verified"
+	checksumMismatch     = "mismatch"
+	checksumNone         = "unverified: no checksum"
+	checksumComposite    = "unverified: composite checksum"
+	checksumWholeObject  = "unverified: whole-object checksum on a range"
+	checksumIncomplete   = "unverified: not read in full"
+	checksumModeHeader   = "X-Amz-Checksum-Mode"
+	checksumHeaderPrefix = "X-Amz-Checksum-"
+)
+
+// The whole object's checksums, keyed by algorithm, from the HEAD at
+// the start of the run.
+var objectChecksums map[string]string
+
+// Return a new hash for the checksum algorithm `algo`, like "CRC32",
+// or nil if we don't know it.
+func newChecksumHash(algo string) hash.Hash {
+	switch algo {
+	case "CRC32":
+		return crc32.NewIEEE()
+	case "CRC32C":
+		return crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	case "CRC64NVME":
+		return crc64.New(crc64.MakeTable(0x9a6c9329ac4bc9b5))
+	case "SHA1":
+		return sha1.New()
+	case "SHA256":
+		return sha256.New()
+	}
+	return nil
+}
+
+// Return the checksums in `header`, keyed by algorithm.
+func checksumsFromHeader(header http.Header) map[string]string {
+	sums := map[string]string{}
+	for k, v := range header {
+		algo, found := strings.CutPrefix(http.CanonicalHeaderKey(k), checksumHeaderPrefix)
+		if !found || len(v) == 0 || algo == "Type" || algo == "Mode" {
+			continue
+		}
+		sums[strings.ToUpper(algo)] = v[0]
+	}
+	return sums
+}
+
+// SDK middleware that asks for checksums on every request.  Use this
+// in s3.Options.APIOptions.
+func addChecksumMode(stack *middleware.Stack) error {
+	return stack.Build.Add(middleware.BuildMiddlewareFunc("AddChecksumMode",
+		func(ctx context.Context, in middleware.BuildInput, next middleware.BuildHandler) (middleware.BuildOutput, middleware.Metadata, error) {
+			if req, ok := in.Request.(*smithyhttp.Request); ok {
+				req.Header.Set(checksumModeHeader, "ENABLED")
+			}
+			return next.HandleBuild(ctx, in)
+		}), middleware.After)
+}
+
+// Fetch the whole object's checksums.
+func headChecksums(ctx context.Context, client *s3.Client, filename string) (map[string]string, error) {
+	out, err := client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: bucket,
+		Key:    aws.String(filename),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return checksumsFromHeader(rawHeader(out.ResultMetadata)), nil
+}
+
+// checksumReader hashes a response body as it's read, and checks it
+// against the response's checksums when it's closed.
+type checksumReader struct {
+	io.ReadCloser
+	sums   map[string]string
+	hashes map[string]hash.Hash
+	length int64 // the response's Content-Length
+	read   int64
+	whole  bool // whether the response is the whole object
+	sample *Sample
+}
+
+// Wrap `body`, which is `length` bytes long and carried `header`.
+// The result goes in `sample`.
+func newChecksumReader(body io.ReadCloser, header http.Header, length int64, whole bool, sample *Sample) io.ReadCloser {
+	r := &checksumReader{
+		ReadCloser: body,
+		sums:       checksumsFromHeader(header),
+		hashes:     map[string]hash.Hash{},
+		length:     length,
+		whole:      whole,
+		sample:     sample,
+	}
+	for algo, sum := range r.sums {
+		if h := newChecksumHash(algo); h != nil && !strings.Contains(sum, "-") {
+			r.hashes[algo] = h
+		}
+	}
+	return r
+}
+
+func (r *checksumReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	for _, h := range r.hashes {
+		h.Write(p[:n])
+	}
+	r.read += int64(n)
+	return n, err
+}
+
+func (r *checksumReader) Close() error {
+	if r.sample.Checksum == "" {
+		r.sample.Checksum = r.verdict()
+	}
+	return r.ReadCloser.Close()
+}
+
+// Decide what the checksums tell us about the data read.
+func (r *checksumReader) verdict() string {
+	switch {
+	case len(r.sums) == 0:
+		return checksumNone
+	case len(r.hashes) == 0:
+		return checksumComposite
+	case r.length < 0 || r.read != r.length:
+		return checksumIncomplete
+	}
+
+	var algos []string
+	for algo := range r.hashes {
+		algos = append(algos, algo)
+	}
+	sort.Strings(algos)
+	for _, algo := range algos {
+		got := base64.StdEncoding.EncodeToString(r.hashes[algo].Sum(nil))
+		if got == r.sums[algo] {
+			continue
+		}
+		if !r.whole && objectChecksums[algo] == r.sums[algo] {
+			return checksumWholeObject
+		}
+		r.sample.warn(fmt.Sprintf("possible corruption: the %s checksum is %s, but the data we got has %s", algo, r.sums[algo], got))
+		return checksumMismatch
+	}
+	return checksumVerified
+}
+
+// Print how many reads' checksums could be checked, and how they
+// turned out.
+func reportChecksums(samples []*Sample) {
+	counts := map[string]int{}
+	carried := 0
+	for _, s := range samples {
+		if s.Checksum != "" {
+			counts[s.Checksum]++
+			if s.Checksum != checksumNone {
+				carried++
+			}
+		}
+	}
+	fmt.Printf("Checksums: %d of %d reads carried checksums; %d verified, %d mismatched\n", carried, len(samples), counts[checksumVerified], counts[checksumMismatch])
+	for _, c := range []string{checksumNone, checksumComposite, checksumWholeObject, checksumIncomplete} {
+		if counts[c] > 0 {
+			fmt.Printf("  %d %s\n", counts[c], c)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+// SeaweedFS stores a file as chunks, each on some volume server, and
+// the question is usually whether particular chunks (and so
+// particular volume servers) are the slow ones.  --by-chunk groups
+// read latency by the chunk each read falls in: the file's real chunk
+// list if --filer-url fetched one, or every --chunk-size bytes if not.
+// A read that spans more than one chunk isn't attributed to either;
+// those go in a separate "straddling" row, since their latency is a
+// mix of both.
+//
+// A chunk is flagged as an outlier if its p90 is at least
+// chunkOutlier times the median latency of all the reads, and there
+// are findings for them.  With many chunks, the table only shows the
+// flagged ones; --json always has them all.
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// A chunk whose p90 is this many times the file's median is
+	// an outlier.
+	chunkOutlier = 3.0
+
+	// Print every chunk's row if there are at most this many.
+	chunkTableRows = 50
+)
+
+// chunkLatency is the latency of the reads within one chunk.
+type chunkLatency struct {
+	Index   int          `json:"index"`
+	Offset  int64        `json:"offset"`
+	Size    int64        `json:"size"`
+	FileID  string       `json:"fileId,omitempty"` // from the filer
+	Volume  string       `json:"volume,omitempty"`
+	Reads   int          `json:"reads"`
+	Errors  int          `json:"errors,omitempty"`
+	Latency latencyStats `json:"latency"`
+	Outlier bool         `json:"outlier,omitempty"`
+
+	latencies []time.Duration
+}
+
+// chunkReport is --by-chunk's table.
+type chunkReport struct {
+	Source     string          `json:"source"` // "filer" or "chunk-size"
+	Median     time.Duration   `json:"medianNs"`
+	Chunks     []*chunkLatency `json:"chunks"`
+	Straddling *chunkLatency   `json:"straddling,omitempty"` // reads spanning more than one chunk
+}
+
+// Return the chunks of a `filesize` byte file: the filer's, if we
+// have them, or every --chunk-size bytes.
+func chunkLayout(filesize int64, filer []chunkInfo) ([]*chunkLatency, string) {
+	var chunks []*chunkLatency
+	if len(filer) > 0 {
+		for _, c := range filer {
+			volume, _, _ := strings.Cut(c.FileID, ",")
+			chunks = append(chunks, &chunkLatency{Offset: c.Offset, Size: c.Size, FileID: c.FileID, Volume: volume})
+		}
+		slices.SortFunc(chunks, func(a, b *chunkLatency) int { return cmp.Compare(a.Offset, b.Offset) })
+		for i, c := range chunks {
+			c.Index = i
+		}
+		return chunks, "filer"
+	}
+	size := *chunkSize
+	for off := int64(0); off < filesize; off += size {
+		chunks = append(chunks, &chunkLatency{Index: len(chunks), Offset: off, Size: min(size, filesize-off)})
+	}
+	return chunks, "chunk-size"
+}
+
+// Return the chunk containing `offset`, or nil.
+func findChunk(chunks []*chunkLatency, offset int64) *chunkLatency {
+	i, found := slices.BinarySearchFunc(chunks, offset, func(c *chunkLatency, off int64) int { return cmp.Compare(c.Offset, off) })
+	if !found {
+		i--
+	}
+	if i < 0 || offset >= chunks[i].Offset+chunks[i].Size {
+		return nil
+	}
+	return chunks[i]
+}
+
+// Group `samples` by chunk.
+func analyzeChunkLatency(samples []*Sample, filesize int64, filer []chunkInfo) *chunkReport {
+	chunks, source := chunkLayout(filesize, filer)
+	if len(chunks) == 0 {
+		return nil
+	}
+	report := &chunkReport{Source: source, Chunks: chunks, Straddling: &chunkLatency{Index: -1}}
+
+	var all []time.Duration
+	for _, s := range samples {
+		size := max(s.Size, 1)
+		first, last := findChunk(chunks, s.Offset), findChunk(chunks, s.Offset+size-1)
+		c := first
+		if first == nil || first != last {
+			c = report.Straddling
+		}
+		c.Reads++
+		if s.Err != "" {
+			c.Errors++
+			continue
+		}
+		c.latencies = append(c.latencies, s.Duration)
+		all = append(all, s.Duration)
+	}
+	report.Median = computeLatencyStats(all).P50
+	for _, c := range append(chunks, report.Straddling) {
+		c.Latency = computeLatencyStats(c.latencies)
+		c.Outlier = c.Index >= 0 && report.Median > 0 && float64(c.Latency.P90) >= chunkOutlier*float64(report.Median)
+	}
+	if report.Straddling.Reads == 0 {
+		report.Straddling = nil
+	}
+	return report
+}
+
+// Return the outlying chunks.
+func (r *chunkReport) outliers() []*chunkLatency {
+	var out []*chunkLatency
+	for _, c := range r.Chunks {
+		if c.Outlier {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func (r *chunkReport) print() {
+	source := "the filer's chunk list"
+	if r.Source == "chunk-size" {
+		source = fmt.Sprintf("every --chunk-size=%d bytes", *chunkSize)
+	}
+	outliers := r.outliers()
+	fmt.Printf("Latency by chunk (%d chunks, from %s; median read %.3fs; %d chunks with p90 over %.0fx that):\n",
+		len(r.Chunks), source, r.Median.Seconds(), len(outliers), chunkOutlier)
+	rows := r.Chunks
+	if len(rows) > chunkTableRows {
+		rows = outliers
+		fmt.Printf("  (showing only those; see --json for the rest)\n")
+	}
+	if len(rows) == 0 && r.Straddling == nil {
+		return
+	}
+	fmt.Printf("  %6s %14s %10s %8s %6s %6s %9s %9s %9s\n", "chunk", "offset", "size", "volume", "reads", "errors", "p50", "p90", "max")
+	for _, c := range rows {
+		c.printRow(fmt.Sprint(c.Index), fmt.Sprint(c.Offset), fmt.Sprint(c.Size))
+	}
+	if r.Straddling != nil {
+		r.Straddling.printRow("across", "-", "-")
+	}
+}
+
+func (c *chunkLatency) printRow(name, offset, size string) {
+	volume := c.Volume
+	if volume == "" {
+		volume = "-"
+	}
+	flag := ""
+	if c.Outlier {
+		flag = " SLOW"
+	}
+	fmt.Printf("  %6s %14s %10s %8s %6d %6d %8.3fs %8.3fs %8.3fs%s\n",
+		name, offset, size, volume, c.Reads, c.Errors, c.Latency.P50.Seconds(), c.Latency.P90.Seconds(), c.Latency.Max.Seconds(), flag)
+}
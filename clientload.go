@@ -0,0 +1,169 @@
+package main
+
+// With very small reads, the client can run out of CPU before the
+// server does, and then the results measure us rather than it.  So
+// while the benchmark runs, we sample our own CPU time and resident
+// memory once a second, report the average and peak in the summary,
+// and keep the series in the --json result.  If we ever used more than
+// 80% of a core, the summary says the results may be client-bound,
+// which answers "are you sure it isn't your test box?" from the same
+// result file.
+//
+// The last sample is taken when the run stops, so it can cover a
+// sliver of a second, and a sliver spent starting up or writing the
+// results is easily a whole core.  The peak only counts full seconds,
+// so a run shorter than one has no peak, and no warning.
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Above this fraction of one core, the client may be the bottleneck.
+const clientBoundCPU = 0.8
+
+// How often we sample.
+const clientLoadInterval = time.Second
+
+// clientLoadSample is our CPU use over one interval, as a fraction of
+// one core, and our resident memory at its end.
+type clientLoadSample struct {
+	Time time.Time `json:"time"`
+	CPU  float64   `json:"cpu"`
+	RSS  uint64    `json:"rssBytes"`
+}
+
+// clientLoad summarizes our own resource use during the run.
+type clientLoad struct {
+	AvgCPU   float64            `json:"avgCpu"`
+	PeakCPU  float64            `json:"peakCpu"` // over full intervals; 0 if there weren't any
+	AvgRSS   uint64             `json:"avgRssBytes"`
+	PeakRSS  uint64             `json:"peakRssBytes"`
+	Drain    string             `json:"drain"`           // see drain.go
+	CPUPerGB float64            `json:"cpuSecondsPerGb"` // of data read
+	Samples  []clientLoadSample `json:"samples"`
+
+	cpu   time.Duration
+	short bool // the run was over before a full interval
+}
+
+// clientLoadMonitor samples our resource use until stopped.
+type clientLoadMonitor struct {
+	stopCh chan struct{}
+	done   sync.WaitGroup
+
+	start            time.Time
+	startCPU, endCPU time.Duration
+	samples          []clientLoadSample
+}
+
+// Start sampling once a second.  Returns nil if we can't measure
+// ourselves on this platform.
+func startClientLoad() *clientLoadMonitor {
+	cpu, _, ok := processUsage()
+	if !ok {
+		return nil
+	}
+	m := &clientLoadMonitor{stopCh: make(chan struct{}), start: time.Now(), startCPU: cpu}
+	m.done.Add(1)
+	go func() {
+		defer m.done.Done()
+		ticker := time.NewTicker(clientLoadInterval)
+		defer ticker.Stop()
+		lastTime, lastCPU := m.start, cpu
+		for {
+			stopping := false
+			select {
+			case <-m.stopCh:
+				stopping = true
+			case <-ticker.C:
+			}
+			now := time.Now()
+			cpu, rss, ok := processUsage()
+			if ok && now.After(lastTime) {
+				m.samples = append(m.samples, clientLoadSample{
+					Time: now,
+					CPU:  float64(cpu-lastCPU) / float64(now.Sub(lastTime)),
+					RSS:  rss,
+				})
+				lastTime, lastCPU = now, cpu
+				m.endCPU = cpu
+			}
+			if stopping {
+				return
+			}
+		}
+	}()
+	return m
+}
+
+// Stop sampling and summarize.
+func (m *clientLoadMonitor) stop() *clientLoad {
+	if m == nil {
+		return nil
+	}
+	close(m.stopCh)
+	m.done.Wait()
+	return m.summarize()
+}
+
+// Summarize the samples taken so far.
+func (m *clientLoadMonitor) summarize() *clientLoad {
+	if len(m.samples) == 0 {
+		return nil
+	}
+
+	load := &clientLoad{Samples: m.samples, short: true}
+	var rssTotal uint64
+	last := m.start
+	for i, s := range m.samples {
+		// Only the final sample can be short, but the ticks
+		// before it may be late, and that's fine.
+		if i < len(m.samples)-1 || s.Time.Sub(last) >= clientLoadInterval {
+			load.PeakCPU = max(load.PeakCPU, s.CPU)
+			load.short = false
+		}
+		last = s.Time
+		load.PeakRSS = max(load.PeakRSS, s.RSS)
+		rssTotal += s.RSS
+	}
+	load.AvgRSS = rssTotal / uint64(len(m.samples))
+	load.AvgCPU = float64(m.endCPU-m.startCPU) / float64(last.Sub(m.start))
+	load.cpu = m.endCPU - m.startCPU
+	return load
+}
+
+// Work out our CPU time per GB, now that we know how much we read.
+func (l *clientLoad) setBytes(bytes int64) {
+	if l == nil || bytes == 0 {
+		return
+	}
+	l.Drain = *drainFlag
+	l.CPUPerGB = l.cpu.Seconds() / (float64(bytes) / 1e9)
+}
+
+// Print the averages and peaks, and warn if we may have been the
+// bottleneck.
+func (l *clientLoad) print() {
+	if l == nil {
+		return
+	}
+	peak := fmt.Sprintf("%.0f%% peak", 100*l.PeakCPU)
+	if l.short {
+		peak = "no peak in a run this short"
+	}
+	fmt.Printf("Client load: CPU %.0f%% of a core on average, %s; RSS %s on average, %s peak\n",
+		100*l.AvgCPU, peak, units.bytes(int64(l.AvgRSS)), units.bytes(int64(l.PeakRSS)))
+	if l.CPUPerGB > 0 {
+		fmt.Printf("Client CPU: %.3fs per GB read, with --drain=%s\n", l.CPUPerGB, l.Drain)
+	}
+	if l.clientBound() {
+		fmt.Printf("WARNING: the client used more than %.0f%% of a core at times, so these results may be client-bound\n", 100*clientBoundCPU)
+	}
+}
+
+// Did we use more than clientBoundCPU of a core for a whole interval?
+func (l *clientLoad) clientBound() bool {
+	return l.PeakCPU > clientBoundCPU
+}
@@ -0,0 +1,36 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Return the CPU time this process has used so far, and its current
+// resident set size in bytes.
+func processUsage() (cpu time.Duration, rss uint64, ok bool) {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return 0, 0, false
+	}
+	cpu = time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+
+	// The second field of statm is the resident size in pages.
+	b, err := os.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0, 0, false
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) < 2 {
+		return 0, 0, false
+	}
+	pages, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return cpu, pages * uint64(os.Getpagesize()), true
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package main
+
+import "testing"
+
+func TestClientLoadUnavailable(t *testing.T) {
+	if _, _, ok := processUsage(); ok {
+		t.Errorf("processUsage() worked")
+	}
+	m := startClientLoad()
+	if m != nil {
+		t.Fatalf("startClientLoad() = %+v, want nil", m)
+	}
+	if load := m.stop(); load != nil {
+		t.Errorf("stop() = %+v, want nil", load)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClientLoadCPUPerGB(t *testing.T) {
+	setFlag(t, drainFlag, "copy")
+	l := &clientLoad{cpu: 2 * time.Second}
+	l.setBytes(4e9)
+	if l.CPUPerGB != 0.5 || l.Drain != "copy" {
+		t.Errorf("2s of CPU for 4 GB: %g s/GB with --drain=%s, want 0.5 with copy", l.CPUPerGB, l.Drain)
+	}
+
+	l = &clientLoad{cpu: time.Second}
+	l.setBytes(0)
+	if l.CPUPerGB != 0 {
+		t.Errorf("no bytes read: %g s/GB", l.CPUPerGB)
+	}
+	var none *clientLoad
+	none.setBytes(1e9)
+}
+
+// The short sample taken when the run stops doesn't count towards the
+// peak, so a busy last moment, or a run shorter than a second, isn't
+// mistaken for being client-bound.
+func TestClientLoadShortSamples(t *testing.T) {
+	start := time.Now()
+	at := func(d time.Duration) time.Time { return start.Add(d) }
+	for _, tc := range []struct {
+		name    string
+		samples []clientLoadSample
+		peak    float64
+		short   bool
+		bound   bool
+	}{
+		{"shorter than a second", []clientLoadSample{{Time: at(200 * time.Millisecond), CPU: 1.5}}, 0, true, false},
+		{"busy at the end", []clientLoadSample{
+			{Time: at(time.Second), CPU: 0.3},
+			{Time: at(2 * time.Second), CPU: 0.4},
+			{Time: at(2100 * time.Millisecond), CPU: 2},
+		}, 0.4, false, false},
+		{"a late tick", []clientLoadSample{
+			{Time: at(1100 * time.Millisecond), CPU: 0.2},
+			{Time: at(1900 * time.Millisecond), CPU: 0.9},
+			{Time: at(2000 * time.Millisecond), CPU: 0.1},
+		}, 0.9, false, true},
+		{"a full last second", []clientLoadSample{
+			{Time: at(time.Second), CPU: 0.5},
+			{Time: at(2 * time.Second), CPU: 0.95},
+		}, 0.95, false, true},
+	} {
+		m := &clientLoadMonitor{start: start, startCPU: time.Second, endCPU: 2 * time.Second, samples: tc.samples}
+		l := m.summarize()
+		if l.PeakCPU != tc.peak || l.short != tc.short || l.clientBound() != tc.bound {
+			t.Errorf("%s: peak %g, short %v, client-bound %v; want %g, %v, %v", tc.name, l.PeakCPU, l.short, l.clientBound(), tc.peak, tc.short, tc.bound)
+		}
+		if want := float64(time.Second) / float64(tc.samples[len(tc.samples)-1].Time.Sub(start)); l.AvgCPU != want {
+			t.Errorf("%s: average %g, want %g", tc.name, l.AvgCPU, want)
+		}
+	}
+}
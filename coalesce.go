@@ -0,0 +1,109 @@
+package main
+
+// One proposed mitigation on the Caddy side is to coalesce nearby
+// small range requests into one larger upstream request and slice
+// the result locally.  --coalesce simulates that, so we can see how
+// much it would reduce the number of requests that SeaweedFS sees.
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// readRange is a single logical read from the benchmark.
+type readRange struct {
+	offset int64
+	size   int64
+}
+
+// coalescedGroup is one upstream request, covering one or more
+// logical reads.
+type coalescedGroup struct {
+	offset int64
+	size   int64
+	reads  []readRange
+}
+
+// Merge reads that start within `window` bytes of the end of the
+// previous read into a single upstream request, as long as the
+// merged request doesn't grow past `maxSize` bytes.  Reads are
+// merged in order; we don't look ahead for out-of-order neighbors.
+func coalesceReads(reads []readRange, window, maxSize int64) []coalescedGroup {
+	var groups []coalescedGroup
+
+	for _, r := range reads {
+		if len(groups) > 0 {
+			g := &groups[len(groups)-1]
+			end := g.offset + g.size
+			newEnd := max(end, r.offset+r.size)
+			if r.offset >= g.offset && r.offset <= end+window && newEnd-g.offset <= maxSize {
+				g.size = newEnd - g.offset
+				g.reads = append(g.reads, r)
+				continue
+			}
+		}
+		groups = append(groups, coalescedGroup{offset: r.offset, size: r.size, reads: []readRange{r}})
+	}
+
+	return groups
+}
+
+// Fetch a single byte range with a ranged GetObject, bypassing s3fs.
+func getRange(ctx context.Context, client *s3.Client, filename string, offset, size int64) ([]byte, error) {
+	out, err := client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: bucket,
+		Key:    aws.String(filename),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+size-1)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer out.Body.Close()
+
+	b := make([]byte, size)
+	n, err := io.ReadFull(out.Body, b)
+	return b[:n], err
+}
+
+// Run the benchmark with coalescing, printing one line per logical
+// read and a summary comparing logical and upstream traffic.
+func runCoalesced(ctx context.Context, client *s3.Client, filename string, reads []readRange, window, maxSize int64) error {
+	groups := coalesceReads(reads, window, maxSize)
+	ctx, collector := collectOperations(ctx)
+
+	var logicalBytes, upstreamBytes int64
+	start := time.Now()
+
+	for _, g := range groups {
+		b, err := getRange(ctx, client, filename, g.offset, g.size)
+		if err != nil {
+			return err
+		}
+		upstreamBytes += int64(len(b))
+		fetched := time.Since(start)
+
+		// Serve the logical reads from the merged buffer.
+		for _, r := range g.reads {
+			lo := min(r.offset-g.offset, int64(len(b)))
+			hi := min(lo+r.size, int64(len(b)))
+			logicalBytes += hi - lo
+			fmt.Printf("Read %d bytes at offset %d from upstream request %d-%d (at %.3fs)\n", hi-lo, r.offset, g.offset, g.offset+g.size-1, fetched.Seconds())
+		}
+	}
+	dur := time.Since(start)
+
+	upstreamAttempts := 0
+	for _, op := range collector.Operations() {
+		upstreamAttempts += len(op.Attempts)
+	}
+
+	fmt.Printf("Logical: %d reads, %s in %.3f seconds at %s%s\n", len(reads), units.bytes(logicalBytes), dur.Seconds(), units.rate(logicalBytes, dur), ceiling.describe(logicalBytes, dur))
+	fmt.Printf("Upstream: %d requests (%d attempts), %d bytes, coalescing window %d bytes\n", len(groups), upstreamAttempts, upstreamBytes, window)
+
+	return nil
+}
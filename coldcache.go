@@ -0,0 +1,135 @@
+package main
+
+// SeaweedFS caches chunks on the filer and volume servers, so the
+// second pass over a file measures something quite different from
+// the first.  With --cold-cache we benchmark a brand new copy of the
+// object instead, so every read hits data that nobody has read yet.
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// coldCopy describes the object made by makeColdCopy.
+type coldCopy struct {
+	Key string
+	How string // a description of how it was created
+
+	// For generated objects, the seed for generatedByte().
+	Generated bool
+	Seed      uint64
+}
+
+// Make a copy of `key` under a new random name, server-side if
+// possible.  If the gateway doesn't support CopyObject, upload a
+// freshly generated object of the same size instead.
+func makeColdCopy(ctx context.Context, client *s3.Client, key string, size int64) (*coldCopy, error) {
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, err
+	}
+	newKey := key + ".s3test-cold-" + hex.EncodeToString(suffix)
+
+	_, err := client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:     bucket,
+		Key:        aws.String(newKey),
+		CopySource: aws.String(copySource(*bucket, key)),
+	})
+	if err == nil {
+		return &coldCopy{Key: newKey, How: "server-side copy of " + key}, nil
+	}
+	fmt.Printf("CopyObject failed (%v), uploading a generated %d byte object instead\n", err, size)
+
+	seed := binary.LittleEndian.Uint64(suffix)
+	_, err = client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:        bucket,
+		Key:           aws.String(newKey),
+		Body:          newGeneratedReader(seed, size),
+		ContentLength: aws.Int64(size),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to create cold-cache object: %w", err)
+	}
+	return &coldCopy{Key: newKey, How: fmt.Sprintf("generated upload (seed %d)", seed), Generated: true, Seed: seed}, nil
+}
+
+// Remove the copy made by makeColdCopy.
+func deleteColdCopy(ctx context.Context, client *s3.Client, key string) {
+	_, err := client.DeleteObject(exemptFromLimits(ctx), &s3.DeleteObjectInput{
+		Bucket: bucket,
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		fmt.Printf("Unable to delete cold-cache object %q: %v\n", key, err)
+	}
+}
+
+// CopySource wants "bucket/key", URL-encoded, but with the slashes
+// in the key left alone.
+func copySource(bucket, key string) string {
+	return bucket + "/" + escapeKey(key)
+}
+
+// generatedReader produces `size` bytes of deterministic
+// pseudo-random data derived from `seed`, without ever holding the
+// whole thing in memory.  It's seekable so the SDK can hash the body
+// before sending it.
+type generatedReader struct {
+	seed   uint64
+	size   int64
+	offset int64
+}
+
+func newGeneratedReader(seed uint64, size int64) *generatedReader {
+	return &generatedReader{seed: seed, size: size}
+}
+
+// Return the byte at `offset` of the stream generated from `seed`.
+// Each 8-byte word is an independent splitmix64 output, so any
+// offset can be computed without generating what comes before it.
+func generatedByte(seed uint64, offset int64) byte {
+	z := seed + uint64(offset/8+1)*0x9e3779b97f4a7c15
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	z ^= z >> 31
+	return byte(z >> (8 * uint(offset%8)))
+}
+
+func (g *generatedReader) Read(p []byte) (int, error) {
+	if g.offset >= g.size {
+		return 0, io.EOF
+	}
+	if remaining := g.size - g.offset; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	for i := range p {
+		p[i] = generatedByte(g.seed, g.offset+int64(i))
+	}
+	g.offset += int64(len(p))
+	return len(p), nil
+}
+
+func (g *generatedReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += g.offset
+	case io.SeekEnd:
+		offset += g.size
+	default:
+		return 0, errors.New("generatedReader.Seek: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("generatedReader.Seek: negative position")
+	}
+	g.offset = offset
+	return offset, nil
+}
@@ -0,0 +1,351 @@
+package main
+
+// `s3test conformance FILE` checks that the gateway handles Range
+// requests the way RFC 7233 says it should, since correctness bugs
+// there look a lot like performance bugs from the client's side.  It
+// runs a fixed set of cases (single, open-ended, suffix, and
+// multi-range requests, invalid ranges, and If-Range) against one
+// object and prints PASS or FAIL for each, along with what the server
+// actually sent.
+//
+// Requests are plain HTTP, presigned unless --mode=http, because the
+// SDK won't send multi-range requests or parse multipart/byteranges
+// responses.  The object is downloaded once first, to know what the
+// responses should contain; use something small.
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// contentRange is a parsed Content-Range header.  For unsatisfied
+// ranges ("bytes */1234"), only `total` is set.
+type contentRange struct {
+	start, end, total int64 // end is inclusive
+	unsatisfied       bool
+}
+
+// Parse a Content-Range header like "bytes 0-99/1234".
+func parseContentRange(header string) (contentRange, bool) {
+	spec, found := strings.CutPrefix(header, "bytes ")
+	if !found {
+		return contentRange{}, false
+	}
+	rng, total, found := strings.Cut(spec, "/")
+	if !found {
+		return contentRange{}, false
+	}
+	var cr contentRange
+	var err error
+	if total == "*" {
+		cr.total = -1
+	} else if cr.total, err = strconv.ParseInt(total, 10, 64); err != nil {
+		return contentRange{}, false
+	}
+	if rng == "*" {
+		cr.unsatisfied = true
+		return cr, true
+	}
+	first, last, found := strings.Cut(rng, "-")
+	if !found {
+		return contentRange{}, false
+	}
+	cr.start, err = strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return contentRange{}, false
+	}
+	cr.end, err = strconv.ParseInt(last, 10, 64)
+	if err != nil || cr.end < cr.start {
+		return contentRange{}, false
+	}
+	return cr, true
+}
+
+// byteRangePart is one part of a multipart/byteranges response.
+type byteRangePart struct {
+	contentRange
+	body []byte
+}
+
+// Parse a multipart/byteranges body, given the response's
+// Content-Type.
+func parseByteRanges(contentType string, body io.Reader) ([]byteRangePart, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, err
+	}
+	if mediaType != "multipart/byteranges" {
+		return nil, fmt.Errorf("content type is %s, not multipart/byteranges", mediaType)
+	}
+	if params["boundary"] == "" {
+		return nil, fmt.Errorf("multipart/byteranges without a boundary")
+	}
+
+	var parts []byteRangePart
+	r := multipart.NewReader(body, params["boundary"])
+	for {
+		p, err := r.NextPart()
+		if err == io.EOF {
+			return parts, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		cr, ok := parseContentRange(p.Header.Get("Content-Range"))
+		if !ok || cr.unsatisfied {
+			return nil, fmt.Errorf("part %d has a bad Content-Range %q", len(parts)+1, p.Header.Get("Content-Range"))
+		}
+		b, err := io.ReadAll(p)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, byteRangePart{contentRange: cr, body: b})
+	}
+}
+
+// conformanceCase is one request and how to judge the response.
+type conformanceCase struct {
+	name    string
+	rng     string // the Range header
+	ifRange string // the If-Range header, if any
+
+	// Returns "" if the response is acceptable, or what's wrong
+	// with it.  `body` has been read in full.
+	check func(resp *http.Response, body []byte) string
+}
+
+// conformanceRunner holds what the cases need to know about the
+// object.
+type conformanceRunner struct {
+	url  func(ctx context.Context) (string, error)
+	data []byte
+	etag string
+}
+
+// Expect a 206 with exactly [start, end] of the object.
+func (c *conformanceRunner) expectRange(start, end int64) func(*http.Response, []byte) string {
+	return func(resp *http.Response, body []byte) string {
+		if resp.StatusCode != http.StatusPartialContent {
+			return fmt.Sprintf("expected 206 for bytes %d-%d", start, end)
+		}
+		return c.checkPart(resp.Header.Get("Content-Range"), body, start, end)
+	}
+}
+
+// Check one range of a response against the object.
+func (c *conformanceRunner) checkPart(header string, body []byte, start, end int64) string {
+	size := int64(len(c.data))
+	cr, ok := parseContentRange(header)
+	switch {
+	case !ok || cr.unsatisfied:
+		return fmt.Sprintf("bad Content-Range %q", header)
+	case cr.start != start || cr.end != end || cr.total != size:
+		return fmt.Sprintf("expected Content-Range bytes %d-%d/%d", start, end, size)
+	case int64(len(body)) != end-start+1:
+		return fmt.Sprintf("expected %d bytes of body, got %d", end-start+1, len(body))
+	case !bytes.Equal(body, c.data[start:end+1]):
+		return "body doesn't match the object's contents"
+	}
+	return ""
+}
+
+// Expect a 200 with the whole object.
+func (c *conformanceRunner) expectWhole(resp *http.Response, body []byte) string {
+	if resp.StatusCode != http.StatusOK {
+		return "expected 200 with the whole object"
+	}
+	if !bytes.Equal(body, c.data) {
+		return "body isn't the whole object"
+	}
+	return ""
+}
+
+// Expect a 416 saying how big the object is.
+func (c *conformanceRunner) expectUnsatisfiable(resp *http.Response, body []byte) string {
+	if resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		return "expected 416"
+	}
+	cr, ok := parseContentRange(resp.Header.Get("Content-Range"))
+	if !ok || !cr.unsatisfied || cr.total != int64(len(c.data)) {
+		return fmt.Sprintf("expected Content-Range bytes */%d", len(c.data))
+	}
+	return ""
+}
+
+// Servers may ignore or reject an invalid Range, so accept either a
+// 200 with the whole object or a 416.
+func (c *conformanceRunner) expectIgnoredOrRejected(resp *http.Response, body []byte) string {
+	if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		return ""
+	}
+	if resp.StatusCode == http.StatusOK && bytes.Equal(body, c.data) {
+		return ""
+	}
+	return "expected 200 with the whole object, or 416"
+}
+
+// Expect the ranges in `want` (start and end pairs).  A server may
+// ignore multi-range requests and send the whole object, or coalesce
+// close ranges into one, so both of those are fine too.
+func (c *conformanceRunner) expectMulti(want [][2]int64) func(*http.Response, []byte) string {
+	return func(resp *http.Response, body []byte) string {
+		if resp.StatusCode == http.StatusOK {
+			return c.expectWhole(resp, body)
+		}
+		if resp.StatusCode != http.StatusPartialContent {
+			return "expected 206 or 200"
+		}
+		if !strings.HasPrefix(resp.Header.Get("Content-Type"), "multipart/") {
+			// Coalesced into a single range.
+			return c.checkPart(resp.Header.Get("Content-Range"), body, want[0][0], want[len(want)-1][1])
+		}
+		parts, err := parseByteRanges(resp.Header.Get("Content-Type"), bytes.NewReader(body))
+		if err != nil {
+			return err.Error()
+		}
+		if len(parts) != len(want) {
+			return fmt.Sprintf("expected %d parts, got %d", len(want), len(parts))
+		}
+		for i, p := range parts {
+			if problem := c.checkPart(fmt.Sprintf("bytes %d-%d/%d", p.start, p.end, p.total), p.body, want[i][0], want[i][1]); problem != "" {
+				return fmt.Sprintf("part %d: %s", i+1, problem)
+			}
+		}
+		return ""
+	}
+}
+
+// Return the cases to run against an object of `size` bytes.
+func (c *conformanceRunner) cases() []conformanceCase {
+	size := int64(len(c.data))
+	return []conformanceCase{
+		{name: "first byte", rng: "bytes=0-0", check: c.expectRange(0, 0)},
+		{name: "single range", rng: "bytes=10-49", check: c.expectRange(10, 49)},
+		{name: "open-ended range", rng: "bytes=10-", check: c.expectRange(10, size-1)},
+		{name: "suffix range", rng: "bytes=-20", check: c.expectRange(size-20, size-1)},
+		{name: "suffix longer than the object", rng: fmt.Sprintf("bytes=-%d", size+100), check: c.expectRange(0, size-1)},
+		{name: "range past the end", rng: fmt.Sprintf("bytes=%d-%d", size-10, size+100), check: c.expectRange(size-10, size-1)},
+		{name: "range starting at the end", rng: fmt.Sprintf("bytes=%d-", size), check: c.expectUnsatisfiable},
+		{name: "multiple ranges", rng: "bytes=0-9,40-49", check: c.expectMulti([][2]int64{{0, 9}, {40, 49}})},
+		{name: "reversed range", rng: "bytes=20-10", check: c.expectIgnoredOrRejected},
+		{name: "garbage range", rng: "bytes=abc", check: c.expectIgnoredOrRejected},
+		{name: "unknown range unit", rng: "items=0-9", check: c.expectWhole},
+		{name: "If-Range with the current ETag", rng: "bytes=10-49", ifRange: c.etag, check: c.expectRange(10, 49)},
+		{name: "If-Range with a stale ETag", rng: "bytes=10-49", ifRange: `"00000000000000000000000000000000"`, check: c.expectWhole},
+	}
+}
+
+// Send one request, returning the response and its body.
+func (c *conformanceRunner) do(ctx context.Context, rng, ifRange string) (*http.Response, []byte, error) {
+	u, err := c.url(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if rng != "" {
+		req.Header.Set("Range", rng)
+	}
+	if ifRange != "" {
+		req.Header.Set("If-Range", ifRange)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return resp, body, err
+}
+
+// Run the conformance subcommand.
+func runConformance(args []string) int {
+	if len(args) != 1 {
+		fmt.Printf("Usage: s3test conformance [--endpoint=...] [--bucket=...] FILE\n")
+		return 1
+	}
+	filename := args[0]
+	ctx := context.Background()
+
+	c := &conformanceRunner{
+		url: func(context.Context) (string, error) { return objectURL(filename), nil },
+	}
+	if *mode != "http" {
+		_, client, err := connect(ctx)
+		if err != nil {
+			panic(err)
+		}
+		presign := s3.NewPresignClient(client)
+		c.url = func(ctx context.Context) (string, error) {
+			req, err := presign.PresignGetObject(ctx, &s3.GetObjectInput{
+				Bucket: bucket,
+				Key:    aws.String(filename),
+			})
+			if err != nil {
+				return "", err
+			}
+			return req.URL, nil
+		}
+	}
+
+	resp, body, err := c.do(ctx, "", "")
+	if err != nil {
+		fmt.Printf("Unable to fetch %s: %v\n", filename, err)
+		return 1
+	}
+	if resp.StatusCode != http.StatusOK {
+		fmt.Printf("Unable to fetch %s: HTTP %d\n", filename, resp.StatusCode)
+		return 1
+	}
+	if len(body) < 100 {
+		fmt.Printf("%s is only %d bytes; conformance tests need at least 100\n", filename, len(body))
+		return 1
+	}
+	c.data = body
+	c.etag = resp.Header.Get("ETag")
+	fmt.Printf("Testing %s (%d bytes, ETag %s)\n", filename, len(c.data), c.etag)
+
+	cases := c.cases()
+	failed := 0
+	for _, tc := range cases {
+		if tc.ifRange != "" && c.etag == "" {
+			fmt.Printf("SKIP %s: the server didn't send an ETag\n", tc.name)
+			continue
+		}
+		resp, body, err := c.do(ctx, tc.rng, tc.ifRange)
+		if err != nil {
+			failed++
+			fmt.Printf("FAIL %s: %v\n", tc.name, err)
+			continue
+		}
+		got := fmt.Sprintf("Range %q -> HTTP %d, %d bytes", tc.rng, resp.StatusCode, len(body))
+		if cr := resp.Header.Get("Content-Range"); cr != "" {
+			got += ", Content-Range " + cr
+		}
+		if problem := tc.check(resp, body); problem != "" {
+			failed++
+			fmt.Printf("FAIL %s: %s (%s)\n", tc.name, problem, got)
+		} else {
+			fmt.Printf("PASS %s (%s)\n", tc.name, got)
+		}
+	}
+
+	if failed > 0 {
+		fmt.Printf("%d of %d cases failed\n", failed, len(cases))
+		return 1
+	}
+	fmt.Printf("All cases passed\n")
+	return 0
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+func TestParseContentRange(t *testing.T) {
+	for header, want := range map[string]contentRange{
+		"bytes 0-99/1234":  {start: 0, end: 99, total: 1234},
+		"bytes 5-5/6":      {start: 5, end: 5, total: 6},
+		"bytes 0-99/*":     {start: 0, end: 99, total: -1},
+		"bytes */1234":     {total: 1234, unsatisfied: true},
+		"bytes 10-20/5000": {start: 10, end: 20, total: 5000},
+	} {
+		if got, ok := parseContentRange(header); !ok || got != want {
+			t.Errorf("parseContentRange(%q) = %+v, %v, want %+v", header, got, ok, want)
+		}
+	}
+	for _, header := range []string{"", "bytes", "bytes 0-99", "items 0-99/100", "bytes 99-0/100", "bytes 0-/100", "bytes a-b/100", "bytes 0-99/lots"} {
+		if got, ok := parseContentRange(header); ok {
+			t.Errorf("parseContentRange(%q) = %+v", header, got)
+		}
+	}
+}
+
+// Build a multipart/byteranges body with a part for each of
+// `ranges`, which are Content-Range headers ("" for none), with
+// `bodies`.
+func byteRanges(t *testing.T, ranges []string, bodies []string) (string, []byte) {
+	t.Helper()
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	for i, cr := range ranges {
+		h := textproto.MIMEHeader{"Content-Type": {"video/mp4"}}
+		if cr != "" {
+			h.Set("Content-Range", cr)
+		}
+		p, err := w.CreatePart(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.Write([]byte(bodies[i]))
+	}
+	w.Close()
+	return "multipart/byteranges; boundary=" + w.Boundary(), buf.Bytes()
+}
+
+func TestParseByteRanges(t *testing.T) {
+	contentType, body := byteRanges(t, []string{"bytes 0-4/20", "bytes 10-14/20"}, []string{"abcde", "klmno"})
+	parts, err := parseByteRanges(contentType, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 2 {
+		t.Fatalf("got %d parts", len(parts))
+	}
+	for i, want := range []struct {
+		cr   contentRange
+		body string
+	}{{contentRange{start: 0, end: 4, total: 20}, "abcde"}, {contentRange{start: 10, end: 14, total: 20}, "klmno"}} {
+		if parts[i].contentRange != want.cr || string(parts[i].body) != want.body {
+			t.Errorf("part %d = %+v %q, want %+v %q", i, parts[i].contentRange, parts[i].body, want.cr, want.body)
+		}
+	}
+
+	// A boundary that shows up in the data doesn't end the part.
+	contentType, body = byteRanges(t, []string{"bytes 0-9/10"}, []string{"--x--y\r\n--"})
+	if parts, err := parseByteRanges(contentType, bytes.NewReader(body)); err != nil || len(parts) != 1 || string(parts[0].body) != "--x--y\r\n--" {
+		t.Errorf("boundary-like data: %+v, %v", parts, err)
+	}
+
+	contentType, body = byteRanges(t, []string{"bytes 0-4/20", ""}, []string{"abcde", "klmno"})
+	if _, err := parseByteRanges(contentType, bytes.NewReader(body)); err == nil || !strings.Contains(err.Error(), "part 2 has a bad Content-Range") {
+		t.Errorf("a part without a Content-Range: %v", err)
+	}
+	contentType, body = byteRanges(t, []string{"bytes */20"}, []string{""})
+	if _, err := parseByteRanges(contentType, bytes.NewReader(body)); err == nil {
+		t.Errorf("an unsatisfied part worked")
+	}
+
+	// Cut off partway through the second part.
+	contentType, body = byteRanges(t, []string{"bytes 0-4/20", "bytes 10-14/20"}, []string{"abcde", "klmno"})
+	cut := bytes.Index(body, []byte("klm")) + 2
+	if _, err := parseByteRanges(contentType, bytes.NewReader(body[:cut])); err == nil {
+		t.Errorf("a truncated body worked")
+	}
+
+	for _, contentType := range []string{"multipart/byteranges", "multipart/mixed; boundary=x", "video/mp4", ""} {
+		if _, err := parseByteRanges(contentType, bytes.NewReader(body)); err == nil {
+			t.Errorf("Content-Type %q worked", contentType)
+		}
+	}
+}
+
+// expectMulti catches parts that are framed correctly but don't
+// match their Content-Range.
+func TestExpectMulti(t *testing.T) {
+	c := &conformanceRunner{data: []byte("abcdefghijklmnopqrst")}
+	check := c.expectMulti([][2]int64{{0, 4}, {10, 14}})
+	respond := func(ranges, bodies []string) string {
+		contentType, body := byteRanges(t, ranges, bodies)
+		resp := &http.Response{StatusCode: http.StatusPartialContent, Header: http.Header{"Content-Type": {contentType}}}
+		return check(resp, body)
+	}
+
+	if problem := respond([]string{"bytes 0-4/20", "bytes 10-14/20"}, []string{"abcde", "klmno"}); problem != "" {
+		t.Errorf("good parts: %s", problem)
+	}
+	for _, tc := range []struct {
+		ranges, bodies []string
+		want           string
+	}{
+		{[]string{"bytes 0-4/20", "bytes 10-14/20"}, []string{"abcde", "klm"}, "part 2: expected 5 bytes of body, got 3"},
+		{[]string{"bytes 0-4/20", "bytes 10-14/20"}, []string{"abcde", "KLMNO"}, "part 2: body doesn't match"},
+		{[]string{"bytes 0-4/20", "bytes 11-15/20"}, []string{"abcde", "lmnop"}, "part 2: expected Content-Range bytes 10-14/20"},
+		{[]string{"bytes 0-4/20"}, []string{"abcde"}, "expected 2 parts, got 1"},
+	} {
+		if problem := respond(tc.ranges, tc.bodies); !strings.Contains(problem, tc.want) {
+			t.Errorf("%q: got %q, want %q", tc.bodies, problem, tc.want)
+		}
+	}
+
+	resp := &http.Response{StatusCode: http.StatusPartialContent, Header: http.Header{"Content-Range": {fmt.Sprintf("bytes 0-14/%d", len(c.data))}}}
+	if problem := check(resp, c.data[:15]); problem != "" {
+		t.Errorf("coalesced into one range: %s", problem)
+	}
+}
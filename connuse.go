@@ -0,0 +1,127 @@
+package main
+
+// If the client stops reusing connections partway through a run,
+// because the pool is exhausted or bodies aren't being drained, the
+// gateway sees a burst of new connections that changes how it
+// behaves, and it looks just like the server getting slower.  So the
+// transport numbers every connection it gets, notes whether it was
+// reused, and attributes it to the read that used it.
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// connectionSet collects the IDs of the connections used by one read.
+type connectionSet struct {
+	mu  sync.Mutex
+	ids []int
+}
+
+type connectionSetKey struct{}
+
+// Return a context that collects the connections used by requests
+// made with it.
+func collectConnections(ctx context.Context) (context.Context, *connectionSet) {
+	c := &connectionSet{}
+	return context.WithValue(ctx, connectionSetKey{}, c), c
+}
+
+func (c *connectionSet) add(id int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !slices.Contains(c.ids, id) {
+		c.ids = append(c.ids, id)
+	}
+}
+
+// Return the distinct connection IDs seen so far.
+func (c *connectionSet) IDs() []int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.ids)
+}
+
+// connectionUsage summarizes how reads used connections.
+type connectionUsage struct {
+	Opened int `json:"opened"`
+	Reused int `json:"reused"` // requests that got a previously-used connection
+
+	// How many reads used each connection, indexed by connection
+	// ID.
+	ReadsPerConnection []int `json:"readsPerConnection"`
+
+	// How many new connections were opened in each tenth of the
+	// run, by read start time.
+	OpenedByDecile []int `json:"openedByDecile"`
+}
+
+// Summarize connection use from the recorded requests and the
+// samples, which have their Connections filled in.
+func analyzeConnectionUse(requests []*recordedRequest, samples []*Sample) *connectionUsage {
+	u := &connectionUsage{OpenedByDecile: make([]int, 10)}
+	for _, r := range requests {
+		if r.connID < 0 {
+			continue
+		}
+		// GotConnInfo.Reused isn't the whole story: a
+		// connection dialed for one request can end up idle
+		// and be handed to another, which counts as reuse.
+		for len(u.ReadsPerConnection) <= r.connID {
+			u.ReadsPerConnection = append(u.ReadsPerConnection, 0)
+			u.Opened++
+		}
+		if r.connReused {
+			u.Reused++
+		}
+	}
+
+	sorted := slices.Clone(samples)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Start.Before(sorted[j].Start) })
+	seen := map[int]bool{}
+	for i, s := range sorted {
+		for _, id := range s.Connections {
+			if id < len(u.ReadsPerConnection) {
+				u.ReadsPerConnection[id]++
+			}
+			if !seen[id] {
+				seen[id] = true
+				u.OpenedByDecile[i*10/len(sorted)]++
+			}
+		}
+	}
+	return u
+}
+
+// Print the connection summary.
+func (u *connectionUsage) print() {
+	if u.Opened+u.Reused == 0 {
+		return
+	}
+	fmt.Printf("Connections: %d opened, reused for %d requests\n", u.Opened, u.Reused)
+
+	dist := map[int]int{}
+	for _, n := range u.ReadsPerConnection {
+		dist[n]++
+	}
+	var counts []int
+	for n := range dist {
+		counts = append(counts, n)
+	}
+	slices.Sort(counts)
+	var parts []string
+	for _, n := range counts {
+		parts = append(parts, fmt.Sprintf("%d with %d", dist[n], n))
+	}
+	fmt.Printf("  reads per connection: %s\n", strings.Join(parts, ", "))
+
+	parts = parts[:0]
+	for _, n := range u.OpenedByDecile {
+		parts = append(parts, fmt.Sprint(n))
+	}
+	fmt.Printf("  new connections in each tenth of the run: %s\n", strings.Join(parts, " "))
+}
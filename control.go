@@ -0,0 +1,227 @@
+package main
+
+// For hour-long soak runs, it's handy to be able to pause the load
+// (say, while grabbing a heap profile from the filer) without losing
+// the run's statistics.  --control-socket listens on a Unix socket
+// for one-word commands, one per line:
+//
+//   - pause: stop handing out new reads once the current ones finish
+//   - resume: carry on
+//   - status: where the run is, and its statistics so far
+//   - stop: end the run early, but still print the summary
+//
+// `s3test ctl SOCKET COMMAND` sends a command and prints the reply.
+// The socket is only accessible to its owner.  Time spent paused is
+// left out of the throughput numbers, and pauses are recorded in the
+// --jsonl output as "pause" and "resume" events.
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// controlEvent marks a pause or resume in the --jsonl output.
+type controlEvent struct {
+	Time time.Time `json:"time"`
+}
+
+// Pause or resume the benchmark, returning false if it was already
+// in that state.
+func (b *benchmark) setPaused(paused bool) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.paused == paused {
+		return false
+	}
+	now := time.Now()
+	b.paused = paused
+	rec := jsonlRecord{Type: "resume", Event: &controlEvent{Time: now}}
+	if paused {
+		b.pausedAt = now
+		rec.Type = "pause"
+	} else {
+		b.pausedTotal += now.Sub(b.pausedAt)
+		if b.unpaused != nil {
+			b.unpaused.Broadcast()
+		}
+	}
+	b.stream.send(rec)
+	if b.jsonl != nil {
+		if err := b.jsonl.write(rec); err != nil {
+			b.fail(err)
+		}
+	}
+	return true
+}
+
+// Stop handing out reads, so the run ends as soon as the current
+// ones finish.
+func (b *benchmark) stop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.stopped = true
+	if b.unpaused != nil {
+		b.unpaused.Broadcast()
+	}
+}
+
+// Wait until the benchmark isn't paused.
+func (b *benchmark) waitWhilePaused() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.paused && !b.stopped {
+		if b.unpaused == nil {
+			b.unpaused = sync.NewCond(&b.mu)
+		}
+		b.unpaused.Wait()
+	}
+}
+
+// Return how long the benchmark has spent paused, including any
+// pause that's still going.
+func (b *benchmark) pausedFor() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	d := b.pausedTotal
+	if b.paused {
+		d += time.Since(b.pausedAt)
+	}
+	return d
+}
+
+// Describe the run so far, for the status command.
+func (b *benchmark) status(start time.Time) string {
+	paused := b.pausedFor()
+	active := time.Since(start) - paused
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	state := "running"
+	if b.stopped {
+		state = "stopping"
+	} else if b.paused {
+		state = "paused"
+	}
+	var offset int64
+	if len(b.asked) > 0 {
+		offset = b.asked[len(b.asked)-1].offset
+	}
+	return fmt.Sprintf("%s: %d reads, last at offset %d; read %s in %.3f seconds at %s, paused for %.3f seconds\nReads: %s",
+		state, len(b.asked), offset, units.bytes(b.totalBytes), active.Seconds(), units.rate(b.totalBytes, active),
+		paused.Seconds(), computeLatencyStats(b.latencies))
+}
+
+// Listen on `path` for control commands for `b`, which started at
+// `start`.  The returned function stops listening and removes the
+// socket.
+func serveControl(path string, b *benchmark, start time.Time) (func(), error) {
+	return listenControl(path, func(conn net.Conn) { handleControl(conn, b, start) })
+}
+
+// Listen on `path`, calling `handle` in its own goroutine for each
+// connection.
+func listenControl(path string, handle func(net.Conn)) (func(), error) {
+	// A stale socket from a killed run would make Listen fail.
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go handle(conn)
+		}
+	}()
+
+	return func() {
+		l.Close()
+		os.Remove(path)
+	}, nil
+}
+
+// Answer commands on `conn` until it's closed.
+func handleControl(conn net.Conn, b *benchmark, start time.Time) {
+	defer conn.Close()
+	s := bufio.NewScanner(conn)
+	for s.Scan() {
+		var reply string
+		switch cmd := strings.TrimSpace(s.Text()); cmd {
+		case "pause":
+			reply = "already paused"
+			if b.setPaused(true) {
+				fmt.Printf("Paused via the control socket\n")
+				reply = "paused"
+			}
+		case "resume":
+			reply = "not paused"
+			if b.setPaused(false) {
+				fmt.Printf("Resumed via the control socket\n")
+				reply = "resumed"
+			}
+		case "status":
+			reply = b.status(start)
+		case "stop":
+			fmt.Printf("Stopping via the control socket\n")
+			b.stop()
+			reply = "stopping"
+		default:
+			reply = fmt.Sprintf("unknown command %q; use pause, resume, status, or stop", cmd)
+		}
+		// An empty line ends each reply.
+		if _, err := fmt.Fprintf(conn, "%s\n\n", reply); err != nil {
+			return
+		}
+	}
+}
+
+// Run the ctl subcommand.
+func runCtl(args []string) int {
+	if len(args) != 2 {
+		fmt.Printf("Usage: s3test ctl SOCKET pause|resume|status|stop\n")
+		return 1
+	}
+	conn, err := net.Dial("unix", args[0])
+	if err != nil {
+		fmt.Printf("Unable to connect to %s: %v\n", args[0], err)
+		return 1
+	}
+	defer conn.Close()
+
+	if _, err := fmt.Fprintf(conn, "%s\n", args[1]); err != nil {
+		fmt.Printf("%v\n", err)
+		return 1
+	}
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if line == "\n" {
+			return 0
+		}
+		fmt.Print(line)
+		if errors.Is(err, io.EOF) {
+			return 0
+		}
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			return 1
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+// Matching a slow read on our side with the request in SeaweedFS's
+// logs is guesswork without something in common.  So each read gets a
+// random ID, which is sent as a header (X-S3test-Read-Id by default)
+// on every request made for that read and recorded in its sample.
+// Then `grep` on the filer logs finds the exact server-side request.
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+type readIDKey struct{}
+
+// Return a new random read ID.
+func newReadID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Return the sample that took the longest, or nil if there aren't
+// any.
+func slowestSample(samples []*Sample) *Sample {
+	var slow *Sample
+	for _, s := range samples {
+		if slow == nil || s.Duration > slow.Duration {
+			slow = s
+		}
+	}
+	return slow
+}
+
+// Return a context that carries the read ID `id`.
+func withReadID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, readIDKey{}, id)
+}
+
+// Add the correlation header for the read in `ctx` to `header`, if
+// there is one and the header isn't disabled.
+func setReadIDHeader(ctx context.Context, header http.Header) {
+	if *noCorrelation {
+		return
+	}
+	if id, ok := ctx.Value(readIDKey{}).(string); ok {
+		header.Set(*correlationHeader, id)
+	}
+}
+
+// SDK middleware that adds the correlation header to every request.
+// Use this in s3.Options.APIOptions.
+func addReadID(stack *middleware.Stack) error {
+	return stack.Build.Add(middleware.BuildMiddlewareFunc("AddReadID",
+		func(ctx context.Context, in middleware.BuildInput, next middleware.BuildHandler) (middleware.BuildOutput, middleware.Metadata, error) {
+			if req, ok := in.Request.(*smithyhttp.Request); ok {
+				setReadIDHeader(ctx, req.Header)
+			}
+			return next.HandleBuild(ctx, in)
+		}), middleware.After)
+}
@@ -0,0 +1,70 @@
+package main
+
+// Is it actually cheaper for SeaweedFS to serve one full-object GET
+// than a pile of range requests covering the same bytes?
+// --compare-coverage runs --mode=fullobject and the ranged pattern
+// back to back on the same file and prints one table comparing them.
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// coverageRun is one row of the --compare-coverage table.
+type coverageRun struct {
+	mode          string
+	reads         int
+	bytes         int64
+	duration      time.Duration
+	amplification *amplificationReport
+}
+
+// Run `sched` with the full-object backend and then with `mode`, and
+// print the comparison.
+func compareCoverage(ctx context.Context, client *s3.Client, etag, filename string, filesize int64, discovery *sizeDiscovery, sched *schedule, mode string) error {
+	var runs []coverageRun
+	for _, m := range []string{"fullobject", mode} {
+		s := sched
+		if m == "fullobject" {
+			s = fullObjectSchedule(filename, filesize)
+		}
+		backend, err := newBackend(m, client, defaultTarget(), etag)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Coverage: reading %s with --mode=%s\n", filename, m)
+		b := &benchmark{
+			ctx:       ctx,
+			client:    client,
+			backend:   backend,
+			filename:  filename,
+			filesize:  filesize,
+			discovery: discovery,
+			result:    &Result{},
+		}
+		before := len(upstream.Requests())
+		start := time.Now()
+		if _, err := b.runSchedule(s); err != nil {
+			return err
+		}
+		dur := time.Since(start)
+
+		runs = append(runs, coverageRun{
+			mode:          m,
+			reads:         len(b.asked),
+			bytes:         b.totalBytes,
+			duration:      dur,
+			amplification: analyzeAmplification(upstream.Requests()[before:], filename, filesize, b.asked),
+		})
+	}
+
+	fmt.Printf("%-12s %8s %12s %10s %12s %10s %14s\n", "mode", "reads", "bytes", "seconds", units.rateUnit(), "GETs", "wire bytes")
+	for _, r := range runs {
+		fmt.Printf("%-12s %8d %12d %10.3f %12.3f %10d %14d\n", r.mode, r.reads, r.bytes, r.duration.Seconds(), units.rateValue(r.bytes, r.duration), r.amplification.Requests, r.amplification.ReceivedBytes)
+	}
+	return nil
+}
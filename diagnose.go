@@ -0,0 +1,287 @@
+package main
+
+// When a cluster starts misbehaving, the first half hour goes on
+// running the same handful of checks, and only some of the people on
+// call know which ones.  --diagnose runs them in order:
+//
+//   - stat: --pattern=open-storm, to see whether Opens alone are slow
+//   - tail-first: the last 256 kB, then the first, the way a
+//     player reads an MP4 with the index at the end
+//   - sequential-256k and sequential-16m: short sampled passes at
+//     both read sizes
+//   - same-range: every worker reading the same range at once
+//   - amplification: the 256 kB pass again with --mode=getobject, one
+//     GET per read, to compare its upstream bytes with the s3fs pass
+//
+// Each step runs s3test again (with the same flags and file, plus the
+// step's own) and gets --diagnose-budget/6 before it's killed.  A step
+// that fails or runs out of time doesn't stop the rest, and its
+// --jsonl log still gives whatever reads it finished.  At the end
+// there's one table in analyze's format, each step's findings, and a
+// few comparisons between steps, and --json gets all of it.
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/scottlaird/s3test/schema"
+)
+
+// diagnoseStep is one step of --diagnose, and how it went.
+type diagnoseStep struct {
+	Name          string               `json:"name"`
+	Description   string               `json:"description"`
+	Args          []string             `json:"args"`
+	Status        string               `json:"status"` // ok, failed, timed out, or skipped
+	ExitCode      int                  `json:"exitCode,omitempty"`
+	Elapsed       time.Duration        `json:"elapsedNs"`
+	Row           *analysisRow         `json:"row,omitempty"`
+	Amplification *amplificationReport `json:"amplification,omitempty"`
+	Findings      []finding            `json:"findings,omitempty"`
+
+	readsize int64 // with samples, for a sampled sequential pass
+	samples  int
+
+	result *Result      // from the step's --json, if it finished
+	run    *analyzedRun // from its --json, or its --jsonl if not
+	tail   []string     // the end of its output
+}
+
+// diagnoseResult is what --json gets for --diagnose.
+type diagnoseResult struct {
+	SchemaVersion string          `json:"schemaVersion"` // see the schema package
+	Start         time.Time       `json:"start"`
+	Budget        time.Duration   `json:"budgetNs"`
+	Steps         []*diagnoseStep `json:"steps"`
+	Findings      []finding       `json:"findings,omitempty"` // comparisons between steps
+}
+
+const (
+	diagnoseSteps     = 6
+	diagnoseTailLines = 10
+)
+
+// Flags that the steps set themselves, and so are removed from the
+// command line the steps get.
+var diagnoseOwnFlags = []string{
+	"diagnose", "diagnose-budget", "schedule", "control-socket", "json", "jsonl", "stream-fifo", "plan",
+	"pattern", "readsize", "sample", "range", "concurrency", "iterations", "mode", "tui",
+}
+
+// Run the diagnostic battery for `args`, this run's command line.
+func runDiagnose(args []string, filename string) int {
+	dir, err := os.MkdirTemp("", "s3test-diagnose-")
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+
+	base := withoutFlags(args, diagnoseOwnFlags...)
+	res := &diagnoseResult{SchemaVersion: schema.Version, Start: time.Now(), Budget: *diagnoseBudget}
+	perStep := *diagnoseBudget / diagnoseSteps
+	fmt.Printf("Diagnosing %s: %d steps, up to %v each\n", filename, diagnoseSteps, perStep)
+
+	steps := []*diagnoseStep{
+		{Name: "stat", Description: "Open and close the object, without reading", Args: []string{"--pattern=open-storm", "--concurrency=4", "--iterations=10"}},
+		{Name: "tail-first", Description: "read the last 256 kB, then the first"},
+		{Name: "sequential-256k", Description: "sampled sequential reads of 256 kB", readsize: 262144, samples: 40},
+		{Name: "sequential-16m", Description: "sampled sequential reads of 16 MB", readsize: 16777216, samples: 8},
+		{Name: "same-range", Description: "8 workers reading the same range at once, then disjoint ranges", Args: []string{"--pattern=same-range", "--concurrency=8", "--iterations=5", "--readsize=262144"}},
+		{Name: "amplification", Description: "the 256 kB pass with one GetObject per read, for comparison", Args: []string{"--mode=getobject"}, readsize: 262144, samples: 40},
+	}
+	for i, step := range steps {
+		size := diagnoseFileSize(steps[0])
+		if step.samples > 0 {
+			step.Args = append(step.Args, fmt.Sprintf("--readsize=%d", step.readsize))
+			// The whole file, if it's too small to sample.
+			if size <= 0 || size/step.readsize > int64(step.samples) {
+				step.Args = append(step.Args, fmt.Sprintf("--sample=%d", step.samples))
+			}
+		}
+		if step.Name == "tail-first" {
+			if size <= 0 {
+				step.Status = "skipped"
+				fmt.Printf("[%d/%d] %s: skipped, since the stat step didn't find the file's size; use --filesize\n", i+1, len(steps), step.Name)
+				res.Steps = append(res.Steps, step)
+				continue
+			}
+			n := min(size, 262144)
+			step.Args = []string{"--readsize=262144", fmt.Sprintf("--range=%d:%d", size-n, n), fmt.Sprintf("--range=0:%d", n)}
+		}
+		fmt.Printf("[%d/%d] %s: %s\n", i+1, len(steps), step.Name, step.Description)
+		step.execute(dir, base, perStep)
+		fmt.Printf("[%d/%d] %s: %s in %.1fs\n", i+1, len(steps), step.Name, step.Status, step.Elapsed.Seconds())
+		res.Steps = append(res.Steps, step)
+	}
+
+	printDiagnosis(res)
+	if *jsonOut != "" {
+		if err := writeJSON(*jsonOut, res); err != nil {
+			fmt.Printf("Unable to write %s: %v\n", *jsonOut, err)
+			return 1
+		}
+	}
+	return 0
+}
+
+// Return the file size that `stat` found, or --filesize.
+func diagnoseFileSize(stat *diagnoseStep) int64 {
+	if *filesizeFlag >= 0 {
+		return *filesizeFlag
+	}
+	if stat.run != nil && stat.run.Run != nil {
+		return stat.run.Run.FileSize
+	}
+	return 0
+}
+
+// Run the step, killing it after `budget`, and load its results.
+func (s *diagnoseStep) execute(dir string, base []string, budget time.Duration) {
+	self, err := os.Executable()
+	if err != nil {
+		self = os.Args[0]
+	}
+	jsonFile := filepath.Join(dir, s.Name+".json")
+	jsonlFile := filepath.Join(dir, s.Name+".jsonl")
+	args := append([]string{"--json=" + jsonFile, "--jsonl=" + jsonlFile}, s.Args...)
+	args = append(args, base...)
+
+	ctx, cancel := context.WithTimeout(context.Background(), budget)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, self, args...)
+	for _, e := range os.Environ() {
+		if !strings.HasPrefix(e, flagEnvName("diagnose")+"=") && !strings.HasPrefix(e, flagEnvName("schedule")+"=") {
+			cmd.Env = append(cmd.Env, e)
+		}
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		s.Status = "failed"
+		s.tail = []string{err.Error()}
+		return
+	}
+	cmd.Stderr = cmd.Stdout
+
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		s.Status = "failed"
+		s.tail = []string{err.Error()}
+		return
+	}
+	scanner := bufio.NewScanner(out)
+	for scanner.Scan() {
+		s.tail = append(s.tail, scanner.Text())
+		if len(s.tail) > diagnoseTailLines {
+			s.tail = s.tail[1:]
+		}
+	}
+	err = cmd.Wait()
+	s.Elapsed = time.Since(start)
+
+	var exitErr *exec.ExitError
+	switch {
+	case ctx.Err() != nil:
+		s.Status = "timed out"
+	case errors.As(err, &exitErr):
+		s.Status = "failed"
+		s.ExitCode = exitErr.ExitCode()
+	case err != nil:
+		s.Status = "failed"
+		s.tail = append(s.tail, err.Error())
+	default:
+		s.Status = "ok"
+	}
+
+	if result, err := loadResult(jsonFile); err == nil {
+		s.result = result
+		s.run = analyzeResult(s.Name, result)
+		s.Amplification = result.Amplification
+		s.Findings = result.Findings
+	} else if records, err := readJSONL(jsonlFile); err == nil && len(records) > 0 {
+		s.run = analyzeRecords(s.Name, records)
+	}
+	if s.run != nil {
+		row := combineRuns(s.Name, []*analyzedRun{s.run})
+		s.Row = &row
+	}
+}
+
+// Compare the steps with each other.
+func diagnoseFindings(steps []*diagnoseStep) []finding {
+	var findings []finding
+	add := func(metric, format string, args ...any) {
+		findings = append(findings, finding{Text: fmt.Sprintf(format, args...), Metric: metric})
+	}
+	byName := map[string]*diagnoseStep{}
+	for _, s := range steps {
+		byName[s.Name] = s
+	}
+
+	if s3fs, get := byName["sequential-256k"].Amplification, byName["amplification"].Amplification; s3fs != nil && get != nil && get.Ratio() > 0 {
+		add("steps[sequential-256k].amplification, steps[amplification].amplification",
+			"the 256 kB reads requested %.1fx the bytes they asked for upstream through --mode=%s, and %.1fx with one GetObject per read",
+			s3fs.Ratio(), *mode, get.Ratio())
+	}
+	if small, big := byName["sequential-256k"].Row, byName["sequential-16m"].Row; small != nil && big != nil && small.Mbps > 0 {
+		var c *networkCeiling
+		if r := byName["sequential-16m"].result; r != nil {
+			c = r.Ceiling
+		}
+		add("steps[sequential-256k].row.mbps, steps[sequential-16m].row.mbps",
+			"16 MB reads ran at %s%s and 256 kB reads at %s%s (%.1fx)",
+			units.rate(big.Bytes, big.Duration), c.describe(big.Bytes, big.Duration), units.rate(small.Bytes, small.Duration), c.describe(small.Bytes, small.Duration), big.Mbps/small.Mbps)
+	}
+	if tail, seq := byName["tail-first"].result, byName["sequential-256k"].Row; tail != nil && seq != nil && len(tail.Samples) > 0 && tail.Samples[0].Err == "" {
+		add("steps[tail-first].samples[0].durationNs, steps[sequential-256k].row.latency.p50Ns",
+			"reading the tail first took %.3fs, against a p50 of %.3fs for 256 kB sequential reads",
+			tail.Samples[0].Duration.Seconds(), seq.Latency.P50.Seconds())
+	}
+	if stat, seq := byName["stat"].Row, byName["sequential-256k"].Row; stat != nil && seq != nil && seq.Latency.P50 > 0 {
+		add("steps[stat].row.latency.p50Ns, steps[sequential-256k].row.latency.p50Ns",
+			"a bare Open took %.3fs at p50, %.0f%% of a whole 256 kB read", stat.Latency.P50.Seconds(), 100*float64(stat.Latency.P50)/float64(seq.Latency.P50))
+	}
+	return findings
+}
+
+// Print the combined report.
+func printDiagnosis(res *diagnoseResult) {
+	res.Findings = diagnoseFindings(res.Steps)
+
+	fmt.Printf("\nDiagnosis:\n")
+	var rows []analysisRow
+	for _, s := range res.Steps {
+		if s.Row != nil {
+			rows = append(rows, *s.Row)
+		}
+	}
+	if len(rows) > 0 {
+		printAnalysisTable(rows)
+	}
+	for _, s := range res.Steps {
+		fmt.Printf("%s: %s", s.Name, s.Status)
+		if s.ExitCode != 0 {
+			fmt.Printf(" (exit status %d)", s.ExitCode)
+		}
+		if s.Amplification != nil {
+			fmt.Printf(", %.2fx amplification", s.Amplification.Ratio())
+		}
+		fmt.Printf("\n")
+		for _, f := range s.Findings {
+			fmt.Printf("  - %s [%s]\n", f.Text, f.Metric)
+		}
+		if s.Status == "failed" || s.Status == "timed out" {
+			for _, line := range s.tail {
+				fmt.Printf("  | %s\n", line)
+			}
+		}
+	}
+	printFindings(res.Findings)
+}
@@ -0,0 +1,132 @@
+package main
+
+// At 16-256 MB reads, allocating a buffer for every read and copying
+// the body into it is a real fraction of the measured time.  --drain
+// picks how readFrom() empties each response:
+//
+//   - readfull: io.ReadFull into a buffer allocated for the read, the
+//     way we always have.
+//   - copy: io.Copy into a buffer that's reused from read to read, so
+//     there's no allocation, but io.Copy adds its own 32 KB bounce
+//     buffer.
+//   - discard: io.Copy to io.Discard, which keeps nothing, so it
+//     can't be combined with anything that checks the data.
+//
+// Whichever it is, the body goes through drainReader, which stops at
+// the end of the read (or at --cancel-after) and does the stall and
+// MP4 checks on each Read, so the strategies only differ in where the
+// bytes end up.  The summary reports client CPU per GB, to compare
+// them, and bench_test.go has a benchmark for each.
+
+import (
+	"io"
+	"sync"
+)
+
+var drainStrategies = []string{"readfull", "copy", "discard"}
+
+// drainReader is a response body as readFrom() sees it: cut off after
+// `limit` bytes, and checked as it goes.
+type drainReader struct {
+	r      io.Reader
+	offset int64 // of the read, in the file
+	limit  int64
+	n      int64 // bytes read so far
+	stall  *stallWatch
+	sample *Sample
+}
+
+func (d *drainReader) Read(p []byte) (int, error) {
+	if d.n >= d.limit {
+		return 0, io.EOF
+	}
+	p = p[:min(int64(len(p)), d.limit-d.n)]
+	n, err := d.r.Read(p)
+	if mp4Index != nil && d.sample.MP4Error == "" {
+		if msg := checkMP4(mp4Index, d.offset+d.n, p[:n]); msg != "" {
+			d.sample.MP4Error = msg
+			d.sample.warn("MP4 validation: " + msg)
+		}
+	}
+	d.n += int64(n)
+	err = d.stall.check(n, err)
+	if d.n >= d.limit {
+		// Ranged responses end exactly where we stop, so this
+		// Read may have returned EOF along with the last of the
+		// data.
+		return n, nil
+	}
+	return n, err
+}
+
+// Empty `d` per --drain, returning the first error other than the body
+// ending where it should.
+func drain(d *drainReader, strategy string) error {
+	var err error
+	switch strategy {
+	case "copy":
+		w := getDrainBuffer(bufferSize(d.limit))
+		_, err = io.Copy(w, d)
+		putDrainBuffer(w)
+	case "discard":
+		_, err = io.Copy(io.Discard, d)
+	default:
+		b := buffers.get(bufferSize(d.limit))
+		if int64(len(b)) == d.limit {
+			_, err = io.ReadFull(d, b)
+		} else {
+			// --memory-policy=stream: keep overwriting the
+			// scratch buffer.
+			for err == nil && d.n < d.limit {
+				_, err = io.ReadFull(d, b[:min(int64(len(b)), d.limit-d.n)])
+			}
+		}
+		buffers.put(b)
+	}
+	if (err == nil || err == io.EOF) && d.n < d.limit {
+		// io.Copy treats EOF as success, and io.ReadFull returns
+		// a plain EOF if the body ends between chunks.
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// drainBuffer is an io.Writer over a reused buffer.  With
+// --memory-policy=stream the buffer is smaller than the read, and
+// wraps around.
+type drainBuffer struct {
+	b   []byte
+	pos int
+}
+
+func (w *drainBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if w.pos == len(w.b) {
+			w.pos = 0
+		}
+		c := copy(w.b[w.pos:], p)
+		w.pos += c
+		p = p[c:]
+	}
+	return n, nil
+}
+
+var drainBuffers sync.Pool
+
+// Return a drainBuffer of `size` bytes, reusing an old one if there's
+// one big enough.
+func getDrainBuffer(size int64) *drainBuffer {
+	buffers.reserve(size)
+	w, _ := drainBuffers.Get().(*drainBuffer)
+	if w == nil || int64(cap(w.b)) < size {
+		w = &drainBuffer{b: make([]byte, size)}
+	}
+	w.b, w.pos = w.b[:size], 0
+	return w
+}
+
+func putDrainBuffer(w *drainBuffer) {
+	buffers.release(int64(len(w.b)))
+	drainBuffers.Put(w)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"testing"
+	"testing/iotest"
+)
+
+// Drain `limit` bytes of `body` with `strategy`, and return what was
+// pulled out of it, and the error.
+func drainAll(t *testing.T, body io.Reader, limit int64, strategy string) ([]byte, error) {
+	t.Helper()
+	var got bytes.Buffer
+	d := &drainReader{r: io.TeeReader(body, &got), limit: limit, stall: &stallWatch{}, sample: &Sample{}}
+	err := drain(d, strategy)
+	if d.n != int64(got.Len()) {
+		t.Errorf("%s: counted %d bytes, read %d", strategy, d.n, got.Len())
+	}
+	return got.Bytes(), err
+}
+
+func TestDrain(t *testing.T) {
+	data := make([]byte, 3*scratchSize+12345)
+	rand.NewChaCha8([32]byte{}).Read(data)
+	readers := map[string]func([]byte) io.Reader{
+		"plain":       func(b []byte) io.Reader { return bytes.NewReader(b) },
+		"one byte":    func(b []byte) io.Reader { return iotest.OneByteReader(bytes.NewReader(b)) },
+		"half":        func(b []byte) io.Reader { return iotest.HalfReader(bytes.NewReader(b)) },
+		"EOF at once": func(b []byte) io.Reader { return iotest.DataErrReader(bytes.NewReader(b)) },
+	}
+	for _, stream := range []bool{false, true} {
+		for _, strategy := range drainStrategies {
+			for name, reader := range readers {
+				t.Run(fmt.Sprintf("%s/stream=%v/%s", strategy, stream, name), func(t *testing.T) {
+					setFlag(t, drainFlag, strategy)
+					setFlag(t, &streamReads, stream)
+					for _, limit := range []int64{1, scratchSize - 1, scratchSize, scratchSize + 1, int64(len(data))} {
+						if name == "one byte" && limit > scratchSize {
+							continue
+						}
+						got, err := drainAll(t, reader(data[:limit]), limit, strategy)
+						if err != nil {
+							t.Fatalf("%d bytes: %v", limit, err)
+						}
+						if !bytes.Equal(got, data[:limit]) {
+							t.Errorf("%d bytes: read %d bytes that don't match", limit, len(got))
+						}
+
+						// A longer body is cut off at the limit.
+						got, err = drainAll(t, reader(data), limit, strategy)
+						if err != nil || !bytes.Equal(got, data[:limit]) {
+							t.Errorf("%d bytes of a longer body: read %d bytes, %v", limit, len(got), err)
+						}
+
+						// A shorter one is an error.
+						if _, err := drainAll(t, reader(data[:limit-1]), limit, strategy); !errors.Is(err, io.ErrUnexpectedEOF) {
+							t.Errorf("%d bytes of a body one byte short: %v", limit, err)
+						}
+					}
+					if buffers.current != 0 {
+						t.Errorf("%d bytes of buffers still reserved", buffers.current)
+						buffers.current = 0
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestDrainError(t *testing.T) {
+	broken := errors.New("connection reset")
+	for _, strategy := range drainStrategies {
+		setFlag(t, drainFlag, strategy)
+		body := io.MultiReader(bytes.NewReader(make([]byte, 1000)), iotest.ErrReader(broken))
+		got, err := drainAll(t, body, 2000, strategy)
+		if !errors.Is(err, broken) || len(got) != 1000 {
+			t.Errorf("%s: read %d bytes, %v", strategy, len(got), err)
+		}
+	}
+}
+
+// The copy strategy's buffer wraps around when it's smaller than the
+// read, and ends up holding the tail of it.
+func TestDrainBuffer(t *testing.T) {
+	w := &drainBuffer{b: make([]byte, 4)}
+	for _, s := range []string{"ab", "cde", "fghij", "k"} {
+		if n, err := w.Write([]byte(s)); n != len(s) || err != nil {
+			t.Fatalf("Write(%q) = %d, %v", s, n, err)
+		}
+	}
+	if string(w.b) != "ijkh" || w.pos != 3 {
+		t.Errorf("the buffer holds %q at %d, want \"ijkh\" at 3", w.b, w.pos)
+	}
+}
+
+func TestBufferSize(t *testing.T) {
+	for _, tc := range []struct {
+		strategy string
+		stream   bool
+		size     int64
+		want     int64
+	}{
+		{"readfull", false, 256 << 20, 256 << 20},
+		{"readfull", true, 256 << 20, scratchSize},
+		{"readfull", true, 1000, 1000},
+		{"copy", true, 256 << 20, scratchSize},
+		{"discard", false, 256 << 20, 0},
+	} {
+		setFlag(t, drainFlag, tc.strategy)
+		setFlag(t, &streamReads, tc.stream)
+		if got := bufferSize(tc.size); got != tc.want {
+			t.Errorf("%s with stream=%v: bufferSize(%d) = %d, want %d", tc.strategy, tc.stream, tc.size, got, tc.want)
+		}
+	}
+}
+
+// Each strategy reads the same bytes from the fake server, and holds
+// on to as much buffer as it says.
+func TestDrainStrategiesRead(t *testing.T) {
+	data := make([]byte, 4<<20)
+	rand.NewChaCha8([32]byte{}).Read(data)
+	setFlag(t, mode, "getobject")
+	setFlag(t, &streamReads, false)
+	client := fakeS3(t, map[string][]byte{"big.mp4": data})
+	backend, err := newBackend(*mode, client, defaultTarget(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const size = 1 << 20
+	for strategy, peak := range map[string]int64{"readfull": size, "copy": size, "discard": 0} {
+		setFlag(t, drainFlag, strategy)
+		buffers.peak = 0
+		for offset := int64(0); offset < int64(len(data)); offset += size {
+			sample, err := readFrom(context.Background(), backend, "big.mp4", offset, size, int64(len(data)))
+			if err != nil {
+				t.Fatalf("%s at %d: %v", strategy, offset, err)
+			}
+			if sample.Bytes != size {
+				t.Errorf("%s at %d: read %d bytes", strategy, offset, sample.Bytes)
+			}
+		}
+		if got := buffers.Peak(); got != peak {
+			t.Errorf("%s: peak buffer use %d, want %d", strategy, got, peak)
+		}
+	}
+}
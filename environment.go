@@ -0,0 +1,129 @@
+package main
+
+// Old results are hard to interpret without knowing which client
+// library versions the binary was built with, and which server the
+// endpoint's hostname pointed at that day.  So we record both, print
+// them at startup, and include them in the structured output.
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"strings"
+)
+
+// Environment describes the client build and the endpoint's address.
+type Environment struct {
+	GoVersion   string   `json:"goVersion"`
+	SDKVersion  string   `json:"sdkVersion"`
+	S3Version   string   `json:"s3Version"`
+	S3FSVersion string   `json:"s3fsVersion"`
+	SDKV1       string   `json:"sdkV1Version,omitempty"` // aws-sdk-go v1, with --mode=getobject-v1
+	Host        string   `json:"host"`
+	Resolved    []string `json:"resolved,omitempty"`         // every A/AAAA record
+	Dialed      []string `json:"dialed,omitempty"`           // the addresses we actually connected to
+	Overrides   []string `json:"resolveOverrides,omitempty"` // from --resolve
+	Proxy       string   `json:"proxy,omitempty"`            // from --socks5
+}
+
+// Return the version of module `path` built into this binary, or
+// "unknown".
+func moduleVersion(info *debug.BuildInfo, path string) string {
+	if info != nil {
+		for _, dep := range info.Deps {
+			if dep.Path == path {
+				if dep.Replace != nil {
+					return dep.Replace.Version + " (replaced)"
+				}
+				return dep.Version
+			}
+		}
+	}
+	return "unknown"
+}
+
+// Work out the client versions and resolve the endpoint's hostname.
+// Call this after the first request, so that Dialed is filled in.
+func collectEnvironment(ctx context.Context) *Environment {
+	info, _ := debug.ReadBuildInfo()
+	env := &Environment{
+		GoVersion:   runtime.Version(),
+		SDKVersion:  moduleVersion(info, "github.com/aws/aws-sdk-go-v2"),
+		S3Version:   moduleVersion(info, "github.com/aws/aws-sdk-go-v2/service/s3"),
+		S3FSVersion: moduleVersion(info, "github.com/jszwec/s3fs/v2"),
+	}
+	if v := moduleVersion(info, "github.com/aws/aws-sdk-go"); *mode == "getobject-v1" && v != "unknown" {
+		env.SDKV1 = v
+	}
+
+	if !usesS3(*mode) {
+		// --endpoint isn't what we're reading from, so there's
+		// nothing to resolve.
+		return env
+	}
+	if u, err := url.Parse(*endpoint); err == nil {
+		env.Host = u.Hostname()
+		if addrs, err := net.DefaultResolver.LookupHost(ctx, env.Host); err == nil {
+			env.Resolved = addrs
+		}
+	}
+	env.Dialed = upstream.dialed()
+	for _, o := range resolves {
+		env.Overrides = append(env.Overrides, o.String())
+	}
+	env.Proxy = socksDescription()
+
+	return env
+}
+
+// Print the startup banner.
+func (e *Environment) print() {
+	fmt.Printf("Client: %s, aws-sdk-go-v2 %s, service/s3 %s, s3fs %s\n", e.GoVersion, e.SDKVersion, e.S3Version, e.S3FSVersion)
+	if e.SDKV1 != "" {
+		fmt.Printf("Reads use aws-sdk-go v1 %s (--mode=getobject-v1); the numbers below are for the v1 SDK\n", e.SDKV1)
+	}
+	if e.Host == "" {
+		return
+	}
+	fmt.Printf("Endpoint: %s resolves to [%s], connected to [%s]\n", e.Host, strings.Join(e.Resolved, " "), strings.Join(e.Dialed, " "))
+	if len(e.Overrides) > 0 {
+		fmt.Printf("  with --resolve %s\n", strings.Join(e.Overrides, " "))
+	}
+	if e.Proxy != "" {
+		fmt.Printf("  through the SOCKS5 proxy %s, so connections show the proxy's address\n", e.Proxy)
+	}
+}
+
+// Return the differences in client versions between `e` and `other`.
+func (e *Environment) versionChanges(other *Environment) []string {
+	var changes []string
+	check := func(name, a, b string) {
+		if a != b {
+			changes = append(changes, fmt.Sprintf("%s %s -> %s", name, b, a))
+		}
+	}
+	check("aws-sdk-go-v2", e.SDKVersion, other.SDKVersion)
+	check("service/s3", e.S3Version, other.S3Version)
+	check("s3fs", e.S3FSVersion, other.S3FSVersion)
+	check("aws-sdk-go", e.SDKV1, other.SDKV1)
+	return changes
+}
+
+// Return the distinct remote addresses of the connections that
+// requests have gone out on so far.
+func (t *recordingTransport) dialed() []string {
+	var addrs []string
+	for _, r := range t.Requests() {
+		if r.conn == nil {
+			continue
+		}
+		if a := r.conn.RemoteAddr().String(); !slices.Contains(addrs, a) {
+			addrs = append(addrs, a)
+		}
+	}
+	return addrs
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+// Only S3 modes resolve --endpoint; localfs never talks to it.
+func TestCollectEnvironment(t *testing.T) {
+	setFlag(t, endpoint, "http://127.0.0.1:8333")
+	for m, host := range map[string]string{"localfs": "", "getobject": "127.0.0.1"} {
+		setFlag(t, mode, m)
+		env := collectEnvironment(context.Background())
+		if env.Host != host {
+			t.Errorf("--mode=%s: Host = %q, want %q", m, env.Host, host)
+		}
+		if want := []string{host}; host != "" && !slices.Equal(env.Resolved, want) {
+			t.Errorf("--mode=%s: resolved to %q, want %q", m, env.Resolved, want)
+		} else if host == "" && (env.Resolved != nil || env.Dialed != nil) {
+			t.Errorf("--mode=%s: resolved to %q, dialed %q", m, env.Resolved, env.Dialed)
+		}
+		if env.GoVersion == "" {
+			t.Errorf("--mode=%s: no Go version", m)
+		}
+	}
+}
+
+func TestVersionChanges(t *testing.T) {
+	a := &Environment{SDKVersion: "v1.37.1", S3Version: "v1.85.1", S3FSVersion: "v2.0.0"}
+	b := *a
+	if c := a.versionChanges(&b); c != nil {
+		t.Errorf("no changes: %q", c)
+	}
+	b.S3Version = "v1.80.0"
+	if c := a.versionChanges(&b); !slices.Equal(c, []string{"service/s3 v1.80.0 -> v1.85.1"}) {
+		t.Errorf("changed service/s3: %q", c)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Serve `objects` from a local stand-in for S3, with path-style
+// addressing in the bucket "test", point the flags at it, and return
+// a client for it.  It only does GET and HEAD, with ranges, and
+// ListObjects, without paging.
+func fakeS3(tb testing.TB, objects map[string][]byte) *s3.Client {
+	tb.Helper()
+	return serveFakeS3(tb, fakeBucket(objects))
+}
+
+// Return the handler for fakeS3(), for tests that wrap it.
+func fakeBucket(objects map[string][]byte) http.Handler {
+	modified := time.Now().Truncate(time.Second)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/test" || r.URL.Path == "/test/" {
+			listObjects(w, r, objects, modified)
+			return
+		}
+		data, ok := objects[strings.TrimPrefix(r.URL.Path, "/test/")]
+		if !ok {
+			http.Error(w, `<Error><Code>NoSuchKey</Code></Error>`, http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", `"fake"`)
+		http.ServeContent(w, r, "", modified, bytes.NewReader(data))
+	})
+}
+
+// Serve `handler` the way fakeS3() does.
+func serveFakeS3(tb testing.TB, handler http.Handler) *s3.Client {
+	tb.Helper()
+	server := httptest.NewServer(handler)
+	tb.Cleanup(server.Close)
+
+	setFlag(tb, bucket, "test")
+	setFlag(tb, endpoint, server.URL)
+	setFlag(tb, pathStyle, true)
+	t := target{Name: "fake", Endpoint: server.URL, Bucket: "test", Region: "us-east-1"}
+	t.config = &aws.Config{
+		Region: "us-east-1",
+		Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "test", SecretAccessKey: "test"}, nil
+		}),
+	}
+	_, client, err := connectTo(context.Background(), t)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return client
+}
+
+type listBucketResult struct {
+	XMLName        xml.Name `xml:"ListBucketResult"`
+	Name           string
+	Prefix         string
+	Delimiter      string
+	IsTruncated    bool
+	Contents       []listObject
+	CommonPrefixes []struct{ Prefix string }
+}
+
+type listObject struct {
+	Key          string
+	Size         int
+	LastModified time.Time
+}
+
+// Answer a ListObjects request for `objects`.
+func listObjects(w http.ResponseWriter, r *http.Request, objects map[string][]byte, modified time.Time) {
+	q := r.URL.Query()
+	result := listBucketResult{Name: "test", Prefix: q.Get("prefix"), Delimiter: q.Get("delimiter")}
+	var prefixes []string
+	for key, data := range objects {
+		rest, ok := strings.CutPrefix(key, result.Prefix)
+		if !ok {
+			continue
+		}
+		if i := strings.Index(rest, result.Delimiter); result.Delimiter != "" && i >= 0 {
+			if p := result.Prefix + rest[:i+len(result.Delimiter)]; !slices.Contains(prefixes, p) {
+				prefixes = append(prefixes, p)
+			}
+			continue
+		}
+		result.Contents = append(result.Contents, listObject{Key: key, Size: len(data), LastModified: modified.UTC()})
+	}
+	slices.SortFunc(result.Contents, func(a, b listObject) int { return strings.Compare(a.Key, b.Key) })
+	slices.Sort(prefixes)
+	for _, p := range prefixes {
+		result.CommonPrefixes = append(result.CommonPrefixes, struct{ Prefix string }{p})
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
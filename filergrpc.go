@@ -0,0 +1,511 @@
+//go:build filergrpc
+
+package main
+
+// --mode=filer-grpc skips both the S3 gateway and the filer's HTTP
+// path, and reads the way `weed mount` does: look the file's entry
+// up over the filer's gRPC API, find the volume servers that hold
+// the chunks we need, and fetch the byte ranges from them directly.
+// If these reads are fast while the S3 gateway is slow for the same
+// ranges, the problem is in the gateway or the filer's streaming, not
+// the volume servers.
+//
+// The filer's generated protobuf stubs drag in most of SeaweedFS, so
+// instead we encode the handful of messages we need by hand, and this
+// is only built with `-tags filergrpc`.  Field numbers are from
+// weed/pb/filer.proto.
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// filerChunk is the part of a filer_pb.FileChunk that we need.
+type filerChunk struct {
+	fileID    string
+	offset    int64
+	size      int64
+	mtime     int64
+	encrypted bool
+	manifest  bool
+}
+
+// filerEntry is the part of a filer_pb.Entry that we need.
+type filerEntry struct {
+	size    int64
+	chunks  []filerChunk
+	content []byte // small files are stored inline
+}
+
+type filerGRPCBackend struct {
+	conn      *grpc.ClientConn
+	bucketDir string
+	bucket    string
+
+	// Volume locations, by volume ID.  weed mount caches these
+	// too, so we only time the lookup the first time.
+	mu      sync.Mutex
+	volumes map[string]string
+}
+
+// This build supports --mode=filer-grpc.
+func checkFilerGRPC() error {
+	return nil
+}
+
+func newFilerGRPCBackend(addr, bucketDir, bucket string) (Backend, error) {
+	conn, err := dialFiler(addr)
+	if err != nil {
+		return nil, err
+	}
+	return &filerGRPCBackend{conn: conn, bucketDir: bucketDir, bucket: bucket, volumes: map[string]string{}}, nil
+}
+
+// The filer's entries can be large for files with many chunks.
+const filerMaxMessage = 64 << 20
+
+func dialFiler(addr string) (*grpc.ClientConn, error) {
+	if addr == "" {
+		return nil, fmt.Errorf("--mode=filer-grpc needs --filer-grpc-addr")
+	}
+	opts := []grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(rawCodec{}), grpc.MaxCallRecvMsgSize(filerMaxMessage)),
+	}
+	if socks != nil {
+		opts = append(opts, grpc.WithContextDialer(socksGRPCDialer))
+	}
+	return grpc.NewClient(addr, opts...)
+}
+
+// Return the size of `key` according to the filer at `addr`, for
+// discoverSize.
+func filerGRPCSize(ctx context.Context, addr, bucketDir, bucket, key string) (int64, error) {
+	conn, err := dialFiler(addr)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	e, err := lookupEntry(ctx, conn, bucketDir, bucket, key)
+	if err != nil {
+		return 0, err
+	}
+	return e.size, nil
+}
+
+func (b *filerGRPCBackend) Open(ctx context.Context, filename string, offset, size int64, sample *Sample) (io.ReadCloser, error) {
+	start := time.Now()
+	phaseCtx, endPhase := startPhase(ctx, "LookupDirectoryEntry")
+	e, err := lookupEntry(phaseCtx, b.conn, b.bucketDir, b.bucket, filename)
+	endPhase()
+	sample.addPhase("lookup", time.Since(start))
+	if err != nil {
+		return nil, err
+	}
+	sample.ObjectSize = e.size
+
+	if offset >= e.size {
+		return nil, io.EOF
+	}
+	end := min(offset+size, e.size)
+	if len(e.chunks) == 0 && e.content != nil {
+		content := e.content[min(offset, int64(len(e.content))):min(end, int64(len(e.content)))]
+		return io.NopCloser(bytes.NewReader(content)), nil
+	}
+
+	var readers []io.Reader
+	var bodies []io.Closer
+	closeAll := func() {
+		for _, c := range bodies {
+			c.Close()
+		}
+	}
+	for _, v := range visibleChunks(e.chunks, offset, end) {
+		if v.chunk == nil {
+			readers = append(readers, io.LimitReader(zeroReader{}, v.stop-v.start))
+			continue
+		}
+		body, err := b.fetchChunk(ctx, v, sample)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		readers = append(readers, body)
+		bodies = append(bodies, body)
+	}
+	return &multiReadCloser{Reader: io.MultiReader(readers...), closers: bodies}, nil
+}
+
+// Fetch one visible piece of a chunk straight from its volume server.
+func (b *filerGRPCBackend) fetchChunk(ctx context.Context, v visibleInterval, sample *Sample) (io.ReadCloser, error) {
+	c := v.chunk
+	if c.encrypted || c.manifest {
+		return nil, fmt.Errorf("chunk %s is encrypted or a chunk manifest, which --mode=filer-grpc doesn't support", c.fileID)
+	}
+	server, err := b.volumeServer(ctx, c.fileID, sample)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+server+"/"+c.fileID, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", rangeHeader(v.start-c.offset, v.stop-v.start))
+	setReadIDHeader(ctx, req.Header)
+
+	start := time.Now()
+	_, endPhase := startPhase(ctx, "chunk GET")
+	resp, err := httpClient.Do(req)
+	endPhase()
+	sample.addPhase("chunk", time.Since(start))
+	if err != nil {
+		return nil, err
+	}
+	sample.Status = resp.StatusCode
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return nil, &httpStatusError{StatusCode: resp.StatusCode, Body: string(msg)}
+	}
+	return resp.Body, nil
+}
+
+// Return the address of a volume server holding `fileID`.
+func (b *filerGRPCBackend) volumeServer(ctx context.Context, fileID string, sample *Sample) (string, error) {
+	vid, _, ok := strings.Cut(fileID, ",")
+	if !ok {
+		return "", fmt.Errorf("malformed file ID %q", fileID)
+	}
+	b.mu.Lock()
+	server, ok := b.volumes[vid]
+	b.mu.Unlock()
+	if ok {
+		return server, nil
+	}
+
+	start := time.Now()
+	phaseCtx, endPhase := startPhase(ctx, "LookupVolume")
+	server, err := lookupVolume(phaseCtx, b.conn, vid)
+	endPhase()
+	sample.addPhase("volume-lookup", time.Since(start))
+	if err != nil {
+		return "", err
+	}
+	b.mu.Lock()
+	b.volumes[vid] = server
+	b.mu.Unlock()
+	return server, nil
+}
+
+// Look up `key`'s entry with SeaweedFiler.LookupDirectoryEntry.
+func lookupEntry(ctx context.Context, conn *grpc.ClientConn, bucketDir, bucket, key string) (*filerEntry, error) {
+	dir, name := path.Split(path.Join("/", bucketDir, bucket, key))
+
+	var req []byte
+	req = protowire.AppendTag(req, 1, protowire.BytesType)
+	req = protowire.AppendString(req, path.Clean(dir))
+	req = protowire.AppendTag(req, 2, protowire.BytesType)
+	req = protowire.AppendString(req, name)
+
+	var resp []byte
+	if err := conn.Invoke(ctx, "/filer_pb.SeaweedFiler/LookupDirectoryEntry", req, &resp); err != nil {
+		return nil, err
+	}
+
+	var e *filerEntry
+	err := walkFields(resp, func(num protowire.Number, v []byte, _ uint64) error {
+		if num == 1 {
+			var err error
+			e, err = parseEntry(v)
+			return err
+		}
+		return nil
+	})
+	if err == nil && e == nil {
+		err = fmt.Errorf("filer returned no entry for %s", key)
+	}
+	return e, err
+}
+
+func parseEntry(b []byte) (*filerEntry, error) {
+	e := &filerEntry{}
+	err := walkFields(b, func(num protowire.Number, v []byte, _ uint64) error {
+		switch num {
+		case 3: // chunks
+			c, err := parseChunk(v)
+			if err != nil {
+				return err
+			}
+			e.chunks = append(e.chunks, c)
+		case 4: // attributes
+			return walkFields(v, func(num protowire.Number, _ []byte, x uint64) error {
+				if num == 1 { // file_size
+					e.size = int64(x)
+				}
+				return nil
+			})
+		case 9: // content
+			e.content = v
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Older filers don't always fill in file_size.
+	for _, c := range e.chunks {
+		e.size = max(e.size, c.offset+c.size)
+	}
+	if len(e.chunks) == 0 && e.content != nil {
+		e.size = max(e.size, int64(len(e.content)))
+	}
+	return e, nil
+}
+
+func parseChunk(b []byte) (filerChunk, error) {
+	var c filerChunk
+	var fid []byte
+	err := walkFields(b, func(num protowire.Number, v []byte, x uint64) error {
+		switch num {
+		case 1:
+			c.fileID = string(v)
+		case 2:
+			if x > math.MaxInt64 {
+				return fmt.Errorf("chunk offset %d is too large", x)
+			}
+			c.offset = int64(x)
+		case 3:
+			if x > math.MaxInt64 {
+				return fmt.Errorf("chunk size %d is too large", x)
+			}
+			c.size = int64(x)
+		case 4:
+			c.mtime = int64(x)
+		case 7:
+			fid = v
+		case 9:
+			c.encrypted = len(v) > 0
+		case 11:
+			c.manifest = x != 0
+		}
+		return nil
+	})
+	if err == nil {
+		// The entry's size is the end of its last chunk.
+		err = checkRange(c.offset, c.size)
+	}
+	if err != nil || c.fileID != "" || fid == nil {
+		return c, err
+	}
+
+	// Newer filers send a structured FileId instead of the
+	// string, so format it the way SeaweedFS does.
+	var volume, key, cookie uint64
+	err = walkFields(fid, func(num protowire.Number, _ []byte, x uint64) error {
+		switch num {
+		case 1:
+			volume = x
+		case 2:
+			key = x
+		case 3:
+			cookie = x
+		}
+		return nil
+	})
+	c.fileID = fmt.Sprintf("%d,%x%08x", volume, key, cookie)
+	return c, err
+}
+
+// Find a volume server for `volumeID` with SeaweedFiler.LookupVolume.
+func lookupVolume(ctx context.Context, conn *grpc.ClientConn, volumeID string) (string, error) {
+	var req []byte
+	req = protowire.AppendTag(req, 1, protowire.BytesType)
+	req = protowire.AppendString(req, volumeID)
+
+	var resp []byte
+	if err := conn.Invoke(ctx, "/filer_pb.SeaweedFiler/LookupVolume", req, &resp); err != nil {
+		return "", err
+	}
+
+	// locations_map is map<string, Locations>, where Locations
+	// is a list of Location{url, public_url, ...}.
+	var server string
+	err := walkFields(resp, func(num protowire.Number, v []byte, _ uint64) error {
+		if num != 1 || server != "" {
+			return nil
+		}
+		var key string
+		var locations []byte
+		err := walkFields(v, func(num protowire.Number, v []byte, _ uint64) error {
+			switch num {
+			case 1:
+				key = string(v)
+			case 2:
+				locations = v
+			}
+			return nil
+		})
+		if err != nil || key != volumeID {
+			return err
+		}
+		return walkFields(locations, func(num protowire.Number, v []byte, _ uint64) error {
+			if num != 1 || server != "" {
+				return nil
+			}
+			return walkFields(v, func(num protowire.Number, v []byte, _ uint64) error {
+				if num == 1 {
+					server = string(v)
+				}
+				return nil
+			})
+		})
+	})
+	if err == nil && server == "" {
+		err = fmt.Errorf("filer doesn't know where volume %s is", volumeID)
+	}
+	return server, err
+}
+
+// Call `fn` for each field in the protobuf message `b`, with the
+// contents of length-delimited fields in `v` and the value of numeric
+// fields in `x`.
+func walkFields(b []byte, fn func(num protowire.Number, v []byte, x uint64) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		var v []byte
+		var x uint64
+		switch typ {
+		case protowire.VarintType:
+			x, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed32Type:
+			var x32 uint32
+			x32, n = protowire.ConsumeFixed32(b)
+			x = uint64(x32)
+		case protowire.Fixed64Type:
+			x, n = protowire.ConsumeFixed64(b)
+		case protowire.BytesType:
+			v, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if err := fn(num, v, x); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// visibleInterval is a piece of the file, and the chunk that holds
+// its current contents, or nil for a hole.
+type visibleInterval struct {
+	start, stop int64
+	chunk       *filerChunk
+}
+
+// Work out which chunk is visible for each part of [start, stop).
+// When chunks overlap, the most recently written one wins, as in
+// SeaweedFS's own reader.
+func visibleChunks(chunks []filerChunk, start, stop int64) []visibleInterval {
+	var overlapping []*filerChunk
+	bounds := []int64{start, stop}
+	for i := range chunks {
+		c := &chunks[i]
+		lo, hi := c.offset, c.offset+c.size
+		if hi <= start || lo >= stop {
+			continue
+		}
+		overlapping = append(overlapping, c)
+		bounds = append(bounds, max(lo, start), min(hi, stop))
+	}
+	sort.Slice(bounds, func(i, j int) bool { return bounds[i] < bounds[j] })
+
+	var visible []visibleInterval
+	for i := 1; i < len(bounds); i++ {
+		lo, hi := bounds[i-1], bounds[i]
+		if lo == hi {
+			continue
+		}
+		var best *filerChunk
+		for _, c := range overlapping {
+			if c.offset <= lo && c.offset+c.size >= hi && (best == nil || c.mtime >= best.mtime) {
+				best = c
+			}
+		}
+		// Merge with the previous piece if it's the same chunk.
+		if n := len(visible); n > 0 && visible[n-1].chunk == best && visible[n-1].stop == lo {
+			visible[n-1].stop = hi
+			continue
+		}
+		visible = append(visible, visibleInterval{start: lo, stop: hi, chunk: best})
+	}
+	return visible
+}
+
+// rawCodec passes pre-encoded protobuf messages straight through, so
+// we don't need generated message types.
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v any) ([]byte, error) {
+	b, ok := v.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("rawCodec can't marshal %T", v)
+	}
+	return b, nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v any) error {
+	p, ok := v.(*[]byte)
+	if !ok {
+		return fmt.Errorf("rawCodec can't unmarshal into %T", v)
+	}
+	*p = append((*p)[:0], data...)
+	return nil
+}
+
+// The server only knows how to talk "proto".
+func (rawCodec) Name() string { return "proto" }
+
+// multiReadCloser reads from a series of chunk bodies, and closes
+// them all when it's closed.
+type multiReadCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (m *multiReadCloser) Close() error {
+	for _, c := range m.closers {
+		c.Close()
+	}
+	return nil
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
@@ -0,0 +1,25 @@
+//go:build !filergrpc
+
+package main
+
+// Without `-tags filergrpc`, --mode=filer-grpc just explains how to
+// get it.
+
+import (
+	"context"
+	"errors"
+)
+
+var errNoFilerGRPC = errors.New("this s3test was built without --mode=filer-grpc; rebuild it with `go build -tags filergrpc`")
+
+func checkFilerGRPC() error {
+	return errNoFilerGRPC
+}
+
+func newFilerGRPCBackend(addr, bucketDir, bucket string) (Backend, error) {
+	return nil, errNoFilerGRPC
+}
+
+func filerGRPCSize(ctx context.Context, addr, bucketDir, bucket, key string) (int64, error) {
+	return 0, errNoFilerGRPC
+}
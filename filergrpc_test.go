@@ -0,0 +1,42 @@
+//go:build filergrpc
+
+package main
+
+import (
+	"math"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Encode a FileChunk with `fileID`, `offset`, and `size`.
+func encodeChunk(fileID string, offset, size uint64) []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, fileID)
+	b = protowire.AppendTag(b, 2, protowire.VarintType)
+	b = protowire.AppendVarint(b, offset)
+	b = protowire.AppendTag(b, 3, protowire.VarintType)
+	b = protowire.AppendVarint(b, size)
+	return b
+}
+
+func TestParseChunk(t *testing.T) {
+	c, err := parseChunk(encodeChunk("3,01637037d6", 5<<40, 8<<20))
+	if err != nil || c.fileID != "3,01637037d6" || c.offset != 5<<40 || c.size != 8<<20 {
+		t.Errorf("parseChunk() = %+v, %v", c, err)
+	}
+	if _, err := parseChunk(encodeChunk("3,1", math.MaxInt64-10, 10)); err != nil {
+		t.Errorf("a chunk ending at the limit: %v", err)
+	}
+	for _, tc := range []struct{ offset, size uint64 }{
+		{0, math.MaxInt64 + 1},
+		{math.MaxInt64 + 1, 1},
+		{math.MaxUint64, 0},
+		{math.MaxInt64 - 10, 11},
+	} {
+		if c, err := parseChunk(encodeChunk("3,1", tc.offset, tc.size)); err == nil {
+			t.Errorf("%d bytes at %d: parsed %+v", tc.size, tc.offset, c)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+// By the end of a run there are a dozen reports, and the part that
+// goes into an upstream bug is always the same few sentences pulled
+// out of them by hand.  So the run ends with "Findings": simple rules
+// over the results that print those sentences, each naming the JSON
+// field it comes from, so it can be checked.  --no-findings turns
+// this off for people who only want the raw numbers.
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// finding is one plain-English observation about a run.
+type finding struct {
+	Text   string `json:"text"`
+	Metric string `json:"metric"`          // where in the --json results it comes from
+	Alert  bool   `json:"alert,omitempty"` // amplification or corruption; see alert.go
+}
+
+const (
+	// Amplification worth mentioning.
+	findingAmplification = 1.5
+
+	// A read is "waiting on headers" if it spent this much of its
+	// time before the first byte, and this much time in absolute
+	// terms.
+	findingTTFBShare = 0.9
+	findingTTFB      = time.Second
+
+	// A jump in median latency from one part of the file to the
+	// rest worth mentioning.
+	findingLatencyStep = 3.0
+
+	// A p99 this many times the p50 is a long tail.
+	findingTail = 10.0
+
+	// Throughput below this share of what one unranged GET reached
+	// with --net-baseline.
+	findingCeiling = 0.5
+)
+
+// Apply the rules to `result`.
+func computeFindings(result *Result) []finding {
+	var findings []finding
+	add := func(metric, format string, args ...any) {
+		findings = append(findings, finding{Text: fmt.Sprintf(format, args...), Metric: metric})
+	}
+	alert := func(metric, format string, args ...any) {
+		add(metric, format, args...)
+		findings[len(findings)-1].Alert = true
+	}
+
+	if a := result.Amplification; a != nil && a.Ratio() >= findingAmplification {
+		alert("amplification.requestedBytes / amplification.logicalBytes",
+			"the benchmark asked for %s, but upstream requests totaled %s (%.1fx amplification)",
+			units.bytes(a.LogicalBytes), units.bytes(a.RequestedBytes), a.Ratio())
+	}
+	if c := result.Ceiling; c != nil && c.BaselineMbps > 0 && c.PercentOfBaseline < 100*findingCeiling {
+		add("ceiling.percentOfBaseline", "reads ran at %s, only %.1f%% of the %s that one unranged GET reached%s",
+			units.rate(result.Bytes, result.Duration), c.PercentOfBaseline, units.rate(c.BaselineBytes, c.BaselineDuration), c.linkShare())
+	}
+	if a := result.Amplification; a != nil && a.RangeDropped > 0 {
+		alert("amplification.rangeDropped", "%d GETs lost their Range header following a redirect, so each asked for the whole object", a.RangeDropped)
+	}
+	if r := result.Redirects; r != nil {
+		add("redirects.redirects", "the server answered %d requests with a redirect, so requests and latency include the extra hops", r.Redirects)
+	}
+	if a := result.Amplification; a != nil && a.FullBody > 0 {
+		alert("amplification.fullBody", "%d ranged responses ignored the Range header and sent more than was asked for", a.FullBody)
+	}
+
+	var disagreed, agreedAfter int
+	for _, c := range result.SizeRechecks {
+		if !c.Agrees {
+			disagreed++
+		} else if disagreed > 0 {
+			agreedAfter++
+		}
+	}
+	if disagreed > 0 && agreedAfter > 0 {
+		add("sizeRechecks[].agrees", "in %d of %d looks at the object after out-of-range reads, its size disagreed with the start of the run, but later looks agreed again, so Stat is giving inconsistent answers; try --stat-consistency",
+			disagreed, len(result.SizeRechecks))
+	} else if disagreed > 0 {
+		add("sizeRechecks[].agrees", "in %d of %d looks at the object after out-of-range reads, its size disagreed with the start of the run", disagreed, len(result.SizeRechecks))
+	}
+
+	var ok []*Sample
+	var errors, stalled, retried, backpressure, waiting, corrupt, mismatched int
+	for _, s := range result.Samples {
+		switch {
+		case s.Stalled:
+			stalled++
+		case s.Err != "":
+			errors++
+		default:
+			ok = append(ok, s)
+		}
+		if s.Attempts() > len(s.Ops) {
+			retried++
+		}
+		if s.Backpressure > 0 {
+			backpressure++
+		}
+		if s.MP4Error != "" {
+			corrupt++
+		}
+		if s.Checksum == checksumMismatch {
+			mismatched++
+		}
+		if s.HeaderLatency >= findingTTFB && s.Duration > 0 && float64(s.HeaderLatency) >= findingTTFBShare*float64(s.Duration) {
+			waiting++
+		}
+	}
+	n := len(result.Samples)
+	if errors > 0 {
+		add("samples[].error", "%d of %d reads failed", errors, n)
+	}
+	if corrupt > 0 {
+		alert("samples[].mp4Error", "%d of %d reads returned data that doesn't match the MP4's box headers, so the server sent the wrong bytes", corrupt, n)
+	}
+	if mismatched > 0 {
+		alert("samples[].checksum", "%d of %d reads returned data that doesn't match the server's own checksum", mismatched, n)
+	}
+	if stalled > 0 {
+		add("samples[].stalled", "%d of %d reads stalled with no data arriving", stalled, n)
+	}
+	if retried > 0 {
+		add("samples[].ops[].attempts", "%d of %d reads needed SDK retries", retried, n)
+	}
+	if backpressure > 0 {
+		add("samples[].backpressure", "%d of %d reads were told to back off with Retry-After", backpressure, n)
+	}
+	if waiting > 0 {
+		var header, body []time.Duration
+		for _, s := range ok {
+			header = append(header, s.HeaderLatency)
+			body = append(body, s.BodyLatency)
+		}
+		h, b := computeLatencyStats(header), computeLatencyStats(body)
+		add("samples[].headerLatencyNs, samples[].bodyLatencyNs",
+			"%.0f%% of reads spent over %.0f%% of their time waiting for response headers (median %.3fs to the first byte, then %.3fs for the body), which suggests the server reads the data before it starts responding",
+			100*float64(waiting)/float64(n), 100*findingTTFBShare, h.P50.Seconds(), b.P50.Seconds())
+	}
+
+	if offset, before, after, ok := latencyStep(ok); ok {
+		add("samples[].offset, samples[].durationNs",
+			"median latency went from %.3fs to %.3fs (%.1fx) after offset %s", before.Seconds(), after.Seconds(), after.Seconds()/before.Seconds(), units.bytes(offset))
+	}
+	if r := result.ChunkLatency; r != nil {
+		for _, c := range r.outliers() {
+			where := ""
+			if c.Volume != "" {
+				where = ", on volume " + c.Volume
+			}
+			add(fmt.Sprintf("chunkLatency.chunks[%d].latency.p90Ns", c.Index),
+				"chunk %d (offset %d%s) had a p90 of %.3fs, %.1fx the median read", c.Index, c.Offset, where, c.Latency.P90.Seconds(), float64(c.Latency.P90)/float64(r.Median))
+		}
+	}
+	if l := result.Latency; l.P50 > 0 && float64(l.P99) >= findingTail*float64(l.P50) {
+		add("latency.p99Ns / latency.p50Ns", "p99 latency is %.1fx the median (%.3fs vs %.3fs), a long tail", float64(l.P99)/float64(l.P50), l.P99.Seconds(), l.P50.Seconds())
+	}
+	return findings
+}
+
+// Find the decile boundary in the file, by offset, with the biggest
+// jump in median latency from the reads before it to the reads after
+// it, if that's at least findingLatencyStep.
+func latencyStep(samples []*Sample) (offset int64, before, after time.Duration, ok bool) {
+	if len(samples) < 20 {
+		return 0, 0, 0, false
+	}
+	sorted := slices.Clone(samples)
+	slices.SortFunc(sorted, func(a, b *Sample) int { return cmp.Compare(a.Offset, b.Offset) })
+	durations := make([]time.Duration, len(sorted))
+	for i, s := range sorted {
+		durations[i] = s.Duration
+	}
+	best := 0.0
+	for d := 1; d < 10; d++ {
+		i := len(sorted) * d / 10
+		b, a := computeLatencyStats(durations[:i]).P50, computeLatencyStats(durations[i:]).P50
+		if b > 0 && float64(a)/float64(b) > best {
+			best = float64(a) / float64(b)
+			offset, before, after = sorted[i].Offset, b, a
+		}
+	}
+	return offset, before, after, best >= findingLatencyStep
+}
+
+// Print the findings.
+func printFindings(findings []finding) {
+	if len(findings) == 0 {
+		fmt.Printf("Findings: nothing unusual\n")
+		return
+	}
+	fmt.Printf("Findings:\n")
+	for _, f := range findings {
+		fmt.Printf("  - %s [%s]\n", f.Text, f.Metric)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+// Every flag can also be set from the environment, as S3TEST_ plus the
+// flag name in upper case with dashes turned into underscores, like
+// S3TEST_ENDPOINT or S3TEST_SSE_C_KEY_FILE.  That makes
+// it easy to point a test box at an endpoint without a wrapper
+// script.  The command line wins over the environment, which wins
+// over the defaults; --dump-config shows where each value came from.
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Where each flag's value came from: "command line", "environment
+// ($NAME)", or "default".
+var flagSources = map[string]string{}
+
+// Sensitive flags whose values --dump-config doesn't print.  A
+// webhook URL usually has its token in the path or the query.
+var secretFlags = map[string]bool{"sse-c-key": true, "webhook-url": true}
+
+// Return the environment variable for the flag `name`.
+func flagEnvName(name string) string {
+	return "S3TEST_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// Fill in any flag in `fs` that wasn't set on the command line from
+// its environment variable.  Call this after fs.Parse().
+func applyFlagEnv(fs *flag.FlagSet, lookup func(string) (string, bool)) error {
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if set[f.Name] {
+			flagSources[f.Name] = "command line"
+			return
+		}
+		env := flagEnvName(f.Name)
+		v, ok := lookup(env)
+		if !ok {
+			flagSources[f.Name] = "default"
+			return
+		}
+		if serr := fs.Set(f.Name, v); serr != nil && err == nil {
+			err = fmt.Errorf("$%s: %w", env, serr)
+		}
+		flagSources[f.Name] = "environment ($" + env + ")"
+	})
+	return err
+}
+
+// Print every flag's value and where it came from.
+func dumpConfig(fs *flag.FlagSet) {
+	var names []string
+	fs.VisitAll(func(f *flag.Flag) { names = append(names, f.Name) })
+	sort.Strings(names)
+	for _, name := range names {
+		v := redactFlag(name, fs.Lookup(name).Value.String())
+		fmt.Printf("%-24s %-24q %s\n", name, v, flagSources[name])
+	}
+}
+
+// Return flag `name`'s value `v` as --dump-config prints it, without
+// secrets.
+func redactFlag(name, v string) string {
+	switch {
+	case v == "":
+		return v
+	case secretFlags[name]:
+		return "(hidden)"
+	case name == "socks5":
+		// user:password@host:port
+		if i := strings.LastIndex(v, "@"); i >= 0 {
+			return "(hidden)" + v[i:]
+		}
+	}
+	return v
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A flag set like the real one, parsed from `args`, with
+// applyFlagEnv's `env`.
+func parseWithEnv(t *testing.T, args []string, env map[string]string) (*flag.FlagSet, *int64, *string, *time.Duration, error) {
+	t.Helper()
+	setFlag(t, &flagSources, map[string]string{})
+	fs := flag.NewFlagSet("s3test", flag.ContinueOnError)
+	readsize := fs.Int64("readsize", 1<<18, "")
+	endpoint := fs.String("endpoint", "http://default:8333", "")
+	interval := fs.Duration("read-interval", 0, "")
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	err := applyFlagEnv(fs, func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	})
+	return fs, readsize, endpoint, interval, err
+}
+
+func TestApplyFlagEnvPrecedence(t *testing.T) {
+	env := map[string]string{"S3TEST_READSIZE": "4096", "S3TEST_ENDPOINT": "http://env:8333"}
+
+	// The command line wins over the environment, which wins over
+	// the defaults.
+	_, readsize, endpoint, interval, err := parseWithEnv(t, []string{"--readsize=1024"}, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *readsize != 1024 || *endpoint != "http://env:8333" || *interval != 0 {
+		t.Errorf("got --readsize=%d --endpoint=%s --read-interval=%v", *readsize, *endpoint, *interval)
+	}
+	for name, want := range map[string]string{
+		"readsize":      "command line",
+		"endpoint":      "environment ($S3TEST_ENDPOINT)",
+		"read-interval": "default",
+	} {
+		if flagSources[name] != want {
+			t.Errorf("--%s came from %q, want %q", name, flagSources[name], want)
+		}
+	}
+
+	// A flag set on the command line to its default still wins.
+	_, readsize, _, _, _ = parseWithEnv(t, []string{"--readsize=262144"}, env)
+	if *readsize != 1<<18 {
+		t.Errorf("--readsize=262144 with $S3TEST_READSIZE: got %d", *readsize)
+	}
+
+	// Dashes become underscores.
+	_, _, _, interval, _ = parseWithEnv(t, nil, map[string]string{"S3TEST_READ_INTERVAL": "2s"})
+	if *interval != 2*time.Second {
+		t.Errorf("$S3TEST_READ_INTERVAL=2s: got %v", *interval)
+	}
+}
+
+func TestApplyFlagEnvBadValues(t *testing.T) {
+	_, _, _, _, err := parseWithEnv(t, nil, map[string]string{"S3TEST_READSIZE": "lots"})
+	if err == nil || !strings.Contains(err.Error(), "$S3TEST_READSIZE") {
+		t.Errorf("$S3TEST_READSIZE=lots: %v", err)
+	}
+
+	// A bad value that the command line overrides doesn't matter.
+	if _, _, _, _, err := parseWithEnv(t, []string{"--readsize=1024"}, map[string]string{"S3TEST_READSIZE": "lots"}); err != nil {
+		t.Errorf("$S3TEST_READSIZE=lots with --readsize: %v", err)
+	}
+
+	// Only the first problem is reported.
+	_, _, _, _, err = parseWithEnv(t, nil, map[string]string{"S3TEST_READSIZE": "lots", "S3TEST_READ_INTERVAL": "soon"})
+	if err == nil || strings.Contains(err.Error(), "S3TEST_READ_INTERVAL") == strings.Contains(err.Error(), "S3TEST_READSIZE") {
+		t.Errorf("two bad values: %v", err)
+	}
+}
+
+func TestRedactFlag(t *testing.T) {
+	for _, c := range []struct{ name, v, want string }{
+		{"sse-c-key", "c2VjcmV0", "(hidden)"},
+		{"sse-c-key", "", ""},
+		{"webhook-url", "https://hooks.slack.com/services/T0/B0/token", "(hidden)"},
+		{"socks5", "alice:hunter2@proxy:1080", "(hidden)@proxy:1080"},
+		{"socks5", "proxy:1080", "proxy:1080"},
+		{"endpoint", "http://a@b", "http://a@b"},
+	} {
+		if got := redactFlag(c.name, c.v); got != c.want {
+			t.Errorf("redactFlag(%q, %q) = %q, want %q", c.name, c.v, got, c.want)
+		}
+	}
+}
+
+func TestSOCKSDescriptionHidesCredentials(t *testing.T) {
+	p, err := parseSOCKS5("alice:hunter2@proxy:1080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := p.String(); strings.Contains(s, "alice") || strings.Contains(s, "hunter2") || !strings.Contains(s, "proxy:1080") {
+		t.Errorf("String() = %q", s)
+	}
+}
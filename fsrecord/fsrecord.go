@@ -0,0 +1,247 @@
+// Package fsrecord wraps an fs.FS, such as the one s3fs provides to
+// Caddy, and records how it's read, in the same --jsonl format that
+// s3test writes.  Put it in a staging build to capture the access
+// pattern of real traffic, then analyze the recording with
+// `s3test analyze`, or run the same reads against a server with
+// `s3test --replay=recording.jsonl`.
+//
+// Each run of Reads on a handle without a Seek in between becomes one
+// sample: its offset, the bytes read, when the first Read started, and
+// how long until the last one finished.  Reads don't allocate, and the
+// recording is written by a separate goroutine, so a slow disk never
+// slows down the Reads; records that don't fit in the buffer are
+// dropped and counted.
+package fsrecord
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/scottlaird/s3test/schema"
+)
+
+// Record is one sample: a run of Reads on one handle.
+type Record struct {
+	File     string        `json:"file"`
+	Handle   uint64        `json:"handle"` // which Open this came from
+	Offset   int64         `json:"offset"`
+	Size     int64         `json:"size"`
+	Bytes    int64         `json:"bytes"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"durationNs"`
+	Err      string        `json:"error,omitempty"`
+}
+
+// Reporter receives the records.  Report is called from whichever
+// goroutine is reading, so it must be safe for concurrent use, and
+// shouldn't block.
+type Reporter interface {
+	Report(Record)
+}
+
+// WrapFS returns an fs.FS that passes everything through to `inner`,
+// and reports the reads of every file opened through it to `r`.
+func WrapFS(inner fs.FS, r Reporter) fs.FS {
+	return &recordingFS{inner: inner, r: r}
+}
+
+type recordingFS struct {
+	inner   fs.FS
+	r       Reporter
+	handles atomic.Uint64
+}
+
+func (f *recordingFS) Open(name string) (fs.File, error) {
+	inner, err := f.inner.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	file := &file{inner: inner, r: f.r, name: name, handle: f.handles.Add(1)}
+	// Callers like http.ServeContent check for Seek, so only offer
+	// it if the inner file has it.
+	if s, ok := inner.(io.Seeker); ok {
+		return &seekFile{file: file, seeker: s}, nil
+	}
+	return file, nil
+}
+
+func (f *recordingFS) Stat(name string) (fs.FileInfo, error) {
+	return fs.Stat(f.inner, name)
+}
+
+// file records the Reads on one handle.
+type file struct {
+	inner  fs.File
+	r      Reporter
+	name   string
+	handle uint64
+
+	mu     sync.Mutex
+	pos    int64
+	cur    Record
+	active bool
+}
+
+func (f *file) Stat() (fs.FileInfo, error) {
+	return f.inner.Stat()
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	start := time.Now()
+	n, err := f.inner.Read(p)
+	end := time.Now()
+
+	if !f.active {
+		f.cur = Record{File: f.name, Handle: f.handle, Offset: f.pos, Start: start}
+		f.active = true
+	}
+	f.pos += int64(n)
+	f.cur.Bytes += int64(n)
+	f.cur.Duration = end.Sub(f.cur.Start)
+	if err != nil && err != io.EOF {
+		f.cur.Err = err.Error()
+		f.flush()
+	}
+	return n, err
+}
+
+// Report the current run of Reads, if any.  Must be called with f.mu
+// held.
+func (f *file) flush() {
+	if !f.active {
+		return
+	}
+	f.cur.Size = f.cur.Bytes
+	f.r.Report(f.cur)
+	f.active = false
+}
+
+func (f *file) Close() error {
+	f.mu.Lock()
+	f.flush()
+	f.mu.Unlock()
+	return f.inner.Close()
+}
+
+// ReadDir passes through to the inner file, for directories.
+func (f *file) ReadDir(n int) ([]fs.DirEntry, error) {
+	if d, ok := f.inner.(fs.ReadDirFile); ok {
+		return d.ReadDir(n)
+	}
+	return nil, &fs.PathError{Op: "readdir", Path: f.name, Err: errors.ErrUnsupported}
+}
+
+// seekFile is a file whose inner file can Seek.
+type seekFile struct {
+	*file
+	seeker io.Seeker
+}
+
+func (f *seekFile) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	pos, err := f.seeker.Seek(offset, whence)
+	if err != nil {
+		return pos, err
+	}
+	// Seek(0, io.SeekCurrent) just asks where we are, and doesn't
+	// end the run of Reads.
+	if pos != f.pos {
+		f.flush()
+		f.pos = pos
+	}
+	return pos, nil
+}
+
+// The version of s3test's --jsonl format that JSONLReporter writes.
+const jsonlVersion = schema.Major
+
+// How many records can be waiting for the writer before
+// JSONLReporter starts dropping them.
+const jsonlBuffer = 4096
+
+// JSONLReporter appends records to a file in s3test's --jsonl format,
+// from a goroutine of its own.
+type JSONLReporter struct {
+	records chan Record
+	done    chan struct{}
+	dropped atomic.Uint64
+	err     error
+}
+
+// The line for each record.
+type jsonlRecord struct {
+	SchemaVersion string  `json:"schemaVersion"`
+	Version       int     `json:"version"`
+	Type          string  `json:"type"`
+	Sample        *Record `json:"sample,omitempty"`
+}
+
+// NewJSONLReporter appends to `path`, creating it if needed.  Call
+// Close to write the last of the records.
+func NewJSONLReporter(path string) (*JSONLReporter, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	r := &JSONLReporter{records: make(chan Record, jsonlBuffer), done: make(chan struct{})}
+	go r.loop(f)
+	return r, nil
+}
+
+// Report queues `rec` to be written, or drops it if the queue is full.
+func (r *JSONLReporter) Report(rec Record) {
+	select {
+	case r.records <- rec:
+	default:
+		r.dropped.Add(1)
+	}
+}
+
+// Dropped returns the number of records dropped so far because the
+// writer couldn't keep up.
+func (r *JSONLReporter) Dropped() uint64 {
+	return r.dropped.Load()
+}
+
+func (r *JSONLReporter) loop(f *os.File) {
+	defer close(r.done)
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for rec := range r.records {
+		if r.err == nil {
+			r.err = enc.Encode(jsonlRecord{SchemaVersion: schema.Version, Version: jsonlVersion, Type: "sample", Sample: &rec})
+		}
+		// Flush whenever we catch up, so the file is never far
+		// behind.
+		if len(r.records) == 0 && r.err == nil {
+			r.err = w.Flush()
+		}
+	}
+	if r.err == nil {
+		r.err = enc.Encode(jsonlRecord{SchemaVersion: schema.Version, Version: jsonlVersion, Type: "end"})
+	}
+	if r.err == nil {
+		r.err = w.Flush()
+	}
+	if err := f.Close(); r.err == nil {
+		r.err = err
+	}
+}
+
+// Close writes the remaining records, and an "end" record, and closes
+// the file.  Don't call Report after Close.
+func (r *JSONLReporter) Close() error {
+	close(r.records)
+	<-r.done
+	return r.err
+}
@@ -0,0 +1,204 @@
+package fsrecord
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// collector keeps every record it's given.
+type collector struct {
+	mu      sync.Mutex
+	records []Record
+}
+
+func (c *collector) Report(rec Record) {
+	c.mu.Lock()
+	c.records = append(c.records, rec)
+	c.mu.Unlock()
+}
+
+func read(t *testing.T, r io.Reader, n int) {
+	t.Helper()
+	if _, err := io.ReadFull(r, make([]byte, n)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func seek(t *testing.T, s io.Seeker, offset int64, whence int) {
+	t.Helper()
+	if _, err := s.Seek(offset, whence); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Each run of Reads is recorded with the offset it started at and the
+// bytes it read, however it was cut into Reads.
+func TestRecord(t *testing.T) {
+	var c collector
+	fsys := WrapFS(fstest.MapFS{"big.mp4": {Data: make([]byte, 4096)}}, &c)
+	f, err := fsys.Open("big.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, ok := f.(io.Seeker)
+	if !ok {
+		t.Fatal("the wrapped file can't Seek")
+	}
+	for range 3 {
+		read(t, f, 100)
+	}
+	seek(t, s, 1000, io.SeekStart)
+	read(t, f, 200)
+	seek(t, s, 0, io.SeekCurrent) // just asking
+	read(t, f, 50)
+	seek(t, s, -96, io.SeekEnd)
+	if b, err := io.ReadAll(f); len(b) != 96 || err != nil {
+		t.Fatalf("read %d bytes at the end, %v", len(b), err)
+	}
+	f.Close()
+
+	want := []Record{
+		{Offset: 0, Bytes: 300},
+		{Offset: 1000, Bytes: 250},
+		{Offset: 4000, Bytes: 96},
+	}
+	if len(c.records) != len(want) {
+		t.Fatalf("recorded %+v", c.records)
+	}
+	for i, rec := range c.records {
+		if rec.File != "big.mp4" || rec.Handle != 1 || rec.Offset != want[i].Offset || rec.Bytes != want[i].Bytes || rec.Size != rec.Bytes || rec.Start.IsZero() || rec.Duration < 0 || rec.Err != "" {
+			t.Errorf("record %d is %+v, want %d bytes at %d", i, rec, want[i].Bytes, want[i].Offset)
+		}
+	}
+
+	// A second Open is another handle.
+	f, err = fsys.Open("big.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	read(t, f, 10)
+	f.Close()
+	if last := c.records[len(c.records)-1]; last.Handle != 2 || last.Bytes != 10 {
+		t.Errorf("the second handle's record is %+v", last)
+	}
+}
+
+// The reporter writes lines that s3test reads as --jsonl samples.
+func TestJSONLReporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "recording.jsonl")
+	r, err := NewJSONLReporter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	for i := range int64(3) {
+		r.Report(Record{File: "big.mp4", Handle: 1, Offset: i * 100, Size: 100, Bytes: 100, Start: start, Duration: time.Millisecond})
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if r.Dropped() != 0 {
+		t.Errorf("dropped %d records", r.Dropped())
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var types []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var line jsonlRecord
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatal(err)
+		}
+		if line.Version != jsonlVersion || line.SchemaVersion == "" {
+			t.Errorf("line %q has version %d, %q", scanner.Text(), line.Version, line.SchemaVersion)
+		}
+		if line.Sample != nil && line.Sample.Offset != int64(len(types))*100 {
+			t.Errorf("sample %d is at %d", len(types), line.Sample.Offset)
+		}
+		types = append(types, line.Type)
+	}
+	if len(types) != 4 || types[0] != "sample" || types[3] != "end" {
+		t.Errorf("wrote %q", types)
+	}
+}
+
+// zeroFile is an endless file of zeros that reads as fast as it can.
+type zeroFile struct{ pos int64 }
+
+func (f *zeroFile) Read(p []byte) (int, error) {
+	f.pos += int64(len(p))
+	return len(p), nil
+}
+func (f *zeroFile) Seek(offset int64, whence int) (int64, error) {
+	f.pos = offset
+	return offset, nil
+}
+func (f *zeroFile) Stat() (fs.FileInfo, error) { return nil, fs.ErrInvalid }
+func (f *zeroFile) Close() error               { return nil }
+
+type zeroFS struct{}
+
+func (zeroFS) Open(name string) (fs.File, error) { return &zeroFile{}, nil }
+
+type discard struct{}
+
+func (discard) Report(Record) {}
+
+// Reads through the wrapper mustn't allocate.
+func TestReadAllocs(t *testing.T) {
+	f, err := WrapFS(zeroFS{}, discard{}).Open("big.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := f.(io.Seeker)
+	buf := make([]byte, 4096)
+	if n := testing.AllocsPerRun(1000, func() { f.Read(buf) }); n != 0 {
+		t.Errorf("a Read allocates %v times", n)
+	}
+	if n := testing.AllocsPerRun(1000, func() { s.Seek(0, io.SeekStart); f.Read(buf) }); n != 0 {
+		t.Errorf("a Seek and a Read allocate %v times", n)
+	}
+}
+
+// The wrapper has to cost well under 1µs per Read; compare
+// wrapped/read with bare.
+func BenchmarkRead(b *testing.B) {
+	buf := make([]byte, 4096)
+	wrapped, err := WrapFS(zeroFS{}, discard{}).Open("big.mp4")
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, bc := range []struct {
+		name string
+		f    fs.File
+		seek bool
+	}{
+		{"bare", &zeroFile{}, false},
+		{"wrapped/read", wrapped, false},
+		// Each Read starts a new record, and reports the last one.
+		{"wrapped/seek", wrapped, true},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			s := bc.f.(io.Seeker)
+			for i := range b.N {
+				if bc.seek {
+					s.Seek(int64(i)*8192, io.SeekStart)
+				}
+				bc.f.Read(buf)
+			}
+		})
+	}
+}
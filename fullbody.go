@@ -0,0 +1,129 @@
+package main
+
+// Behind a misconfigured proxy, every "range" request can come back
+// as a 200 with the entire multi-gigabyte object.  The client reads
+// the --readsize bytes it wanted and closes the connection, which
+// looks fine from here, but the server (and the network) had to
+// start sending the whole thing every time.  So the transport checks
+// every ranged GET: the answer should be a 206 no longer than what we
+// asked for.  This catches it in every mode, including s3fs, which
+// never shows us the status.
+//
+// With --abort-on-full-body, the first such response stops the run,
+// since carrying on just melts the cluster.  It exits with status 5,
+// so that a script can tell it apart from --fail-on-amplification.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var errFullBody = errors.New("the server ignored the Range header and sent the whole object")
+
+// fullBodyResponse describes a response that was bigger than the
+// range we asked for.
+type fullBodyResponse struct {
+	Range         string
+	Status        int
+	ContentLength int64
+}
+
+func (f fullBodyResponse) String() string {
+	if f.Status == http.StatusOK {
+		return fmt.Sprintf("asked for %s but got a 200 with %d bytes", f.Range, f.ContentLength)
+	}
+	return fmt.Sprintf("asked for %s but got a %d with %d bytes", f.Range, f.Status, f.ContentLength)
+}
+
+// fullBodySet collects the oversized responses seen by one read.
+type fullBodySet struct {
+	mu        sync.Mutex
+	responses []fullBodyResponse
+}
+
+type fullBodySetKey struct{}
+
+// Return a context that collects the oversized responses to requests
+// made with it.
+func collectFullBodies(ctx context.Context) (context.Context, *fullBodySet) {
+	f := &fullBodySet{}
+	return context.WithValue(ctx, fullBodySetKey{}, f), f
+}
+
+func (f *fullBodySet) Responses() []fullBodyResponse {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]fullBodyResponse(nil), f.responses...)
+}
+
+// Check the response to a ranged GET, returning a description of the
+// problem if it's bigger than what was asked for.
+func checkRangedResponse(req *http.Request, resp *http.Response) (fullBodyResponse, bool) {
+	rangeHeader := req.Header.Get("Range")
+	if req.Method != http.MethodGet || rangeHeader == "" {
+		return fullBodyResponse{}, false
+	}
+	f := fullBodyResponse{Range: rangeHeader, Status: resp.StatusCode, ContentLength: resp.ContentLength}
+	want, bounded := requestedLength(rangeHeader)
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// A 200 is only harmless if the range covered the whole
+		// object anyway.
+		if bounded && resp.ContentLength >= 0 && resp.ContentLength <= want {
+			return f, false
+		}
+		if !bounded && strings.HasPrefix(rangeHeader, "bytes=0-") {
+			return f, false
+		}
+		return f, true
+	case http.StatusPartialContent:
+		return f, bounded && resp.ContentLength > want
+	}
+	return f, false
+}
+
+// Return the number of bytes asked for by a single "bytes=A-B" range,
+// or false if it's open-ended or something more complicated.
+func requestedLength(header string) (int64, bool) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, false
+	}
+	first, last, _ := strings.Cut(spec, "-")
+	start, err1 := strconv.ParseInt(first, 10, 64)
+	end, err2 := strconv.ParseInt(last, 10, 64)
+	if err1 != nil || err2 != nil || end < start {
+		return 0, false
+	}
+	return end - start + 1, true
+}
+
+// Note an oversized response in the read's context, and with
+// --abort-on-full-body, replace its body with one that fails
+// straight away, so we don't read any of it.
+func noteFullBody(req *http.Request, resp *http.Response, f fullBodyResponse) {
+	if s, ok := req.Context().Value(fullBodySetKey{}).(*fullBodySet); ok {
+		s.mu.Lock()
+		s.responses = append(s.responses, f)
+		s.mu.Unlock()
+	}
+	if *abortOnFullBody {
+		resp.Body.Close()
+		resp.Body = io.NopCloser(errReader{errFullBody})
+	}
+}
+
+type errReader struct {
+	err error
+}
+
+func (e errReader) Read([]byte) (int, error) {
+	return 0, e.err
+}
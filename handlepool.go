@@ -0,0 +1,305 @@
+package main
+
+// Normally every read opens the file afresh.  A server like Caddy
+// keeps a handle open and seeks it around instead, and with s3fs that
+// changes the traffic: a read that starts where the last one stopped
+// carries on with the GET that's already open.  --reuse-handle does the
+// same for --mode=s3fs and localfs.
+//
+// One handle would serialize every worker through its seek position,
+// so there's a pool of them per file, --handle-pool of them (by default
+// one per worker).  Each read checks a handle out, seeks it, reads, and
+// checks it back in.  A handle that saw an error, or isn't where the
+// read should have left it, is closed rather than reused, and the next
+// read that needs one opens a new one; the summary counts those
+// reopens separately, since churn is exactly what reuse was meant to
+// avoid.
+//
+// A handle that's checked out twice at once would mean two reads
+// interleaving Seek and Read on it, and wrong data, so we panic if
+// that ever happens.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jszwec/s3fs/v2"
+)
+
+// fileHandle is one open, seekable file.
+type fileHandle struct {
+	f  io.ReadSeekCloser
+	cl *contextClient // the s3fs client, whose context we set per read; nil for localfs
+
+	id    int
+	inUse atomic.Bool
+}
+
+// A Backend whose files can be kept open and reused.
+type handleOpener interface {
+	openHandle(ctx context.Context, filename string, sample *Sample) (*fileHandle, error)
+}
+
+func (b *s3fsBackend) openHandle(ctx context.Context, filename string, sample *Sample) (*fileHandle, error) {
+	// Unlike Open(), the client outlives this read, so its context
+	// is set again by each read that checks the handle out.
+	cl := &contextClient{client: b.client}
+	fs := s3fs.New(cl, b.bucket, s3fs.WithReadSeeker)
+
+	start := time.Now()
+	phaseCtx, endPhase := startPhase(ctx, "Open")
+	cl.ctx = phaseCtx
+	f, err := fs.Open(filename)
+	endPhase()
+	sample.addPhase("open", time.Since(start))
+	if err != nil {
+		return nil, err
+	}
+	if info, err := f.Stat(); err == nil {
+		sample.ObjectSize = info.Size()
+	}
+	fSeek, ok := f.(io.ReadSeekCloser)
+	if !ok {
+		f.Close()
+		return nil, errNoSeek
+	}
+	return &fileHandle{f: fSeek, cl: cl}, nil
+}
+
+func (b *localFSBackend) openHandle(ctx context.Context, filename string, sample *Sample) (*fileHandle, error) {
+	flags := os.O_RDONLY
+	if b.direct {
+		flags |= directIOFlag
+	}
+	start := time.Now()
+	f, err := os.OpenFile(filename, flags, 0)
+	sample.addPhase("open", time.Since(start))
+	if err != nil {
+		return nil, err
+	}
+	if info, err := f.Stat(); err == nil {
+		sample.ObjectSize = info.Size()
+	}
+	return &fileHandle{f: f}, nil
+}
+
+// handlePool is the --reuse-handle backend.
+type handlePool struct {
+	inner handleOpener
+	size  int
+
+	mu    sync.Mutex
+	files map[string]*filePool
+	stats handleReport
+}
+
+// filePool holds one file's handles.
+type filePool struct {
+	slots chan struct{}    // one per handle that may be checked out
+	free  chan *fileHandle // open handles that aren't checked out
+	lost  int              // handles discarded, and not yet replaced
+}
+
+// handleReport goes in the results.
+type handleReport struct {
+	Pool      int           `json:"pool"` // handles per file
+	Opened    int           `json:"opened"`
+	Reused    int           `json:"reused"`    // reads that got an already-open handle
+	Discarded int           `json:"discarded"` // handles closed after an error or at an unexpected position
+	Reopened  int           `json:"reopened"`  // handles opened to replace discarded ones
+	Waits     int           `json:"waits"`     // reads that waited for a handle
+	Wait      time.Duration `json:"waitNs"`
+}
+
+// The --reuse-handle pool, if any.
+var handles *handlePool
+
+// Wrap `backend` in a pool of `size` handles per file.
+func newHandlePool(backend Backend, size int) (*handlePool, error) {
+	inner, ok := backend.(handleOpener)
+	if !ok {
+		return nil, fmt.Errorf("--reuse-handle needs --mode=s3fs or localfs")
+	}
+	return &handlePool{inner: inner, size: size, files: map[string]*filePool{}, stats: handleReport{Pool: size}}, nil
+}
+
+func (p *handlePool) file(filename string) *filePool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fp := p.files[filename]
+	if fp == nil {
+		fp = &filePool{slots: make(chan struct{}, p.size), free: make(chan *fileHandle, p.size)}
+		p.files[filename] = fp
+	}
+	return fp
+}
+
+// Check out a handle on `filename`, opening one if none are free.
+func (p *handlePool) checkout(ctx context.Context, fp *filePool, filename string, sample *Sample) (*fileHandle, error) {
+	start := time.Now()
+	select {
+	case fp.slots <- struct{}{}:
+	default:
+		select {
+		case fp.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		wait := time.Since(start)
+		sample.addPhase("handle wait", wait)
+		p.mu.Lock()
+		p.stats.Waits++
+		p.stats.Wait += wait
+		p.mu.Unlock()
+	}
+
+	var h *fileHandle
+	select {
+	case h = <-fp.free:
+		p.mu.Lock()
+		p.stats.Reused++
+		p.mu.Unlock()
+	default:
+		// Whether this open replaces a discarded handle is decided
+		// here, not from how many have been opened: a pool that
+		// never filled up can still have lost one.
+		p.mu.Lock()
+		reopen := fp.lost > 0
+		if reopen {
+			fp.lost--
+		}
+		p.mu.Unlock()
+		var err error
+		if h, err = p.inner.openHandle(ctx, filename, sample); err != nil {
+			if reopen {
+				p.mu.Lock()
+				fp.lost++
+				p.mu.Unlock()
+			}
+			<-fp.slots
+			return nil, err
+		}
+		p.mu.Lock()
+		if reopen {
+			p.stats.Reopened++
+		} else {
+			p.stats.Opened++
+		}
+		h.id = p.stats.Opened + p.stats.Reopened
+		p.mu.Unlock()
+	}
+	if !h.inUse.CompareAndSwap(false, true) {
+		panic(fmt.Sprintf("--reuse-handle: handle %d is already checked out", h.id))
+	}
+	return h, nil
+}
+
+// Return `h` to the pool, or close it if it can't be trusted.
+func (p *handlePool) checkin(fp *filePool, h *fileHandle, ok bool) {
+	h.inUse.Store(false)
+	if ok {
+		fp.free <- h
+	} else {
+		h.f.Close()
+		p.mu.Lock()
+		p.stats.Discarded++
+		fp.lost++
+		p.mu.Unlock()
+	}
+	<-fp.slots
+}
+
+func (p *handlePool) Open(ctx context.Context, filename string, offset, size int64, sample *Sample) (io.ReadCloser, error) {
+	fp := p.file(filename)
+	h, err := p.checkout(ctx, fp, filename, sample)
+	if err != nil {
+		return nil, err
+	}
+	if h.cl != nil {
+		h.cl.ctx = ctx
+	}
+
+	start := time.Now()
+	phaseCtx, endPhase := startPhase(ctx, "Seek")
+	if h.cl != nil {
+		h.cl.ctx = phaseCtx
+	}
+	_, err = h.f.Seek(offset, io.SeekStart)
+	endPhase()
+	sample.addPhase("seek", time.Since(start))
+	if h.cl != nil {
+		h.cl.ctx = ctx
+	}
+	if err != nil {
+		p.checkin(fp, h, false)
+		return nil, &readError{Phase: "seek", Err: err}
+	}
+	return &pooledReader{pool: p, fp: fp, h: h, pos: offset}, nil
+}
+
+// pooledReader reads from a checked-out handle, and checks it back in
+// when it's closed.
+type pooledReader struct {
+	pool *handlePool
+	fp   *filePool
+	h    *fileHandle
+	pos  int64 // where the handle should be
+	bad  bool  // a Read failed
+}
+
+func (r *pooledReader) Read(b []byte) (int, error) {
+	n, err := r.h.f.Read(b)
+	r.pos += int64(n)
+	if err != nil && !errors.Is(err, io.EOF) {
+		r.bad = true
+	}
+	return n, err
+}
+
+func (r *pooledReader) Close() error {
+	if r.h == nil {
+		return nil
+	}
+	ok := !r.bad
+	if ok {
+		// Asking where it is doesn't do any I/O.
+		pos, err := r.h.f.Seek(0, io.SeekCurrent)
+		ok = err == nil && pos == r.pos
+	}
+	r.pool.checkin(r.fp, r.h, ok)
+	r.h = nil
+	return nil
+}
+
+// Close every handle that isn't checked out.
+func (p *handlePool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, fp := range p.files {
+		for len(fp.free) > 0 {
+			(<-fp.free).f.Close()
+		}
+	}
+}
+
+// Summarize the pool's use.
+func (p *handlePool) report() *handleReport {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	r := p.stats
+	return &r
+}
+
+func (r *handleReport) print() {
+	fmt.Printf("Handles: pool of %d per file; %d opened, reused by %d reads; %d discarded after an error or at an unexpected position, and %d reopened\n",
+		r.Pool, r.Opened, r.Reused, r.Discarded, r.Reopened)
+	if r.Waits > 0 {
+		fmt.Printf("  %d reads waited %.3fs in all for a free handle\n", r.Waits, r.Wait.Seconds())
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// Read `size` bytes at `offset` through the pool, and return the
+// reader, still open, with what it read.
+func poolRead(t *testing.T, p *handlePool, offset, size int64) (*pooledReader, []byte, error) {
+	t.Helper()
+	rc, err := p.Open(context.Background(), "big.mp4", offset, size, &Sample{})
+	if err != nil {
+		return nil, nil, err
+	}
+	r := rc.(*pooledReader)
+	b := make([]byte, size)
+	_, err = io.ReadFull(r, b)
+	return r, b, err
+}
+
+func poolData(t *testing.T, handler func(fake http.Handler) http.Handler) (*handlePool, []byte) {
+	t.Helper()
+	data := make([]byte, 1<<20)
+	rand.NewChaCha8([32]byte{}).Read(data)
+	fake := fakeBucket(map[string][]byte{"big.mp4": data})
+	if handler != nil {
+		fake = handler(fake)
+	}
+	client := serveFakeS3(t, fake)
+	p, err := newHandlePool(&s3fsBackend{client: client, bucket: "test"}, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(p.close)
+	return p, data
+}
+
+// Concurrent reads each get a handle to themselves, and the right
+// bytes from it.  Run with -race, this also checks the inUse CAS.
+func TestHandlePoolConcurrent(t *testing.T) {
+	p, data := poolData(t, nil)
+	const workers, reads, size = 8, 20, 4096
+
+	var mu sync.Mutex
+	active := map[int]bool{}
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rng := rand.New(rand.NewPCG(uint64(w), 0))
+			for range reads {
+				offset := rng.Int64N(int64(len(data)) - size)
+				r, b, err := poolRead(t, p, offset, size)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				mu.Lock()
+				if active[r.h.id] {
+					t.Errorf("handle %d is checked out twice", r.h.id)
+				}
+				active[r.h.id] = true
+				mu.Unlock()
+				if !bytes.Equal(b, data[offset:offset+size]) {
+					t.Errorf("read at %d got the wrong bytes", offset)
+				}
+				mu.Lock()
+				delete(active, r.h.id)
+				mu.Unlock()
+				r.Close()
+			}
+		}()
+	}
+	wg.Wait()
+
+	rep := p.report()
+	if rep.Opened > p.size || rep.Opened+rep.Reused != workers*reads || rep.Discarded != 0 || rep.Reopened != 0 {
+		t.Errorf("report %+v for %d reads through %d handles", rep, workers*reads, p.size)
+	}
+}
+
+// A handle that hit a read error, or that isn't where its reads left
+// it, is closed, and the next read opens another.
+func TestHandlePoolDiscard(t *testing.T) {
+	var fail atomic.Bool
+	p, data := poolData(t, func(fake http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if fail.Load() && r.Method == http.MethodGet {
+				fake.ServeHTTP(&abortWriter{ResponseWriter: w, left: 100}, r)
+				return
+			}
+			fake.ServeHTTP(w, r)
+		})
+	})
+	const size = 4096
+
+	r, _, err := poolRead(t, p, 0, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := r.h
+	r.Close()
+
+	fail.Store(true)
+	r, _, err = poolRead(t, p, 8*size, size)
+	if err == nil {
+		t.Fatal("the aborted read worked")
+	}
+	if r.h != first {
+		t.Errorf("the failed read used handle %d, not the free one", r.h.id)
+	}
+	r.Close()
+	fail.Store(false)
+
+	r, b, err := poolRead(t, p, 8*size, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.h == first {
+		t.Errorf("the discarded handle was reused")
+	}
+	if !bytes.Equal(b, data[8*size:9*size]) {
+		t.Errorf("the read after a reopen got the wrong bytes")
+	}
+	// Something else moved the handle.
+	if _, err := r.h.f.Seek(5, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	second := r.h
+	r.Close()
+
+	r, b, err = poolRead(t, p, 0, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.h == second {
+		t.Errorf("the moved handle was reused")
+	}
+	if !bytes.Equal(b, data[:size]) {
+		t.Errorf("the read after a reopen got the wrong bytes")
+	}
+	r.Close()
+
+	rep := p.report()
+	if want := (handleReport{Pool: 4, Opened: 1, Reused: 1, Discarded: 2, Reopened: 2}); *rep != want {
+		t.Errorf("report %+v, want %+v", *rep, want)
+	}
+}
+
+// Reads that follow on are reused, and a handle that replaces a
+// discarded one counts as a reopen, even when the pool never filled.
+func TestHandlePoolCounts(t *testing.T) {
+	p, _ := poolData(t, nil)
+	const size = 4096
+	for i := range int64(5) {
+		r, _, err := poolRead(t, p, i*size, size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 4 {
+			r.pos++ // pretend the handle isn't where it should be
+		}
+		r.Close()
+	}
+	for i := range int64(3) {
+		r, _, err := poolRead(t, p, i*size, size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Close()
+	}
+
+	rep := p.report()
+	if want := (handleReport{Pool: 4, Opened: 1, Reused: 6, Discarded: 1, Reopened: 1}); *rep != want {
+		t.Errorf("report %+v, want %+v", *rep, want)
+	}
+}
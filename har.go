@@ -0,0 +1,205 @@
+package main
+
+// "Browser-like" reads are guesswork: a player's Range requests depend
+// on the player, the container, and where the viewer scrubs to.  But
+// Chrome will save exactly what it did as a HAR file (DevTools,
+// Network, "Save all as HAR").  --replay-har=FILE takes the GETs in it
+// whose URL matches --har-url (by default, any URL ending in the key
+// we're reading), and replays their ranges against the configured
+// bucket and key, each starting as long after the first as it did in
+// the capture, divided by --replay-speed.  Concurrency is the most
+// requests the browser had in flight at once.
+//
+// The Range headers mean what they do in amplification.go: no header
+// is the whole object, and a suffix range is the end of it, for the
+// object we're reading, which needn't be the size of the one that was
+// captured.  Chrome asks for "bytes=N-" and then cancels the download
+// once it has what it wants, so a read is cut short to the bytes the
+// browser actually received, when the HAR says.  Requests answered
+// from the browser's cache never went out, and are skipped.
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The parts of a HAR file we use.
+type harFile struct {
+	Log struct {
+		Entries []harEntry `json:"entries"`
+	} `json:"log"`
+}
+
+type harEntry struct {
+	Started   time.Time `json:"startedDateTime"`
+	Time      float64   `json:"time"` // total, in ms
+	FromCache string    `json:"_fromCache"`
+	Request   struct {
+		Method  string      `json:"method"`
+		URL     string      `json:"url"`
+		Headers []harHeader `json:"headers"`
+	} `json:"request"`
+	Response struct {
+		Status   int   `json:"status"`
+		BodySize int64 `json:"bodySize"` // -1 if unknown
+	} `json:"response"`
+}
+
+type harHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// harRequest is one GET to replay.
+type harRequest struct {
+	At       time.Duration // after the first request
+	Range    string        // the Range header, or "" for none
+	Received int64         // body bytes the browser got, or -1 if the HAR doesn't say
+}
+
+// harCapture is the matching requests from a HAR file.
+type harCapture struct {
+	Filename    string
+	Requests    []harRequest
+	Concurrency int
+}
+
+// Return the URL pattern to match: `pattern` if it's set, or else any
+// URL whose path ends with `key`.
+func harURLPattern(pattern, key string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		pattern = "/" + regexp.QuoteMeta(strings.TrimPrefix(key, "/")) + `(\?|$)`
+	}
+	return regexp.Compile(pattern)
+}
+
+// Read the GETs from `filename` whose URL matches `match`.
+func loadHAR(filename string, match *regexp.Regexp) (*harCapture, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var har harFile
+	if err := json.Unmarshal(b, &har); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	var entries []harEntry
+	for _, e := range har.Log.Entries {
+		if e.Request.Method != "GET" || e.FromCache != "" || !match.MatchString(harUnescape(e.Request.URL)) {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("none of the %d requests in %s are GETs of a URL matching %q that went to the server; use --har-url", len(har.Log.Entries), filename, match)
+	}
+	slices.SortStableFunc(entries, func(a, b harEntry) int { return a.Started.Compare(b.Started) })
+
+	c := &harCapture{Filename: filename}
+	var spans []*Sample
+	for _, e := range entries {
+		r := harRequest{At: e.Started.Sub(entries[0].Started), Received: e.Response.BodySize}
+		for _, h := range e.Request.Headers {
+			if strings.EqualFold(h.Name, "Range") {
+				r.Range = h.Value
+			}
+		}
+		if e.Response.Status == 0 {
+			// Cancelled before the response; we can't tell how much
+			// arrived.
+			r.Received = -1
+		}
+		c.Requests = append(c.Requests, r)
+		spans = append(spans, &Sample{Start: e.Started, Duration: time.Duration(e.Time * float64(time.Millisecond))})
+	}
+	c.Concurrency = peakInFlight(spans)
+	return c, nil
+}
+
+// Match against the URL as it was typed, so that a --har-url with
+// spaces in it works.
+func harUnescape(u string) string {
+	if s, err := url.PathUnescape(u); err == nil {
+		return s
+	}
+	return u
+}
+
+// Turn the capture into a schedule for `filename`, which is `filesize`
+// bytes long.
+func (c *harCapture) schedule(filename string, filesize int64) (*schedule, error) {
+	s := &schedule{File: filename, FileSize: filesize, Pattern: "sequential", Concurrency: c.Concurrency, Timed: true}
+	var whole, cut, empty int
+	for i, r := range c.Requests {
+		ranges, ok := parseRangeHeader(r.Range, filesize)
+		if !ok {
+			return nil, fmt.Errorf("%s: request %d has a Range header we don't understand: %q", c.Filename, i, r.Range)
+		}
+		if r.Range == "" {
+			whole++
+		}
+		for _, br := range ranges {
+			size := br.end - br.start
+			if len(ranges) == 1 && r.Received >= 0 && r.Received < size {
+				size = r.Received
+				cut++
+			}
+			if size == 0 {
+				empty++
+				continue
+			}
+			s.Reads = append(s.Reads, scheduledRead{Worker: -1, Offset: br.start, Size: size, At: r.At})
+		}
+	}
+	if len(s.Reads) == 0 {
+		return nil, fmt.Errorf("none of the %d requests in %s read any of the %d byte %s", len(c.Requests), c.Filename, filesize, filename)
+	}
+	fmt.Printf("Replaying %d requests from the HAR %s as %d reads, over %.3fs at %gx speed, with up to %d at once\n",
+		len(c.Requests), c.Filename, len(s.Reads), c.Requests[len(c.Requests)-1].At.Seconds() / *replaySpeed, *replaySpeed, s.Concurrency)
+	if whole > 0 {
+		fmt.Printf("  requests with no Range header, which read the whole object: %d\n", whole)
+	}
+	if cut > 0 {
+		fmt.Printf("  reads cut short where the browser stopped reading: %d\n", cut)
+	}
+	if empty > 0 {
+		fmt.Printf("  ranges skipped, which were past the end of the %d byte object: %d\n", filesize, empty)
+	}
+	return s, nil
+}
+
+// Hand out `reads` in order, each no sooner than its At after the
+// first, divided by `speed`.  A read that's late because every worker
+// was busy starts as soon as one is free.
+func timedSource(reads []scheduledRead, speed float64) readSource {
+	var mu sync.Mutex
+	var start time.Time
+	next := 0
+	return func(worker int) (readRange, bool) {
+		mu.Lock()
+		if next >= len(reads) {
+			mu.Unlock()
+			return readRange{}, false
+		}
+		if start.IsZero() {
+			start = time.Now()
+		}
+		r := reads[next]
+		next++
+		due := start.Add(time.Duration(float64(r.At) / speed))
+		mu.Unlock()
+
+		if wait := time.Until(due); wait > 0 {
+			time.Sleep(wait)
+		}
+		return readRange{offset: r.Offset, size: r.Size}, true
+	}
+}
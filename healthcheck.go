@@ -0,0 +1,189 @@
+package main
+
+// The benchmark's own latency says how our reads are doing, not
+// whether the gateway itself is still responsive.  --healthcheck-object
+// names a small control object, and every --healthcheck-interval we
+// GET its first byte, on a connection pool of its own that bypasses
+// --max-rps, so the probe never waits behind our own reads.  Before the
+// benchmark starts we take a baseline from a few probes, and the first
+// time two probes in a row take healthDegradeFactor times that (or
+// fail), we note how far the benchmark had got.  That's the point
+// where the cluster saturated, whatever our reads' latency says.
+//
+// The probes go in the --json results, and in --jsonl and
+// --stream-fifo as "health" records as they happen.
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const (
+	// Probes taken back to back for the baseline.
+	healthBaselineProbes = 3
+
+	// A probe this many times the baseline is degraded, as long as
+	// it's also this much slower, so that a 1ms baseline doesn't
+	// make 3ms of jitter a finding.
+	healthDegradeFactor = 3.0
+	healthDegradeSlack  = 50 * time.Millisecond
+)
+
+// healthSample is one control probe.
+type healthSample struct {
+	Time      time.Time     `json:"time"`
+	Duration  time.Duration `json:"durationNs"`
+	Err       string        `json:"error,omitempty"`
+	BytesRead int64         `json:"bytesRead"` // by the benchmark, when the probe started
+}
+
+// healthReport goes in the results.
+type healthReport struct {
+	Object   string         `json:"object"`
+	Interval time.Duration  `json:"intervalNs"`
+	Baseline time.Duration  `json:"baselineNs"`
+	Latency  latencyStats   `json:"latency"`
+	Errors   int            `json:"errors"`
+	Degraded *healthSample  `json:"degraded,omitempty"` // the first of two degraded probes in a row
+	Samples  []healthSample `json:"samples"`
+}
+
+// healthcheck probes the control object until stopped.
+type healthcheck struct {
+	client   *s3.Client
+	object   string
+	progress func() int64 // bytes the benchmark has read
+	emit     func(jsonlRecord)
+	cancel   context.CancelFunc
+	done     sync.WaitGroup
+
+	mu     sync.Mutex
+	report healthReport
+}
+
+// Return an S3 client with its own connection pool, whose requests
+// aren't recorded or rate limited.
+func healthClient(ctx context.Context) (*s3.Client, error) {
+	inner, ok := upstream.inner.(*http.Transport)
+	if !ok {
+		return nil, fmt.Errorf("can't give --healthcheck-object its own connections on a %T", upstream.inner)
+	}
+	t := defaultTarget()
+	t.client = &http.Client{Transport: inner.Clone(), CheckRedirect: checkRedirect}
+	_, client, err := connectTo(ctx, t)
+	return client, err
+}
+
+// Take the baseline, then start probing `object` every `interval`.
+func startHealthcheck(ctx context.Context, object string, interval time.Duration, progress func() int64, emit func(jsonlRecord)) (*healthcheck, error) {
+	client, err := healthClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(withoutRecording(ctx))
+	h := &healthcheck{client: client, object: object, progress: progress, emit: emit, cancel: cancel}
+	h.report.Object, h.report.Interval = object, interval
+
+	var baseline []time.Duration
+	for range healthBaselineProbes {
+		s := h.probe(ctx)
+		if s.Err != "" {
+			cancel()
+			return nil, fmt.Errorf("control probe of %s failed: %s", object, s.Err)
+		}
+		baseline = append(baseline, s.Duration)
+	}
+	h.report.Baseline = computeLatencyStats(baseline).P50
+	fmt.Printf("Control probe: 1 byte of %s every %v, baseline %.3fs\n", object, interval, h.report.Baseline.Seconds())
+
+	h.done.Add(1)
+	go func() {
+		defer h.done.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			s := h.probe(ctx)
+			if ctx.Err() != nil {
+				return // stopped mid-probe; don't count it
+			}
+			h.add(s)
+		}
+	}()
+	return h, nil
+}
+
+// GET the first byte of the control object.
+func (h *healthcheck) probe(ctx context.Context) healthSample {
+	s := healthSample{Time: time.Now(), BytesRead: h.progress()}
+	out, err := h.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: bucket,
+		Key:    aws.String(h.object),
+		Range:  aws.String("bytes=0-0"),
+	})
+	if err == nil {
+		_, err = io.Copy(io.Discard, out.Body)
+		out.Body.Close()
+	}
+	s.Duration = time.Since(s.Time)
+	if err != nil {
+		s.Err = err.Error()
+	}
+	return s
+}
+
+func (h *healthcheck) degraded(s healthSample) bool {
+	return s.Err != "" || (float64(s.Duration) >= healthDegradeFactor*float64(h.report.Baseline) && s.Duration >= h.report.Baseline+healthDegradeSlack)
+}
+
+func (h *healthcheck) add(s healthSample) {
+	h.mu.Lock()
+	r := &h.report
+	if n := len(r.Samples); r.Degraded == nil && n > 0 && h.degraded(r.Samples[n-1]) && h.degraded(s) {
+		first := r.Samples[n-1]
+		r.Degraded = &first
+		fmt.Printf("Control probe degraded: %.3fs, against a %.3fs baseline, after the benchmark had read %s\n", first.Duration.Seconds(), r.Baseline.Seconds(), units.bytes(first.BytesRead))
+	}
+	r.Samples = append(r.Samples, s)
+	h.mu.Unlock()
+	h.emit(jsonlRecord{Type: "health", Health: &s})
+}
+
+// Stop probing, and summarize.
+func (h *healthcheck) stop() *healthReport {
+	h.cancel()
+	h.done.Wait()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	r := h.report
+	var durations []time.Duration
+	for _, s := range r.Samples {
+		if s.Err != "" {
+			r.Errors++
+		} else {
+			durations = append(durations, s.Duration)
+		}
+	}
+	r.Latency = computeLatencyStats(durations)
+	return &r
+}
+
+func (r *healthReport) print(start time.Time, filesize int64) {
+	fmt.Printf("Control probe: %d probes of %s, %d failed; baseline %.3fs, during the run %s\n", len(r.Samples), r.Object, r.Errors, r.Baseline.Seconds(), r.Latency)
+	if d := r.Degraded; d != nil {
+		fmt.Printf("  degraded %.1fs into the run, after the benchmark had read %s (%.1f%% of the file)\n", d.Time.Sub(start).Seconds(), units.bytes(d.BytesRead), percentOf(d.BytesRead, filesize))
+	} else {
+		fmt.Printf("  never degraded to %.0fx the baseline\n", healthDegradeFactor)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+// Some proxies in front of SeaweedFS negotiate HTTP/2, which puts all
+// of our "independent" range requests on one multiplexed connection,
+// and can change how the server behaves a lot.  So the transport
+// records the protocol of every response, each read records the
+// protocol of the response that carried its data, and the summary
+// says which protocols were used, with a latency breakdown by
+// protocol if there was more than one.  --http-version forces one or
+// the other: 1.1, or 2, which over plain http:// means h2c with prior
+// knowledge.
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Set up the transport for --http-version.
+func configureHTTPVersion(version string) error {
+	if version == "" {
+		return nil
+	}
+	inner, ok := upstream.inner.(*http.Transport)
+	if !ok {
+		return fmt.Errorf("can't force --http-version on a %T", upstream.inner)
+	}
+	t := inner.Clone()
+	var p http.Protocols
+	switch version {
+	case "1.1":
+		p.SetHTTP1(true)
+	case "2":
+		p.SetHTTP2(true)
+		p.SetUnencryptedHTTP2(true)
+	default:
+		return fmt.Errorf("unknown --http-version %q; use 1.1 or 2", version)
+	}
+	t.Protocols = &p
+	upstream.inner = t
+	return nil
+}
+
+// Return the protocol of the response carrying a read's data, or of
+// its first response if none carried any.
+func (r *responseSet) protocol() string {
+	if data := r.data(); data != nil {
+		return data.Proto
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.requests) > 0 {
+		return r.requests[0].Proto
+	}
+	return ""
+}
+
+// Count the recorded responses by protocol.
+func countProtocols(requests []*recordedRequest) map[string]int {
+	counts := map[string]int{}
+	for _, r := range requests {
+		if r.Proto != "" {
+			counts[r.Proto]++
+		}
+	}
+	return counts
+}
+
+// Print which protocols the responses used and, if there was more
+// than one, the reads' latency with each.
+func reportProtocols(counts map[string]int, samples []*Sample) {
+	if len(counts) == 0 {
+		return
+	}
+	var protos []string
+	for p := range counts {
+		protos = append(protos, p)
+	}
+	slices.Sort(protos)
+	var parts []string
+	for _, p := range protos {
+		parts = append(parts, fmt.Sprintf("%s for %d", p, counts[p]))
+	}
+	fmt.Printf("HTTP protocols: %s responses\n", strings.Join(parts, ", "))
+	if len(protos) < 2 {
+		return
+	}
+
+	latencies := map[string][]time.Duration{}
+	for _, s := range samples {
+		if s.Err == "" && s.Protocol != "" {
+			latencies[s.Protocol] = append(latencies[s.Protocol], s.Duration)
+		}
+	}
+	for _, p := range protos {
+		if len(latencies[p]) > 0 {
+			fmt.Printf("  %s: %d reads, %s\n", p, len(latencies[p]), computeLatencyStats(latencies[p]))
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+// At high concurrency with big reads, we can ask for far more data
+// than the client's NIC can take, and then the reads queue up on our
+// side and client-side buffering looks like server latency.
+// --max-inflight-bytes holds each read back until the bytes already
+// requested but not yet drained, plus its own, fit under the limit.
+// The time spent waiting isn't counted in the read's latency, and the
+// summary says how often and how long workers waited, which separates
+// "the server can't produce" from "the client can't consume".
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// inflightGate is a semaphore weighted by read size.
+type inflightGate struct {
+	mu    sync.Mutex
+	freed *sync.Cond
+	limit int64 // 0 for no limit
+	used  int64
+
+	reads   int
+	waited  int
+	total   time.Duration
+	longest time.Duration
+}
+
+var inflight = &inflightGate{}
+
+// Set the gate's limit; 0 turns it off.
+func (g *inflightGate) setLimit(limit int64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.limit = limit
+	if g.freed == nil {
+		g.freed = sync.NewCond(&g.mu)
+	}
+}
+
+// Wait until `size` more bytes fit under the limit, and claim them.
+// A read bigger than the whole limit goes ahead once nothing else is
+// in flight, rather than waiting forever.  Returns how long it waited.
+func (g *inflightGate) acquire(size int64) time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.limit == 0 {
+		return 0
+	}
+	start := time.Now()
+	blocked := false
+	for g.used > 0 && g.used+size > g.limit {
+		blocked = true
+		g.freed.Wait()
+	}
+	g.used += size
+	g.reads++
+	if !blocked {
+		return 0
+	}
+	wait := time.Since(start)
+	g.waited++
+	g.total += wait
+	g.longest = max(g.longest, wait)
+	return wait
+}
+
+// Give back `size` bytes claimed by acquire().
+func (g *inflightGate) release(size int64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.limit == 0 {
+		return
+	}
+	g.used -= size
+	g.freed.Broadcast()
+}
+
+// Print how often reads had to wait for the gate.
+func (g *inflightGate) report() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.limit == 0 {
+		return
+	}
+	fmt.Printf("In-flight limit: %d of %d reads waited for --max-inflight-bytes=%d, for %.3fs in total (longest %.3fs)\n",
+		g.waited, g.reads, g.limit, g.total.Seconds(), g.longest.Seconds())
+}
@@ -0,0 +1,125 @@
+package main
+
+// The incident that keeps recurring: one viewer's big sequential reads
+// make everyone else's small reads slow.  Two separate runs never line
+// up in time well enough to show it, so --interference runs both in
+// one: small random reads for the whole run, and large sequential
+// reads only during the middle third.  Comparing the small reads'
+// latency before, during, and after the large ones gives the
+// interference directly, and the "after" phase shows whether things
+// recover once the large reads stop.
+//
+// Both streams read the file named on the command line, using the
+// tenant machinery from tenants.go: the large reads are a stream
+// tenant with --interference-viewers workers, and the small reads are
+// a random tenant starting --interference-rate reads a second.
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// interferencePhase is the small reads' latency during one phase.
+type interferencePhase struct {
+	Name    string       `json:"name"`
+	Reads   int          `json:"reads"`
+	Errors  int          `json:"errors"`
+	Latency latencyStats `json:"latency"`
+}
+
+// interferenceResult is the --json output of an --interference run.
+type interferenceResult struct {
+	RunInfo
+	Window time.Duration       `json:"windowNs"`
+	Phases []interferencePhase `json:"phases"`
+	Large  *tenantResult       `json:"large"`
+	Small  *tenantResult       `json:"small"`
+}
+
+// Run small random reads for three windows, with large sequential
+// reads alongside them in the second, and print the small reads'
+// latency in each.
+func runInterference(ctx context.Context, backend Backend, filename string, filesize int64, result *interferenceResult) error {
+	window := *interferenceWin
+	large, small := *interferenceLarge, *interferenceSmall
+	if large > filesize || small > filesize {
+		return fmt.Errorf("%s is only %d bytes, smaller than the %d and %d byte interference reads", filename, filesize, large, small)
+	}
+	objects := []tenantObject{{key: filename, size: filesize}}
+	result.Window = window
+	result.Large = &tenantResult{Tenant: tenant{Name: "large", Kind: "stream", ReadSize: large, Viewers: *interferenceView, Start: window}, objects: objects}
+	result.Small = &tenantResult{Tenant: tenant{Name: "small", Kind: "random", ReadSize: small, Rate: *interferenceRate, Workers: 16}, objects: objects}
+
+	fmt.Printf("Interference: %d byte random reads at %.0f/s for %v, with %d workers of %d byte sequential reads in the middle %v\n",
+		small, *interferenceRate, 3*window, *interferenceView, large, window)
+	start := time.Now()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		runRandomTenant(ctx, backend, result.Small, start.Add(3*window))
+		result.Small.Duration = time.Since(start)
+	}()
+	time.Sleep(window)
+	fmt.Printf("Interference: starting the large reads\n")
+	runStreamTenant(ctx, backend, result.Large, start.Add(2*window))
+	result.Large.Duration = time.Since(start) - window
+	fmt.Printf("Interference: stopped the large reads\n")
+	wg.Wait()
+
+	for _, r := range []*tenantResult{result.Large, result.Small} {
+		var durations []time.Duration
+		for _, s := range r.Samples {
+			r.Reads++
+			if s.Err != "" {
+				r.Errors++
+				continue
+			}
+			r.Bytes += s.Bytes
+			durations = append(durations, s.Duration)
+		}
+		r.Latency = computeLatencyStats(durations)
+	}
+
+	names := []string{"before", "during", "after"}
+	phases := make([][]time.Duration, 3)
+	result.Phases = make([]interferencePhase, 3)
+	for i := range result.Phases {
+		result.Phases[i].Name = names[i]
+	}
+	for _, s := range result.Small.Samples {
+		i := min(int(s.Start.Sub(start)/window), 2)
+		result.Phases[i].Reads++
+		if s.Err != "" {
+			result.Phases[i].Errors++
+			continue
+		}
+		phases[i] = append(phases[i], s.Duration)
+	}
+	for i := range result.Phases {
+		result.Phases[i].Latency = computeLatencyStats(phases[i])
+	}
+	printInterference(result)
+	return nil
+}
+
+// Print the small reads' latency in each phase, relative to before.
+func printInterference(r *interferenceResult) {
+	fmt.Printf("Large reads: %d reads, %d errors, %s%s, p90 %.3fs\n", r.Large.Reads, r.Large.Errors, units.rate(r.Large.Bytes, r.Large.Duration), ceiling.describe(r.Large.Bytes, r.Large.Duration), r.Large.Latency.P90.Seconds())
+	fmt.Printf("Small reads by phase:\n")
+	fmt.Printf("%-8s %8s %7s %10s %10s %10s %12s\n", "phase", "reads", "errors", "p50", "p90", "p99", "p90 change")
+	before := r.Phases[0].Latency.P90
+	for _, p := range r.Phases {
+		change := "n/a"
+		if before > 0 {
+			change = fmt.Sprintf("%.2fx", p.Latency.P90.Seconds()/before.Seconds())
+		}
+		fmt.Printf("%-8s %8d %7d %10.3f %10.3f %10.3f %12s\n", p.Name, p.Reads, p.Errors,
+			p.Latency.P50.Seconds(), p.Latency.P90.Seconds(), p.Latency.P99.Seconds(), change)
+	}
+	if r.Small.Dropped > 0 {
+		fmt.Printf("WARNING: %d small reads were dropped because every worker was busy\n", r.Small.Dropped)
+	}
+}
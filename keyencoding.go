@@ -0,0 +1,165 @@
+package main
+
+// Real keys have spaces, '+', '#', and non-ASCII in them, and every
+// layer between us and the filer has its own idea of how to escape
+// them.  When "my file (1080p) #2.mp4" fails, it's hard to tell
+// whether we or the gateway got it wrong.  --probe-key-encoding
+// uploads a small object under each of a gauntlet of nasty key names
+// (each holding its own name, so we can tell if we got a different
+// object back), checks that ListObjectsV2 returns the key unchanged,
+// reads it back with every S3 backend, and prints a PASS/FAIL table.
+// The objects are deleted afterward.
+//
+// s3fs can only open keys that are valid io/fs paths, so it's SKIPped
+// for keys with empty or "."/".." segments.
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Key names that have caused trouble somewhere.
+var nastyKeys = []string{
+	"plain.mp4",
+	"with space.mp4",
+	"plus+sign.mp4",
+	"hash#2.mp4",
+	"my file (1080p) #2.mp4",
+	"percent%20literal.mp4",
+	"question?mark.mp4",
+	"amp&equals=.mp4",
+	"colon:semi;comma,.mp4",
+	"at@dollar$.mp4",
+	"tilde~apostrophe'.mp4",
+	"brackets[1]{2}.mp4",
+	"caret^pipe|backtick`.mp4",
+	"backslash\\name.mp4",
+	"trailing space .mp4",
+	"ünïcödé-ファイル.mp4",
+	"emoji-🎬.mp4",
+	"nfd-é.mp4",
+	"dir/sub dir/nested.mp4",
+	"double//slash.mp4",
+}
+
+// The backends every key is read back with.
+var keyProbeModes = []string{"getobject", "http", "presigned", "s3fs"}
+
+// Upload, list, and read back every key in nastyKeys under `prefix`.
+// Returns the exit status.
+func runKeyEncodingProbe(ctx context.Context, client *s3.Client, prefix string) int {
+	backends := make(map[string]Backend)
+	for _, m := range keyProbeModes {
+		b, err := newBackend(m, client, defaultTarget(), "")
+		if err != nil {
+			panic(err)
+		}
+		backends[m] = b
+	}
+
+	fmt.Printf("Probing key encoding with %d keys under %s\n", len(nastyKeys), prefix)
+	fmt.Printf("%-32s %-5s %-5s", "key", "put", "list")
+	for _, m := range keyProbeModes {
+		fmt.Printf(" %-9s", m)
+	}
+	fmt.Printf("\n")
+
+	var failures []string
+	for _, name := range nastyKeys {
+		key := prefix + name
+		row := fmt.Sprintf("%-32q", name)
+		fail := func(what string, err error) {
+			row += fmt.Sprintf(" %-5s", "FAIL")
+			failures = append(failures, fmt.Sprintf("%q %s: %v", name, what, err))
+		}
+
+		content := []byte(key)
+		_, err := client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket: bucket,
+			Key:    aws.String(key),
+			Body:   bytes.NewReader(content),
+		})
+		if err != nil {
+			fail("put", err)
+			fmt.Printf("%s\n", row)
+			continue
+		}
+		row += fmt.Sprintf(" %-5s", "PASS")
+
+		if err := checkListedKey(ctx, client, key); err != nil {
+			fail("list", err)
+		} else {
+			row += fmt.Sprintf(" %-5s", "PASS")
+		}
+
+		for _, m := range keyProbeModes {
+			result := "PASS"
+			if m == "s3fs" && !fs.ValidPath(key) {
+				result = "SKIP"
+			} else if err := readBackKey(ctx, backends[m], key, content); err != nil {
+				result = "FAIL"
+				failures = append(failures, fmt.Sprintf("%q via %s: %v", name, m, err))
+			}
+			row += fmt.Sprintf(" %-9s", result)
+		}
+		fmt.Printf("%s\n", row)
+
+		if _, err := client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: bucket, Key: aws.String(key)}); err != nil {
+			fmt.Printf("WARNING: unable to delete %q: %v\n", key, err)
+		}
+	}
+
+	for _, f := range failures {
+		fmt.Printf("FAIL %s\n", f)
+	}
+	if len(failures) > 0 {
+		fmt.Printf("%d checks failed\n", len(failures))
+		return 1
+	}
+	fmt.Printf("All keys passed\n")
+	return 0
+}
+
+// Check that listing finds `key` exactly as it was written.
+func checkListedKey(ctx context.Context, client *s3.Client, key string) error {
+	out, err := client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+		Bucket:  bucket,
+		Prefix:  aws.String(key),
+		MaxKeys: aws.Int32(10),
+	})
+	if err != nil {
+		return err
+	}
+	var seen []string
+	for _, obj := range out.Contents {
+		if aws.ToString(obj.Key) == key {
+			return nil
+		}
+		seen = append(seen, fmt.Sprintf("%q", aws.ToString(obj.Key)))
+	}
+	return fmt.Errorf("not listed; listing the prefix found [%s]", strings.Join(seen, " "))
+}
+
+// Read all of `key` through `backend` and check that it's `want`.
+func readBackKey(ctx context.Context, backend Backend, key string, want []byte) error {
+	f, err := backend.Open(ctx, key, 0, int64(len(want)), &Sample{})
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	got, err := io.ReadAll(io.LimitReader(f, int64(len(want))+1))
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(got, want) {
+		return fmt.Errorf("got a different object back: %q", got)
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Return the speed of interface `name` in Mbps, from sysfs.  Virtual
+// interfaces either have no speed, or report -1.
+func interfaceSpeed(name string) (float64, error) {
+	b, err := os.ReadFile("/sys/class/net/" + name + "/speed")
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(strings.TrimSpace(string(b)), 64)
+}
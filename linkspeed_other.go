@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// We don't know how to ask on this platform; use --link-speed.
+func interfaceSpeed(name string) (float64, error) {
+	return 0, errors.ErrUnsupported
+}
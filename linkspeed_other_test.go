@@ -0,0 +1,24 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestInterfaceSpeedUnavailable(t *testing.T) {
+	if _, err := interfaceSpeed("en0"); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("interfaceSpeed() = %v", err)
+	}
+	setFlag(t, linkSpeed, 0)
+	if c := findLinkSpeed("127.0.0.1:8333"); c != nil {
+		t.Errorf("findLinkSpeed() = %+v without --link-speed", c)
+	}
+}
+
+func TestPhysicalMemoryUnavailable(t *testing.T) {
+	if mem := physicalMemory(); mem != 0 {
+		t.Errorf("physicalMemory() = %d", mem)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+// --mode=localfs runs the same read loop against a local file, to get
+// a hardware baseline for the S3 numbers.  It's also a quick way to
+// try out reporting changes without a cluster.
+//
+// Local reads normally come from the page cache after the first run,
+// which measures memory rather than disk.  On Linux, --direct-io opens
+// the file with O_DIRECT to bypass the cache; this needs every read's
+// offset and size to be a multiple of the disk's block size, which we
+// take to be 4096.
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+type localFSBackend struct {
+	direct bool
+}
+
+func (b *localFSBackend) Open(ctx context.Context, filename string, offset, size int64, sample *Sample) (io.ReadCloser, error) {
+	flags := os.O_RDONLY
+	if b.direct {
+		flags |= directIOFlag
+	}
+
+	start := time.Now()
+	f, err := os.OpenFile(filename, flags, 0)
+	sample.addPhase("open", time.Since(start))
+	if err != nil {
+		return nil, err
+	}
+	if info, err := f.Stat(); err == nil {
+		sample.ObjectSize = info.Size()
+	}
+
+	if *noSeek {
+		if err := skipTo(f, offset, sample); err != nil {
+			f.Close()
+			return nil, &readError{Phase: "seek", Err: err}
+		}
+		return f, nil
+	}
+
+	start = time.Now()
+	_, err = f.Seek(offset, io.SeekStart)
+	sample.addPhase("seek", time.Since(start))
+	if err != nil {
+		f.Close()
+		return nil, &readError{Phase: "seek", Err: err}
+	}
+
+	if *checkPosition {
+		return newPositionChecker(f, offset, sample), nil
+	}
+	return f, nil
+}
+
+// Describe whether local reads are likely to hit the page cache.
+func localCacheNote(direct bool) string {
+	if direct {
+		return "localfs: reading with O_DIRECT, bypassing the page cache"
+	}
+	return "localfs: reads may be served from the page cache; use --direct-io on Linux, or drop caches between runs, to measure the disk"
+}
+
+// The alignment that --direct-io needs.
+const directIOAlign = 4096
+
+// Check that --direct-io can work here, and that every read it makes
+// will be aligned.
+func checkDirectIO() error {
+	if directIOFlag == 0 {
+		return fmt.Errorf("--direct-io is only supported on Linux")
+	}
+	if *readsize%directIOAlign != 0 {
+		return fmt.Errorf("--direct-io needs --readsize to be a multiple of %d", directIOAlign)
+	}
+	for _, r := range explicitRanges {
+		if r.offset%directIOAlign != 0 || r.size%directIOAlign != 0 {
+			return fmt.Errorf("--direct-io needs --range offsets and lengths to be multiples of %d, not %d:%d", directIOAlign, r.offset, r.size)
+		}
+	}
+	if *pattern == "same-range" || *pattern == "open-storm" {
+		if *rangeOffset%directIOAlign != 0 || *rangeLength%directIOAlign != 0 {
+			return fmt.Errorf("--direct-io needs --offset and --length to be multiples of %d", directIOAlign)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,7 @@
+//go:build linux
+
+package main
+
+import "syscall"
+
+const directIOFlag = syscall.O_DIRECT
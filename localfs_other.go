@@ -0,0 +1,6 @@
+//go:build !linux
+
+package main
+
+// O_DIRECT is Linux-only.
+const directIOFlag = 0
@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+func TestCheckDirectIO(t *testing.T) {
+	if directIOFlag == 0 {
+		if err := checkDirectIO(); err == nil {
+			t.Errorf("--direct-io worked without O_DIRECT")
+		}
+		return
+	}
+	setFlag(t, readsize, 1<<20)
+	setFlag(t, pattern, "sequential")
+	setFlag(t, &explicitRanges, rangeList{{offset: 8192, size: 4096}})
+	if err := checkDirectIO(); err != nil {
+		t.Errorf("aligned: %v", err)
+	}
+
+	for _, r := range []readRange{{offset: 100, size: 4096}, {offset: 4096, size: 5000}} {
+		setFlag(t, &explicitRanges, rangeList{r})
+		if err := checkDirectIO(); err == nil {
+			t.Errorf("--range=%d:%d worked", r.offset, r.size)
+		}
+	}
+	setFlag(t, &explicitRanges, nil)
+
+	setFlag(t, readsize, 5000)
+	if err := checkDirectIO(); err == nil {
+		t.Errorf("--readsize=5000 worked")
+	}
+	setFlag(t, readsize, 4096)
+
+	setFlag(t, pattern, "same-range")
+	setFlag(t, rangeOffset, 100)
+	if err := checkDirectIO(); err == nil {
+		t.Errorf("--offset=100 worked")
+	}
+	setFlag(t, rangeOffset, 0)
+	setFlag(t, rangeLength, 4096)
+	if err := checkDirectIO(); err != nil {
+		t.Errorf("--offset=0 --length=4096: %v", err)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+// --readsize 268435456 with --concurrency 8 needs 2 GB of read
+// buffers, which is enough to get the tool OOM-killed on a small VM
+// before it prints anything useful.  --max-memory caps the total,
+// and --memory-policy says what to do when the plan won't fit:
+//
+//   - refuse: exit with an explanation before reading anything.
+//   - reduce-concurrency: run fewer workers at once.
+//   - stream: read each response through a fixed 1 MB scratch
+//     buffer, throwing the data away as it arrives.
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Size of the scratch buffer used with --memory-policy=stream.
+const scratchSize = 1 << 20
+
+// If true, readFrom() drains responses through a scratch buffer
+// instead of holding the whole read in memory.
+var streamReads bool
+
+// bufferAccounting tracks how many bytes of read buffers are
+// allocated at once.
+type bufferAccounting struct {
+	mu      sync.Mutex
+	current int64
+	peak    int64
+}
+
+var buffers bufferAccounting
+
+// Allocate a read buffer of `size` bytes.  Call put() when done.
+func (a *bufferAccounting) get(size int64) []byte {
+	a.reserve(size)
+	return make([]byte, size)
+}
+
+func (a *bufferAccounting) put(b []byte) {
+	a.release(int64(len(b)))
+}
+
+// Count `size` bytes of buffers that are in use, but were allocated
+// elsewhere; see drain.go.  Call release() when done.
+func (a *bufferAccounting) reserve(size int64) {
+	a.mu.Lock()
+	a.current += size
+	a.peak = max(a.peak, a.current)
+	a.mu.Unlock()
+}
+
+func (a *bufferAccounting) release(size int64) {
+	a.mu.Lock()
+	a.current -= size
+	a.mu.Unlock()
+}
+
+// Return the most buffer memory that was ever allocated at once.
+func (a *bufferAccounting) Peak() int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.peak
+}
+
+// Return the size of the buffer readFrom() will use for a `size`
+// byte read.
+func bufferSize(size int64) int64 {
+	if *drainFlag == "discard" {
+		return 0
+	}
+	if streamReads {
+		return min(size, scratchSize)
+	}
+	return size
+}
+
+// Apply --max-memory and --memory-policy to `sched`, possibly
+// lowering its concurrency or turning on streamReads.
+func limitMemory(sched *schedule, limit int64, policy string) error {
+	var largest int64
+	for _, r := range sched.Reads {
+		largest = max(largest, r.Size)
+	}
+	need := bufferSize(largest) * int64(sched.Concurrency)
+	if limit == 0 || need <= limit {
+		return nil
+	}
+
+	switch policy {
+	case "refuse":
+		return fmt.Errorf("%d workers with reads of up to %d bytes need %d bytes of buffers, more than --max-memory=%d; try --memory-policy=reduce-concurrency or stream", sched.Concurrency, largest, need, limit)
+	case "reduce-concurrency":
+		workers := limit / largest
+		if workers == 0 {
+			return fmt.Errorf("a single %d byte read doesn't fit in --max-memory=%d; try --memory-policy=stream", largest, limit)
+		}
+		fmt.Printf("Reducing concurrency from %d to %d to fit in --max-memory=%d\n", sched.Concurrency, workers, limit)
+		sched.Concurrency = int(workers)
+	case "stream":
+		if need = min(largest, scratchSize) * int64(sched.Concurrency); need > limit {
+			return fmt.Errorf("%d workers need %d bytes of scratch buffers, more than --max-memory=%d", sched.Concurrency, need, limit)
+		}
+		fmt.Printf("Streaming reads through %d byte scratch buffers to fit in --max-memory=%d\n", min(largest, scratchSize), limit)
+		streamReads = true
+	default:
+		return fmt.Errorf("unknown --memory-policy %q; use refuse, reduce-concurrency, or stream", policy)
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package main
+
+// Counting bytes won't notice a server that's fast because it's
+// sending the wrong ones, and what we actually care about is video
+// that plays.  With --validate-mp4, we walk the file's top-level MP4
+// boxes before the run (one small read per box, which isn't counted
+// as part of the run), insist that the first one is an ftyp, and
+// then check every read's data against that index: wherever a read
+// covers a box header, the bytes have to be the box's size and type.
+// Anything else is reported as corruption at that offset.
+//
+// This only looks at top-level box headers, so it catches data from
+// the wrong offset or the wrong object, not a flipped bit in the
+// middle of an mdat; --verify-checksums does that when the server
+// supports it.  No ffprobe is needed, just enough of ISO 14496-12 to
+// follow box sizes.
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+)
+
+const (
+	mp4HeaderLen      = 8
+	mp4LargeHeaderLen = 16
+	mp4MaxBoxes       = 100000
+)
+
+var errBadMP4 = errors.New("not an MP4 file, or a corrupt one")
+
+// mp4Box is a top-level box, and the header bytes that should be at
+// its offset.
+type mp4Box struct {
+	Type   string
+	Offset int64
+	Size   int64
+	header []byte
+}
+
+// The file's top-level boxes, with --validate-mp4.
+var mp4Index []mp4Box
+
+// Read the header of each top-level box in `filename`.
+func indexMP4(ctx context.Context, backend Backend, filename string, filesize int64) ([]mp4Box, error) {
+	ctx = unrecorded(ctx)
+	var boxes []mp4Box
+	for offset := int64(0); offset < filesize; {
+		if len(boxes) == mp4MaxBoxes {
+			return nil, fmt.Errorf("more than %d top-level boxes", mp4MaxBoxes)
+		}
+		header, err := readMP4Header(ctx, backend, filename, offset, min(mp4LargeHeaderLen, filesize-offset))
+		if err != nil {
+			return nil, err
+		}
+		box, err := parseMP4Header(header, offset, filesize)
+		if err != nil {
+			return nil, err
+		}
+		if offset == 0 && box.Type != "ftyp" {
+			return nil, fmt.Errorf("%w: the first box is %q, not ftyp", errBadMP4, box.Type)
+		}
+		boxes = append(boxes, box)
+		offset += box.Size
+	}
+	return boxes, nil
+}
+
+// Read `size` bytes at `offset`.
+func readMP4Header(ctx context.Context, backend Backend, filename string, offset, size int64) ([]byte, error) {
+	f, err := backend.Open(ctx, filename, offset, size, &Sample{Offset: offset, Size: size})
+	if err != nil {
+		return nil, fmt.Errorf("reading the box header at %d: %w", offset, err)
+	}
+	defer f.Close()
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(f, buf); err != nil {
+		return nil, fmt.Errorf("reading the box header at %d: %w", offset, err)
+	}
+	return buf, nil
+}
+
+// Parse the box header at `offset`, which `header` starts with.
+func parseMP4Header(header []byte, offset, filesize int64) (mp4Box, error) {
+	if len(header) < mp4HeaderLen {
+		return mp4Box{}, fmt.Errorf("%w: %d bytes at %d is too short for a box header", errBadMP4, len(header), offset)
+	}
+	box := mp4Box{Type: string(header[4:8]), Offset: offset, Size: int64(binary.BigEndian.Uint32(header)), header: header[:mp4HeaderLen]}
+	for _, c := range box.Type {
+		if c < 0x20 || c > 0x7e {
+			return mp4Box{}, fmt.Errorf("%w: box at %d has type %q", errBadMP4, offset, box.Type)
+		}
+	}
+	switch box.Size {
+	case 0:
+		// The box runs to the end of the file.
+		box.Size = filesize - offset
+	case 1:
+		if len(header) < mp4LargeHeaderLen {
+			return mp4Box{}, fmt.Errorf("%w: %q box at %d is too short for its 64-bit size", errBadMP4, box.Type, offset)
+		}
+		box.Size = int64(binary.BigEndian.Uint64(header[8:]))
+		box.header = header[:mp4LargeHeaderLen]
+		if box.Size < mp4LargeHeaderLen {
+			return mp4Box{}, fmt.Errorf("%w: %q box at %d claims %d bytes", errBadMP4, box.Type, offset, box.Size)
+		}
+	default:
+		if box.Size < mp4HeaderLen {
+			return mp4Box{}, fmt.Errorf("%w: %q box at %d claims %d bytes", errBadMP4, box.Type, offset, box.Size)
+		}
+	}
+	if box.Size > filesize-offset {
+		return mp4Box{}, fmt.Errorf("%w: %q box at %d claims %d bytes, past the end of the %d byte file", errBadMP4, box.Type, offset, box.Size, filesize)
+	}
+	box.header = slices.Clone(box.header)
+	return box, nil
+}
+
+// Check `data`, which was read at `offset`, against the box headers
+// it covers, and return a description of the first mismatch, if any.
+func checkMP4(boxes []mp4Box, offset int64, data []byte) string {
+	end := offset + int64(len(data))
+	// The first box whose header ends after `offset`.
+	i := sort.Search(len(boxes), func(i int) bool {
+		return boxes[i].Offset+int64(len(boxes[i].header)) > offset
+	})
+	for ; i < len(boxes) && boxes[i].Offset < end; i++ {
+		b := boxes[i]
+		for j, want := range b.header {
+			pos := b.Offset + int64(j)
+			if pos < offset || pos >= end {
+				continue
+			}
+			if got := data[pos-offset]; got != want {
+				return fmt.Sprintf("byte %d should be %#02x, part of the %q box header at %d, but was %#02x", pos, want, b.Type, b.Offset, got)
+			}
+		}
+	}
+	return ""
+}
+
+// Print how many reads returned data that broke the box structure.
+func reportMP4(samples []*Sample) {
+	var bad int
+	var first *Sample
+	for _, s := range samples {
+		if s.MP4Error == "" {
+			continue
+		}
+		bad++
+		if first == nil {
+			first = s
+		}
+	}
+	if bad == 0 {
+		fmt.Printf("MP4 validation: all %d reads matched the %d top-level box headers\n", len(samples), len(mp4Index))
+		return
+	}
+	fmt.Printf("MP4 validation: %d of %d reads returned corrupt data; first, at offset %d: %s\n", bad, len(samples), first.Offset, first.MP4Error)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testdata/sample.mp4 is a tiny file with the top-level structure of
+// a real one: an ftyp, a moov with an mvhd, a free, an mdat with a
+// 64-bit size, and a last box whose size is 0, meaning "to the end".
+const sampleMP4 = "testdata/sample.mp4"
+
+var sampleBoxes = []struct {
+	typ          string
+	offset, size int64
+}{
+	{"ftyp", 0, 32},
+	{"moov", 32, 116},
+	{"free", 148, 40},
+	{"mdat", 188, 3016},
+	{"skip", 3204, 108},
+}
+
+func indexSample(t *testing.T) ([]mp4Box, []byte) {
+	t.Helper()
+	data, err := os.ReadFile(sampleMP4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setFlag(t, noSeek, false)
+	setFlag(t, checkPosition, false)
+	boxes, err := indexMP4(context.Background(), &localFSBackend{}, sampleMP4, int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return boxes, data
+}
+
+func TestIndexMP4(t *testing.T) {
+	boxes, data := indexSample(t)
+	if len(boxes) != len(sampleBoxes) {
+		t.Fatalf("found %d boxes, want %d", len(boxes), len(sampleBoxes))
+	}
+	for i, want := range sampleBoxes {
+		b := boxes[i]
+		if b.Type != want.typ || b.Offset != want.offset || b.Size != want.size {
+			t.Errorf("box %d is %q at %d (%d bytes), want %q at %d (%d bytes)", i, b.Type, b.Offset, b.Size, want.typ, want.offset, want.size)
+		}
+		if !bytes.Equal(b.header, data[b.Offset:b.Offset+int64(len(b.header))]) {
+			t.Errorf("box %d has the wrong header bytes", i)
+		}
+	}
+	if n := len(boxes[3].header); n != mp4LargeHeaderLen {
+		t.Errorf("the mdat's header is %d bytes, want %d", n, mp4LargeHeaderLen)
+	}
+}
+
+func TestParseMP4Header(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{"short", "\x00\x00\x00\x08fty", "too short"},
+		{"binary type", "\x00\x00\x00\x08f\x00yp", "has type"},
+		{"too small", "\x00\x00\x00\x04ftyp", "claims 4 bytes"},
+		{"past the end", "\x00\x00\x10\x00ftyp", "past the end"},
+		{"64-bit, cut off", "\x00\x00\x00\x01mdat\x00\x00", "too short for its 64-bit size"},
+		{"64-bit, too small", "\x00\x00\x00\x01mdat\x00\x00\x00\x00\x00\x00\x00\x08", "claims 8 bytes"},
+	} {
+		_, err := parseMP4Header([]byte(tc.header), 0, 1000)
+		if !errors.Is(err, errBadMP4) || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: %v, want %q", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestIndexMP4NotFtyp(t *testing.T) {
+	path := t.TempDir() + "/moov-first.mp4"
+	data, err := os.ReadFile(sampleMP4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Drop the ftyp, so the file starts with the moov.
+	if err := os.WriteFile(path, data[32:], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := indexMP4(context.Background(), &localFSBackend{}, path, int64(len(data)-32)); !errors.Is(err, errBadMP4) {
+		t.Errorf("indexMP4() = %v, want errBadMP4", err)
+	}
+}
+
+func TestCheckMP4(t *testing.T) {
+	boxes, data := indexSample(t)
+	// Good data passes however it's cut up, including reads that
+	// split a header.
+	for _, size := range []int{1, 7, 100, 4096} {
+		for offset := 0; offset < len(data); offset += size {
+			end := min(offset+size, len(data))
+			if msg := checkMP4(boxes, int64(offset), data[offset:end]); msg != "" {
+				t.Fatalf("%d bytes at %d: %s", end-offset, offset, msg)
+			}
+		}
+	}
+
+	// Data from the wrong offset doesn't.
+	msg := checkMP4(boxes, 140, data[141:200])
+	if !strings.Contains(msg, `"free" box header at 148`) {
+		t.Errorf("shifted data: %q", msg)
+	}
+	// A flipped bit in the mdat's 64-bit size does.
+	bad := bytes.Clone(data[180:220])
+	bad[188+12-180] ^= 1
+	if msg := checkMP4(boxes, 180, bad); !strings.Contains(msg, "byte 200") {
+		t.Errorf("a flipped bit: %q", msg)
+	}
+	// But one in the middle of the mdat doesn't.
+	bad = bytes.Clone(data[1000:2000])
+	bad[500] ^= 1
+	if msg := checkMP4(boxes, 1000, bad); msg != "" {
+		t.Errorf("a flipped bit in the mdat: %q", msg)
+	}
+}
+
+// A server that returns the wrong bytes is caught as the data is
+// read.
+func TestValidateMP4Read(t *testing.T) {
+	boxes, data := indexSample(t)
+	setFlag(t, &mp4Index, boxes)
+	setFlag(t, mode, "getobject")
+	client := fakeS3(t, map[string][]byte{
+		"good.mp4": data,
+		"bad.mp4":  slices.Concat(data[:1], data), // one byte off
+	})
+	backend, err := newBackend(*mode, client, defaultTarget(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := int64(len(data))
+	for name, corrupt := range map[string]bool{"good.mp4": false, "bad.mp4": true} {
+		for _, offset := range []int64{0, 100, 3100} {
+			sample, err := readFrom(context.Background(), backend, name, offset, 200, size)
+			if err != nil {
+				t.Fatalf("%s at %d: %v", name, offset, err)
+			}
+			if (sample.MP4Error != "") != corrupt {
+				t.Errorf("%s at %d: MP4Error = %q", name, offset, sample.MP4Error)
+			}
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+// The s3fs and localfs backends depend on the handle from Open()
+// being seekable.  fs.File doesn't promise that, and a backend that
+// can't seek used to fail with a panic from the type assertion.  Now
+// it's an error, and --no-seek benchmarks such backends anyway: each
+// read opens the file, reads from the start up to its offset, and
+// throws that away.  The discarded bytes are counted separately, so
+// the summary shows how much the lack of Seek() costs.  Beware that
+// a sequential pattern reads the start of the file over and over, so
+// the total transferred grows with the square of the file size.
+//
+// For s3fs, --no-seek also leaves out s3fs.WithReadSeeker, so reads
+// go through its plain streaming fs.File.
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+var errNoSeek = errors.New("backend does not support seeking; use --mode=getobject, or --no-seek to read up to the offset instead")
+
+// Read and discard `offset` bytes from `r`, recording them on `sample`.
+func skipTo(r io.Reader, offset int64, sample *Sample) error {
+	start := time.Now()
+	n, err := io.CopyN(io.Discard, r, offset)
+	sample.addPhase("discard", time.Since(start))
+	sample.Discarded = n
+	if err == io.EOF {
+		return fmt.Errorf("file ended after %d bytes, before offset %d", n, offset)
+	}
+	return err
+}
+
+// Print how much was read and thrown away because of --no-seek.
+func reportDiscarded(samples []*Sample) {
+	var discarded, delivered int64
+	for _, s := range samples {
+		discarded += s.Discarded
+		delivered += s.Bytes
+	}
+	total := discarded + delivered
+	if total == 0 {
+		return
+	}
+	fmt.Printf("--no-seek: read and discarded %s to get to the offsets, on top of the %s used; %.1f%% of what we read was thrown away\n",
+		units.bytes(discarded), units.bytes(delivered), 100*float64(discarded)/float64(total))
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/jszwec/s3fs/v2"
+)
+
+// The fake server has to look like a real bucket through s3fs, with
+// and without seeking, for the s3fs tests to mean anything.
+func TestFakeS3FS(t *testing.T) {
+	client := fakeS3(t, map[string][]byte{
+		"big.mp4":        bytes.Repeat([]byte("v"), 100<<10),
+		"empty":          {},
+		"videos/a.mp4":   []byte("aaaa"),
+		"videos/b/c.mp4": []byte("c"),
+	})
+	for name, opts := range map[string][]s3fs.Option{"streaming": nil, "WithReadSeeker": {s3fs.WithReadSeeker}} {
+		if err := fstest.TestFS(s3fs.New(client, "test", opts...), "big.mp4", "empty", "videos/a.mp4", "videos/b/c.mp4"); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestSkipTo(t *testing.T) {
+	r := bytes.NewReader([]byte("0123456789"))
+	sample := &Sample{}
+	if err := skipTo(r, 4, sample); err != nil {
+		t.Fatal(err)
+	}
+	if rest, _ := io.ReadAll(r); string(rest) != "456789" || sample.Discarded != 4 {
+		t.Errorf("after skipping 4 bytes: %q, %d discarded", rest, sample.Discarded)
+	}
+	if err := skipTo(bytes.NewReader([]byte("0123")), 10, sample); err == nil || sample.Discarded != 4 {
+		t.Errorf("skipping past the end: %v, %d discarded", err, sample.Discarded)
+	}
+}
+
+// Reads with --no-seek return the same bytes as reads that seek, and
+// count what they threw away.
+func TestNoSeek(t *testing.T) {
+	data := make([]byte, 1<<20)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	client := fakeS3(t, map[string][]byte{"big.mp4": data})
+	local := filepath.Join(t.TempDir(), "big.mp4")
+	if err := os.WriteFile(local, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, m := range []string{"s3fs", "localfs"} {
+		filename := "big.mp4"
+		if m == "localfs" {
+			filename = local
+		}
+		backend, err := newBackend(m, client, defaultTarget(), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, skip := range []bool{false, true} {
+			setFlag(t, noSeek, skip)
+			for _, offset := range []int64{0, 1000, 1<<20 - 500} {
+				sample := &Sample{}
+				f, err := backend.Open(context.Background(), filename, offset, 500, sample)
+				if err != nil {
+					t.Fatalf("%s, --no-seek=%v, at %d: %v", m, skip, offset, err)
+				}
+				got := make([]byte, 500)
+				_, err = io.ReadFull(f, got)
+				f.Close()
+				if err != nil || !bytes.Equal(got, data[offset:offset+500]) {
+					t.Errorf("%s, --no-seek=%v, at %d: read the wrong bytes, %v", m, skip, offset, err)
+				}
+				want := int64(0)
+				if skip {
+					want = offset
+				}
+				if sample.Discarded != want {
+					t.Errorf("%s, --no-seek=%v, at %d: discarded %d bytes, want %d", m, skip, offset, sample.Discarded, want)
+				}
+			}
+
+			// Seeking past the end is fine, but reading up to
+			// there can't be.
+			if _, err := backend.Open(context.Background(), filename, 2<<20, 500, &Sample{}); skip && err == nil {
+				t.Errorf("%s, --no-seek: opening past the end worked", m)
+			}
+		}
+	}
+}
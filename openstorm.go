@@ -0,0 +1,82 @@
+package main
+
+// Caddy opens the same object hundreds of times a second under load,
+// and if the Opens alone are enough to load the filer, that's a
+// different bug from range-read amplification.  --pattern=open-storm
+// has every worker Open the object at --offset (for --length bytes,
+// for the backends whose Open is a ranged GET) and immediately Close
+// it, --iterations times, without reading anything.  --concurrency
+// and --read-interval set the rate.
+//
+// It goes through the usual read path and records ordinary samples,
+// so the summary, --json, --jsonl, and analyze all work as usual; the
+// latency is the Open's, and the bytes are zero.  The summary adds
+// which HTTP requests each Open made.
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Plan --iterations Opens on each of `concurrency` workers.
+func planOpenStorm(filesize int64, concurrency int) ([]scheduledRead, error) {
+	length := *rangeLength
+	if length == 0 {
+		length = min(*readsize, filesize)
+	}
+	if length > filesize || *rangeOffset > filesize-length {
+		return nil, fmt.Errorf("--offset %d and --length %d don't fit in the %d byte object", *rangeOffset, length, filesize)
+	}
+
+	var reads []scheduledRead
+	for range *iterations {
+		for w := range concurrency {
+			reads = append(reads, scheduledRead{Label: "open-storm", Worker: w, Offset: *rangeOffset, Size: length})
+		}
+	}
+	return reads, nil
+}
+
+// Print the Open latency and the requests that each Open made.
+func reportOpenStorm(samples []*Sample) {
+	latencies, errors := sampleLatencies(samples)
+	fmt.Printf("Open storm: %d Opens, %d failed, %s\n", len(samples), errors, computeLatencyStats(latencies))
+
+	// Group the Opens by the requests they made, like "1 HEAD, 1 GET".
+	counts := map[string]int{}
+	var kinds []string
+	var heads, gets int
+	var ok int
+	for _, s := range samples {
+		if s.Err != "" {
+			continue
+		}
+		ok++
+		heads += s.Heads
+		gets += s.Gets
+		var parts []string
+		if s.Heads > 0 {
+			parts = append(parts, fmt.Sprintf("%d HEAD", s.Heads))
+		}
+		if s.Gets > 0 {
+			parts = append(parts, fmt.Sprintf("%d GET", s.Gets))
+		}
+		kind := strings.Join(parts, ", ")
+		if kind == "" {
+			kind = "no requests"
+		}
+		if counts[kind] == 0 {
+			kinds = append(kinds, kind)
+		}
+		counts[kind]++
+	}
+	if ok == 0 {
+		return
+	}
+	fmt.Printf("  %.2f HEADs and %.2f GETs per Open\n", float64(heads)/float64(ok), float64(gets)/float64(ok))
+	slices.SortFunc(kinds, func(a, b string) int { return counts[b] - counts[a] })
+	for _, k := range kinds {
+		fmt.Printf("  %6d Opens made %s\n", counts[k], k)
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+// Structured output.  --json writes a single document at the end of
+// the run, while --jsonl appends one line per read as it happens, so
+// that a soak test that gets killed after six hours still leaves
+// something behind.  `s3test analyze FILE...` recomputes the
+// statistics from either afterward, and compares runs.
+//
+// Files written at the end of a run go to a temporary name in the
+// same directory and are renamed into place once they're complete,
+// so a crash or an OOM kill leaves either the old file or the new
+// one, never half of one.  --jsonl can't work that way, so it ends
+// with an "end" record instead, and analyze says when it's missing.
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/scottlaird/s3test/schema"
+)
+
+// RunInfo describes a run's configuration.
+type RunInfo struct {
+	SchemaVersion string    `json:"schemaVersion"` // see the schema package
+	RunID         string    `json:"runId"`
+	Start         time.Time `json:"start"`
+	Endpoint      string    `json:"endpoint"`
+	Bucket        string    `json:"bucket"`
+	File          string    `json:"file"`
+	Mode          string    `json:"mode"`
+	Addressing    string    `json:"addressing"`
+	ReadSize      int64     `json:"readSize"`
+	FileSize      int64     `json:"fileSize"`
+	Pattern       string    `json:"pattern,omitempty"`
+	Concurrency   int       `json:"concurrency,omitempty"`
+	Cache         string    `json:"cache"`
+	ETag          string    `json:"etag,omitempty"`
+	ReplayOf      string    `json:"replayOf,omitempty"` // the run ID this run replayed, with --replay-result
+	Pacing        *pacing   `json:"pacing,omitempty"`
+
+	Environment *Environment   `json:"environment,omitempty"`
+	Storage     *objectStorage `json:"storage,omitempty"` // see storageclass.go
+}
+
+// Result is the end-of-run document written by --json.
+type Result struct {
+	RunInfo
+	SizeDiscovery        *sizeDiscovery       `json:"sizeDiscovery,omitempty"`
+	Topology             *topologySnapshot    `json:"topology,omitempty"`
+	Bytes                int64                `json:"bytes"`
+	Duration             time.Duration        `json:"durationNs"` // not counting time spent paused
+	Paused               time.Duration        `json:"pausedNs,omitempty"`
+	Mbps                 float64              `json:"mbps"`
+	Errors               int                  `json:"errors"`
+	Latency              latencyStats         `json:"latency"`
+	Amplification        *amplificationReport `json:"amplification,omitempty"`
+	BackgroundMetadata   []metadataSample     `json:"backgroundMetadata,omitempty"`
+	Healthcheck          *healthReport        `json:"healthcheck,omitempty"` // with --healthcheck-object
+	Connections          []connectionStats    `json:"connections,omitempty"`
+	ConnectionUse        *connectionUsage     `json:"connectionUse,omitempty"`
+	Protocols            map[string]int       `json:"protocols,omitempty"` // responses by HTTP protocol
+	Redirects            *redirectReport      `json:"redirects,omitempty"`
+	ChunkLatency         *chunkReport         `json:"chunkLatency,omitempty"`         // with --by-chunk
+	SOCKS                *socksReport         `json:"socks,omitempty"`                // with --socks5
+	Handles              *handleReport        `json:"handles,omitempty"`              // with --reuse-handle
+	ConnectionsByAddress map[string]int       `json:"connectionsByAddress,omitempty"` // with --resolve
+	PeakBufferBytes      int64                `json:"peakBufferBytes"`
+	ClientLoad           *clientLoad          `json:"clientLoad,omitempty"`
+	ObjectChange         *objectChange        `json:"objectChange,omitempty"` // if set, the results are contaminated
+	SizeRechecks         []sizeRecheck        `json:"sizeRechecks,omitempty"` // after out-of-range reads
+	Schedule             *schedule            `json:"schedule,omitempty"`     // for --replay-result
+	Ceiling              *networkCeiling      `json:"ceiling,omitempty"`      // with a known link speed, or --net-baseline
+	Findings             []finding            `json:"findings,omitempty"`
+	Resumed              *resumeInfo          `json:"resumed,omitempty"` // with --resume
+	Samples              []*Sample            `json:"samples"`
+}
+
+// Return a new random run ID.
+func newRunID() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Read a --json result file.
+func loadResult(filename string) (*Result, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	result := &Result{}
+	if err := json.Unmarshal(b, result); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	if err := schema.Check(result.SchemaVersion); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return result, nil
+}
+
+// Print how `result` compares with the earlier `baseline`, warning
+// about client library changes.
+func compareBaseline(result, baseline *Result) {
+	fmt.Printf("Baseline %s: %s, p50 %.3fs, p90 %.3fs\n", baseline.RunID, units.rate(baseline.Bytes, baseline.Duration), baseline.Latency.P50.Seconds(), baseline.Latency.P90.Seconds())
+	fmt.Printf("This run %s: %s%s, p50 %.3fs, p90 %.3fs\n", result.RunID, units.rate(result.Bytes, result.Duration), ceiling.describe(result.Bytes, result.Duration), result.Latency.P50.Seconds(), result.Latency.P90.Seconds())
+	if result.Environment == nil || baseline.Environment == nil {
+		fmt.Printf("WARNING: the baseline doesn't record client library versions\n")
+		return
+	}
+	for _, c := range result.Environment.versionChanges(baseline.Environment) {
+		fmt.Printf("WARNING: client library changed since the baseline: %s\n", c)
+	}
+	if result.Environment.Proxy != baseline.Environment.Proxy {
+		fmt.Printf("WARNING: the baseline went through %s, and this run through %s; the extra hop changes the numbers\n",
+			orDirect(baseline.Environment.Proxy), orDirect(result.Environment.Proxy))
+	}
+}
+
+// Write `result` to `filename` as indented JSON.
+func writeJSON(filename string, result any) error {
+	b, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filename, func(w io.Writer) error {
+		_, err := w.Write(append(b, '\n'))
+		return err
+	})
+}
+
+// Create `filename` with whatever `write` writes, by way of a
+// temporary file that's only renamed into place if everything
+// worked.  The temporary file is removed if anything fails, even if
+// `write` panics.
+func writeFileAtomic(filename string, write func(io.Writer) error) error {
+	f, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	renamed := false
+	defer func() {
+		if !renamed {
+			f.Close()
+			os.Remove(tmp)
+		}
+	}()
+
+	err = write(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, 0o644)
+	}
+	if err == nil {
+		err = os.Rename(tmp, filename)
+		renamed = err == nil
+	}
+	return err
+}
+
+// The version of the jsonlRecord format, from before there was a
+// schemaVersion.  It's the schema's major version.
+const jsonlVersion = schema.Major
+
+// jsonlRecord is one line of a --jsonl file or --stream-fifo.  The
+// first line is a "run" record, followed by one "sample" record per
+// read, with "pause" and "resume" records wherever the run was
+// paused, "health" records for --healthcheck-object's probes, and an
+// "end" record once the reads are finished.  A run
+// without an "end" record was killed, or aborted, or was written by
+// an older s3test.
+type jsonlRecord struct {
+	SchemaVersion string        `json:"schemaVersion"`
+	Version       int           `json:"version"`
+	Type          string        `json:"type"`
+	Run           *RunInfo      `json:"run,omitempty"`
+	Sample        *Sample       `json:"sample,omitempty"`
+	Event         *controlEvent `json:"event,omitempty"`
+	Health        *healthSample `json:"health,omitempty"`
+}
+
+// jsonlWriter appends records to a file, calling fsync every
+// `syncInterval` or every `syncSamples` records, whichever comes
+// first, and rotating the file according to `rotation`; see
+// rotate.go.
+type jsonlWriter struct {
+	filename     string
+	syncInterval time.Duration
+	syncSamples  int
+	rotation     rotationPolicy
+
+	mu       sync.Mutex
+	f        *os.File
+	size     int64     // of the current file
+	opened   time.Time // when the current file was started
+	header   []byte    // the "run" record, repeated at the top of each rotated file
+	lastSync time.Time
+	unsynced int
+}
+
+func newJSONLWriter(filename string, syncInterval time.Duration, syncSamples int, rotation rotationPolicy) (*jsonlWriter, error) {
+	w := &jsonlWriter{
+		filename:     filename,
+		syncInterval: syncInterval,
+		syncSamples:  syncSamples,
+		rotation:     rotation,
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Open (or reopen) the file for appending.
+func (w *jsonlWriter) open() error {
+	f, err := os.OpenFile(w.filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.f, w.size, w.opened, w.lastSync = f, info.Size(), time.Now(), time.Now()
+	return nil
+}
+
+func (w *jsonlWriter) write(rec jsonlRecord) error {
+	rec.SchemaVersion, rec.Version = schema.Version, jsonlVersion
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if rec.Type == "run" {
+		w.header = line
+	} else if w.rotation.due(w.size, w.opened) {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+	if err := w.append(line); err != nil {
+		return err
+	}
+	w.unsynced++
+	if w.unsynced >= w.syncSamples || time.Since(w.lastSync) >= w.syncInterval {
+		w.unsynced = 0
+		w.lastSync = time.Now()
+		return w.f.Sync()
+	}
+	return nil
+}
+
+// Write `line` to the current file.  Must be called with w.mu held.
+func (w *jsonlWriter) append(line []byte) error {
+	n, err := w.f.Write(line)
+	w.size += int64(n)
+	return err
+}
+
+func (w *jsonlWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.f.Sync()
+	return w.f.Close()
+}
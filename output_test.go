@@ -0,0 +1,285 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/scottlaird/s3test/schema"
+)
+
+// List the names in `dir`.
+func dirNames(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "result.json")
+	write := func(s string) func(io.Writer) error {
+		return func(w io.Writer) error {
+			_, err := io.WriteString(w, s)
+			return err
+		}
+	}
+	if err := writeFileAtomic(filename, write("old")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Fail partway through, with an error or a panic.  Either way,
+	// the old file is left alone, and there's nothing else in the
+	// directory.
+	broken := errors.New("disk full")
+	failures := map[string]func(io.Writer) error{
+		"error": func(w io.Writer) error {
+			io.WriteString(w, `{"half": `)
+			return broken
+		},
+		"panic": func(w io.Writer) error {
+			io.WriteString(w, `{"half": `)
+			panic(broken)
+		},
+	}
+	for name, fail := range failures {
+		err := func() (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = r.(error)
+				}
+			}()
+			return writeFileAtomic(filename, fail)
+		}()
+		if !errors.Is(err, broken) {
+			t.Errorf("%s: got %v", name, err)
+		}
+		if b, _ := os.ReadFile(filename); string(b) != "old" {
+			t.Errorf("%s: the file holds %q", name, b)
+		}
+		if names := dirNames(t, dir); !slices.Equal(names, []string{"result.json"}) {
+			t.Errorf("%s: the directory holds %q", name, names)
+		}
+	}
+
+	// A rename that fails leaves nothing behind, either.
+	blocked := filepath.Join(dir, "blocked")
+	if err := os.Mkdir(blocked, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(blocked, "x"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(blocked, write("new")); err == nil {
+		t.Errorf("writing over a directory worked")
+	}
+	if names := dirNames(t, dir); !slices.Equal(names, []string{"blocked", "result.json"}) {
+		t.Errorf("after a failed rename, the directory holds %q", names)
+	}
+
+	if err := writeFileAtomic(filename, write("new")); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(filename); string(b) != "new" {
+		t.Errorf("the file holds %q, want \"new\"", b)
+	}
+	if info, err := os.Stat(filename); err != nil {
+		t.Error(err)
+	} else if info.Mode().Perm() != 0o644 {
+		t.Errorf("the file's mode is %v, want 0644", info.Mode())
+	}
+}
+
+// A --jsonl file is only complete with its "end" record, and one cut
+// off mid-line still has the samples before the cut.
+func TestJSONLTruncation(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "run.jsonl")
+	w, err := newJSONLWriter(filename, time.Hour, 1000, rotationPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	records := []jsonlRecord{{Type: "run", Run: &RunInfo{File: "big.mp4", ReadSize: 100}}}
+	for i := range 3 {
+		records = append(records, jsonlRecord{Type: "sample", Sample: &Sample{Offset: int64(i) * 100, Size: 100, Bytes: 100, Start: start, Duration: time.Second}})
+	}
+	for _, rec := range records {
+		if err := w.write(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Close()
+	whole, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	end := jsonlRecord{Type: "end", Event: &controlEvent{Time: time.Now()}}
+	w, err = newJSONLWriter(filename, time.Hour, 1000, rotationPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.write(end)
+	w.Close()
+	complete, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name      string
+		data      []byte
+		reads     int
+		truncated bool
+	}{
+		{"complete", complete, 3, false},
+		{"without the end record", whole, 3, true},
+		{"cut off in the end record", complete[:len(complete)-5], 3, true},
+		{"cut off in a sample", whole[:len(whole)-20], 2, true},
+	} {
+		if err := os.WriteFile(filename, tc.data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		a, err := loadRun(filename)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if a.Reads != tc.reads || a.Truncated != tc.truncated {
+			t.Errorf("%s: %d reads, truncated %v; want %d, %v", tc.name, a.Reads, a.Truncated, tc.reads, tc.truncated)
+		}
+	}
+}
+
+// Return the JSON fields of struct type `t`, by name, including those
+// of embedded structs.
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if f.Anonymous {
+			for name, ft := range jsonFields(f.Type) {
+				fields[name] = ft
+			}
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name != "" && name != "-" && f.IsExported() {
+			fields[name] = f.Type
+		}
+	}
+	return fields
+}
+
+// Check that everything in the schema struct `want` is written, with
+// the same JSON type, by the output struct `got`.
+func checkSchemaFields(t *testing.T, path string, want, got reflect.Type) {
+	t.Helper()
+	for want.Kind() == reflect.Pointer || want.Kind() == reflect.Slice {
+		if got.Kind() != want.Kind() {
+			t.Errorf("%s is a %v in the schema, but a %v in the output", path, want, got)
+			return
+		}
+		want, got = want.Elem(), got.Elem()
+	}
+	if want.Kind() != got.Kind() {
+		t.Errorf("%s is a %v in the schema, but a %v in the output", path, want, got)
+		return
+	}
+	if want.Kind() != reflect.Struct || want == reflect.TypeFor[time.Time]() {
+		return
+	}
+	gotFields := jsonFields(got)
+	for name, wt := range jsonFields(want) {
+		gt, ok := gotFields[name]
+		if !ok {
+			t.Errorf("%s.%s is in the schema, but isn't written", path, name)
+			continue
+		}
+		checkSchemaFields(t, path+"."+name, wt, gt)
+	}
+}
+
+// The schema package is what scripts rely on, so everything in it has
+// to be in what we write.
+func TestSchemaMatchesOutput(t *testing.T) {
+	checkSchemaFields(t, "Result", reflect.TypeFor[schema.Result](), reflect.TypeFor[Result]())
+	checkSchemaFields(t, "Record", reflect.TypeFor[schema.Record](), reflect.TypeFor[jsonlRecord]())
+	// --target and --tenant results have samples of their own.
+	for name, typ := range map[string]reflect.Type{
+		"targetResult": reflect.TypeFor[targetResult](),
+		"tenantResult": reflect.TypeFor[tenantResult](),
+	} {
+		if ft, ok := jsonFields(typ)["samples"]; !ok {
+			t.Errorf("%s has no samples", name)
+		} else {
+			checkSchemaFields(t, name+".samples", reflect.TypeFor[[]*schema.Sample](), ft)
+		}
+	}
+}
+
+// Every file we write for scripts or for later runs starts with its
+// schemaVersion.
+func TestOutputsHaveSchemaVersion(t *testing.T) {
+	for name, typ := range map[string]reflect.Type{
+		"--json":                 reflect.TypeFor[Result](),
+		"--jsonl":                reflect.TypeFor[jsonlRecord](),
+		"--json with --target":   reflect.TypeFor[targetsResult](),
+		"--json with --tenant":   reflect.TypeFor[tenantsResult](),
+		"--json with --diagnose": reflect.TypeFor[diagnoseResult](),
+		"--plan":                 reflect.TypeFor[schedule](),
+		"--state-file":           reflect.TypeFor[stateFile](),
+	} {
+		if ft, ok := jsonFields(typ)["schemaVersion"]; !ok || ft.Kind() != reflect.String {
+			t.Errorf("%s (%v) has no schemaVersion string", name, typ)
+		}
+	}
+}
+
+// --plan and --state-file files are read back, so a newer major
+// version is refused rather than misread.
+func TestPlanAndStateVersions(t *testing.T) {
+	dir := t.TempDir()
+	plan := filepath.Join(dir, "plan.json")
+	sched := &schedule{SchemaVersion: schema.Version, File: "big.mp4", FileSize: 100, Concurrency: 1, Reads: []scheduledRead{{Offset: 0, Size: 100}}}
+	if err := writeJSON(plan, sched); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := loadSchedule(plan); err != nil || len(s.Reads) != 1 {
+		t.Errorf("loadSchedule() = %+v, %v", s, err)
+	}
+
+	state := filepath.Join(dir, "state.json")
+	sf := &stateFile{Entries: map[string]*stateEntry{"k": {Size: 100, ETag: `"x"`, Schedule: sched}}}
+	if err := sf.save(state); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := loadState(state); err != nil || s.SchemaVersion != schema.Version || s.Entries["k"] == nil {
+		t.Errorf("loadState() = %+v, %v", s, err)
+	}
+
+	var verr *schema.VersionError
+	for _, future := range []string{`{"schemaVersion": "2.0", "reads": []}`, `{"schemaVersion": "2.0", "entries": {}}`} {
+		if err := os.WriteFile(plan, []byte(future), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadSchedule(plan); !errors.As(err, &verr) {
+			t.Errorf("loadSchedule(%s) = %v", future, err)
+		}
+		if _, err := loadState(plan); !errors.As(err, &verr) {
+			t.Errorf("loadState(%s) = %v", future, err)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+// A buggy proxy can send the requested range twice, back to back, in
+// one response.  The client reads the `size` bytes it asked for,
+// closes the body, and never notices, while the backend quietly does
+// double the work.  So in the modes that read the response body
+// directly (getobject, http, and presigned), once a read has its
+// bytes, we try one more Read with a short deadline.  Anything that
+// arrives is overdelivery: it's counted, warned about, and added to
+// the amplification report.  Responses with a Content-Length stop at
+// it, so overdelivery from one of those means the Content-Length
+// itself was bigger than the range; the warning gives it, to tell
+// the two bugs apart.
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	// How long to wait for data past the end of the range.
+	overdeliveryWait = 50 * time.Millisecond
+
+	// Stop counting after this much, so a 200 with the whole
+	// object doesn't make us read all of it.
+	overdeliveryLimit = 1 << 20
+)
+
+// Whether `mode` gives us the response body itself, so that reading
+// past the range would mean the server sent too much.
+func checksOverdelivery(mode string) bool {
+	return mode == "getobject" || mode == "getobject-v1" || mode == "http" || mode == "presigned"
+}
+
+// Read whatever `f` has left, for up to overdeliveryWait, and return
+// how many bytes that was.
+func probeOverdelivery(f io.ReadCloser) int64 {
+	timer := time.AfterFunc(overdeliveryWait, func() { f.Close() })
+	defer timer.Stop()
+	buf := make([]byte, 32*1024)
+	var extra int64
+	for extra < overdeliveryLimit {
+		n, err := f.Read(buf)
+		extra += int64(n)
+		if err != nil {
+			break
+		}
+	}
+	return extra
+}
+
+// Describe an overdelivery for a sample's warnings.
+func overdeliveryWarning(extra, size int64, contentLength int64) string {
+	cl := "no Content-Length"
+	if contentLength >= 0 {
+		cl = fmt.Sprintf("Content-Length %d", contentLength)
+	}
+	more := ""
+	if extra >= overdeliveryLimit {
+		more = " or more"
+	}
+	return fmt.Sprintf("the server sent %d bytes%s past the %d asked for (%s)", extra, more, size, cl)
+}
+
+// Return the total overdelivered bytes and how many reads got any.
+func totalOverdelivery(samples []*Sample) (bytes int64, reads int) {
+	for _, s := range samples {
+		if s.Overdelivered > 0 {
+			bytes += s.Overdelivered
+			reads++
+		}
+	}
+	return bytes, reads
+}
+
+// Print the overdelivery totals, if there was any.
+func reportOverdelivery(samples []*Sample) {
+	bytes, reads := totalOverdelivery(samples)
+	if reads == 0 {
+		return
+	}
+	fmt.Printf("WARNING: Overdelivery: %d reads got %d bytes past the ranges they asked for; the gateway may be repeating data\n", reads, bytes)
+}
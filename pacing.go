@@ -0,0 +1,90 @@
+package main
+
+// Normally every worker starts its next read the moment the last one
+// finishes.  --read-interval paces each worker to one read per
+// interval instead, so we can hold the request rate steady.  But N
+// paced workers that all start at the same instant stay in lockstep,
+// and the gateway sees a burst of N requests every interval, which
+// real traffic doesn't look like.  --jitter=FRACTION spreads them
+// out: each worker starts at a random point in the first interval,
+// and each gap is randomly stretched or shrunk by up to FRACTION of
+// the interval.  The average rate is the same either way, so bursty
+// and jittered runs can be compared directly, to tell whether
+// SeaweedFS minds the peak concurrency or the total volume.
+//
+// The jitter comes from a seeded generator, and the seed is saved in
+// the schedule and the results, so a jittered run can be repeated
+// exactly with --replay.
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// pacing is how reads are spaced out, as saved in the schedule.
+type pacing struct {
+	Interval time.Duration `json:"intervalNs"`
+	Jitter   float64       `json:"jitter,omitempty"`
+	Seed     uint64        `json:"seed,omitempty"`
+}
+
+// Work out the pacing from the flags, or nil for back-to-back reads.
+func pacingFromFlags() *pacing {
+	if *readInterval <= 0 {
+		return nil
+	}
+	p := &pacing{Interval: *readInterval, Jitter: *jitter, Seed: *jitterSeed}
+	if p.Jitter > 0 && p.Seed == 0 {
+		p.Seed = rand.Uint64()
+	}
+	return p
+}
+
+func (p *pacing) String() string {
+	if p.Jitter == 0 {
+		return fmt.Sprintf("one read per worker every %v, all workers in step", p.Interval)
+	}
+	return fmt.Sprintf("one read per worker every %v on average, jittered by up to %.0f%% (seed %d)", p.Interval, 100*p.Jitter, p.Seed)
+}
+
+// Wrap `next` so that each of `concurrency` workers gets a read at
+// most once per interval, with jitter.
+func (p *pacing) source(next readSource, concurrency int) readSource {
+	var mu sync.Mutex
+	rngs := make([]*rand.Rand, concurrency)
+	due := make([]time.Time, concurrency)
+	for w := range rngs {
+		rngs[w] = rand.New(rand.NewPCG(p.Seed, uint64(w)))
+	}
+
+	return func(worker int) (readRange, bool) {
+		mu.Lock()
+		rng := rngs[worker]
+		now := time.Now()
+		if due[worker].IsZero() {
+			// Stagger the first read across the first
+			// interval.
+			due[worker] = now
+			if p.Jitter > 0 {
+				due[worker] = now.Add(time.Duration(rng.Float64() * float64(p.Interval)))
+			}
+		}
+		wait := due[worker].Sub(now)
+
+		// Schedule the next read.  If we're running behind,
+		// don't try to catch up with a burst.
+		gap := float64(p.Interval) * (1 + p.Jitter*(2*rng.Float64()-1))
+		if due[worker].Before(now) {
+			due[worker] = now
+		}
+		due[worker] = due[worker].Add(time.Duration(gap))
+		mu.Unlock()
+
+		if wait > 0 {
+			time.Sleep(wait)
+		}
+		return next(worker)
+	}
+}
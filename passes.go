@@ -0,0 +1,98 @@
+package main
+
+// Comparing a cold run with a warm one used to take two runs and a
+// diff.  With --passes=2, the schedule runs twice, back to back, and
+// --report-pass-delta compares the first pass with the last, read by
+// read.  Offsets where the second pass isn't any faster are listed,
+// since they suggest that nothing is caching that part of the file.
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// passRead pairs up the first and last pass's reads of one range.
+type passRead struct {
+	offset      int64
+	first, last time.Duration
+}
+
+func (p passRead) speedup() float64 {
+	if p.last <= 0 {
+		return 0
+	}
+	return p.first.Seconds() / p.last.Seconds()
+}
+
+// Compare the first pass's samples with the last pass's.
+func comparePasses(first, last []*Sample, filesize int64) {
+	lastBy := map[int64]*Sample{}
+	for _, s := range last {
+		if s.Err == "" {
+			lastBy[s.Offset] = s
+		}
+	}
+	var reads []passRead
+	var firstLat, lastLat []time.Duration
+	for _, s := range first {
+		l, ok := lastBy[s.Offset]
+		if s.Err != "" || !ok {
+			continue
+		}
+		reads = append(reads, passRead{offset: s.Offset, first: s.Duration, last: l.Duration})
+		firstLat = append(firstLat, s.Duration)
+		lastLat = append(lastLat, l.Duration)
+	}
+	if len(reads) == 0 {
+		fmt.Printf("Pass delta: no offsets were read successfully in both passes\n")
+		return
+	}
+
+	fs, ls := computeLatencyStats(firstLat), computeLatencyStats(lastLat)
+	fmt.Printf("First pass: p50 %.3fs, p90 %.3fs\n", fs.P50.Seconds(), fs.P90.Seconds())
+	fmt.Printf("Last pass:  p50 %.3fs, p90 %.3fs\n", ls.P50.Seconds(), ls.P90.Seconds())
+
+	speedups := make([]float64, len(reads))
+	for i, r := range reads {
+		speedups[i] = r.speedup()
+	}
+	slices.Sort(speedups)
+	pct := func(p int) float64 { return speedups[min(len(speedups)-1, p*len(speedups)/100)] }
+	fmt.Printf("Speedup (first/last) over %d offsets: min %.2fx p10 %.2fx p50 %.2fx p90 %.2fx max %.2fx\n",
+		len(speedups), speedups[0], pct(10), pct(50), pct(90), speedups[len(speedups)-1])
+
+	// Median speedup in each tenth of the file.
+	const bins = 10
+	binned := make([][]float64, bins)
+	for _, r := range reads {
+		i := int(min(bins-1, mulDiv(r.offset, bins, max(filesize, 1))))
+		binned[i] = append(binned[i], r.speedup())
+	}
+	fmt.Printf("%24s %8s %10s\n", "offsets", "reads", "speedup")
+	for i, b := range binned {
+		if len(b) == 0 {
+			continue
+		}
+		slices.Sort(b)
+		fmt.Printf("%11d-%-12d %8d %9.2fx\n", mulDiv(int64(i), filesize, bins), mulDiv(int64(i+1), filesize, bins)-1, len(b), b[len(b)/2])
+	}
+
+	var slow []passRead
+	for _, r := range reads {
+		if r.last >= r.first {
+			slow = append(slow, r)
+		}
+	}
+	if len(slow) == 0 {
+		return
+	}
+	fmt.Printf("%d offsets weren't any faster the second time:\n", len(slow))
+	for i, r := range slow {
+		if i == 20 {
+			fmt.Printf("  ... and %d more\n", len(slow)-i)
+			break
+		}
+		fmt.Printf("  offset %d: %.3fs, then %.3fs\n", r.offset, r.first.Seconds(), r.last.Seconds())
+	}
+}
@@ -0,0 +1,15 @@
+//go:build linux
+
+package main
+
+import "syscall"
+
+// Return the machine's physical memory in bytes, or 0 if we can't
+// tell.
+func physicalMemory() int64 {
+	var info syscall.Sysinfo_t
+	if err := syscall.Sysinfo(&info); err != nil {
+		return 0
+	}
+	return int64(info.Totalram) * int64(info.Unit)
+}
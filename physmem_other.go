@@ -0,0 +1,8 @@
+//go:build !linux
+
+package main
+
+// We don't know how to ask on this platform, so skip the check.
+func physicalMemory() int64 {
+	return 0
+}
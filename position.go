@@ -0,0 +1,110 @@
+package main
+
+// Interleaved Seeks and Reads on one handle have been known to return
+// data from the wrong offset, which shows up much later as mysterious
+// video corruption.  With --check-position, every Read() on an s3fs or
+// localfs handle is followed by Seek(0, io.SeekCurrent), which s3fs
+// answers without a request, and the result is compared against where
+// the handle should be.  If we know what the data should be (a
+// generated --cold-cache object, or a local file), the bytes are
+// checked as well.
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// If non-nil, returns the bytes that should be at `offset`.
+var expectedContent func(offset int64, n int) ([]byte, error)
+
+// Return an expectedContent for an object generated from `seed`.
+func generatedContent(seed uint64) func(int64, int) ([]byte, error) {
+	return func(offset int64, n int) ([]byte, error) {
+		b := make([]byte, n)
+		for i := range b {
+			b[i] = generatedByte(seed, offset+int64(i))
+		}
+		return b, nil
+	}
+}
+
+// Return an expectedContent that reads `filename` with ReadAt, which
+// doesn't depend on any handle's position.
+func localContent(filename string) (func(int64, int) ([]byte, error), error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	return func(offset int64, n int) ([]byte, error) {
+		b := make([]byte, n)
+		n, err := f.ReadAt(b, offset)
+		if err == io.EOF {
+			err = nil
+		}
+		return b[:n], err
+	}, nil
+}
+
+// positionChecker wraps a handle and checks its position after every
+// Read.  Problems are recorded as warnings on the sample, once each.
+type positionChecker struct {
+	f        io.ReadSeekCloser
+	expected int64
+	sample   *Sample
+	warned   bool
+}
+
+// Wrap `f`, which should be positioned at `offset`.
+func newPositionChecker(f io.ReadSeekCloser, offset int64, sample *Sample) *positionChecker {
+	c := &positionChecker{f: f, expected: offset, sample: sample}
+	c.check()
+	return c
+}
+
+func (c *positionChecker) Read(p []byte) (int, error) {
+	n, err := c.f.Read(p)
+	if n > 0 && expectedContent != nil && !c.warned {
+		want, werr := expectedContent(c.expected, n)
+		if werr == nil && !bytes.Equal(p[:n], want) {
+			c.problem(fmt.Sprintf("data read at offset %d doesn't match what's there (first difference at +%d)", c.expected, firstDifference(p[:n], want)))
+		}
+	}
+	c.expected += int64(n)
+	c.check()
+	return n, err
+}
+
+func (c *positionChecker) Close() error {
+	return c.f.Close()
+}
+
+// Compare the handle's idea of its position with ours.
+func (c *positionChecker) check() {
+	if c.warned {
+		return
+	}
+	pos, err := c.f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		c.problem(fmt.Sprintf("Seek(0, SeekCurrent) failed: %v", err))
+	} else if pos != c.expected {
+		c.problem(fmt.Sprintf("handle is at offset %d, but should be at %d", pos, c.expected))
+	}
+}
+
+func (c *positionChecker) problem(msg string) {
+	c.warned = true
+	c.sample.warn("position check: " + msg)
+}
+
+// Return the index of the first byte that differs between `a` and
+// `b`.
+func firstDifference(a, b []byte) int {
+	for i := range min(len(a), len(b)) {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return min(len(a), len(b))
+}
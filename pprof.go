@@ -0,0 +1,155 @@
+package main
+
+// Sometimes the client is the suspect: is a 45-second read spent in the
+// SDK, in TLS, or waiting on the network?  --pprof-listen serves
+// net/http/pprof for the length of the run, for poking at by hand.
+//
+// A profile of the whole run mostly shows the reads that were fine,
+// though.  So with --profile-slow-reads, once a read has been going for
+// --slow-read-threshold, we start a CPU profile and take a heap
+// snapshot, and stop profiling when that read finishes (or after
+// --profile-duration, if it's sooner).  The files go in --output-dir,
+// named for the read's ID so they can be matched with its dump and its
+// sample.  Only one CPU profile can run at a time, so a read that goes
+// slow while another is being profiled isn't, and --max-profiles caps
+// how many we take in all.
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	runtimepprof "runtime/pprof"
+	"sync"
+	"time"
+)
+
+// Serve net/http/pprof on `addr`.  The returned function stops it.
+func servePprof(addr string) (func(), error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	server := &http.Server{Handler: mux}
+	go server.Serve(l)
+	fmt.Printf("Serving pprof on http://%s/debug/pprof/\n", l.Addr())
+	return func() { server.Close() }, nil
+}
+
+// slowReadProfiler takes the --profile-slow-reads profiles.
+type slowReadProfiler struct {
+	mu        sync.Mutex
+	profiling bool // a CPU profile is running
+	taken     int
+}
+
+var profiler = &slowReadProfiler{}
+
+// profileWatch watches one read, and profiles it if it goes slow.
+type profileWatch struct {
+	timer *time.Timer
+
+	mu    sync.Mutex
+	files []string
+	done  chan struct{} // closed when the read finishes
+}
+
+// Start watching the read of `sample`.  Call the returned function
+// when it finishes; it records any profiles in the sample.
+func (p *slowReadProfiler) watch(sample *Sample) func() {
+	if !*profileSlowReads {
+		return func() {}
+	}
+	w := &profileWatch{done: make(chan struct{})}
+	w.timer = time.AfterFunc(*slowReadThreshold, func() { p.capture(w, sample.ReadID) })
+	return func() {
+		w.timer.Stop()
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		close(w.done)
+		sample.Profiles = w.files
+	}
+}
+
+// Profile the slow read `readID` until it finishes.
+func (p *slowReadProfiler) capture(w *profileWatch, readID string) {
+	p.mu.Lock()
+	if p.profiling || p.taken >= *maxProfiles {
+		p.mu.Unlock()
+		return
+	}
+	p.profiling = true
+	p.taken++
+	last := p.taken == *maxProfiles
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		p.profiling = false
+		p.mu.Unlock()
+	}()
+
+	// If the read has already finished, there's nothing to profile.
+	w.mu.Lock()
+	select {
+	case <-w.done:
+		w.mu.Unlock()
+		return
+	default:
+	}
+	cpuName, err := outputPath("slow-"+readID+".cpu.pprof", nil)
+	if err != nil {
+		w.mu.Unlock()
+		fmt.Printf("WARNING: unable to profile slow read %s: %v\n", readID, err)
+		return
+	}
+	heapName, _ := outputPath("slow-"+readID+".heap.pprof", nil)
+	w.files = []string{cpuName, heapName}
+	w.mu.Unlock()
+
+	fmt.Printf("Read %s has taken over %s; profiling it to %s and %s\n", readID, *slowReadThreshold, cpuName, heapName)
+	if last {
+		fmt.Printf("Not profiling any more slow reads (--max-profiles=%d)\n", *maxProfiles)
+	}
+	if err := writeHeapProfile(heapName); err != nil {
+		fmt.Printf("WARNING: unable to write %s: %v\n", heapName, err)
+	}
+
+	f, err := os.Create(cpuName)
+	if err != nil {
+		fmt.Printf("WARNING: unable to write %s: %v\n", cpuName, err)
+		return
+	}
+	defer f.Close()
+	if err := runtimepprof.StartCPUProfile(f); err != nil {
+		fmt.Printf("WARNING: unable to profile slow read %s: %v\n", readID, err)
+		return
+	}
+	select {
+	case <-w.done:
+	case <-time.After(*profileDuration):
+	}
+	runtimepprof.StopCPUProfile()
+}
+
+// Write a heap profile to `filename`.
+func writeHeapProfile(filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	// The heap profile is as of the last GC.
+	runtime.GC()
+	if err := runtimepprof.Lookup("heap").WriteTo(f, 0); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,58 @@
+package main
+
+// Production readers often HEAD an object before every GET, and that
+// metadata lookup can cost the filer as much as the read does.  Every
+// read counts the HEAD and GET requests it made, and the summary gives
+// the HEAD:GET ratio, both for the reads themselves and for everything
+// sent upstream for the file (which includes our own setup requests,
+// like the size lookup and fetching the ETag).
+//
+// --no-stat removes our setup requests: it trusts --filesize instead
+// of asking the server, and skips fetching the ETag, so in getobject
+// mode the run sends nothing but GETs.  Comparing filer load between
+// runs with and without a HEAD per read, with identical GETs,
+// separates the metadata cost from the data cost.
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Print how many HEADs went with the GETs, per read and overall.
+func reportPreflight(samples []*Sample, requests []*recordedRequest, filename string) {
+	var heads, gets, withHead int
+	for _, s := range samples {
+		heads += s.Heads
+		gets += s.Gets
+		if s.Heads > 0 {
+			withHead++
+		}
+	}
+	var allHeads, allGets int
+	for _, req := range requests {
+		if !isObjectPath(req.Path, filename) {
+			continue
+		}
+		switch req.Method {
+		case http.MethodHead:
+			allHeads++
+		case http.MethodGet:
+			allGets++
+		}
+	}
+	if len(samples) == 0 || allHeads+allGets == 0 {
+		return
+	}
+	fmt.Printf("Metadata: reads made %d HEADs and %d GETs (HEAD:GET %s, %.2f HEADs per read, %d of %d reads HEADed)\n",
+		heads, gets, headRatio(heads, gets), float64(heads)/float64(len(samples)), withHead, len(samples))
+	fmt.Printf("  all requests for %s: %d HEADs and %d GETs (HEAD:GET %s)\n", filename, allHeads, allGets, headRatio(allHeads, allGets))
+}
+
+// Format heads:gets as 1:N, or as a bare count if there weren't any
+// of one or the other.
+func headRatio(heads, gets int) string {
+	if heads == 0 || gets == 0 {
+		return fmt.Sprintf("%d:%d", heads, gets)
+	}
+	return fmt.Sprintf("1:%.2f", float64(gets)/float64(heads))
+}
@@ -0,0 +1,363 @@
+package main
+
+// Twice now, someone has run a 32-worker soak against the production
+// endpoint because shell history betrayed them.  So the --profiles
+// file can name the endpoints that are production, with lines like
+//
+//	# Anything here needs --i-know-this-is-production.
+//	production *.prod.example.com
+//	production https://s3.example.com:8333
+//
+// Each pattern is a shell glob, matched against the endpoint's
+// hostname, its host:port, and the whole URL.  A run against a
+// matching --endpoint or --target refuses to start without
+// --i-know-this-is-production, and the refusal says which pattern
+// matched which endpoint.
+//
+// Even then, the run is held to conservative limits on concurrency,
+// how long it runs, and how much it reads, unless --unsafe-limits is
+// given.  The limits can be changed in the same file:
+//
+//	max-concurrency 8
+//	max-duration 10m
+//	max-bytes 21474836480
+//
+// Whatever we can tell from the flags and the schedule is checked
+// before the first read, and the refusal says which limit it would go
+// over.  The rest (a sequential run's duration, say) is checked as the
+// run goes, by recordingTransport, which every request to the endpoint
+// goes through, so that it holds for tenants and --bisect as much as
+// for the benchmark.  Once the run gets there, new requests fail with
+// errProductionLimit, and so does the rest of any body in flight.
+// Only cleaning up after ourselves (deleting a --cold-cache copy or a
+// tenant's uploads) is exempt.
+//
+// Reaching the limits isn't a failure.  The benchmark stops as if it
+// had been told to (see benchmark.record), and so do the sweeps and
+// the tenants, and the results so far are printed and written to
+// --json and --jsonl as usual.  Anything else that runs into them
+// (making a --cold-cache copy, say) stops the run with exit status 1,
+// without the panic a failed request there would otherwise be.
+//
+// An endpoint that doesn't match any "production" line has no limits
+// at all, whatever the file says about max-concurrency and the rest.
+//
+// The check comes before anything that sends a request, including
+// `s3test conformance`, `s3test repl`, and --probe-key-encoding.
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// productionLimits caps runs against production.
+type productionLimits struct {
+	concurrency int
+	duration    time.Duration
+	bytes       int64
+}
+
+// The limits, unless the --profiles file says otherwise.
+var defaultProductionLimits = productionLimits{
+	concurrency: 4,
+	duration:    5 * time.Minute,
+	bytes:       10 << 30,
+}
+
+// productionPattern is one "production" line from the --profiles file.
+type productionPattern struct {
+	pattern string
+	where   string // file:line
+}
+
+// profiles is what we read from the --profiles file.
+type profiles struct {
+	production []productionPattern
+	limits     productionLimits
+}
+
+// productionGuard is a run against production, and how much of its
+// limits it has used.
+type productionGuard struct {
+	pattern  productionPattern
+	endpoint string
+	limits   productionLimits
+	enforce  bool // false with --unsafe-limits
+
+	mu      sync.Mutex
+	started time.Time
+	bytes   int64
+}
+
+// The guard for this run, or nil if it isn't against production.
+var production *productionGuard
+
+// errProductionLimit is wrapped by the errors from requests refused
+// because the run has reached one of its production limits.  The SDK
+// mustn't retry them.
+var errProductionLimit error = noRetryError("over the limits for a run against production")
+
+type noRetryError string
+
+func (e noRetryError) Error() string        { return string(e) }
+func (e noRetryError) RetryableError() bool { return false }
+
+// Return the default --profiles file, or "" if there isn't a config
+// directory.
+func defaultProfilesFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "s3test", "profiles")
+}
+
+// Read the --profiles file `filename`.  It's fine for it not to exist
+// unless `required` is set.
+func loadProfiles(filename string, required bool) (*profiles, error) {
+	p := &profiles{limits: defaultProductionLimits}
+	if filename == "" {
+		return p, nil
+	}
+	f, err := os.Open(filename)
+	if errors.Is(err, os.ErrNotExist) && !required {
+		return p, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		where := fmt.Sprintf("%s:%d", filename, n)
+		key, value, _ := strings.Cut(line, " ")
+		value = strings.TrimSpace(value)
+		if value == "" {
+			return nil, fmt.Errorf("%s: %q needs a value", where, key)
+		}
+		switch key {
+		case "production":
+			if _, err := path.Match(value, ""); err != nil {
+				return nil, fmt.Errorf("%s: bad pattern %q: %v", where, value, err)
+			}
+			p.production = append(p.production, productionPattern{strings.ToLower(value), where})
+		case "max-concurrency":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("%s: max-concurrency must be at least 1, not %q", where, value)
+			}
+			p.limits.concurrency = n
+		case "max-duration":
+			d, err := time.ParseDuration(value)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("%s: max-duration must be a positive duration like 5m, not %q", where, value)
+			}
+			p.limits.duration = d
+		case "max-bytes":
+			n, err := parseOffset(value)
+			if err != nil || n == 0 {
+				return nil, fmt.Errorf("%s: max-bytes must be a positive number of bytes, not %q", where, value)
+			}
+			p.limits.bytes = n
+		default:
+			return nil, fmt.Errorf("%s: unknown setting %q; use production, max-concurrency, max-duration, or max-bytes", where, key)
+		}
+	}
+	return p, scanner.Err()
+}
+
+// Return the first pattern that `endpoint` matches, or false.
+func (p *profiles) match(endpoint string) (productionPattern, bool) {
+	names := []string{endpoint}
+	if u, err := url.Parse(endpoint); err == nil && u.Host != "" {
+		names = append(names, u.Host, u.Hostname())
+	}
+	for _, pat := range p.production {
+		if slices.ContainsFunc(names, func(name string) bool {
+			ok, _ := path.Match(pat.pattern, strings.ToLower(name))
+			return ok
+		}) {
+			return pat, true
+		}
+	}
+	return productionPattern{}, false
+}
+
+// Return the endpoints this run will read from.
+func runEndpoints() []string {
+	if !usesS3(*mode) {
+		return nil
+	}
+	if len(targets) == 0 {
+		return []string{*endpoint}
+	}
+	var endpoints []string
+	for _, t := range targets {
+		endpoints = append(endpoints, t.Endpoint)
+	}
+	return endpoints
+}
+
+// Decide whether this run is against production, going by the
+// --profiles file `filename`, and if it is, whether it may go ahead.
+func checkProduction(filename string) error {
+	p, err := loadProfiles(filename, flagSources["profiles"] != "default")
+	if err != nil {
+		return fmt.Errorf("unable to read --profiles: %v", err)
+	}
+	for _, e := range runEndpoints() {
+		pat, ok := p.match(e)
+		if !ok {
+			continue
+		}
+		if !*knowProduction {
+			return fmt.Errorf("Refusing to run: %s is production (it matches %q at %s); pass --i-know-this-is-production if you mean it", e, pat.pattern, pat.where)
+		}
+		production = &productionGuard{pattern: pat, endpoint: e, limits: p.limits, enforce: !*unsafeLimits, started: time.Now()}
+		return production.checkFlags()
+	}
+	return nil
+}
+
+// Describe going over a limit as a refusal.
+func (g *productionGuard) refusal(format string, args ...any) error {
+	return fmt.Errorf("Refusing to run against production %s (it matches %q at %s): %s; use --unsafe-limits to lift the limits",
+		g.endpoint, g.pattern.pattern, g.pattern.where, fmt.Sprintf(format, args...))
+}
+
+// Check the limits against what the flags say the run will do.
+func (g *productionGuard) checkFlags() error {
+	if !g.enforce {
+		return nil
+	}
+	workers := *concurrency
+	what := fmt.Sprintf("--concurrency=%d", workers)
+	var duration time.Duration
+	var durationWhat string
+	if *concurrencySweep != "" {
+		if levels, err := parseSweepLevels(*concurrencySweep); err == nil {
+			workers = slices.Max(levels)
+			what = fmt.Sprintf("--concurrency-sweep up to %d", workers)
+			duration, durationWhat = time.Duration(len(levels))*(*sweepDuration), "--concurrency-sweep's levels of --sweep-duration"
+		}
+	}
+	if *targetP90 > 0 {
+		workers = *adaptiveMax
+		what = fmt.Sprintf("--adaptive-max=%d", workers)
+		duration, durationWhat = *adaptiveDuration, "--adaptive-duration"
+	}
+	if len(tenants) > 0 {
+		workers = 0
+		for _, t := range tenants {
+			workers += t.Viewers + t.Workers
+		}
+		what = fmt.Sprintf("--tenant viewers and workers adding up to %d", workers)
+		duration, durationWhat = *tenantDuration, "--tenant-duration"
+	}
+	if *interference {
+		// The small reads are a random tenant with 16 workers; see
+		// interference.go.
+		workers = *interferenceView + 16
+		what = fmt.Sprintf("--interference-viewers=%d plus 16 small-read workers", *interferenceView)
+		duration, durationWhat = 3*(*interferenceWin), "--interference's three --interference-window phases"
+	}
+	if workers > g.limits.concurrency {
+		return g.refusal("%s is over the limit of %d workers", what, g.limits.concurrency)
+	}
+	if duration > g.limits.duration {
+		return g.refusal("%s would run for %s, over the limit of %s", durationWhat, duration, g.limits.duration)
+	}
+	return nil
+}
+
+// Check the limits against `sched`, once we have it.
+func (g *productionGuard) checkSchedule(sched *schedule) error {
+	if g == nil || !g.enforce {
+		return nil
+	}
+	if sched.Concurrency > g.limits.concurrency {
+		return g.refusal("the schedule's concurrency of %d is over the limit of %d workers", sched.Concurrency, g.limits.concurrency)
+	}
+	var total int64
+	for _, r := range sched.Reads {
+		total += r.Size
+	}
+	total *= int64(*passes)
+	if total > g.limits.bytes {
+		return g.refusal("the schedule would read %s, over the limit of %s", units.bytes(total), units.bytes(g.limits.bytes))
+	}
+	return nil
+}
+
+// Count `n` more bytes received from the endpoint, and return why the
+// run has to stop, or nil if it can carry on.
+func (g *productionGuard) read(n int64) error {
+	if g == nil || !g.enforce {
+		return nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.bytes += n
+	return g.over()
+}
+
+// Return why the run has to stop, or nil if it can carry on.
+func (g *productionGuard) check() error {
+	return g.read(0)
+}
+
+// Must be called with g.mu held.
+func (g *productionGuard) over() error {
+	switch {
+	case g.bytes >= g.limits.bytes:
+		return fmt.Errorf("%w: this run has read %s, its limit; use --unsafe-limits to lift it", errProductionLimit, units.bytes(g.bytes))
+	case time.Since(g.started) >= g.limits.duration:
+		return fmt.Errorf("%w: this run has gone on for %s, its limit; use --unsafe-limits to lift it", errProductionLimit, g.limits.duration)
+	}
+	return nil
+}
+
+// If `err` is a request refused at the production limits, say that
+// we're stopping, and return true.
+func stoppedAtLimit(err error) bool {
+	if !errors.Is(err, errProductionLimit) {
+		return false
+	}
+	fmt.Printf("Stopping: %v\n", err)
+	return true
+}
+
+// Much of s3test panics when a request fails, but one refused because
+// the run reached its production limits isn't a bug.  Given what
+// recover() returned, return the exit status for that, or carry on
+// panicking with anything else.
+func productionLimitStatus(r any) int {
+	if err, ok := r.(error); ok && stoppedAtLimit(err) {
+		return 1
+	}
+	panic(r)
+}
+
+type limitExemptKey struct{}
+
+// Return a context whose requests aren't held to the production
+// limits, for cleaning up after ourselves.
+func exemptFromLimits(ctx context.Context) context.Context {
+	return context.WithValue(ctx, limitExemptKey{}, true)
+}
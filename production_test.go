@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Set *p to v for the rest of the test.
+func setFlag[T any](t testing.TB, p *T, v T) {
+	t.Helper()
+	old := *p
+	*p = v
+	t.Cleanup(func() { *p = old })
+}
+
+func writeProfiles(t *testing.T, text string) string {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "profiles")
+	if err := os.WriteFile(name, []byte(text), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestLoadProfiles(t *testing.T) {
+	p, err := loadProfiles(writeProfiles(t, "# comment\nproduction *.prod.example.com\nmax-concurrency 8\nmax-duration 10m\nmax-bytes 1000\n"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := productionLimits{concurrency: 8, duration: 10 * time.Minute, bytes: 1000}
+	if p.limits != want {
+		t.Errorf("limits = %+v, want %+v", p.limits, want)
+	}
+	if len(p.production) != 1 || p.production[0].pattern != "*.prod.example.com" {
+		t.Errorf("production = %+v", p.production)
+	}
+
+	for _, bad := range []string{"max-concurrency 0\n", "max-duration soon\n", "max-bytes -1\n", "production [\n", "colour blue\n", "production\n"} {
+		if _, err := loadProfiles(writeProfiles(t, bad), true); err == nil {
+			t.Errorf("loadProfiles(%q) worked", bad)
+		}
+	}
+
+	if _, err := loadProfiles(filepath.Join(t.TempDir(), "missing"), false); err != nil {
+		t.Errorf("a missing optional file: %v", err)
+	}
+	if _, err := loadProfiles(filepath.Join(t.TempDir(), "missing"), true); err == nil {
+		t.Errorf("a missing required file worked")
+	}
+}
+
+func TestProfilesMatch(t *testing.T) {
+	p := &profiles{production: []productionPattern{{"*.prod.example.com", "a:1"}, {"https://s3.example.com:8333", "a:2"}, {"10.0.0.1:8333", "a:3"}}}
+	for endpoint, want := range map[string]bool{
+		"https://s3.prod.example.com":      true,
+		"http://S3.PROD.EXAMPLE.COM:8333":  true,
+		"https://s3.example.com:8333":      true,
+		"https://s3.example.com":           false,
+		"http://10.0.0.1:8333":             true,
+		"http://10.0.0.1:9333":             false,
+		"https://s3.staging.example.com":   false,
+		"https://prod.example.com.evil.io": false,
+	} {
+		if _, got := p.match(endpoint); got != want {
+			t.Errorf("match(%q) = %v, want %v", endpoint, got, want)
+		}
+	}
+}
+
+func TestCheckFlags(t *testing.T) {
+	g := &productionGuard{endpoint: "https://s3.example.com", limits: productionLimits{concurrency: 4, duration: time.Minute, bytes: 1 << 20}, enforce: true}
+
+	setFlag(t, concurrency, 4)
+	if err := g.checkFlags(); err != nil {
+		t.Errorf("--concurrency=4: %v", err)
+	}
+	setFlag(t, concurrency, 5)
+	if err := g.checkFlags(); err == nil || !strings.Contains(err.Error(), "--concurrency=5") {
+		t.Errorf("--concurrency=5: %v", err)
+	}
+	setFlag(t, concurrency, 1)
+
+	var l tenantList
+	for _, s := range []string{"a=stream,viewers=2", "b=random,workers=3"} {
+		if err := l.Set(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	setFlag(t, &tenants, l)
+	setFlag(t, tenantDuration, 30*time.Second)
+	if err := g.checkFlags(); err == nil || !strings.Contains(err.Error(), "adding up to 5") {
+		t.Errorf("tenants with 5 workers: %v", err)
+	}
+	setFlag(t, &tenants, nil)
+
+	setFlag(t, interference, true)
+	setFlag(t, interferenceWin, 10*time.Second)
+	if err := g.checkFlags(); err == nil || !strings.Contains(err.Error(), "--interference-viewers") {
+		t.Errorf("--interference: %v", err)
+	}
+	g.limits.concurrency = 100
+	if err := g.checkFlags(); err != nil {
+		t.Errorf("--interference under the limits: %v", err)
+	}
+	setFlag(t, interferenceWin, time.Minute)
+	if err := g.checkFlags(); err == nil || !strings.Contains(err.Error(), "would run for 3m0s") {
+		t.Errorf("--interference for 3m: %v", err)
+	}
+
+	g.enforce = false
+	if err := g.checkFlags(); err != nil {
+		t.Errorf("--unsafe-limits: %v", err)
+	}
+}
+
+func TestGuardRead(t *testing.T) {
+	g := &productionGuard{limits: productionLimits{concurrency: 1, duration: time.Hour, bytes: 100}, enforce: true, started: time.Now()}
+	if err := g.read(99); err != nil {
+		t.Errorf("99 bytes: %v", err)
+	}
+	if err := g.read(1); !errors.Is(err, errProductionLimit) {
+		t.Errorf("100 bytes: %v", err)
+	}
+
+	g = &productionGuard{limits: productionLimits{concurrency: 1, duration: time.Second, bytes: 100}, enforce: true, started: time.Now().Add(-time.Minute)}
+	if err := g.check(); !errors.Is(err, errProductionLimit) {
+		t.Errorf("after its duration: %v", err)
+	}
+	g.enforce = false
+	if err := g.check(); err != nil {
+		t.Errorf("--unsafe-limits: %v", err)
+	}
+
+	var none *productionGuard
+	if err := none.read(1 << 40); err != nil {
+		t.Errorf("not production: %v", err)
+	}
+}
+
+// The limits hold for every request through the transport, not just
+// the benchmark's.
+func TestTransportEnforcesLimits(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(make([]byte, 60))
+		w.(http.Flusher).Flush()
+		w.Write(make([]byte, 60))
+	}))
+	defer server.Close()
+	client := &http.Client{Transport: &recordingTransport{inner: http.DefaultTransport}}
+
+	setFlag(t, &production, &productionGuard{limits: productionLimits{concurrency: 1, duration: time.Hour, bytes: 200}, enforce: true, started: time.Now()})
+	get := func() error {
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		_, err = io.ReadAll(resp.Body)
+		return err
+	}
+	if err := get(); err != nil {
+		t.Fatalf("the first 120 bytes: %v", err)
+	}
+	if err := get(); !errors.Is(err, errProductionLimit) {
+		t.Errorf("the body that went over the limit: %v", err)
+	}
+	if err := get(); !errors.Is(err, errProductionLimit) {
+		t.Errorf("a request after the limit: %v", err)
+	}
+}
+
+// Reaching the limits is exit status 1 rather than a panic, and
+// anything else still panics.
+func TestProductionLimitStatus(t *testing.T) {
+	if status := productionLimitStatus(fmt.Errorf("making a copy: %w", errProductionLimit)); status != 1 {
+		t.Errorf("status %d, want 1", status)
+	}
+
+	other := errors.New("connection refused")
+	defer func() {
+		if r := recover(); r != other {
+			t.Errorf("recovered %v, want %v", r, other)
+		}
+	}()
+	productionLimitStatus(other)
+	t.Error("didn't panic")
+}
+
+// A sweep that reaches the limits stops at the end of that level,
+// without an error, and writes what it has.
+func TestSweepStopsAtLimit(t *testing.T) {
+	setFlag(t, mode, "getobject")
+	setFlag(t, readsize, 4096)
+	setFlag(t, sweepBytes, 16384)
+	setFlag(t, sweepDuration, 0)
+	setFlag(t, sweepCooldown, 0)
+	csvFile := filepath.Join(t.TempDir(), "sweep.csv")
+	setFlag(t, sweepCSV, csvFile)
+	client := fakeS3(t, map[string][]byte{"big.mp4": make([]byte, 1<<20)})
+	setFlag(t, &production, &productionGuard{limits: productionLimits{concurrency: 8, duration: time.Hour, bytes: 10000}, enforce: true, started: time.Now()})
+
+	b := &benchmark{ctx: context.Background(), backend: &getObjectBackend{client: client, bucket: "test"}, filename: "big.mp4", filesize: 1 << 20, result: &Result{}}
+	if err := runConcurrencySweep(b, []int{1, 2, 4}); err != nil {
+		t.Fatalf("runConcurrencySweep: %v", err)
+	}
+	if !b.stopped || b.failure != nil {
+		t.Errorf("stopped %v, failure %v", b.stopped, b.failure)
+	}
+	data, err := os.ReadFile(csvFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 2 || !strings.HasPrefix(lines[1], "1,") {
+		t.Errorf("wrote %q, want the header and the first level", lines)
+	}
+}
+
+// Cleaning up after ourselves still works once the run has reached
+// its limits.
+func TestExemptFromLimits(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+	}))
+	defer server.Close()
+	client := &http.Client{Transport: &recordingTransport{inner: http.DefaultTransport}}
+	setFlag(t, &production, &productionGuard{limits: productionLimits{concurrency: 1, duration: time.Hour, bytes: 0}, enforce: true, started: time.Now()})
+
+	do := func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodDelete, server.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+	if err := do(context.Background()); !errors.Is(err, errProductionLimit) {
+		t.Errorf("a request after the limit: %v", err)
+	}
+	if err := do(exemptFromLimits(context.Background())); err != nil {
+		t.Errorf("an exempt request: %v", err)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("the server saw %d requests, want 1", n)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+// Scraping a CLI that runs for a minute is awkward, so for runs from
+// cron, --pushgateway-url pushes the summary to a Prometheus
+// Pushgateway when the run is over.  The grouping key is job "s3test",
+// this host as the instance, and the mode, readsize, bucket, and file,
+// so that a nightly sweep keeps one group per configuration instead of
+// each run overwriting the last.  --push-per-read-histogram adds a
+// histogram of the read latencies.
+//
+// We write the text exposition format ourselves rather than pulling in
+// the Prometheus client for a handful of gauges.  A failed push is
+// reported but doesn't change the exit status, unless --require-push
+// is set.
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Upper bounds of the --push-per-read-histogram buckets, in seconds.
+var pushLatencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// Return the Pushgateway URL for `result`'s group.
+func pushURL(base string, result *Result) string {
+	instance, err := os.Hostname()
+	if err != nil {
+		instance = "unknown"
+	}
+	labels := [][2]string{
+		{"job", "s3test"},
+		{"instance", instance},
+		{"mode", result.Mode},
+		{"readsize", fmt.Sprint(result.ReadSize)},
+		{"bucket", result.Bucket},
+		{"file", result.File},
+	}
+	u := strings.TrimSuffix(base, "/") + "/metrics"
+	for _, l := range labels {
+		u += "/" + pushLabel(l[0], l[1])
+	}
+	return u
+}
+
+// Encode one label of a Pushgateway grouping key.  Values that can't
+// go in a URL path as they are, like file names with slashes, use the
+// Pushgateway's base64 form.
+func pushLabel(name, value string) string {
+	if value == "" || strings.Contains(value, "/") || url.PathEscape(value) != value {
+		return name + "@base64/" + base64.RawURLEncoding.EncodeToString([]byte(value))
+	}
+	return name + "/" + value
+}
+
+// Format `result` in the Prometheus text format.
+func pushMetrics(result *Result, latencies []time.Duration, histogram bool) []byte {
+	var buf bytes.Buffer
+	gauge := func(name, help string, value float64) {
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s gauge\n%s %g\n", name, help, name, name, value)
+	}
+	fmt.Fprintf(&buf, "# HELP s3test_run_info The run this group was last pushed by.\n# TYPE s3test_run_info gauge\n")
+	fmt.Fprintf(&buf, "s3test_run_info{run_id=%q,endpoint=%q} 1\n", result.RunID, result.Endpoint)
+	gauge("s3test_last_run_timestamp_seconds", "When the run started.", float64(result.Start.Unix()))
+	gauge("s3test_bytes", "Bytes read.", float64(result.Bytes))
+	gauge("s3test_duration_seconds", "How long the reads took, not counting time spent paused.", result.Duration.Seconds())
+	gauge("s3test_throughput_mbps", "Read throughput in megabits per second.", result.Mbps)
+	gauge("s3test_reads", "Reads made.", float64(len(result.Samples)))
+	gauge("s3test_errors", "Reads that failed.", float64(result.Errors))
+
+	fmt.Fprintf(&buf, "# HELP s3test_latency_seconds Latency of the successful reads.\n# TYPE s3test_latency_seconds gauge\n")
+	for _, q := range []struct {
+		quantile string
+		value    time.Duration
+	}{{"0.5", result.Latency.P50}, {"0.9", result.Latency.P90}, {"0.99", result.Latency.P99}} {
+		fmt.Fprintf(&buf, "s3test_latency_seconds{quantile=%q} %g\n", q.quantile, q.value.Seconds())
+	}
+
+	if histogram {
+		fmt.Fprintf(&buf, "# HELP s3test_read_duration_seconds Latency of each successful read.\n# TYPE s3test_read_duration_seconds histogram\n")
+		sorted := slices.Clone(latencies)
+		slices.Sort(sorted)
+		var sum time.Duration
+		for _, l := range sorted {
+			sum += l
+		}
+		i := 0
+		for _, le := range pushLatencyBuckets {
+			for i < len(sorted) && sorted[i].Seconds() <= le {
+				i++
+			}
+			fmt.Fprintf(&buf, "s3test_read_duration_seconds_bucket{le=\"%g\"} %d\n", le, i)
+		}
+		fmt.Fprintf(&buf, "s3test_read_duration_seconds_bucket{le=\"+Inf\"} %d\n", len(sorted))
+		fmt.Fprintf(&buf, "s3test_read_duration_seconds_sum %g\n", sum.Seconds())
+		fmt.Fprintf(&buf, "s3test_read_duration_seconds_count %d\n", len(sorted))
+	}
+	return buf.Bytes()
+}
+
+// Push `result` to the Pushgateway at `base`, replacing whatever was
+// last pushed for the same configuration.
+func pushResult(base string, result *Result, latencies []time.Duration) error {
+	u := pushURL(base, result)
+	req, err := http.NewRequest(http.MethodPut, u, bytes.NewReader(pushMetrics(result, latencies, *pushHistogram)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", u, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+// For a quick "is this range slow right now?" it's a nuisance to
+// write a --replay file.  --range takes the reads straight from the
+// command line, as offset:length or start-end (with the end exclusive,
+// so 0-1048576 is the first MiB), and runs exactly those, in order,
+// instead of planning a schedule.  With --passes, that's "probe these
+// three ranges ten times each".  Ranges past the end of the file are
+// rejected before any reads are sent.
+
+import (
+	"fmt"
+	"strings"
+)
+
+// rangeList is a repeatable --range flag.
+type rangeList []readRange
+
+func (l *rangeList) String() string {
+	var parts []string
+	for _, r := range *l {
+		parts = append(parts, fmt.Sprintf("%d:%d", r.offset, r.size))
+	}
+	return strings.Join(parts, " ")
+}
+
+// Parse "offset:length" or "start-end".
+func (l *rangeList) Set(s string) error {
+	var a, b string
+	var isLength bool
+	if x, y, found := strings.Cut(s, ":"); found {
+		a, b, isLength = x, y, true
+	} else if x, y, found := strings.Cut(s, "-"); found {
+		a, b = x, y
+	} else {
+		return fmt.Errorf("want offset:length or start-end, not %q", s)
+	}
+	start, err := parseOffset(a)
+	if err != nil {
+		return fmt.Errorf("bad offset in %q: %v", s, err)
+	}
+	n, err := parseOffset(b)
+	if err != nil {
+		return fmt.Errorf("bad end in %q: %v", s, err)
+	}
+	size := n
+	if !isLength {
+		if n <= start {
+			return fmt.Errorf("range %q ends before it starts", s)
+		}
+		size = n - start
+	}
+	if size == 0 {
+		return fmt.Errorf("range %q is empty", s)
+	}
+	*l = append(*l, readRange{offset: start, size: size})
+	return nil
+}
+
+// Return the --range reads, checking that they fit in the file.
+func planRanges(ranges rangeList, filesize int64) ([]scheduledRead, error) {
+	reads := make([]scheduledRead, len(ranges))
+	for i, r := range ranges {
+		if r.size > filesize || r.offset > filesize-r.size {
+			return nil, fmt.Errorf("--range %d:%d goes past the end of the %d byte file", r.offset, r.size, filesize)
+		}
+		reads[i] = scheduledRead{Worker: -1, Offset: r.offset, Size: r.size}
+	}
+	return reads, nil
+}
@@ -0,0 +1,152 @@
+package main
+
+// Against production, ops allows us a fixed number of requests per
+// second, whatever the concurrency or the number of files.
+// --max-rps is a token bucket (holding up to --burst tokens) shared by
+// everything that goes through the recording transport, so every HTTP
+// request takes a token: retries, s3fs's extra requests, and the
+// preflight checks included.
+//
+// Each read takes its first token before it starts, so, as with
+// --max-inflight-bytes, the wait for it isn't counted in the read's
+// latency; it's recorded in the sample as rateLimitWaitNs.  Any later
+// request for the same read (a retry, say) waits inside the read.
+// The summary compares the rate that requests were offered at with
+// the rate they were let through at, and how long they waited.
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket for HTTP requests.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // tokens per second, or 0 for no limit
+	burst  int
+	tokens float64
+	last   time.Time
+
+	requests     int
+	limited      int
+	total        time.Duration
+	longest      time.Duration
+	firstArrival time.Time
+	lastArrival  time.Time
+	lastRelease  time.Time
+}
+
+var requestLimit = &rateLimiter{}
+
+// Set the limit; a rate of 0 turns it off.
+func (l *rateLimiter) setLimit(rate float64, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate, l.burst = rate, max(burst, 1)
+	l.tokens = float64(l.burst)
+}
+
+// Take a token, and return how long to wait before using it.
+func (l *rateLimiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate == 0 {
+		return 0
+	}
+	now := time.Now()
+	if !l.last.IsZero() {
+		l.tokens = min(float64(l.burst), l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	}
+	l.last = now
+	l.tokens--
+
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+		l.limited++
+		l.total += delay
+		l.longest = max(l.longest, delay)
+	}
+	l.requests++
+	if l.firstArrival.IsZero() {
+		l.firstArrival = now
+	}
+	l.lastArrival = now
+	if release := now.Add(delay); release.After(l.lastRelease) {
+		l.lastRelease = release
+	}
+	return delay
+}
+
+// Wait for a token, or until `ctx` is done.  Returns how long it
+// waited.
+func (l *rateLimiter) wait(ctx context.Context) time.Duration {
+	delay := l.reserve()
+	if delay <= 0 {
+		return 0
+	}
+	start := time.Now()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	return time.Since(start)
+}
+
+// rateToken is a token a read took before it started, for its first
+// request to use.
+type rateToken struct {
+	mu   sync.Mutex
+	used bool
+}
+
+type rateTokenKey struct{}
+
+// Wait for a token for a read's first request, and return a context
+// that carries it, and how long we waited.
+func takeReadToken(ctx context.Context) (context.Context, time.Duration) {
+	if requestLimit.rate == 0 {
+		return ctx, 0
+	}
+	wait := requestLimit.wait(ctx)
+	return context.WithValue(ctx, rateTokenKey{}, &rateToken{}), wait
+}
+
+// Wait for a token for a request with `ctx`, unless its read already
+// has one it hasn't used.
+func waitForRequest(ctx context.Context) {
+	if t, ok := ctx.Value(rateTokenKey{}).(*rateToken); ok {
+		t.mu.Lock()
+		used := t.used
+		t.used = true
+		t.mu.Unlock()
+		if !used {
+			return
+		}
+	}
+	requestLimit.wait(ctx)
+}
+
+// Print the offered and achieved request rates.
+func (l *rateLimiter) report() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate == 0 {
+		return
+	}
+	fmt.Printf("Rate limit: %d of %d requests waited for --max-rps=%g (--burst=%d), for %.3fs in total (longest %.3fs)\n",
+		l.limited, l.requests, l.rate, l.burst, l.total.Seconds(), l.longest.Seconds())
+	if l.requests < 2 {
+		return
+	}
+	offered := l.lastArrival.Sub(l.firstArrival)
+	achieved := l.lastRelease.Sub(l.firstArrival)
+	if offered > 0 && achieved > 0 {
+		n := float64(l.requests - 1)
+		fmt.Printf("  offered %.1f requests/s, achieved %.1f requests/s\n", n/offered.Seconds(), n/achieved.Seconds())
+	}
+}
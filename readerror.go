@@ -0,0 +1,54 @@
+package main
+
+// A read that fails with "unexpected EOF" or "connection reset" says
+// nothing about which read it was, and by the time it reaches the
+// panic at the end of run(), there's no way to tell.  So readFrom()
+// wraps every failure in a readError with the file, offset, size, and
+// how far the read got.  The backends return one themselves when they
+// know the phase better (a failed Seek() inside s3fs's Open(), say),
+// and readFrom() fills in the rest.  readError unwraps to the original
+// error, so errors.Is and errors.As (and so statusOf, errStall, and
+// the rest) see straight through it.
+
+import (
+	"errors"
+	"fmt"
+)
+
+// readError is a failed read.
+type readError struct {
+	File     string
+	Offset   int64
+	Size     int64
+	Phase    string // "open", "seek", or "read"
+	Received int64  // bytes read before the failure
+	Attempts int    // SDK attempts made for the read, if it used the SDK
+	Err      error
+}
+
+func (e *readError) Error() string {
+	msg := fmt.Sprintf("%s of %s at offset %d (%d bytes) failed", e.Phase, e.File, e.Offset, e.Size)
+	if e.Phase == "read" {
+		msg += fmt.Sprintf(" after %d bytes", e.Received)
+	}
+	if e.Attempts > 1 {
+		msg += fmt.Sprintf(" after %d attempts", e.Attempts)
+	}
+	return msg + ": " + e.Err.Error()
+}
+
+func (e *readError) Unwrap() error {
+	return e.Err
+}
+
+// Return `err` as a readError for `phase`, or, if a backend already
+// returned one, fill in the parts that it didn't know.
+func wrapReadError(err error, phase, file string, offset, size, received int64, attempts int) *readError {
+	var re *readError
+	if !errors.As(err, &re) {
+		re = &readError{Phase: phase, Err: err}
+	}
+	re.File, re.Offset, re.Size = file, offset, size
+	re.Received, re.Attempts = received, attempts
+	return re
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestReadErrorString(t *testing.T) {
+	cause := errors.New("connection reset")
+	for _, tc := range []struct {
+		e    readError
+		want string
+	}{
+		{readError{File: "big.mp4", Offset: 4096, Size: 1024, Phase: "open", Attempts: 1, Err: cause},
+			"open of big.mp4 at offset 4096 (1024 bytes) failed: connection reset"},
+		{readError{File: "big.mp4", Offset: 4096, Size: 1024, Phase: "read", Received: 100, Attempts: 1, Err: cause},
+			"read of big.mp4 at offset 4096 (1024 bytes) failed after 100 bytes: connection reset"},
+		{readError{File: "big.mp4", Offset: 5 << 40, Size: 1 << 20, Phase: "open", Attempts: 3, Err: cause},
+			"open of big.mp4 at offset 5497558138880 (1048576 bytes) failed after 3 attempts: connection reset"},
+	} {
+		if got := tc.e.Error(); got != tc.want {
+			t.Errorf("Error() = %q, want %q", got, tc.want)
+		}
+	}
+}
+
+// A backend's readError keeps its phase, and gets the rest filled in.
+func TestWrapReadError(t *testing.T) {
+	err := fmt.Errorf("opening: %w", &readError{Phase: "seek", Err: io.ErrUnexpectedEOF})
+	re := wrapReadError(err, "open", "big.mp4", 100, 200, 0, 2)
+	want := readError{File: "big.mp4", Offset: 100, Size: 200, Phase: "seek", Attempts: 2, Err: io.ErrUnexpectedEOF}
+	if *re != want {
+		t.Errorf("wrapReadError() = %+v, want %+v", *re, want)
+	}
+	if !errors.Is(re, io.ErrUnexpectedEOF) {
+		t.Errorf("%v isn't io.ErrUnexpectedEOF", re)
+	}
+}
+
+// abortWriter passes `left` bytes of the body through, then drops the
+// connection.
+type abortWriter struct {
+	http.ResponseWriter
+	left int64
+}
+
+func (w *abortWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > w.left {
+		w.ResponseWriter.Write(p[:w.left])
+		w.ResponseWriter.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+	w.left -= int64(len(p))
+	return w.ResponseWriter.Write(p)
+}
+
+// Failures injected by the server show up in the error with the
+// offset of the read that hit them.
+func TestReadErrorFromServer(t *testing.T) {
+	const (
+		readAt  = 3 << 18 // the read that fails
+		failAt  = readAt + 1000
+		forbid  = 5 << 18 // the read that's refused
+		objSize = 8 << 18
+	)
+	objects := map[string][]byte{"big.mp4": make([]byte, objSize)}
+	fake := fakeBucket(objects)
+	client := serveFakeS3(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var start int64
+		fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-", &start)
+		switch {
+		case r.Method == http.MethodGet && start == forbid:
+			http.Error(w, `<Error><Code>AccessDenied</Code></Error>`, http.StatusForbidden)
+		case r.Method == http.MethodGet && start <= failAt && r.Header.Get("Range") != "":
+			fake.ServeHTTP(&abortWriter{ResponseWriter: w, left: failAt - start}, r)
+		default:
+			fake.ServeHTTP(w, r)
+		}
+	}))
+
+	for _, m := range []string{"getobject", "s3fs"} {
+		setFlag(t, mode, m)
+		backend, err := newBackend(m, client, defaultTarget(), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := readFrom(context.Background(), backend, "big.mp4", 1<<18, 1<<18, objSize); err != nil {
+			t.Fatalf("%s: a read before the failure: %v", m, err)
+		}
+
+		_, err = readFrom(context.Background(), backend, "big.mp4", readAt, 1<<18, objSize)
+		var re *readError
+		if !errors.As(err, &re) {
+			t.Fatalf("%s: got %v, not a readError", m, err)
+		}
+		if re.Phase != "read" || re.Offset != readAt || re.Received != failAt-readAt {
+			t.Errorf("%s: got a %s error at %d after %d bytes, want a read error at %d after %d", m, re.Phase, re.Offset, re.Received, readAt, failAt-readAt)
+		}
+		if want := fmt.Sprintf("at offset %d (%d bytes) failed after %d bytes", readAt, 1<<18, failAt-readAt); !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: %q doesn't say %q", m, err, want)
+		}
+
+		// s3fs only asks for the range when it seeks.
+		phase := "open"
+		if m == "s3fs" {
+			phase = "seek"
+		}
+		_, err = readFrom(context.Background(), backend, "big.mp4", forbid, 1<<18, objSize)
+		if !errors.As(err, &re) || re.Phase != phase || re.Offset != forbid || statusOf(err) != http.StatusForbidden {
+			t.Errorf("%s: the refused read failed with %v", m, err)
+		}
+	}
+}
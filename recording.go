@@ -0,0 +1,99 @@
+package main
+
+// The fsrecord package wraps a real server's fs.FS and records its
+// reads in our --jsonl format, so --replay can take a recording as
+// well as a --plan file, and run the reads that real traffic made
+// against whatever server we point it at.  A --jsonl log from an
+// earlier run works the same way.
+//
+// A recording can cover any number of files; the one named on the
+// command line is replayed, or the one with the most reads if there's
+// no name.  The reads run in the order they started, with as many
+// workers as there were reads in flight at once in the recording.
+// The recording doesn't say how big the file is, so the size is
+// looked up as usual.
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Load a --replay file: a .jsonl recording, or a --plan schedule.
+func loadReplay(filename, file string) (*schedule, error) {
+	if strings.HasSuffix(filename, ".jsonl") {
+		return loadRecording(filename, file)
+	}
+	return loadSchedule(filename)
+}
+
+// Turn the reads of `file` in the recording into a schedule.
+func loadRecording(filename, file string) (*schedule, error) {
+	records, err := readJSONL(filename)
+	if err != nil {
+		return nil, err
+	}
+	byFile := map[string][]*Sample{}
+	runFile := ""
+	for _, rec := range records {
+		switch {
+		case rec.Run != nil:
+			runFile = rec.Run.File
+		case rec.Sample != nil:
+			name := rec.Sample.File
+			if name == "" {
+				name = runFile
+			}
+			if rec.Sample.Err == "" && rec.Sample.Bytes > 0 {
+				byFile[name] = append(byFile[name], rec.Sample)
+			}
+		}
+	}
+
+	if file == "" {
+		for name, samples := range byFile {
+			if len(samples) > len(byFile[file]) || (len(samples) == len(byFile[file]) && name < file) {
+				file = name
+			}
+		}
+	}
+	samples := byFile[file]
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("%s has no successful reads of %q", filename, file)
+	}
+	slices.SortStableFunc(samples, func(a, b *Sample) int { return a.Start.Compare(b.Start) })
+
+	s := &schedule{File: file, Pattern: "sequential", Concurrency: peakInFlight(samples)}
+	for _, sample := range samples {
+		s.Reads = append(s.Reads, scheduledRead{Worker: -1, Offset: sample.Offset, Size: sample.Bytes})
+	}
+	fmt.Printf("Replaying %d reads of %s from the recording %s, with up to %d at once\n", len(s.Reads), file, filename, s.Concurrency)
+	return s, nil
+}
+
+// Return the most samples that were in progress at the same time.
+func peakInFlight(samples []*Sample) int {
+	type event struct {
+		at    time.Time
+		delta int
+	}
+	var events []event
+	for _, s := range samples {
+		events = append(events, event{s.Start, 1}, event{s.Start.Add(s.Duration), -1})
+	}
+	// Ends sort before starts at the same instant.
+	slices.SortFunc(events, func(a, b event) int {
+		if c := a.at.Compare(b.at); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.delta, b.delta)
+	})
+	peak, n := 1, 0
+	for _, e := range events {
+		n += e.delta
+		peak = max(peak, n)
+	}
+	return peak
+}
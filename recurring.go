@@ -0,0 +1,364 @@
+package main
+
+// Nightly runs from cron need a wrapper script for the environment,
+// the output redirection, and the "is last night's run still going?"
+// check, and that's fragile across a fleet of test boxes.  With
+// --schedule="0 3 * * *", s3test stays resident instead, and runs
+// itself (with the same flags, minus --schedule and --control-socket)
+// at each time that matches the cron expression.  Use {date} and
+// {time} in --json and friends so each run gets its own files, and
+// --pushgateway-url to push each run's summary.
+//
+// Times are computed from the wall clock each time, rather than by
+// adding up intervals, so the schedule doesn't drift, and a long sleep
+// is taken a minute at a time so that a suspended machine or a clock
+// change is noticed.  If a run is still going when the next one is
+// due, the next one is skipped with a warning.  Between runs we hold
+// no connections and do nothing but sleep.
+//
+// With --control-socket, `s3test ctl SOCKET status` reports the last
+// run, the next one, and the end of the last run's output; `stop`
+// exits after the current run, if any.
+//
+// The cron expression has the usual five fields (minute, hour, day of
+// month, month, and day of week, with 0 as Sunday), each of which can
+// be *, a number, a range like 1-5, a list like 1,15, or any of those
+// with a /step.  As in cron, if both the day of month and the day of
+// week are restricted, a day matching either one counts.
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cronSpec is a parsed cron expression, as a bitmap of the allowed
+// values for each field.
+type cronSpec struct {
+	fields [5]uint64
+	anyDOM bool // the day of month field was *
+	anyDOW bool // the day of week field was *
+}
+
+var cronRanges = [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+
+// Parse a five-field cron expression.
+func parseCron(s string) (*cronSpec, error) {
+	parts := strings.Fields(s)
+	if len(parts) != 5 {
+		return nil, fmt.Errorf("--schedule %q should have five fields: minute hour day-of-month month day-of-week", s)
+	}
+	c := &cronSpec{anyDOM: parts[2] == "*", anyDOW: parts[4] == "*"}
+	for i, part := range parts {
+		lo, hi := cronRanges[i][0], cronRanges[i][1]
+		for _, item := range strings.Split(part, ",") {
+			r, stepStr, hasStep := strings.Cut(item, "/")
+			step := 1
+			if hasStep {
+				var err error
+				if step, err = strconv.Atoi(stepStr); err != nil || step < 1 {
+					return nil, fmt.Errorf("bad step in %q in --schedule %q", item, s)
+				}
+			}
+			first, last := lo, hi
+			if r != "*" {
+				a, b, isRange := strings.Cut(r, "-")
+				var err1, err2 error
+				first, err1 = strconv.Atoi(a)
+				last = first
+				if isRange {
+					last, err2 = strconv.Atoi(b)
+				} else if hasStep {
+					last = hi
+				}
+				if err1 != nil || err2 != nil || first < lo || last > hi || first > last {
+					return nil, fmt.Errorf("bad value %q in --schedule %q; field %d runs from %d to %d", item, s, i+1, lo, hi)
+				}
+			}
+			for v := first; v <= last; v += step {
+				c.fields[i] |= 1 << v
+			}
+		}
+	}
+	// Sunday is both 0 and 7.
+	if c.fields[4]&(1<<7) != 0 {
+		c.fields[4] |= 1
+	}
+	return c, nil
+}
+
+func (c *cronSpec) has(field, v int) bool {
+	return c.fields[field]&(1<<v) != 0
+}
+
+// Whether `t` is a day the schedule runs on.
+func (c *cronSpec) matchesDay(t time.Time) bool {
+	dom, dow := c.has(2, t.Day()), c.has(4, int(t.Weekday()))
+	switch {
+	case c.anyDOM && c.anyDOW:
+		return true
+	case c.anyDOM:
+		return dow
+	case c.anyDOW:
+		return dom
+	}
+	return dom || dow
+}
+
+// Return the first scheduled time after `t`, or false if there isn't
+// one in the next five years (like "0 0 30 2 *").
+func (c *cronSpec) next(t time.Time) (time.Time, bool) {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	end := t.AddDate(5, 0, 0)
+	for t.Before(end) {
+		switch {
+		case !c.has(3, int(t.Month())):
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case !c.has(1, t.Hour()):
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case !c.has(0, t.Minute()):
+			t = t.Add(time.Minute)
+		default:
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// scheduledRun is what we know about one run, for the status command.
+type scheduledRun struct {
+	start    time.Time
+	duration time.Duration
+	status   int
+	tail     []string // the last lines of its output
+}
+
+// scheduler runs s3test at each time matching `spec`.
+type scheduler struct {
+	spec *cronSpec
+	args []string
+
+	mu      sync.Mutex
+	running *scheduledRun
+	last    *scheduledRun
+	nextRun time.Time
+	stopped bool
+	done    chan struct{}
+}
+
+// How many lines of each run's output to keep for the status command.
+const scheduledTailLines = 20
+
+// Run the benchmark at each time matching `spec`, forever, or until
+// a stop command.  Returns the exit status.
+func runScheduled(spec *cronSpec, args []string) int {
+	s := &scheduler{spec: spec, args: withoutFlags(args, "schedule", "control-socket"), done: make(chan struct{})}
+	if *controlSocket != "" {
+		stop, err := listenControl(*controlSocket, s.handleControl)
+		if err != nil {
+			fmt.Printf("Unable to listen on %s: %v\n", *controlSocket, err)
+			return 1
+		}
+		defer stop()
+	}
+
+	var runs sync.WaitGroup
+	defer runs.Wait()
+	for {
+		next, ok := spec.next(time.Now())
+		if !ok {
+			fmt.Printf("--schedule %q never matches\n", *scheduleFlag)
+			return 1
+		}
+		s.mu.Lock()
+		s.nextRun = next
+		s.mu.Unlock()
+		fmt.Printf("Next run at %s\n", next.Format(time.RFC3339))
+
+		// Sleep a minute at a time, so a clock change or a
+		// suspend doesn't leave us sleeping past the run.
+		for now := time.Now(); now.Before(next); now = time.Now() {
+			select {
+			case <-s.done:
+				return 0
+			case <-time.After(min(next.Sub(now), time.Minute)):
+			}
+		}
+
+		s.mu.Lock()
+		if s.running != nil {
+			fmt.Printf("WARNING: skipping the %s run; the one that started at %s is still going\n", next.Format(time.RFC3339), s.running.start.Format(time.RFC3339))
+			s.mu.Unlock()
+			continue
+		}
+		r := &scheduledRun{start: time.Now()}
+		s.running = r
+		s.mu.Unlock()
+		fmt.Printf("Starting the %s run\n", r.start.Format(time.RFC3339))
+
+		runs.Add(1)
+		go func() {
+			defer runs.Done()
+			s.execute(r)
+		}()
+	}
+}
+
+// Run s3test once, passing its output through and keeping the end of
+// it.
+func (s *scheduler) execute(r *scheduledRun) {
+	self, err := os.Executable()
+	if err != nil {
+		self = os.Args[0]
+	}
+	cmd := exec.Command(self, s.args...)
+	// The environment could set these too.
+	for _, e := range os.Environ() {
+		if !strings.HasPrefix(e, flagEnvName("schedule")+"=") && !strings.HasPrefix(e, flagEnvName("control-socket")+"=") {
+			cmd.Env = append(cmd.Env, e)
+		}
+	}
+	pr, pw := io.Pipe()
+	cmd.Stdout, cmd.Stderr = pw, pw
+
+	var tail []string
+	copied := make(chan struct{})
+	go func() {
+		defer close(copied)
+		scanner := bufio.NewScanner(pr)
+		for scanner.Scan() {
+			line := scanner.Text()
+			fmt.Println(line)
+			tail = append(tail, line)
+			if len(tail) > scheduledTailLines {
+				tail = tail[1:]
+			}
+		}
+		io.Copy(io.Discard, pr)
+	}()
+
+	status := 0
+	if err := cmd.Run(); err != nil {
+		status = -1
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			status = exitErr.ExitCode()
+		} else {
+			fmt.Printf("WARNING: unable to run %s: %v\n", self, err)
+		}
+	}
+	pw.Close()
+	<-copied
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r.duration = time.Since(r.start)
+	r.status = status
+	r.tail = tail
+	s.last = r
+	s.running = nil
+	fmt.Printf("The %s run finished in %.3fs with exit status %d\n", r.start.Format(time.RFC3339), r.duration.Seconds(), status)
+}
+
+// Describe the schedule, for the status command.
+func (s *scheduler) status() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var b strings.Builder
+	fmt.Fprintf(&b, "schedule %q", *scheduleFlag)
+	if s.stopped {
+		fmt.Fprintf(&b, ", stopping")
+	}
+	fmt.Fprintf(&b, "\n")
+	if s.running != nil {
+		fmt.Fprintf(&b, "running: started at %s\n", s.running.start.Format(time.RFC3339))
+	}
+	if !s.stopped {
+		fmt.Fprintf(&b, "next run: %s\n", s.nextRun.Format(time.RFC3339))
+	}
+	if s.last == nil {
+		fmt.Fprintf(&b, "last run: none yet")
+		return b.String()
+	}
+	fmt.Fprintf(&b, "last run: started at %s, took %.3fs, exit status %d\n", s.last.start.Format(time.RFC3339), s.last.duration.Seconds(), s.last.status)
+	// The reply ends at an empty line, so leave those out.
+	for _, line := range s.last.tail {
+		if strings.TrimSpace(line) != "" {
+			fmt.Fprintf(&b, "  %s\n", line)
+		}
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// Answer status and stop commands on `conn` until it's closed.
+func (s *scheduler) handleControl(conn net.Conn) {
+	defer conn.Close()
+	lines := bufio.NewScanner(conn)
+	for lines.Scan() {
+		var reply string
+		switch cmd := strings.TrimSpace(lines.Text()); cmd {
+		case "status":
+			reply = s.status()
+		case "stop":
+			s.mu.Lock()
+			if !s.stopped {
+				s.stopped = true
+				close(s.done)
+				fmt.Printf("Stopping via the control socket, after the current run if there is one\n")
+			}
+			s.mu.Unlock()
+			reply = "stopping"
+		default:
+			reply = fmt.Sprintf("unknown command %q; with --schedule, use status or stop", cmd)
+		}
+		if _, err := fmt.Fprintf(conn, "%s\n\n", reply); err != nil {
+			return
+		}
+	}
+}
+
+// Return `args` without any of the flags `names`, in either -name=value
+// or -name value form, with one or two dashes.
+func withoutFlags(args []string, names ...string) []string {
+	var out []string
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if a == "--" || !strings.HasPrefix(a, "-") {
+			// Flags stop here.
+			return append(out, args[i:]...)
+		}
+		name, _, hasValue := strings.Cut(strings.TrimLeft(a, "-"), "=")
+		// Unless it's a boolean, a flag without =value takes
+		// the next argument.
+		n := 1
+		if !hasValue && !isBoolFlag(name) && i+1 < len(args) {
+			n = 2
+		}
+		if !slices.Contains(names, name) {
+			out = append(out, args[i:i+n]...)
+		}
+		i += n - 1
+	}
+	return out
+}
+
+// Whether the flag `name` is a boolean, which doesn't take the next
+// argument as its value.
+func isBoolFlag(name string) bool {
+	f := flag.CommandLine.Lookup(name)
+	if f == nil {
+		return false
+	}
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
@@ -0,0 +1,136 @@
+package main
+
+// Some S3 gateways redirect requests to the node that owns the data.
+// net/http follows redirects underneath the SDK, s3fs, and the http
+// backends, so all we used to see was more requests and different
+// numbers.  Now the recording transport notes each hop: the response
+// that redirected, where to, and whether the Range header survived
+// into the request that followed it.  A read's hops go in its sample,
+// the summary counts them by status and target host, and a hop that
+// lost the Range (and so fetched the whole object) is counted as
+// amplification.
+//
+// --max-redirects caps how many redirects one request follows; past
+// that, or with --max-redirects=0, the redirect response goes back to
+// the client stack as is, and it's up to it what to make of it.
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// redirectHop is one redirect that a read's request got.
+type redirectHop struct {
+	Status       int    `json:"status"`
+	From         string `json:"from"` // host and path
+	To           string `json:"to"`   // the Location header
+	Followed     bool   `json:"followed"`
+	RangeDropped bool   `json:"rangeDropped,omitempty"` // the request that followed had no Range
+}
+
+// redirectReport summarizes the redirects in a run.
+type redirectReport struct {
+	Redirects    int            `json:"redirects"`
+	NotFollowed  int            `json:"notFollowed,omitempty"`
+	ByStatus     map[string]int `json:"byStatus"`
+	Hosts        map[string]int `json:"hosts"` // where the followed redirects went
+	RangeDropped int            `json:"rangeDropped,omitempty"`
+}
+
+// The http.Client CheckRedirect policy for --max-redirects.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) > *maxRedirects {
+		return http.ErrUseLastResponse
+	}
+	return nil
+}
+
+// Is `r` a redirect response?
+func (r *recordedRequest) isRedirect() bool {
+	return r.Status >= 300 && r.Status < 400 && r.Location != ""
+}
+
+// Return the request that started the chain of redirects that `r` is
+// part of, or `r` itself.
+func (r *recordedRequest) origin() *recordedRequest {
+	for r.redirectedFrom != nil {
+		r = r.redirectedFrom
+	}
+	return r
+}
+
+// Did `r` follow a redirect and lose the Range header on the way?
+func (r *recordedRequest) rangeDropped() bool {
+	return r.redirectedFrom != nil && r.Range == "" && r.origin().Range != ""
+}
+
+// Return the redirects among `requests`, in order.
+func redirectHops(requests []*recordedRequest) []redirectHop {
+	followed := map[*recordedRequest]*recordedRequest{}
+	for _, r := range requests {
+		if r.redirectedFrom != nil {
+			followed[r.redirectedFrom] = r
+		}
+	}
+	var hops []redirectHop
+	for _, r := range requests {
+		if !r.isRedirect() {
+			continue
+		}
+		hop := redirectHop{Status: r.Status, From: r.Host + r.Path, To: r.Location}
+		if next := followed[r]; next != nil {
+			hop.Followed = true
+			hop.RangeDropped = next.rangeDropped()
+		}
+		hops = append(hops, hop)
+	}
+	return hops
+}
+
+// Summarize the redirects among `requests`, or return nil if there
+// weren't any.
+func analyzeRedirects(requests []*recordedRequest) *redirectReport {
+	var report *redirectReport
+	for _, r := range requests {
+		if r.isRedirect() {
+			if report == nil {
+				report = &redirectReport{ByStatus: map[string]int{}, Hosts: map[string]int{}}
+			}
+			report.Redirects++
+			report.ByStatus[strconv.Itoa(r.Status)]++
+		}
+	}
+	if report == nil {
+		return nil
+	}
+	report.NotFollowed = report.Redirects
+	for _, r := range requests {
+		if r.redirectedFrom == nil {
+			continue
+		}
+		report.NotFollowed--
+		report.Hosts[r.Host]++
+		if r.rangeDropped() {
+			report.RangeDropped++
+		}
+	}
+	return report
+}
+
+func (r *redirectReport) print() {
+	var statuses []string
+	for _, s := range slices.Sorted(maps.Keys(r.ByStatus)) {
+		statuses = append(statuses, fmt.Sprintf("%d × %s", r.ByStatus[s], s))
+	}
+	fmt.Printf("Redirects: %d (%s), %d not followed (--max-redirects=%d)\n", r.Redirects, strings.Join(statuses, ", "), r.NotFollowed, *maxRedirects)
+	for _, h := range slices.Sorted(maps.Keys(r.Hosts)) {
+		fmt.Printf("  %6d to %s\n", r.Hosts[h], h)
+	}
+	if r.RangeDropped > 0 {
+		fmt.Printf("  WARNING: %d redirected requests lost their Range header, and asked for the whole object\n", r.RangeDropped)
+	}
+}
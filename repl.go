@@ -0,0 +1,248 @@
+package main
+
+// During an incident, re-running the whole CLI for every probe means
+// paying for credentials, connections, and preflight each time, and
+// waiting for a full run when one read would do.  `s3test repl` keeps
+// one client and one connection pool open and reads commands:
+//
+//	stat KEY                  the object's size, as a run would find it
+//	read KEY OFFSET SIZE      one read, printed like a run's reads
+//	range KEY START-END       likewise, for an inclusive byte range
+//	trace on|off              print every request of every read
+//	mode s3fs|getobject|http|presigned
+//	summary                   statistics for the reads since the last summary
+//	history, !N               list and repeat earlier commands
+//	help, quit
+//
+// Reads go through readFrom() and the same backends, transport, and
+// recording as a normal run, so `trace on` prints what
+// --slow-read-threshold would.  The other flags (--endpoint, --bucket,
+// --path-style, and so on) apply as usual.
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/jszwec/s3fs/v2"
+)
+
+const replHelp = `Commands:
+  stat KEY                  print the object's size
+  read KEY OFFSET SIZE      read SIZE bytes at OFFSET
+  range KEY START-END       read bytes START through END, inclusive
+  trace on|off              print every request of every read
+  mode s3fs|getobject|http|presigned
+                            read through a different client stack
+  summary                   print statistics for the reads since the last summary
+  history                   list earlier commands; !N repeats one
+  help                      print this
+  quit                      leave
+`
+
+// repl is the state of an interactive session.
+type repl struct {
+	ctx     context.Context
+	fs      *s3fs.S3FS
+	client  *s3.Client
+	backend Backend
+	sizes   map[string]int64 // from stat, so reads can give a percentage
+	samples []*Sample
+	history []string
+}
+
+func runREPL(args []string) int {
+	if len(args) > 0 {
+		fmt.Printf("Usage: s3test [flags] repl\n")
+		return 1
+	}
+	if *mode != "s3fs" && *mode != "getobject" && *mode != "http" && *mode != "presigned" {
+		fmt.Printf("s3test repl works with --mode=s3fs, getobject, http, or presigned\n")
+		return 1
+	}
+	ctx := context.Background()
+	fsys, client, err := connect(ctx)
+	if err != nil {
+		panic(err)
+	}
+	r := &repl{ctx: ctx, fs: fsys, client: client, sizes: map[string]int64{}}
+	if err := r.setMode(*mode); err != nil {
+		fmt.Printf("%v\n", err)
+		return 1
+	}
+	fmt.Printf("Reading from %s, bucket %s, with --mode=%s; \"help\" lists the commands\n", *endpoint, *bucket, *mode)
+	r.loop(os.Stdin)
+	return 0
+}
+
+// Read and run commands from `in` until it ends or we're told to quit.
+func (r *repl) loop(in io.Reader) {
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Printf("s3test> ")
+		if !scanner.Scan() {
+			fmt.Printf("\n")
+			return
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if n, ok := strings.CutPrefix(line, "!"); ok {
+			i, err := strconv.Atoi(n)
+			if err != nil || i < 1 || i > len(r.history) {
+				fmt.Printf("No command %s in the history\n", line)
+				continue
+			}
+			line = r.history[i-1]
+			fmt.Printf("%s\n", line)
+		}
+		if line == "" {
+			continue
+		}
+		if line != "history" {
+			r.history = append(r.history, line)
+		}
+		if !r.run(strings.Fields(line)) {
+			return
+		}
+	}
+}
+
+// Run one command, returning false for quit.
+func (r *repl) run(words []string) bool {
+	usage := func(u string) { fmt.Printf("Usage: %s\n", u) }
+	switch words[0] {
+	case "quit", "exit":
+		return false
+	case "help", "?":
+		fmt.Print(replHelp)
+	case "history":
+		for i, h := range r.history {
+			fmt.Printf("%4d  %s\n", i+1, h)
+		}
+	case "stat":
+		if len(words) != 2 {
+			usage("stat KEY")
+			break
+		}
+		r.stat(words[1])
+	case "read":
+		if len(words) != 4 {
+			usage("read KEY OFFSET SIZE")
+			break
+		}
+		offset, err1 := parseOffset(words[2])
+		size, err2 := parseOffset(words[3])
+		if err1 != nil || err2 != nil || size == 0 {
+			usage("read KEY OFFSET SIZE, with SIZE at least 1")
+			break
+		}
+		r.read(words[1], offset, size)
+	case "range":
+		if len(words) != 3 {
+			usage("range KEY START-END")
+			break
+		}
+		a, b, _ := strings.Cut(words[2], "-")
+		start, err1 := parseOffset(a)
+		end, err2 := parseOffset(b)
+		if err1 != nil || err2 != nil || end < start {
+			usage("range KEY START-END, with END at least START")
+			break
+		}
+		r.read(words[1], start, end-start+1)
+	case "trace":
+		if len(words) != 2 || (words[1] != "on" && words[1] != "off") {
+			usage("trace on|off")
+			break
+		}
+		traceReads.Store(words[1] == "on")
+	case "mode":
+		if len(words) != 2 {
+			usage("mode s3fs|getobject|http|presigned")
+			break
+		}
+		if err := r.setMode(words[1]); err != nil {
+			fmt.Printf("%v\n", err)
+		}
+	case "summary":
+		r.summary()
+	default:
+		fmt.Printf("Unknown command %q; try help\n", words[0])
+	}
+	return true
+}
+
+// Switch to reading through `m`.
+func (r *repl) setMode(m string) error {
+	if m != "s3fs" && m != "getobject" && m != "http" && m != "presigned" {
+		return fmt.Errorf("unknown mode %q; use s3fs, getobject, http, or presigned", m)
+	}
+	backend, err := newBackend(m, r.client, defaultTarget(), "")
+	if err != nil {
+		return err
+	}
+	*mode = m
+	r.backend = backend
+	return nil
+}
+
+func (r *repl) stat(key string) {
+	discovery, err := discoverSize(r.ctx, r.fs, r.client, key, *sizeFrom)
+	if err != nil {
+		fmt.Printf("Unable to get the size of %s: %v\n", key, err)
+		return
+	}
+	r.sizes[key] = discovery.Size
+	fmt.Printf("File size %d bytes via %s in %.3fs\n", discovery.Size, discovery.Method, discovery.Duration.Seconds())
+}
+
+func (r *repl) read(key string, offset, size int64) {
+	if err := checkRange(offset, size); err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+	// Without a stat, the percentage is of the end of this read.
+	total, ok := r.sizes[key]
+	if !ok {
+		total = offset + size
+	}
+	sample, err := readFrom(r.ctx, r.backend, key, offset, size, total)
+	sample.Label = *mode
+	r.samples = append(r.samples, sample)
+	if err != nil {
+		fmt.Printf("Read failed: %v\n", err)
+	}
+}
+
+// Print statistics for the reads since the last summary, and start
+// again.
+func (r *repl) summary() {
+	if len(r.samples) == 0 {
+		fmt.Printf("No reads since the last summary\n")
+		return
+	}
+	var latencies []time.Duration
+	var bytes int64
+	var errors, retried int
+	for _, s := range r.samples {
+		if s.Err != "" {
+			errors++
+			continue
+		}
+		latencies = append(latencies, s.Duration)
+		bytes += s.Bytes
+		if s.Attempts() > len(s.Ops) {
+			retried++
+		}
+	}
+	fmt.Printf("%d reads, %d failed, %d needed retries; read %s\n", len(r.samples), errors, retried, units.bytes(bytes))
+	fmt.Printf("Reads: %s\n", computeLatencyStats(latencies))
+	reportResponseTiming(r.samples)
+	reportStalls(r.samples)
+	r.samples = nil
+}
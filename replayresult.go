@@ -0,0 +1,37 @@
+package main
+
+// When a run shows something odd, the first question is whether it
+// happens again.  Every --json result includes the schedule it ran
+// (with the --jitter seed, if any), so --replay-result=FILE can run
+// exactly the same reads in the same order without having saved a
+// --plan first.  It refuses if the object's size or ETag has changed
+// since, because then it wouldn't be the same experiment, and the new
+// result records the original's run ID in `replayOf` so the pair can
+// be found again later.
+
+import (
+	"fmt"
+)
+
+// Load the result of the run to replay.
+func loadReplayResult(filename string) (*Result, error) {
+	original, err := loadResult(filename)
+	if err != nil {
+		return nil, err
+	}
+	if original.Schedule == nil {
+		return nil, fmt.Errorf("%s doesn't include its schedule; it was probably written by an older version of s3test", filename)
+	}
+	return original, nil
+}
+
+// Check that the object is the same one `original` read.
+func checkReplayable(original *Result, filesize int64, etag string) error {
+	if filesize != original.FileSize {
+		return fmt.Errorf("can't replay run %s: %s was %d bytes then, but is %d bytes now", original.RunID, original.File, original.FileSize, filesize)
+	}
+	if original.ETag != "" && etag != original.ETag {
+		return fmt.Errorf("can't replay run %s: %s had ETag %s then, but has %s now", original.RunID, original.File, original.ETag, etag)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+// Golden files of the requests that s3fs and the SDK make for each
+// read pattern, so that a dependency bump that changes them (an extra
+// HEAD, a missing Range, different range math) fails here, and the
+// diff shows how.  If the change is expected, regenerate them with
+//
+//	go test -run TestRequestPatterns -update
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// requestLog records each request's method, path, and Range header.
+type requestLog struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *requestLog) wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rng := r.Header.Get("Range")
+		if rng == "" {
+			rng = "-"
+		}
+		l.mu.Lock()
+		l.lines = append(l.lines, fmt.Sprintf("%s %s %s", r.Method, r.URL.Path, rng))
+		l.mu.Unlock()
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Return the requests so far, and start over.
+func (l *requestLog) take() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s := strings.Join(l.lines, "\n") + "\n"
+	l.lines = nil
+	return s
+}
+
+// Compare `got` with testdata/requests/`name`.txt, or rewrite it with
+// -update.
+func checkGolden(t *testing.T, name, got string) {
+	t.Helper()
+	filename := filepath.Join("testdata", "requests", name+".txt")
+	if *updateGolden {
+		if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filename, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("%v; run with -update to create it", err)
+	}
+	if got != string(want) {
+		t.Errorf("%s: the requests changed; run with -update if that's expected\ngot:\n%swant:\n%s", filename, got, want)
+	}
+}
+
+func TestRequestPatterns(t *testing.T) {
+	const filesize = 4 << 20
+	data := make([]byte, filesize)
+	var log requestLog
+	client := serveFakeS3(t, log.wrap(fakeBucket(map[string][]byte{"big.mp4": data})))
+	setFlag(t, mode, "s3fs")
+	setFlag(t, concurrency, 1)
+	setFlag(t, pattern, "sequential")
+
+	for _, tc := range []struct {
+		name  string
+		set   func(t *testing.T, readsize int64)
+		reuse bool
+	}{
+		{"sequential", func(t *testing.T, readsize int64) {}, false},
+		{"random", func(t *testing.T, readsize int64) {
+			setFlag(t, sampleCount, 3)
+			setFlag(t, sampleStrategy, "random")
+			setFlag(t, sampleSeed, 1)
+		}, false},
+		{"reuse-handle", func(t *testing.T, readsize int64) {}, true},
+		// What --diagnose does: the end of the file, then the start.
+		{"tail-first", func(t *testing.T, readsize int64) {
+			setFlag(t, &explicitRanges, rangeList{{offset: filesize - readsize, size: readsize}, {offset: 0, size: readsize}})
+		}, false},
+	} {
+		for _, size := range []int64{256 << 10, 1 << 20} {
+			name := fmt.Sprintf("%s-%dk", tc.name, size>>10)
+			t.Run(name, func(t *testing.T) {
+				setFlag(t, readsize, size)
+				tc.set(t, size)
+				var backend Backend = &s3fsBackend{client: client, bucket: "test"}
+				if tc.reuse {
+					pool, err := newHandlePool(backend, 1)
+					if err != nil {
+						t.Fatal(err)
+					}
+					defer pool.close()
+					backend = pool
+				}
+				s, err := planSchedule("big.mp4", filesize)
+				if err != nil {
+					t.Fatal(err)
+				}
+				log.take()
+				for _, r := range s.Reads {
+					if _, err := readFrom(context.Background(), backend, "big.mp4", r.Offset, r.Size, filesize); err != nil {
+						t.Fatal(err)
+					}
+				}
+				checkGolden(t, name, log.take())
+			})
+		}
+	}
+}
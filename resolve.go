@@ -0,0 +1,132 @@
+package main
+
+// The endpoint's hostname usually resolves to a VIP, and comparing
+// two of the nodes behind it means pointing s3test at each one in
+// turn.  Swapping the hostname in --endpoint for an address doesn't
+// work with virtual-hosted-style requests, since the bucket is part
+// of the hostname and the signature, and editing /etc/hosts isn't an
+// option on shared test machines.  So --resolve works like curl's:
+// "host:port:addr" makes connections to host:port go to addr instead,
+// with the Host header and TLS SNI left alone.  It applies to every
+// connection the benchmark's transport makes, including the ones to
+// bucket.host in virtual-hosted style, so give the full hostname.
+//
+// The startup banner and the results record the overrides, and the
+// summary counts the connections to each address we actually dialed.
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// resolveOverride sends connections to Host:Port to Addr.
+type resolveOverride struct {
+	Host string
+	Port string
+	Addr string
+}
+
+func (o resolveOverride) String() string {
+	return fmt.Sprintf("%s:%s:%s", o.Host, o.Port, o.Addr)
+}
+
+type resolveList []resolveOverride
+
+func (l *resolveList) String() string {
+	var parts []string
+	for _, o := range *l {
+		parts = append(parts, o.String())
+	}
+	return strings.Join(parts, " ")
+}
+
+// Parse "host:port:addr", where addr may be an IPv6 address in
+// brackets.
+func (l *resolveList) Set(s string) error {
+	parts := strings.SplitN(s, ":", 3)
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return fmt.Errorf("want host:port:addr, not %q", s)
+	}
+	o := resolveOverride{Host: strings.ToLower(parts[0]), Port: parts[1], Addr: strings.TrimSuffix(strings.TrimPrefix(parts[2], "["), "]")}
+	if net.ParseIP(o.Addr) == nil {
+		return fmt.Errorf("%q in --resolve %q isn't an IP address", o.Addr, s)
+	}
+	for _, other := range *l {
+		if other.Host == o.Host && other.Port == o.Port {
+			return fmt.Errorf("--resolve gives %s:%s twice", o.Host, o.Port)
+		}
+	}
+	*l = append(*l, o)
+	return nil
+}
+
+// Return the address to dial instead of `addr`, if any.
+func (l resolveList) lookup(addr string) (string, bool) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", false
+	}
+	host = strings.ToLower(host)
+	for _, o := range l {
+		if o.Host == host && o.Port == port {
+			return net.JoinHostPort(o.Addr, port), true
+		}
+	}
+	return "", false
+}
+
+// Set up the transport for --resolve.
+func configureResolve(overrides resolveList) error {
+	if len(overrides) == 0 {
+		return nil
+	}
+	inner, ok := upstream.inner.(*http.Transport)
+	if !ok {
+		return fmt.Errorf("can't apply --resolve to a %T", upstream.inner)
+	}
+	t := inner.Clone()
+	dial := t.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if to, ok := overrides.lookup(addr); ok {
+			addr = to
+		}
+		return dial(ctx, network, addr)
+	}
+	upstream.inner = t
+	return nil
+}
+
+// Count the connections that requests went out on by remote address.
+func connectionsByAddress(requests []*recordedRequest) map[string]int {
+	seen := make(map[net.Conn]bool)
+	counts := make(map[string]int)
+	for _, r := range requests {
+		if r.conn == nil || seen[r.conn] {
+			continue
+		}
+		seen[r.conn] = true
+		counts[r.conn.RemoteAddr().String()]++
+	}
+	return counts
+}
+
+// Print the connection counts by address.
+func reportConnectionsByAddress(counts map[string]int) {
+	var addrs []string
+	for a := range counts {
+		addrs = append(addrs, a)
+	}
+	slices.Sort(addrs)
+	var parts []string
+	for _, a := range addrs {
+		parts = append(parts, fmt.Sprintf("%s (%d)", a, counts[a]))
+	}
+	fmt.Printf("Connections by address, with --resolve=%s: %s\n", resolves.String(), strings.Join(parts, ", "))
+}
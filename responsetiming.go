@@ -0,0 +1,115 @@
+package main
+
+// s3fs hides the HTTP layer, so an s3fs read's latency lumps
+// together waiting for the server to answer and draining the body,
+// while the getobject and http modes can tell them apart.  The
+// recording transport sees every response, though, so it notes when
+// the headers arrived and when the body hit EOF (or was closed), and
+// the read whose context sent the request picks that up afterward.
+// This works the same way in every mode, so s3fs runs get the same
+// header and body percentiles as the others.
+//
+// A read can make several requests (s3fs's Open and Seek each make
+// one), so the timings come from the response that delivered the most
+// data, which is the one the read was actually waiting on.
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// responseSet collects the requests made by one read.
+type responseSet struct {
+	mu       sync.Mutex
+	requests []*recordedRequest
+}
+
+type responseSetKey struct{}
+
+// Return a context that collects the requests made with it.
+func collectResponses(ctx context.Context) (context.Context, *responseSet) {
+	r := &responseSet{}
+	return context.WithValue(ctx, responseSetKey{}, r), r
+}
+
+func (r *responseSet) add(rec *recordedRequest) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, rec)
+}
+
+// Return a copy of the requests collected so far.
+func (r *responseSet) Requests() []*recordedRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*recordedRequest(nil), r.requests...)
+}
+
+// Return the request that received the most data, or nil if none
+// received any.
+func (r *responseSet) data() *recordedRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var data *recordedRequest
+	for _, rec := range r.requests {
+		if data == nil || rec.Received() > data.Received() {
+			data = rec
+		}
+	}
+	if data == nil || data.Received() == 0 {
+		return nil
+	}
+	return data
+}
+
+// Return how long the data-carrying response took to send its headers
+// and then its body, or false if there wasn't one or it isn't done.
+func (r *responseSet) timing() (header, body time.Duration, ok bool) {
+	data := r.data()
+	if data == nil {
+		return 0, 0, false
+	}
+	headersAt, doneAt := data.timestamps()
+	if headersAt.IsZero() || doneAt.IsZero() {
+		return 0, 0, false
+	}
+	return headersAt.Sub(data.Start), doneAt.Sub(headersAt), true
+}
+
+// Count the HEAD and GET requests this read made.
+func (r *responseSet) methods() (heads, gets int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, rec := range r.requests {
+		switch rec.Method {
+		case http.MethodHead:
+			heads++
+		case http.MethodGet:
+			gets++
+		}
+	}
+	return heads, gets
+}
+
+// Print percentiles for the header and body latency of every read
+// that has them.
+func reportResponseTiming(samples []*Sample) {
+	var headers, bodies []time.Duration
+	for _, s := range samples {
+		if s.Err == "" && s.HeaderLatency > 0 {
+			headers = append(headers, s.HeaderLatency)
+			bodies = append(bodies, s.BodyLatency)
+		}
+	}
+	if len(headers) == 0 {
+		return
+	}
+	fmt.Printf("Response headers: %s\n", computeLatencyStats(headers))
+	fmt.Printf("Response bodies:  %s\n", computeLatencyStats(bodies))
+}
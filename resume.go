@@ -0,0 +1,185 @@
+package main
+
+// A multi-hour 64 kB pass that dies at 70% used to mean starting over,
+// and re-reading the part that was fine to get back to the part that
+// wasn't.  So with --state-file, a sequential run saves its progress
+// (the samples so far, and when each session ran) in the file's entry
+// every progressSaveInterval, and when it stops early; a run that
+// finishes clears it.  --resume then reads only what the saved
+// samples don't cover, and the summary, --json, and the rest include
+// the earlier samples as if it had been one run.
+//
+// It isn't quite one run, though: the caches were cold or warm at
+// different points, and there's a gap in wall-clock time between
+// sessions.  The duration and throughput only count the time spent
+// reading, and the summary and the results (`resumed`) say that the
+// run was resumed and how long the gap was.  If the object's ETag has
+// changed since, we refuse to resume, since it wouldn't be the same
+// experiment.
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+const progressSaveInterval = 30 * time.Second
+
+// runSession is one session of a resumable run.
+type runSession struct {
+	Start  time.Time     `json:"start"`
+	End    time.Time     `json:"end"`
+	Active time.Duration `json:"activeNs"` // not counting time spent paused
+	Reads  int           `json:"reads"`
+}
+
+// runProgress is a partly finished run, as saved in the state file.
+type runProgress struct {
+	Sessions []runSession `json:"sessions"`
+	Samples  []*Sample    `json:"samples"`
+}
+
+// resumeInfo goes in the results of a resumed run.
+type resumeInfo struct {
+	Sessions []runSession  `json:"sessions"` // including this one
+	Gap      time.Duration `json:"gapNs"`    // between sessions, not counted in the duration
+}
+
+// Return the reads in `sched` that the saved samples don't cover.
+func (p *runProgress) remaining(sched *schedule) *schedule {
+	done := make(map[int64]bool, len(p.Samples))
+	for _, s := range p.Samples {
+		done[s.Offset] = true
+	}
+	rest := *sched
+	rest.Reads = nil
+	for _, r := range sched.Reads {
+		if !done[r.Offset] {
+			rest.Reads = append(rest.Reads, r)
+		}
+	}
+	return &rest
+}
+
+// Return the time spent reading in the saved sessions.
+func (p *runProgress) active() time.Duration {
+	var d time.Duration
+	for _, s := range p.Sessions {
+		d += s.Active
+	}
+	return d
+}
+
+// Add the saved samples to the benchmark, as if it had read them.
+func (b *benchmark) restore(samples []*Sample) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, s := range samples {
+		b.asked = append(b.asked, readRange{offset: s.Offset, size: s.Size})
+		b.result.Samples = append(b.result.Samples, s)
+		if s.Err != "" {
+			b.result.Errors++
+			continue
+		}
+		b.totalBytes += s.Bytes
+		b.latencies = append(b.latencies, s.Duration)
+		if s.Attempts() > len(s.Ops) {
+			b.retriedReads++
+		}
+	}
+}
+
+// progressSaver saves a run's progress in the state file as it goes.
+type progressSaver struct {
+	state    *stateFile
+	filename string
+	key      string
+	b        *benchmark
+	earlier  []runSession
+	start    time.Time
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// Start saving the progress of `b` in the state file's entry `key`,
+// after the sessions in `prev`, if any.
+func startProgress(state *stateFile, filename, key string, b *benchmark, prev *runProgress) *progressSaver {
+	p := &progressSaver{state: state, filename: filename, key: key, b: b, start: time.Now(), stop: make(chan struct{}), done: make(chan struct{})}
+	if prev != nil {
+		p.earlier = prev.Sessions
+	}
+	go func() {
+		defer close(p.done)
+		ticker := time.NewTicker(progressSaveInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := p.save(); err != nil {
+					fmt.Printf("WARNING: unable to save progress to %s: %v\n", p.filename, err)
+				}
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+	return p
+}
+
+// Return the sessions so far, including this one.
+func (p *progressSaver) sessions(reads int) []runSession {
+	now := time.Now()
+	return append(slices.Clone(p.earlier), runSession{Start: p.start, End: now, Active: now.Sub(p.start) - p.b.pausedFor(), Reads: reads})
+}
+
+func (p *progressSaver) save() error {
+	p.b.mu.Lock()
+	samples := slices.Clone(p.b.result.Samples)
+	p.b.mu.Unlock()
+	reads := len(samples)
+	for _, s := range p.earlier {
+		reads -= s.Reads
+	}
+	p.state.Entries[p.key].Progress = &runProgress{Sessions: p.sessions(reads), Samples: samples}
+	return p.state.save(p.filename)
+}
+
+// Stop saving, and either clear the progress, if the run is
+// `complete`, or save it one last time so it can be resumed.
+func (p *progressSaver) finish(complete bool) {
+	close(p.stop)
+	<-p.done
+	var err error
+	if complete {
+		p.state.Entries[p.key].Progress = nil
+		err = p.state.save(p.filename)
+	} else {
+		err = p.save()
+		if err == nil {
+			fmt.Printf("Saved progress to %s; use --resume to carry on from here\n", p.filename)
+		}
+	}
+	if err != nil {
+		fmt.Printf("WARNING: unable to save progress to %s: %v\n", p.filename, err)
+	}
+}
+
+// Describe a resumed run's sessions for the results, with `reads` in
+// this session so far.
+func (p *progressSaver) resumeInfo(reads int) *resumeInfo {
+	info := &resumeInfo{Sessions: p.sessions(reads)}
+	for i := 1; i < len(info.Sessions); i++ {
+		info.Gap += info.Sessions[i].Start.Sub(info.Sessions[i-1].End)
+	}
+	return info
+}
+
+// Print what a resumed run's numbers cover.
+func (r *resumeInfo) print() {
+	var earlier int
+	for _, s := range r.Sessions[:len(r.Sessions)-1] {
+		earlier += s.Reads
+	}
+	fmt.Printf("Resumed run: %d sessions, %d reads from earlier sessions; the %.0fs between sessions isn't counted, and cache state may differ between them\n",
+		len(r.Sessions), earlier, r.Gap.Seconds())
+}
@@ -0,0 +1,194 @@
+package main
+
+// A --jsonl file from a week-long soak run grows until it fills the
+// disk and kills the run.  With --rotate-size or --rotate-interval,
+// once the file gets that big or that old it's renamed with the next
+// sequence number (results.jsonl becomes results.000001.jsonl, then
+// results.000002.jsonl, and so on) and a new results.jsonl is started,
+// with the run's "run" record repeated at the top so that each file
+// makes sense on its own.  --max-rotated-files deletes the oldest
+// renamed files past that many.
+//
+// The rename is atomic, and it happens with the writer's lock held,
+// so every record lands whole in exactly one file.  `s3test analyze`
+// stitches a rotated set back together, in sequence order with the
+// current file last, whether it's given the files or a quoted glob.
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rotationPolicy says when to rotate a --jsonl file.
+type rotationPolicy struct {
+	size     int64         // bytes, or 0 for no limit
+	interval time.Duration // or 0 for no limit
+	keep     int           // rotated files to keep, or 0 for all of them
+}
+
+// Is a file `size` bytes long, started at `opened`, due for rotation?
+func (p rotationPolicy) due(size int64, opened time.Time) bool {
+	return (p.size > 0 && size >= p.size) || (p.interval > 0 && time.Since(opened) >= p.interval)
+}
+
+// Rotated files are named like the original, with a sequence number
+// before the extension.
+var rotatedPattern = regexp.MustCompile(`^(.*)\.(\d{6,})(\.[^.]*)$`)
+
+// Return the name of rotated file number `seq` of `filename`.
+func rotatedName(filename string, seq int) string {
+	ext := filepath.Ext(filename)
+	return fmt.Sprintf("%s.%06d%s", strings.TrimSuffix(filename, ext), seq, ext)
+}
+
+// Return the original name and sequence number of the rotated file
+// `name`, or false if it isn't one.
+func parseRotatedName(name string) (base string, seq int, ok bool) {
+	m := rotatedPattern.FindStringSubmatch(name)
+	if m == nil {
+		return "", 0, false
+	}
+	seq, err := strconv.Atoi(m[2])
+	if err != nil {
+		return "", 0, false
+	}
+	return m[1] + m[3], seq, true
+}
+
+// Return the rotated files of `filename`, oldest first.
+func rotatedFiles(filename string) ([]string, error) {
+	ext := filepath.Ext(filename)
+	matches, err := filepath.Glob(globEscape(strings.TrimSuffix(filename, ext)) + ".*" + globEscape(ext))
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, m := range matches {
+		if base, _, ok := parseRotatedName(m); ok && base == filename {
+			files = append(files, m)
+		}
+	}
+	slices.SortFunc(files, func(a, b string) int {
+		_, sa, _ := parseRotatedName(a)
+		_, sb, _ := parseRotatedName(b)
+		return sa - sb
+	})
+	return files, nil
+}
+
+// Escape the glob metacharacters in `s`.
+func globEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[\`, r) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Rename the current file with the next sequence number, start a new
+// one with the "run" record, and delete old files past the policy's
+// limit.  Must be called with w.mu held.
+func (w *jsonlWriter) rotate() error {
+	old, err := rotatedFiles(w.filename)
+	if err != nil {
+		return err
+	}
+	seq := 1
+	if len(old) > 0 {
+		_, last, _ := parseRotatedName(old[len(old)-1])
+		seq = last + 1
+	}
+
+	w.f.Sync()
+	if err := w.f.Close(); err != nil {
+		return err
+	}
+	rotated := rotatedName(w.filename, seq)
+	if err := os.Rename(w.filename, rotated); err != nil {
+		return err
+	}
+	if err := w.open(); err != nil {
+		return err
+	}
+	if w.header != nil {
+		if err := w.append(w.header); err != nil {
+			return err
+		}
+	}
+	w.unsynced = 0
+
+	old = append(old, rotated)
+	if w.rotation.keep > 0 && len(old) > w.rotation.keep {
+		for _, f := range old[:len(old)-w.rotation.keep] {
+			if err := os.Remove(f); err != nil {
+				fmt.Printf("WARNING: unable to remove old --jsonl file: %v\n", err)
+			}
+		}
+	}
+	return nil
+}
+
+// Expand any globs among analyze's arguments, and group the files of
+// each rotated set together, in order, with the current file last.
+// Files that aren't part of a rotated set come back as groups of one.
+func analyzeFileGroups(args []string) ([][]string, error) {
+	var files []string
+	for _, arg := range args {
+		if _, err := os.Stat(arg); err == nil || !strings.ContainsAny(arg, "*?[") {
+			files = append(files, arg)
+			continue
+		}
+		matches, err := filepath.Glob(arg)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no files match %s", arg)
+		}
+		files = append(files, matches...)
+	}
+
+	// The set's name is the current file's name, whether or not
+	// it's among the files.
+	sets := map[string][]string{}
+	for _, f := range files {
+		if base, _, ok := parseRotatedName(f); ok {
+			sets[base] = append(sets[base], f)
+		}
+	}
+	var groups [][]string
+	seen := map[string]bool{}
+	for _, f := range files {
+		name := f
+		if base, _, ok := parseRotatedName(f); ok {
+			name = base
+		}
+		rotated, ok := sets[name]
+		switch {
+		case !ok:
+			groups = append(groups, []string{f})
+		case !seen[name]:
+			seen[name] = true
+			slices.SortFunc(rotated, func(a, b string) int {
+				_, sa, _ := parseRotatedName(a)
+				_, sb, _ := parseRotatedName(b)
+				return sa - sb
+			})
+			group := slices.Compact(rotated)
+			if slices.Contains(files, name) {
+				group = append(group, name)
+			}
+			groups = append(groups, group)
+		}
+	}
+	return groups, nil
+}
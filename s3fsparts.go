@@ -0,0 +1,209 @@
+package main
+
+// Libraries that read S3 objects in fixed-size parts interact with
+// --readsize in ways that are hard to see: a 256 KiB read that
+// straddles two 1 MiB parts costs 2 MiB.  s3fs v2 doesn't have a part
+// size (its Seek() asks for everything from the offset to the end of
+// the object and reads as far as it needs), so --s3fs-part-size
+// emulates one: s3fs mode reads through a layer that fetches aligned,
+// part-sized ranges with GetObject and serves reads from them.
+// --s3fs-part-size-sweep runs the same schedule once per part size and
+// prints a table, and every s3fs run reports the sizes of the Range
+// headers that were actually sent.
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// partBackend reads through part-sized, aligned GetObject ranges.
+type partBackend struct {
+	client *s3.Client
+	bucket string
+	part   int64
+}
+
+func (b *partBackend) Open(ctx context.Context, filename string, offset, size int64, sample *Sample) (io.ReadCloser, error) {
+	return &partReader{ctx: ctx, backend: b, key: filename, pos: offset, end: offset + size, sample: sample}, nil
+}
+
+// partReader serves one read from a series of part-sized GETs.
+type partReader struct {
+	ctx     context.Context
+	backend *partBackend
+	key     string
+	pos     int64 // where the next Read starts
+	end     int64 // where the read ends
+	sample  *Sample
+
+	body    io.ReadCloser
+	bodyEnd int64 // the offset just past the part in `body`
+}
+
+// Start reading the part that holds `p.pos`, skipping up to it.
+func (p *partReader) fetch() error {
+	start := p.pos / p.backend.part * p.backend.part
+	t := time.Now()
+	ctx, endPhase := startPhase(p.ctx, "GetObject part")
+	out, err := p.backend.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(p.backend.bucket),
+		Key:    aws.String(p.key),
+		Range:  aws.String(rangeHeader(start, p.backend.part)),
+	})
+	endPhase()
+	p.sample.addPhase("part", time.Since(t))
+	if err != nil {
+		p.sample.Status = statusOf(err)
+		return err
+	}
+	if total, ok := contentRangeSize(aws.ToString(out.ContentRange)); ok {
+		p.sample.ObjectSize = total
+	}
+	if _, err := io.CopyN(io.Discard, out.Body, p.pos-start); err != nil {
+		out.Body.Close()
+		return err
+	}
+	p.body = out.Body
+	p.bodyEnd = start + p.backend.part
+	return nil
+}
+
+func (p *partReader) Read(buf []byte) (int, error) {
+	if p.pos >= p.end {
+		return 0, io.EOF
+	}
+	if p.body == nil || p.pos >= p.bodyEnd {
+		if p.body != nil {
+			p.body.Close()
+			p.body = nil
+		}
+		if err := p.fetch(); err != nil {
+			return 0, err
+		}
+	}
+	want := min(int64(len(buf)), p.end-p.pos, p.bodyEnd-p.pos)
+	n, err := p.body.Read(buf[:want])
+	p.pos += int64(n)
+	if err == io.EOF {
+		// The part was short, at the end of the object.
+		p.bodyEnd = p.pos
+		if p.pos < p.end && n > 0 {
+			err = nil
+		}
+	}
+	return n, err
+}
+
+func (p *partReader) Close() error {
+	if p.body != nil {
+		return p.body.Close()
+	}
+	return nil
+}
+
+// Parse a comma-separated list of part sizes.
+func parsePartSizes(s string) ([]int64, error) {
+	var sizes []int64
+	for _, f := range strings.Split(s, ",") {
+		n, err := parseOffset(strings.TrimSpace(f))
+		if err != nil || n == 0 {
+			return nil, fmt.Errorf("bad --s3fs-part-size-sweep size %q", f)
+		}
+		sizes = append(sizes, n)
+	}
+	return sizes, nil
+}
+
+// Print how many GETs for `filename` asked for each size of range.
+func reportRequestSizes(requests []*recordedRequest, filename string, filesize int64) {
+	counts := map[string]int{}
+	var keys []string
+	for _, req := range requests {
+		if req.Method != http.MethodGet || !isObjectPath(req.Path, filename) {
+			continue
+		}
+		key := "other"
+		if req.Range == "" {
+			key = "no Range"
+		} else if strings.HasSuffix(req.Range, "-") {
+			key = "open-ended"
+		} else if ranges, ok := parseRangeHeader(req.Range, filesize); ok && len(ranges) == 1 {
+			key = fmt.Sprintf("%d bytes", ranges[0].end-ranges[0].start)
+		}
+		if counts[key] == 0 {
+			keys = append(keys, key)
+		}
+		counts[key]++
+	}
+	if len(keys) == 0 {
+		return
+	}
+	slices.SortFunc(keys, func(a, b string) int { return counts[b] - counts[a] })
+	var parts []string
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("%d × %s", counts[k], k))
+	}
+	fmt.Printf("Upstream GET ranges: %s\n", strings.Join(parts, ", "))
+}
+
+// partSweepStep is one row of the part size sweep.
+type partSweepStep struct {
+	part     int64
+	reads    int
+	errors   int
+	bytes    int64
+	duration time.Duration
+	latency  latencyStats
+	amp      *amplificationReport
+}
+
+// Run `sched` once with each of `sizes` as the part size, and print a
+// comparison.
+func runPartSweep(b *benchmark, sched *schedule, sizes []int64) error {
+	var steps []partSweepStep
+	for _, part := range sizes {
+		fmt.Printf("Part size sweep: %d byte parts\n", part)
+		before := len(upstream.Requests())
+		lb := &benchmark{
+			ctx:      b.ctx,
+			client:   b.client,
+			backend:  &partBackend{client: b.client, bucket: *bucket, part: part},
+			filename: b.filename,
+			filesize: b.filesize,
+			result:   &Result{},
+		}
+		start := time.Now()
+		if _, err := lb.runSchedule(sched); err != nil {
+			return err
+		}
+		step := partSweepStep{part: part, duration: time.Since(start), bytes: lb.totalBytes, latency: computeLatencyStats(lb.latencies)}
+		for _, s := range lb.result.Samples {
+			step.reads++
+			if s.Err != "" {
+				step.errors++
+			}
+		}
+		requests := upstream.Requests()[before:]
+		step.amp = analyzeAmplification(requests, b.filename, b.filesize, lb.asked)
+		reportRequestSizes(requests, b.filename, b.filesize)
+		steps = append(steps, step)
+		if lb.isStopped() {
+			break
+		}
+	}
+
+	fmt.Printf("%12s %8s %7s %8s %14s %8s %12s %10s %10s\n", "part size", "reads", "errors", "GETs", "requested", "amp", units.rateUnit(), "p50", "p90")
+	for _, s := range steps {
+		fmt.Printf("%12d %8d %7d %8d %14d %7.2fx %12.3f %10.3f %10.3f\n", s.part, s.reads, s.errors, s.amp.Requests, s.amp.RequestedBytes, s.amp.Ratio(),
+			units.rateValue(s.bytes, s.duration), s.latency.P50.Seconds(), s.latency.P90.Seconds())
+	}
+	return nil
+}
@@ -47,6 +47,65 @@ package main
 // You can vary the read size with --readsize, the default is 256 kB.
 // To use a 1 MB read size, use `--readsize 1048576`, etc.
 //
+// By default this reads through s3fs, exactly like Caddy.  To see
+// whether the extra traffic depends on the client stack, use
+// --mode=getobject (one ranged GetObject per read), --mode=http (a
+// plain ranged GET, for anonymous buckets), or --mode=presigned.
+// --mode=fullobject reads the whole file with a single un-ranged
+// GetObject, and --compare-coverage runs that and the ranged mode back
+// to back.
+//
+// SeaweedFS caches chunks, so repeated runs against the same file
+// get faster.  Use --cold-cache to benchmark a fresh server-side copy
+// of the file instead (this needs write access to the bucket).
+//
+// Use --json FILE to save the full results at the end of the run, or
+// --jsonl FILE to append one line per read as it happens.  `./s3test
+// analyze FILE` recomputes the summary from a --jsonl file, even if
+// the run was killed partway through.  Output filenames can include
+// variables like {date} and {runid}, and --output-dir puts them all in
+// one place.
+//
+// Every flag can also be set with an S3TEST_ environment variable,
+// like S3TEST_ENDPOINT; --dump-config shows what's in effect.
+//
+// `./s3test conformance FILE` checks the gateway's Range handling
+// against RFC 7233, rather than its speed.
+//
+// --control-socket lets `s3test ctl SOCKET pause` (or resume, status,
+// or stop) control a long run from another terminal.
+//
+// --target runs exactly the same reads against several servers (say,
+// SeaweedFS and MinIO with the same content) and compares them.
+//
+// --mode=filer-grpc (in builds with `-tags filergrpc`) skips the S3
+// gateway and the filer entirely, and reads chunks straight from the
+// volume servers, the way weed mount does.
+//
+// --concurrency N runs N reads at once, and --tui shows what each of
+// them is doing.  With --pattern=same-range, every worker reads the
+// same --offset/--length repeatedly, and then the run is repeated
+// with disjoint ranges, to see whether identical requests get cached
+// or collapsed on the server side.  --pattern=open-storm only opens
+// and closes the object, to see whether Opens alone load the filer.
+//
+// --concurrency-sweep runs the sequential pattern at several
+// concurrency levels, to find where latency starts climbing.
+//
+// --target-p90 instead adjusts the concurrency as it goes, to find
+// the most a node can take while keeping p90 latency under a target.
+//
+// --bisect looks for the offset where reads go from fast to slow (or
+// slow to fast), for files that are only slow in places.
+//
+// --diagnose runs a standard battery of short checks against the file
+// and prints one combined report, for triage by whoever is on call.
+//
+// --dry-run prints the reads that a run would make without making
+// them, and --plan FILE saves them so that `--replay FILE` can run
+// exactly the same schedule somewhere else.  --replay-har FILE
+// replays what a browser actually did, from its HAR capture.
+//
 // Ideally, watch the network load on volume server(s) and filer(s)
 // while running this.  Alternately, watch the S3 latency or the
 // number of filer threads; they should both skyrocket up *and remain
@@ -102,126 +161,1561 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"os"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/jszwec/s3fs/v2"
+	"github.com/scottlaird/s3test/schema"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
 	endpoint = flag.String("endpoint", "http://s3.internal.sigkill.org:8333", "endpoint for talking to SeaweedFS's S3 interface")
 	bucket   = flag.String("bucket", "webvideo", "s3 bucket to read from")
 	region   = flag.String("region", "none", "s3 region to read from")
-	readsize = flag.Int("readsize", 1<<18, "number of bytes to read per file open")
+	readsize = flag.Int64("readsize", 1<<18, "number of bytes to read per file open")
+
+	pattern        = flag.String("pattern", "sequential", "read pattern: sequential, same-range (every worker reads --offset/--length repeatedly), open-storm (every worker opens and closes the object at --offset repeatedly, without reading), or zip-member (find a member of a zip archive from its central directory, and read it)")
+	zipMemberName  = flag.String("member", "", "with --pattern=zip-member, the member to read; defaults to a random file")
+	concurrency    = flag.Int("concurrency", 1, "number of reads to run at once")
+	rangeOffset    = flag.Int64("offset", 0, "offset to read from with --pattern=same-range, or to open at with open-storm")
+	rangeLength    = flag.Int64("length", 0, "bytes to read with --pattern=same-range, or to ask for with open-storm; defaults to --readsize")
+	iterations     = flag.Int("iterations", 10, "reads per worker with --pattern=same-range, or Opens with open-storm")
+	readInterval   = flag.Duration("read-interval", 0, "if > 0, each worker starts at most one read per interval, instead of reading back to back")
+	jitter         = flag.Float64("jitter", 0, "with --read-interval, randomly stretch or shrink each worker's gaps by up to this fraction of the interval, and stagger their start times")
+	jitterSeed     = flag.Uint64("jitter-seed", 0, "seed for --jitter, to repeat a run exactly; 0 picks one at random")
+	sampleCount    = flag.Int("sample", 0, "if > 0, read only this many blocks of --readsize bytes spread across the file, for files too big to read in full")
+	sampleStrategy = flag.String("sample-strategy", "uniform", "with --sample, how to pick the blocks: uniform, stratified (random within each tenth of the file), or random")
+	sampleSeed     = flag.Uint64("sample-seed", 0, "seed for --sample-strategy=stratified or random, to repeat a run exactly; 0 picks one at random")
+
+	concurrencySweep = flag.String("concurrency-sweep", "", "comma-separated concurrency levels to run in turn, e.g. 1,2,4,8,16,32")
+	sweepBytes       = flag.Int64("sweep-bytes", 0, "with --concurrency-sweep, bytes to read at each level (0 for no limit)")
+	sweepDuration    = flag.Duration("sweep-duration", 10*time.Second, "with --concurrency-sweep, how long to run each level (0 for no limit)")
+	sweepCooldown    = flag.Duration("sweep-cooldown", 5*time.Second, "with --concurrency-sweep, how long to pause between levels")
+	sweepSLO         = flag.Duration("sweep-slo", time.Second, "with --concurrency-sweep, report the highest level whose p90 latency is under this")
+	targetP90        = flag.Duration("target-p90", 0, "adjust concurrency to find the most that keeps p90 latency under this")
+	adaptiveWindow   = flag.Duration("adaptive-window", 5*time.Second, "with --target-p90, how often to measure p90 and adjust concurrency")
+	adaptiveDuration = flag.Duration("adaptive-duration", time.Minute, "with --target-p90, how long to run")
+	adaptiveMax      = flag.Int("adaptive-max", 256, "with --target-p90, the most workers to use")
+	sweepCSV         = flag.String("sweep-csv", "", "with --concurrency-sweep, also write the results to this CSV file")
+
+	dryRun           = flag.Bool("dry-run", false, "print the read schedule and exit without reading anything")
+	dryRunLimit      = flag.Int("dry-run-limit", 20, "with --dry-run, print only this many reads (0 for all of them)")
+	planOut          = flag.String("plan", "", "write the read schedule to this file as JSON, for use with --replay")
+	replay           = flag.String("replay", "", "read the schedule from this --plan file instead of computing it from the flags, or from a .jsonl recording made with the fsrecord package or --jsonl")
+	replayResultFile = flag.String("replay-result", "", "re-run exactly the reads of the run that wrote this --json result, if the object hasn't changed since")
+	replayHAR        = flag.String("replay-har", "", "replay the Range requests of the GETs in this HAR file, such as a browser's capture of a video player, against the file, with the same timing")
+	harURL           = flag.String("har-url", "", "with --replay-har, a regular expression for the URLs to replay (default: URLs ending in the file's key)")
+	replaySpeed      = flag.Float64("replay-speed", 1, "with --replay-har, or a --replay schedule made from one, run this many times faster than the recording")
+
+	seekProbe        = flag.Bool("seek-probe", false, "open the file via s3fs, Seek around without reading, and report which steps sent HTTP requests")
+	probeKeyEncoding = flag.Bool("probe-key-encoding", false, "upload small objects under s3test-keys/ with spaces, '+', '#', non-ASCII, and other awkward characters in their keys, read each back with every S3 mode, and print a PASS/FAIL table")
+	bisect           = flag.Bool("bisect", false, "find the offset where reads switch between fast and slow")
+	bisectProbes     = flag.Int("probes", 3, "with --bisect, how many times to read at each offset")
+	slowThreshold    = flag.Duration("slow-threshold", 0, "with --bisect, reads slower than this are slow; if 0, use --slow-factor")
+	slowFactor       = flag.Float64("slow-factor", 2, "with --bisect, reads more than this many times slower than the fastest probe are slow")
+	chunkSize        = flag.Int64("chunk-size", 4<<20, "SeaweedFS chunk size, for reporting chunk-aligned offsets, and for --by-chunk without --filer-url")
+	byChunk          = flag.Bool("by-chunk", false, "group read latency by the SeaweedFS chunk each read falls in, from --filer-url's chunk list or every --chunk-size bytes, and flag the slow chunks")
+
+	stateFileName = flag.String("state-file", "", "remember the file's size, ETag, and schedule in this JSON file, and reuse them while the ETag matches")
+	refreshState  = flag.Bool("refresh-state", false, "with --state-file, ignore any saved state and regenerate it")
+	resume        = flag.Bool("resume", false, "with --state-file, carry on with an interrupted sequential run from where it stopped, and include its earlier samples in the results")
+
+	maxMemory    = flag.Int64("max-memory", 0, "if > 0, limit the total size of read buffers to this many bytes")
+	maxRPS       = flag.Float64("max-rps", 0, "if > 0, send at most this many HTTP requests per second across all workers, retries included")
+	burst        = flag.Int("burst", 1, "with --max-rps, how many requests can go out at once after an idle spell")
+	maxInflight  = flag.Int64("max-inflight-bytes", 0, "if > 0, hold reads back until the bytes requested but not yet drained, across all workers, fit under this limit")
+	memoryPolicy = flag.String("memory-policy", "refuse", "what to do when the reads won't fit in --max-memory: refuse, reduce-concurrency, or stream")
+	drainFlag    = flag.String("drain", "readfull", "how to empty each response: readfull into a buffer per read, copy into a reused buffer, or discard without keeping the data")
+
+	pathStyle       = flag.Bool("path-style", true, "use path-style addressing (http://host/bucket/key); false for virtual-hosted-style (http://bucket.host/key)")
+	unsignedPayload = flag.Bool("unsigned-payload", false, "send UNSIGNED-PAYLOAD instead of signing request bodies")
+	signingRegion   = flag.String("signing-region", "", "region to sign requests for, if different from --region")
+
+	coalesce          = flag.Int("coalesce", -1, "if >= 0, merge reads that are within this many bytes of each other into a single upstream request")
+	coalesceMax       = flag.Int("coalesce-max", 1<<24, "maximum size of a single coalesced upstream request")
+	mode              = flag.String("mode", "s3fs", "how to read from S3: s3fs, getobject, getobject-v1 (aws-sdk-go v1, with -tags sdkv1), http, presigned, or fullobject; filer-grpc to read chunks from the volume servers directly; or localfs to read a local file")
+	directIO          = flag.Bool("direct-io", false, "with --mode=localfs, open the file with O_DIRECT (Linux only)")
+	compareCov        = flag.Bool("compare-coverage", false, "read the whole file with --mode=fullobject, then again with --mode, and compare throughput and wire bytes")
+	conditional       = flag.Bool("conditional", false, "send the object's ETag with every read (If-Match or If-Range) and report how the server handled it")
+	mutateDuring      = flag.Bool("mutate-during-run", false, "with --conditional and --cold-cache, overwrite the object halfway through the run")
+	slowReadThreshold = flag.Duration("slow-read-threshold", 0, "if > 0, print a detailed record of every read that takes longer than this: phases, attempts, Range headers, connections, and response headers")
+	maxSlowDumps      = flag.Int("max-slow-dumps", 10, "with --slow-read-threshold, print at most this many detailed records")
+	pprofListen       = flag.String("pprof-listen", "", "serve net/http/pprof on this address, like localhost:6060, while running")
+	profileSlowReads  = flag.Bool("profile-slow-reads", false, "once a read has taken longer than --slow-read-threshold, take a CPU profile until it finishes and a heap profile, in --output-dir")
+	profileDuration   = flag.Duration("profile-duration", 30*time.Second, "with --profile-slow-reads, the longest to run each CPU profile")
+	maxProfiles       = flag.Int("max-profiles", 3, "with --profile-slow-reads, take at most this many profiles")
+	noPreflight       = flag.Bool("no-preflight", false, "skip the HeadBucket and one-byte GET that check the endpoint, bucket, and file before the run, for servers where HeadBucket is broken")
+	allowArchive      = flag.Bool("allow-archive", false, "run even if the object is in an archive storage class or on a SeaweedFS remote tier, instead of refusing to")
+	noStat            = flag.Bool("no-stat", false, "don't HEAD or stat the object before reading; trust --filesize and skip fetching the ETag, so the only requests are the reads themselves")
+	filesizeFlag      = flag.Int64("filesize", -1, "if >= 0, skip the size lookup entirely and assume the file is this many bytes")
+	sizeFrom          = flag.String("size-from", "stat", "how to learn the file's size: stat (via s3fs), head, or get-range (a 0-0 ranged GET)")
+	backgroundRate    = flag.Float64("background-metadata", 0, "if > 0, issue this many HeadObject/ListObjectsV2 calls per second in the background while reading")
+	healthObject      = flag.String("healthcheck-object", "", "while reading, GET the first byte of this small control object every --healthcheck-interval, on separate connections, to see when the gateway itself slows down")
+	healthInterval    = flag.Duration("healthcheck-interval", 2*time.Second, "with --healthcheck-object, how often to probe")
+	jsonOut           = flag.String("json", "", "write the results to this file as JSON at the end of the run; see --output-dir for {variables}")
+	baselineFile      = flag.String("baseline", "", "compare the results with this earlier --json file")
+	jsonlOut          = flag.String("jsonl", "", "append one JSON line per read to this file as the run progresses")
+	outputDir         = flag.String("output-dir", "", "directory for --json, --jsonl, --plan, and --sweep-csv files.  Their names may use {date}, {time}, {host}, {bucket}, {file}, {readsize}, {pattern}, {mode}, and {runid}")
+	jsonlSyncInterval = flag.Duration("jsonl-sync-interval", 5*time.Second, "fsync the --jsonl file at least this often")
+	rotateSize        = flag.Int64("rotate-size", 0, "once the --jsonl file reaches this many bytes, rename it with a sequence number and start a new one; 0 for no limit")
+	rotateInterval    = flag.Duration("rotate-interval", 0, "likewise, once the --jsonl file is this old")
+	maxRotated        = flag.Int("max-rotated-files", 0, "with --rotate-size or --rotate-interval, delete the oldest rotated --jsonl files past this many; 0 to keep them all")
+	jsonlSyncSamples  = flag.Int("jsonl-sync-samples", 100, "fsync the --jsonl file at least every this many samples")
+	failAmplification = flag.Float64("fail-on-amplification", 0, "if > 0, exit with status 3 when upstream requests cover more than this many times the bytes we asked for")
+	otlpEndpoint      = flag.String("otlp-endpoint", "", "if set, export OpenTelemetry traces to this OTLP/HTTP endpoint, e.g. http://collector:4318")
+	correlationHeader = flag.String("correlation-header", "X-S3test-Read-Id", "header used to send each read's ID to the server, for matching up with server logs")
+	noCorrelation     = flag.Bool("no-correlation-header", false, "don't send the read ID header at all")
+	sseCKey           = flag.String("sse-c-key", "", "base64 AES-256 key for objects encrypted with SSE-C (prefer --sse-c-key-file, since this shows up in ps)")
+	sseCKeyFile       = flag.String("sse-c-key-file", "", "file holding the SSE-C key, either base64 or the raw 32 bytes")
+	maxRetryAfter     = flag.Duration("max-retry-after", 30*time.Second, "never wait longer than this for a Retry-After")
+	httpVersion       = flag.String("http-version", "", "force HTTP 1.1 or 2 for every request, instead of whatever the server negotiates; 2 over http:// means h2c")
+	ignoreRetryAfter  = flag.Bool("ignore-retry-after", false, "retry without waiting for Retry-After, to compare against a well-behaved client")
+	s3fsPartSize      = flag.Int64("s3fs-part-size", 0, "with --mode=s3fs, if > 0, read through aligned GETs of this many bytes instead of s3fs's open-ended ones, to see how a part size interacts with --readsize")
+	s3fsPartSweep     = flag.String("s3fs-part-size-sweep", "", "with --mode=s3fs, comma-separated part sizes to run the same schedule with in turn, e.g. 65536,1048576,8388608")
+	noSeek            = flag.Bool("no-seek", false, "with --mode=s3fs or localfs, don't Seek(); read from the start of the file and discard everything before each read's offset, to benchmark backends that can't seek")
+	validateMP4       = flag.Bool("validate-mp4", false, "index the file's top-level MP4 boxes before the run, and check that every read's data has the right box headers in the right places")
+	checkPosition     = flag.Bool("check-position", false, "with --mode=s3fs or localfs, check the handle's position (and the data, if we know what it should be) after every Read")
+	reuseHandle       = flag.Bool("reuse-handle", false, "with --mode=s3fs or localfs, keep files open and seek them from read to read, from a pool of handles; see handlepool.go")
+	handlePoolSize    = flag.Int("handle-pool", 0, "with --reuse-handle, how many handles to keep per file; 0 for one per worker")
+	unitsName         = flag.String("units", "bits", "show rates in bits or bytes per second")
+	siUnits           = flag.Bool("si", false, "use powers of 1000 (MB, Mbps) for sizes and rates; this is the default")
+	iecUnits          = flag.Bool("iec", false, "use powers of 1024 (MiB, Mibps) for sizes and rates")
+	tenantDuration    = flag.Duration("tenant-duration", time.Minute, "with --tenant, how long to run the tenants for, including any start= delays")
+	tenantPrefix      = flag.String("tenant-prefix", "s3test-tenants/", "with --tenant, where to upload random tenants' generated objects")
+	interference      = flag.Bool("interference", false, "run small random reads for three --interference-window phases, with large sequential reads alongside them in the middle one, and compare the small reads' latency in each phase")
+	interferenceWin   = flag.Duration("interference-window", 20*time.Second, "with --interference, how long each phase lasts")
+	interferenceLarge = flag.Int64("interference-large", 4<<20, "with --interference, the size of the large sequential reads")
+	interferenceSmall = flag.Int64("interference-small", 64<<10, "with --interference, the size of the small random reads")
+	interferenceView  = flag.Int("interference-viewers", 4, "with --interference, how many workers do large sequential reads")
+	interferenceRate  = flag.Float64("interference-rate", 20, "with --interference, how many small reads to start per second")
+	statConsistency   = flag.Bool("stat-consistency", false, "instead of reading the file, Stat and HEAD it repeatedly for --stat-duration, and report every different size, ETag, or mtime that comes back")
+	statRate          = flag.Float64("stat-rate", 100, "with --stat-consistency, how many metadata calls to make per second")
+	statWorkers       = flag.Int("stat-concurrency", 8, "with --stat-consistency, how many metadata calls can be in flight at once")
+	statDuration      = flag.Duration("stat-duration", 30*time.Second, "with --stat-consistency, how long to keep calling")
+	parallelTargets   = flag.Bool("parallel-targets", false, "with --target, run every target at once instead of one after another")
+	targetHash        = flag.Bool("target-hash", false, "with --target, check that the first --readsize bytes are the same on every target")
+	verifyChecksums   = flag.Bool("verify-checksums", false, "with --mode=getobject, fullobject, or http, ask for x-amz-checksum-* headers and check them against the data")
+	topologyURL       = flag.String("topology-url", "", "SeaweedFS master or filer status URL (like http://master:9333/dir/status) to save in the results at the start and end of the run")
+	diagnose          = flag.Bool("diagnose", false, "run the standard diagnostic battery (stat, tail-first, 256 kB and 16 MB passes, same-range, and a GetObject amplification comparison) and print one combined report; see diagnose.go")
+	diagnoseBudget    = flag.Duration("diagnose-budget", 3*time.Minute, "with --diagnose, the total time for all six steps; each step gets a sixth of it")
+	socks5            = flag.String("socks5", "", "connect through this SOCKS5 proxy, given as [user:password@]host:port; the proxy resolves hostnames")
+	maxRedirects      = flag.Int("max-redirects", 10, "how many HTTP redirects one request may follow; past that, the redirect response goes back to the client as is; 0 to never follow them")
+	linkSpeed         = flag.Float64("link-speed", 0, "the client's network link speed in Mbps, to give throughput as a percentage of it; by default, it's read from /sys/class/net on Linux")
+	profilesFile      = flag.String("profiles", defaultProfilesFile(), "file naming the production endpoints and the limits on runs against them; see production.go")
+	knowProduction    = flag.Bool("i-know-this-is-production", false, "allow a run against an endpoint that the --profiles file says is production")
+	unsafeLimits      = flag.Bool("unsafe-limits", false, "with --i-know-this-is-production, lift the limits on concurrency, duration, and bytes read (endpoints that match no \"production\" line are never limited)")
+	netBaseline       = flag.Bool("net-baseline", false, "after the run, time one unranged GET of the object as a practical ceiling, and give throughput as a percentage of it")
+	noFindings        = flag.Bool("no-findings", false, "don't print the plain-English findings at the end of the run, or save them in the JSON results")
+	fetchLogs         = flag.Int("fetch-server-logs", 0, "after the run, fetch the server's log lines for this many of the slowest reads from --server-log-url or --server-log-command, and save them in the JSON results")
+	serverLogURL      = flag.String("server-log-url", "", "with --fetch-server-logs, a URL that returns log lines, with {start}, {end}, {startunix}, {endunix}, and {readid} filled in")
+	serverLogCommand  = flag.String("server-log-command", "", "with --fetch-server-logs, a shell command (like ssh ... journalctl) that prints log lines, with the same variables as --server-log-url")
+	serverLogSlack    = flag.Duration("server-log-slack", 2*time.Second, "with --fetch-server-logs, widen each read's window by this much on either side, for clock skew")
+	filerURL          = flag.String("filer-url", "", "SeaweedFS filer URL, to save the file's chunk list in the results")
+	filerBucketDir    = flag.String("filer-bucket-dir", "/buckets", "where the filer keeps S3 buckets")
+	filerGRPCAddr     = flag.String("filer-grpc-addr", "", "with --mode=filer-grpc, the filer's gRPC address (usually its HTTP port plus 10000, like filer:18888)")
+	passes            = flag.Int("passes", 1, "run the schedule this many times, back to back")
+	reportPassDelta   = flag.Bool("report-pass-delta", false, "with --passes, compare each offset's latency in the first and last passes")
+	onChange          = flag.String("on-change", "abort", "if the object changes size or ETag during the run, abort or clamp the remaining reads to the new size")
+	streamFIFO        = flag.String("stream-fifo", "", "also write --jsonl records to this named pipe (created if needed) for live dashboards; records are dropped rather than slowing the run if nobody's reading")
+	scheduleFlag      = flag.String("schedule", "", "stay resident and run the benchmark at each time matching this cron expression, like \"0 3 * * *\"; see recurring.go")
+	controlSocket     = flag.String("control-socket", "", "listen on this Unix socket for pause, resume, status, and stop commands from \"s3test ctl\"")
+	dumpConfigFlag    = flag.Bool("dump-config", false, "print every flag's value and whether it came from the command line, the environment, or the default, then exit")
+	cancelAfter       = flag.String("cancel-after", "", "with --mode=getobject, http, or presigned, close each response body after this many bytes (or this percentage, like 25%) and move on to the next read")
+	tuiFlag           = flag.Bool("tui", false, "show a live full-screen view of what each worker is doing, if stdout is a terminal")
+	pushgatewayURL    = flag.String("pushgateway-url", "", "at the end of the run, push the summary to the Prometheus Pushgateway at this URL")
+	pushHistogram     = flag.Bool("push-per-read-histogram", false, "with --pushgateway-url, also push a histogram of the read latencies")
+	requirePush       = flag.Bool("require-push", false, "exit with an error if the push to --pushgateway-url fails")
+	onFailureExec     = flag.String("on-failure-exec", "", "if the run fails --fail-on-amplification, or finds amplification or corrupt data, run this command with sh -c and the JSON summary on stdin")
+	webhookURL        = flag.String("webhook-url", "", "if the run fails --fail-on-amplification, or finds amplification or corrupt data, POST the JSON summary here")
+	hookTimeout       = flag.Duration("hook-timeout", 30*time.Second, "how long --on-failure-exec and --webhook-url can take")
+	alertTemplate     = flag.String("alert-template", defaultAlertTemplate, "Go template for the short message sent by --on-failure-exec and --webhook-url; it gets the results' fields and .Reasons")
+	maxZeroReads      = flag.Int("max-zero-reads", 100, "abort a read as stalled after this many Read() calls in a row return no data and no error; 0 to never give up")
+	stallTimeout      = flag.Duration("stall-timeout", 0, "abort a read as stalled if no data arrives for this long; 0 to wait forever")
+	continueOnStall   = flag.Bool("continue-on-stall", false, "record stalled reads and keep going, instead of stopping the run")
+	abortOnFullBody   = flag.Bool("abort-on-full-body", false, "stop the run, with exit status 5, as soon as a ranged GET comes back with more than the range, like a 200 with the whole object")
+	coldCache         = flag.Bool("cold-cache", false, "benchmark a fresh server-side copy of the file so that no reads hit SeaweedFS's caches")
+	exactPercentiles  = flag.Bool("exact-percentiles", false, "sort every latency for exact percentiles, even past the million or so where they're estimated to within 1%")
 )
 
-// Set up the Go s3fs client, as used by Caddy.
-func connect(ctx context.Context) (*s3fs.S3FS, error) {
-	config, err := config.LoadDefaultConfig(
-		ctx,
-		config.WithRegion(*region),
-	)
-	if err != nil {
-		return nil, err
+// Servers to compare, from --target.
+var targets targetList
+var tenants tenantList
+var explicitRanges rangeList
+var resolves resolveList
+
+func init() {
+	flag.Var(&tenants, "tenant", "`name=stream|random[,key=value...]` workload to run alongside the other tenants; repeat to simulate several apps sharing the cluster, see tenants.go")
+	flag.Var(&explicitRanges, "range", "`offset:length` or start-end (end exclusive) to read instead of planning a schedule; repeat for several reads, which run in order, and use --passes to repeat them")
+	flag.Var(&targets, "target", "`name=endpoint,bucket[,region][,key=value...]` to run the same reads against, with optional creds=, path-style=, insecure=, and ca= settings; repeat to compare several servers")
+	flag.Var(&resolves, "resolve", "`host:port:addr` to connect to addr instead of whatever host resolves to, keeping the Host header and TLS SNI, like curl's --resolve; repeat for several hosts")
+}
+
+// Set up the Go s3fs client, as used by Caddy.  The underlying S3
+// client is returned as well, for the operations that s3fs doesn't
+// expose.
+func connect(ctx context.Context) (*s3fs.S3FS, *s3.Client, error) {
+	return connectTo(ctx, defaultTarget())
+}
+
+// Like connect, but for any target.
+func connectTo(ctx context.Context, t target) (*s3fs.S3FS, *s3.Client, error) {
+	var cfg aws.Config
+	if t.config != nil {
+		cfg = *t.config
+	} else {
+		var err error
+		if cfg, err = config.LoadDefaultConfig(ctx, config.WithRegion(t.Region)); err != nil {
+			return nil, nil, err
+		}
 	}
 
-	client := s3.NewFromConfig(config, func(o *s3.Options) {
-		o.BaseEndpoint = aws.String(*endpoint)
-		o.UsePathStyle = true
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.BaseEndpoint = aws.String(t.Endpoint)
+		o.HTTPClient = t.httpClient()
+		o.UsePathStyle = t.pathStyle()
 		o.DisableLogOutputChecksumValidationSkipped = true
+		o.APIOptions = append(o.APIOptions, recordAttempts)
+		o.Retryer = &backpressureRetryer{RetryerV2: retry.NewStandard()}
+		if *unsignedPayload {
+			o.APIOptions = append(o.APIOptions, v4.SwapComputePayloadSHA256ForUnsignedPayloadMiddleware)
+		}
+		if *signingRegion != "" {
+			o.Region = *signingRegion
+		}
+		if tracingEnabled {
+			o.APIOptions = append(o.APIOptions, propagateTrace)
+		}
+		if sseC != nil {
+			o.APIOptions = append(o.APIOptions, addSSECustomerKey)
+		}
+		if !*noCorrelation {
+			o.APIOptions = append(o.APIOptions, addReadID)
+		}
+		if *verifyChecksums {
+			o.APIOptions = append(o.APIOptions, addChecksumMode)
+		}
 	})
-	fs := s3fs.New(client, *bucket, s3fs.WithReadSeeker)
+	fs := s3fs.New(client, t.Bucket, s3fs.WithReadSeeker)
 
-	return fs, nil
+	return fs, client, nil
 }
 
-// Read `size` bytes at `offset` from `filename` via `fs`.
-func readFrom(fs *s3fs.S3FS, filename string, offset uint64, size uint64, totalsize uint64) error {
-	start := time.Now()
+// Sample records what happened during one call to readFrom().
+type Sample struct {
+	Offset   int64         `json:"offset"`
+	Size     int64         `json:"size"`
+	Bytes    int64         `json:"bytes"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"durationNs"`
+	Err      string        `json:"error,omitempty"`
+	ErrPhase string        `json:"errorPhase,omitempty"` // open, seek, or read; see readerror.go
 
-	f, err := fs.Open(filename)
-	defer f.Close()
-	if err != nil {
-		return err
+	// The file read, in recordings from the fsrecord package;
+	// otherwise it's the run's file.
+	File string `json:"file,omitempty"`
+
+	// Responses that asked us to back off with Retry-After, and the
+	// time we spent waiting because of them.
+	Backpressure     int           `json:"backpressure,omitempty"`
+	BackpressureWait time.Duration `json:"backpressureWaitNs,omitempty"`
+
+	// Things that looked wrong about the response, even though the
+	// read worked.
+	Warnings []string `json:"warnings,omitempty"`
+
+	// Sent to the server with every request for this read; see
+	// --correlation-header.
+	ReadID string `json:"readId"`
+
+	// Which worker made this read, and which part of the run
+	// it belongs to, for patterns that have more than one.
+	Worker int    `json:"worker"`
+	Label  string `json:"label,omitempty"`
+
+	// The IDs of the connections this read's requests went out
+	// on; see connuse.go.
+	Connections []int `json:"connections,omitempty"`
+
+	// Which pass of the schedule this was, with --passes.
+	Pass int `json:"pass,omitempty"`
+
+	// Set if the server sent more than the range we asked for;
+	// see fullbody.go.
+	FullBody bool `json:"fullBody,omitempty"`
+
+	// Bytes the server sent past the end of the range; see
+	// overdelivery.go.
+	Overdelivered int64 `json:"overdelivered,omitempty"`
+
+	// Bytes read from the start of the file and thrown away to
+	// get to the offset, with --no-seek.
+	Discarded int64 `json:"discarded,omitempty"`
+
+	// Set if the read was aborted because no data was arriving,
+	// and the longest this read waited for data; see stall.go.
+	Stalled     bool          `json:"stalled,omitempty"`
+	LongestWait time.Duration `json:"longestWaitNs,omitempty"`
+
+	// How long the read waited for --max-inflight-bytes before
+	// starting.  This isn't included in Duration.
+	GateWait time.Duration `json:"gateWaitNs,omitempty"`
+
+	// How long the read waited for a --max-rps token before
+	// starting.  This isn't included in Duration either.
+	RateLimitWait time.Duration `json:"rateLimitWaitNs,omitempty"`
+
+	// Set if the read stopped early because of --cancel-after.
+	Cancelled bool `json:"cancelled,omitempty"`
+
+	// What --verify-checksums made of the response's checksums.
+	Checksum string `json:"checksum,omitempty"`
+
+	// The first box header the data didn't match, with
+	// --validate-mp4; see mp4.go.
+	MP4Error string `json:"mp4Error,omitempty"`
+
+	// HTTP status of the response carrying the data, if the
+	// backend can see it.
+	Status int `json:"status,omitempty"`
+
+	// The object's total size, as reported by the server in
+	// this response, or 0 if we couldn't tell.
+	ObjectSize int64 `json:"objectSize,omitempty"`
+
+	// How long the response carrying the data took to send its
+	// headers, and then its body; see responsetiming.go.
+	HeaderLatency time.Duration `json:"headerLatencyNs,omitempty"`
+	BodyLatency   time.Duration `json:"bodyLatencyNs,omitempty"`
+
+	// How many HEAD and GET requests this read made; see
+	// preflight.go.
+	Heads int `json:"heads,omitempty"`
+	Gets  int `json:"gets,omitempty"`
+
+	// The HTTP protocol of the response carrying the data; see
+	// httpversion.go.
+	Protocol string `json:"protocol,omitempty"`
+
+	// The server's log lines for this read, with
+	// --fetch-server-logs.
+	ServerLogs []string `json:"serverLogs,omitempty"`
+
+	// Redirects that this read's requests got; see redirect.go.
+	Redirects []redirectHop `json:"redirects,omitempty"`
+
+	// CPU and heap profiles taken while this read was slow, with
+	// --profile-slow-reads; see pprof.go.
+	Profiles []string `json:"profiles,omitempty"`
+
+	// How long each phase of the read took, in order.
+	Phases []Phase `json:"phases,omitempty"`
+
+	// The SDK operations issued while reading, with each of
+	// their attempts.
+	Ops []Operation `json:"ops,omitempty"`
+}
+
+// Phase is one timed step of a read, like "open" or "drain".
+type Phase struct {
+	Name     string        `json:"name"`
+	Duration time.Duration `json:"durationNs"`
+}
+
+func (s *Sample) addPhase(name string, d time.Duration) {
+	s.Phases = append(s.Phases, Phase{Name: name, Duration: d})
+}
+
+// Add the SDK's Retry-After responses and waits to the sample.
+func (s *Sample) noteBackpressure() {
+	for _, op := range s.Ops {
+		for _, a := range op.Attempts {
+			if a.RetryAfter > 0 {
+				s.Backpressure++
+			}
+		}
 	}
+	s.BackpressureWait += backpressureWait(s.Ops)
+}
 
-	// fs.Open() returns a fs.FS, which is an interface that
-	// doesn't include `Seek`, although the underlying
-	// implementation does support it.  Casting it to
-	// `io.ReadSeeker` is the recommended way to fix this.
-	fSeek := f.(io.ReadSeeker)
+// Record a warning about this sample, and print it.
+func (s *Sample) warn(msg string) {
+	s.Warnings = append(s.Warnings, msg)
+	fmt.Printf("WARNING: read at offset %d: %s\n", s.Offset, msg)
+}
 
-	_, err = fSeek.Seek(int64(offset), 0)
-	if err != nil {
-		return err
+// Number of SDK attempts made for this sample, including retries.
+func (s *Sample) Attempts() int {
+	n := 0
+	for _, op := range s.Ops {
+		n += len(op.Attempts)
 	}
+	return n
+}
 
-	b := make([]byte, size)
+// Read `size` bytes at `offset` from `filename` via `backend`.  The
+// returned sample is non-nil even on error.
+func readFrom(ctx context.Context, backend Backend, filename string, offset int64, size int64, totalsize int64) (*Sample, error) {
+	ctx, rateWait := takeReadToken(ctx)
+	start := time.Now()
 
-	var curOffset uint64
-	var n int
+	sample := &Sample{
+		Offset: offset,
+		Size:   size,
+		Start:  start,
+		ReadID: newReadID(),
 
-	for {
-		n, err = f.Read(b[curOffset:])
-		if err != nil {
-			return err
+		RateLimitWait: rateWait,
+	}
+
+	ctx, span := tracer.Start(ctx, "readFrom", trace.WithAttributes(
+		attribute.Int64("offset", offset),
+		attribute.Int64("size", size),
+		attribute.String("backend", *mode),
+		attribute.String("endpoint", *endpoint),
+		attribute.String("read_id", sample.ReadID)))
+	defer span.End()
+	ctx = withReadID(ctx, sample.ReadID)
+	ctx, conns := collectConnections(ctx)
+	defer func() { sample.Connections = conns.IDs() }()
+
+	// f.Close() is deferred below, so this runs once the body is
+	// finished, and after stall.stop() has filled in LongestWait.
+	ctx, responses := collectResponses(ctx)
+	defer func() {
+		if header, body, ok := responses.timing(); ok {
+			sample.HeaderLatency, sample.BodyLatency = header, body
 		}
-		curOffset += uint64(n)
-		if curOffset >= size {
-			break
+		sample.Heads, sample.Gets = responses.methods()
+		sample.Protocol = responses.protocol()
+		sample.Redirects = redirectHops(responses.Requests())
+		slowReads.check(sample, responses.Requests())
+	}()
+	defer profiler.watch(sample)()
+
+	ctx, fullBodies := collectFullBodies(ctx)
+	defer func() {
+		for _, f := range fullBodies.Responses() {
+			sample.FullBody = true
+			sample.warn(f.String())
 		}
+	}()
+
+	ctx, collector := collectOperations(ctx)
+	f, err := backend.Open(ctx, filename, offset, size, sample)
+	if err != nil {
+		sample.Duration = time.Since(start)
+		sample.Ops = collector.Operations()
+		sample.noteBackpressure()
+		re := wrapReadError(err, "open", filename, offset, size, 0, sample.Attempts())
+		sample.Err, sample.ErrPhase = re.Err.Error(), re.Phase
+		span.RecordError(re)
+		return sample, re
+	}
+	defer f.Close()
+
+	if *pattern == "open-storm" {
+		// Only the Open is being timed; close without reading.
+		sample.Duration = time.Since(start)
+		sample.Ops = collector.Operations()
+		sample.noteBackpressure()
+		fmt.Printf("Opened at offset %d in %.3fs\n", offset, sample.Duration.Seconds())
+		return sample, nil
 	}
 
+	stopAt := cancel.limit(size)
+	stall := newStallWatch(f)
+	defer func() { sample.LongestWait = stall.stop() }()
+
+	drainStart := time.Now()
+	_, endPhase := startPhase(ctx, "drain")
+	body := &drainReader{r: f, offset: offset, limit: size, stall: stall, sample: sample}
+	if stopAt > 0 {
+		// Close the body early, the way a browser does when
+		// someone seeks.
+		body.limit = stopAt
+	}
+	err = drain(body, *drainFlag)
+	curOffset := body.n
+	if err != nil {
+		endPhase()
+		sample.Bytes = curOffset
+		sample.Stalled = errors.Is(err, errStall)
+		sample.Duration = time.Since(start)
+		sample.Ops = collector.Operations()
+		sample.noteBackpressure()
+		re := wrapReadError(err, "read", filename, offset, size, curOffset, sample.Attempts())
+		sample.Err, sample.ErrPhase = re.Err.Error(), re.Phase
+		span.RecordError(re)
+		return sample, re
+	}
+	sample.Cancelled = stopAt > 0
+	endPhase()
+	sample.addPhase("drain", time.Since(drainStart))
+	span.SetAttributes(attribute.Int64("bytes", curOffset))
+
 	dur := time.Since(start)
 
-	fmt.Printf("Read %d bytes at offset %d in %.3fs (%.1f%%)\n", curOffset, offset, dur.Seconds(), float64(100*offset)/float64(totalsize))
+	if checksOverdelivery(*mode) && !sample.Cancelled {
+		if extra := probeOverdelivery(f); extra > 0 {
+			contentLength := int64(-1)
+			if rec := responses.data(); rec != nil {
+				contentLength = rec.contentLength
+			}
+			sample.Overdelivered = extra
+			sample.warn(overdeliveryWarning(extra, size, contentLength))
+		}
+	}
+
+	sample.Bytes = curOffset
+	sample.Duration = dur
+	sample.Ops = collector.Operations()
+	sample.noteBackpressure()
+
+	fmt.Printf("Read %d bytes at offset %d in %.3fs at %s (%.1f%%)\n", curOffset, offset, dur.Seconds(), units.rate(curOffset, dur), percentOf(offset, totalsize))
+	for _, op := range sample.Ops {
+		if len(op.Attempts) > 1 {
+			fmt.Printf("  %s needed %d attempts:", op.Name, len(op.Attempts))
+			for _, a := range op.Attempts {
+				fmt.Printf(" %d in %.3fs", a.StatusCode, a.Duration.Seconds())
+			}
+			fmt.Printf("\n")
+		}
+	}
 
-	return nil
+	return sample, nil
 }
 
+// Exit statuses for a run that went wrong in a particular way, so
+// that scripts can tell them apart from 1, which is everything else.
+// The preflight check's are in bucketcheck.go.
+const (
+	exitAmplification = 3 // over --fail-on-amplification
+	exitStall         = 4 // a read stalled; see stall.go
+	exitFullBody      = 5 // --abort-on-full-body; see fullbody.go
+)
+
 func main() {
+	os.Exit(run())
+}
+
+// Run the benchmark, returning the exit status.  This is separate
+// from main() so that deferred cleanup happens before we exit.
+func run() (status int) {
+	defer func() {
+		if r := recover(); r != nil {
+			status = productionLimitStatus(r)
+		}
+	}()
 	flag.Parse()
+	if err := applyFlagEnv(flag.CommandLine, os.LookupEnv); err != nil {
+		fmt.Printf("%v\n", err)
+		return 1
+	}
+	if *dumpConfigFlag {
+		dumpConfig(flag.CommandLine)
+		return 0
+	}
+
+	if flag.Arg(0) == "analyze" {
+		return runAnalyze(flag.Args()[1:])
+	}
+	if flag.Arg(0) == "ctl" {
+		return runCtl(flag.Args()[1:])
+	}
+	// Everything from here on sends requests.
+	if err := checkProduction(*profilesFile); err != nil {
+		fmt.Printf("%v\n", err)
+		return 1
+	}
+	if flag.Arg(0) == "conformance" {
+		return runConformance(flag.Args()[1:])
+	}
+	if flag.Arg(0) == "repl" {
+		return runREPL(flag.Args()[1:])
+	}
+	if *probeKeyEncoding {
+		ctx := context.Background()
+		_, client, err := connect(ctx)
+		if err != nil {
+			panic(err)
+		}
+		return runKeyEncodingProbe(ctx, client, "s3test-keys/"+newRunID()+"/")
+	}
+
+	var sched *schedule
+	var original *Result
+	replayFrom := *replay
+	if *replay != "" {
+		var err error
+		sched, err = loadReplay(*replay, flag.Arg(0))
+		if err != nil {
+			fmt.Printf("Unable to load --replay schedule: %v\n", err)
+			return 1
+		}
+	}
+	var capture *harCapture
+	if *replayHAR != "" && flag.Arg(0) != "" {
+		match, err := harURLPattern(*harURL, flag.Arg(0))
+		if err != nil {
+			fmt.Printf("Bad --har-url: %v\n", err)
+			return 1
+		}
+		if capture, err = loadHAR(*replayHAR, match); err != nil {
+			fmt.Printf("Unable to load --replay-har: %v\n", err)
+			return 1
+		}
+		if *pattern != "sequential" || *concurrency != capture.Concurrency {
+			fmt.Printf("Replaying %s: pattern sequential and concurrency %d come from the HAR\n", *replayHAR, capture.Concurrency)
+			*pattern = "sequential"
+			*concurrency = capture.Concurrency
+		}
+	}
+	if *replayResultFile != "" {
+		var err error
+		original, err = loadReplayResult(*replayResultFile)
+		if err != nil {
+			fmt.Printf("Unable to load --replay-result: %v\n", err)
+			return 1
+		}
+		sched = original.Schedule
+		replayFrom = *replayResultFile
+		if original.ReadSize > 0 {
+			*readsize = original.ReadSize
+		}
+	}
+	// The flags are checked against what's actually going to run.
+	if sched != nil && (sched.Pattern != *pattern || sched.Concurrency != *concurrency) {
+		fmt.Printf("Replaying %s: pattern %s and concurrency %d come from the schedule\n", replayFrom, sched.Pattern, sched.Concurrency)
+		*pattern = sched.Pattern
+		*concurrency = sched.Concurrency
+	}
 
 	filename := flag.Arg(0)
+	if len(filename) == 0 && sched != nil {
+		filename = sched.File
+	}
 	if len(filename) == 0 {
 		fmt.Printf("Please provide a filename, and optionally --endpoint= and --bucket= args\n")
-		os.Exit(1)
+		return 1
+	}
+
+	if err := parseUnits(*unitsName, *siUnits, *iecUnits); err != nil {
+		fmt.Printf("%v\n", err)
+		return 1
+	}
+	if problems := validateFlags(); len(problems) > 0 {
+		printFlagProblems(problems)
+		return 1
+	}
+	if *pprofListen != "" {
+		stop, err := servePprof(*pprofListen)
+		if err != nil {
+			fmt.Printf("Unable to serve pprof on %s: %v\n", *pprofListen, err)
+			return 1
+		}
+		defer stop()
+	}
+	if err := configureHTTPVersion(*httpVersion); err != nil {
+		fmt.Printf("%v\n", err)
+		return 1
+	}
+	if err := configureSOCKS5(*socks5); err != nil {
+		fmt.Printf("%v\n", err)
+		return 1
+	}
+	if err := configureResolve(resolves); err != nil {
+		fmt.Printf("%v\n", err)
+		return 1
+	}
+	requestLimit.setLimit(*maxRPS, *burst)
+	if *scheduleFlag != "" {
+		spec, err := parseCron(*scheduleFlag)
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			return 1
+		}
+		return runScheduled(spec, os.Args[1:])
+	}
+	var err error
+	if cancel, err = parseCancelPoint(*cancelAfter); err != nil {
+		fmt.Printf("%v\n", err)
+		return 1
+	}
+	var partSizes []int64
+	if *s3fsPartSweep != "" {
+		partSizes, err = parsePartSizes(*s3fsPartSweep)
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			return 1
+		}
+	}
+	if *mode == "localfs" {
+		fmt.Printf("%s\n", localCacheNote(*directIO))
+	}
+	if *sseCKey != "" || *sseCKeyFile != "" {
+		if *sizeFrom == "stat" {
+			*sizeFrom = "head"
+		}
+		var err error
+		sseC, err = loadSSECKey(*sseCKey, *sseCKeyFile)
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			return 1
+		}
+	}
+	var sweepLevels []int
+	if *concurrencySweep != "" {
+		var err error
+		sweepLevels, err = parseSweepLevels(*concurrencySweep)
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			return 1
+		}
+	}
+	if len(targets) > 0 {
+		// Plan against the first target.
+		*endpoint = targets[0].Endpoint
+		*bucket = targets[0].Bucket
+		*region = targets[0].Region
+	}
+
+	var baseline *Result
+	if *baselineFile != "" {
+		var err error
+		if baseline, err = loadResult(*baselineFile); err != nil {
+			fmt.Printf("Unable to load --baseline: %v\n", err)
+			return 1
+		}
+	}
+
+	runID := newRunID()
+	vars := outputVars(runID, filename, time.Now())
+	for _, out := range []*string{jsonOut, jsonlOut, planOut, sweepCSV} {
+		if *out == "" {
+			continue
+		}
+		var err error
+		if *out, err = outputPath(*out, vars); err != nil {
+			fmt.Printf("Bad output filename: %v\n", err)
+			return 1
+		}
+	}
+	if *diagnose {
+		return runDiagnose(os.Args[1:], filename)
 	}
 
 	ctx := context.Background()
-	fs, err := connect(ctx)
+
+	if *otlpEndpoint != "" {
+		shutdown, err := setupTracing(ctx, *otlpEndpoint)
+		if err != nil {
+			panic(err)
+		}
+		defer shutdown(ctx)
+	}
+
+	if err := prepareTargets(ctx, targets); err != nil {
+		fmt.Printf("%v\n", err)
+		return 1
+	}
+	fs, client, err := connect(ctx)
 	if err != nil {
 		panic(err)
 	}
 
+	// Make sure that the bucket and file are reachable with the
+	// addressing style we picked before doing anything else; see
+	// bucketcheck.go.  A dry run
+	// doesn't talk to the server at all, except perhaps to learn
+	// the file's size.
+	if usesS3(*mode) {
+		fmt.Printf("Addressing: %s (%s)\n", addressingStyle(), objectURL(filename))
+	}
+	var storage *objectStorage
+	if !*dryRun && !*noPreflight && usesS3(*mode) {
+		if f := checkBucket(ctx, client, filename); f != nil {
+			fmt.Printf("%v\n", f)
+			return f.status
+		}
+		if storage, err = checkStorage(ctx, client, filename, *filerURL); err != nil {
+			fmt.Printf("WARNING: unable to check the object's storage class: %v\n", err)
+		}
+	}
+
+	env := collectEnvironment(ctx)
+	env.print()
+	if storage != nil {
+		storage.print()
+		if storage.Cold != "" {
+			if !*allowArchive {
+				fmt.Printf("Refusing to benchmark %s: %s, so its reads won't say anything about hot storage; use --allow-archive if that's what you want to measure\n", filename, storage.Cold)
+				return exitArchived
+			}
+			fmt.Printf("WARNING: %s: %s, so expect archive latency, not hot-storage latency (--allow-archive)\n", filename, storage.Cold)
+		}
+	}
+	if *mode != "localfs" {
+		remote := ""
+		if len(env.Dialed) > 0 {
+			remote = env.Dialed[0]
+		}
+		ceiling = findLinkSpeed(remote)
+	}
+
+	// With --state-file, we may already know the size and
+	// schedule from an earlier run.
+	var state *stateFile
+	key := stateKey(filename)
+	var discovery *sizeDiscovery
+	var progress *runProgress
+	if sched == nil && *stateFileName != "" {
+		state, err = loadState(*stateFileName)
+		if err != nil {
+			panic(err)
+		}
+		if !*refreshState {
+			prev := state.Entries[key]
+			entry, err := state.lookup(ctx, client, key, filename)
+			if err != nil {
+				panic(err)
+			}
+			if entry != nil {
+				discovery = &sizeDiscovery{Method: "state", Size: entry.Size}
+				sched = entry.Schedule
+				if *resume {
+					progress = entry.Progress
+				}
+			} else if *resume && prev != nil && prev.Progress != nil {
+				fmt.Printf("Refusing to --resume: %s has changed since the interrupted run\n", filename)
+				return 1
+			}
+		}
+	}
+	if *resume && progress == nil {
+		fmt.Printf("Nothing to --resume: %s has no interrupted run of %s with these flags\n", *stateFileName, filename)
+		return 1
+	}
+
 	// Figure out how big the file is
-	fileinfo, err := fs.Stat(filename)
+	switch {
+	case discovery != nil:
+		// We already know, from --state-file.
+	case sched != nil && sched.FileSize > 0 && *filesizeFlag < 0 && original == nil:
+		discovery = &sizeDiscovery{Method: "replay", Size: sched.FileSize}
+	default:
+		discovery, err = discoverSize(ctx, fs, client, filename, *sizeFrom)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if discovery.Size < 0 {
+		fmt.Printf("The server says %s is %d bytes long, which can't be right\n", filename, discovery.Size)
+		return 1
+	}
+	filesize := discovery.Size
+	fmt.Printf("File size %d bytes via %s in %.3fs\n", discovery.Size, discovery.Method, discovery.Duration.Seconds())
+	if filesize == 0 {
+		fmt.Printf("%s is empty, so there's nothing to read\n", filename)
+		return 0
+	}
+
+	var objectETag string
+	if usesS3(*mode) && !*noStat {
+		if objectETag, err = headETag(ctx, client, filename); err != nil {
+			fmt.Printf("WARNING: unable to get the ETag of %s: %v\n", filename, err)
+		}
+	}
+	if original != nil {
+		if err := checkReplayable(original, filesize, objectETag); err != nil {
+			fmt.Printf("%v\n", err)
+			return 1
+		}
+		fmt.Printf("Replaying the %d reads of run %s\n", len(sched.Reads), original.RunID)
+	}
+	if capture != nil {
+		if sched, err = capture.schedule(filename, filesize); err != nil {
+			fmt.Printf("Unable to use --replay-har: %v\n", err)
+			return 1
+		}
+	}
+
+	if sched == nil {
+		sched, err = planSchedule(filename, filesize)
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			return 1
+		}
+		if state != nil {
+			etag, err := headETag(ctx, client, filename)
+			if err != nil {
+				panic(err)
+			}
+			state.Entries[key] = &stateEntry{Size: filesize, ETag: etag, Schedule: sched}
+			if err := state.save(*stateFileName); err != nil {
+				fmt.Printf("Unable to write %s: %v\n", *stateFileName, err)
+				return 1
+			}
+		}
+	}
+	inflight.setLimit(*maxInflight)
+	if err := sched.check(); err != nil {
+		fmt.Printf("Unable to use the schedule: %v\n", err)
+		return 1
+	}
+	if err := limitMemory(sched, *maxMemory, *memoryPolicy); err != nil {
+		fmt.Printf("%v\n", err)
+		return 1
+	}
+	if err := production.checkSchedule(sched); err != nil {
+		fmt.Printf("%v\n", err)
+		return 1
+	}
+	if *planOut != "" {
+		sched.SchemaVersion = schema.Version
+		if err := writeJSON(*planOut, sched); err != nil {
+			fmt.Printf("Unable to write %s: %v\n", *planOut, err)
+			return 1
+		}
+	}
+	if *dryRun {
+		sched.print(*dryRunLimit)
+		return 0
+	}
+
+	// With --cold-cache, read from a brand new copy of the file
+	// instead, and clean it up afterward.
+	cache := "warm"
+	if *mode == "localfs" && *directIO {
+		cache = "no"
+	}
+	if *coldCache {
+		cold, err := makeColdCopy(ctx, client, filename, filesize)
+		if err != nil {
+			panic(err)
+		}
+		defer deleteColdCopy(ctx, client, cold.Key)
+		fmt.Printf("Cold cache: reading %s (%s)\n", cold.Key, cold.How)
+		filename = cold.Key
+		cache = "cold"
+		if cold.Generated {
+			expectedContent = generatedContent(cold.Seed)
+		}
+	}
+	if *checkPosition && *mode == "localfs" {
+		expectedContent, err = localContent(filename)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	if *verifyChecksums {
+		objectChecksums, err = headChecksums(ctx, client, filename)
+		if err != nil {
+			fmt.Printf("WARNING: unable to get the object's checksums: %v\n", err)
+		}
+	}
+
+	var etag string
+	if *conditional {
+		etag, err = headETag(ctx, client, filename)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("Conditional reads against ETag %s\n", etag)
+	}
+
+	backend, err := newBackend(*mode, client, defaultTarget(), etag)
 	if err != nil {
 		panic(err)
 	}
-	filesize := uint64(fileinfo.Size())
+	if *reuseHandle {
+		size := *handlePoolSize
+		if size == 0 {
+			size = sched.Concurrency
+		}
+		if handles, err = newHandlePool(backend, size); err != nil {
+			fmt.Printf("%v\n", err)
+			return 1
+		}
+		defer handles.close()
+		backend = handles
+	}
+	if *validateMP4 {
+		mp4Index, err = indexMP4(ctx, backend, filename, filesize)
+		if err != nil {
+			fmt.Printf("--validate-mp4: unable to index %s: %v\n", filename, err)
+			return 1
+		}
+		fmt.Printf("MP4 validation: checking reads against %d top-level boxes\n", len(mp4Index))
+	}
+
+	readSize := *readsize
+	reads := sched.ranges()
+
+	if len(targets) > 0 {
+		results, err := runTargets(ctx, targets, filename, filesize, sched, *parallelTargets, *targetHash)
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			return 1
+		}
+		if *jsonOut != "" {
+			if err := writeJSON(*jsonOut, &targetsResult{SchemaVersion: schema.Version, Targets: results}); err != nil {
+				panic(err)
+			}
+		}
+		return 0
+	}
+
+	if *interference {
+		result := &interferenceResult{RunInfo: RunInfo{
+			SchemaVersion: schema.Version,
+			RunID:         runID,
+			Start:         time.Now(),
+			Endpoint:      *endpoint,
+			Bucket:        *bucket,
+			File:          filename,
+			Mode:          *mode,
+			Addressing:    addressingStyle(),
+			FileSize:      filesize,
+			Cache:         cache,
+			Environment:   env,
+			Storage:       storage,
+		}}
+		if err := runInterference(ctx, backend, filename, filesize, result); err != nil {
+			fmt.Printf("%v\n", err)
+			return 1
+		}
+		if *jsonOut != "" {
+			if err := writeJSON(*jsonOut, result); err != nil {
+				panic(err)
+			}
+		}
+		return 0
+	}
+
+	if len(tenants) > 0 {
+		results, err := runTenants(ctx, client, backend, tenants, *tenantDuration, *tenantPrefix, runID, filename, filesize)
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			return 1
+		}
+		if *jsonOut != "" {
+			if err := writeJSON(*jsonOut, &tenantsResult{SchemaVersion: schema.Version, Tenants: results}); err != nil {
+				panic(err)
+			}
+		}
+		return 0
+	}
+
+	if *statConsistency {
+		result := &statConsistencyResult{RunInfo: RunInfo{
+			SchemaVersion: schema.Version,
+			RunID:         runID,
+			Start:         time.Now(),
+			Endpoint:      *endpoint,
+			Bucket:        *bucket,
+			File:          filename,
+			Mode:          *mode,
+			Addressing:    addressingStyle(),
+			FileSize:      filesize,
+			Cache:         cache,
+			Environment:   env,
+			Storage:       storage,
+		}}
+		runStatConsistency(ctx, client, filename, result)
+		if *jsonOut != "" {
+			if err := writeJSON(*jsonOut, result); err != nil {
+				panic(err)
+			}
+		}
+		return 0
+	}
+
+	if *seekProbe {
+		if err := runSeekProbe(fs, filename, filesize); err != nil && !stoppedAtLimit(err) {
+			panic(err)
+		}
+		return 0
+	}
+
+	if *bisect {
+		err = runBisect(ctx, backend, filename, filesize)
+		if err != nil && !stoppedAtLimit(err) {
+			panic(err)
+		}
+		return 0
+	}
+
+	if *pattern == "zip-member" {
+		b := &benchmark{
+			ctx:       ctx,
+			client:    client,
+			backend:   backend,
+			filename:  filename,
+			filesize:  filesize,
+			discovery: discovery,
+			result:    &Result{},
+		}
+		result := &zipMemberResult{RunInfo: RunInfo{
+			SchemaVersion: schema.Version,
+			RunID:         runID,
+			Start:         time.Now(),
+			Endpoint:      *endpoint,
+			Bucket:        *bucket,
+			File:          filename,
+			Mode:          *mode,
+			Addressing:    addressingStyle(),
+			ReadSize:      readSize,
+			FileSize:      filesize,
+			Pattern:       *pattern,
+			Concurrency:   *concurrency,
+			Cache:         cache,
+			Environment:   env,
+			Storage:       storage,
+		}}
+		if err := runZipMember(b, result); err != nil {
+			fmt.Printf("%v\n", err)
+			return 1
+		}
+		if *jsonOut != "" {
+			if err := writeJSON(*jsonOut, result); err != nil {
+				panic(err)
+			}
+		}
+		return 0
+	}
+
+	if *targetP90 > 0 {
+		b := &benchmark{
+			ctx:       ctx,
+			client:    client,
+			backend:   backend,
+			filename:  filename,
+			filesize:  filesize,
+			discovery: discovery,
+			result:    &Result{},
+		}
+		if err := runAdaptive(b, *targetP90); err != nil && !stoppedAtLimit(err) {
+			panic(err)
+		}
+		return 0
+	}
+
+	if sweepLevels != nil {
+		b := &benchmark{
+			ctx:       ctx,
+			client:    client,
+			backend:   backend,
+			filename:  filename,
+			filesize:  filesize,
+			discovery: discovery,
+			result:    &Result{},
+		}
+		if err := runConcurrencySweep(b, sweepLevels); err != nil && !stoppedAtLimit(err) {
+			panic(err)
+		}
+		return 0
+	}
+
+	if partSizes != nil {
+		b := &benchmark{
+			ctx:       ctx,
+			client:    client,
+			backend:   backend,
+			filename:  filename,
+			filesize:  filesize,
+			discovery: discovery,
+			result:    &Result{},
+		}
+		if err := runPartSweep(b, sched, partSizes); err != nil && !stoppedAtLimit(err) {
+			panic(err)
+		}
+		return 0
+	}
+
+	if *compareCov {
+		err = compareCoverage(ctx, client, etag, filename, filesize, discovery, sched, *mode)
+		if err != nil && !stoppedAtLimit(err) {
+			panic(err)
+		}
+		return 0
+	}
+
+	result := &Result{
+		RunInfo: RunInfo{
+			SchemaVersion: schema.Version,
+			RunID:         runID,
+			Start:         time.Now(),
+			Endpoint:      *endpoint,
+			Bucket:        *bucket,
+			File:          filename,
+			Mode:          *mode,
+			Addressing:    addressingStyle(),
+			ReadSize:      readSize,
+			FileSize:      filesize,
+			Pattern:       *pattern,
+			Concurrency:   *concurrency,
+			Cache:         cache,
+			ETag:          objectETag,
+			Pacing:        sched.Pacing,
+
+			Environment: env,
+			Storage:     storage,
+		},
+		SizeDiscovery: discovery,
+		Schedule:      sched,
+	}
+	if original != nil {
+		result.ReplayOf = original.RunID
+	}
+	// Whatever the run exits with from here on, the alert hooks see
+	// it, even when it stops early.
+	defer func() {
+		// The hooks need the real exit status, and this runs before
+		// the recover() at the top of run().
+		if r := recover(); r != nil {
+			status = productionLimitStatus(r)
+		}
+		runAlertHooks(result, status)
+	}()
+
+	if *coalesce >= 0 {
+		err = runCoalesced(ctx, client, filename, reads, int64(*coalesce), int64(*coalesceMax))
+		if err != nil && !stoppedAtLimit(err) {
+			panic(err)
+		}
+		result.Amplification = analyzeAmplification(upstream.Requests(), filename, filesize, reads)
+		return checkAmplification(result.Amplification)
+	}
+
+	if *topologyURL != "" || *filerURL != "" {
+		result.Topology = &topologySnapshot{URL: *topologyURL}
+		if *topologyURL != "" {
+			result.Topology.Start = fetchTopology(*topologyURL)
+		}
+		if *filerURL != "" {
+			result.Topology.Entry, result.Topology.Chunks = fetchChunks(*filerURL, *filerBucketDir, *bucket, filename)
+			if len(result.Topology.Chunks) > 0 {
+				printChunks(result.Topology.Chunks)
+			}
+		}
+	}
+
+	var jsonl *jsonlWriter
+	if *jsonlOut != "" {
+		jsonl, err = newJSONLWriter(*jsonlOut, *jsonlSyncInterval, *jsonlSyncSamples, rotationPolicy{*rotateSize, *rotateInterval, *maxRotated})
+		if err != nil {
+			panic(err)
+		}
+		defer jsonl.Close()
+		err = jsonl.write(jsonlRecord{Type: "run", Run: &result.RunInfo})
+		if err != nil {
+			panic(err)
+		}
+	}
+	var stream *fifoStream
+	if *streamFIFO != "" {
+		stream, err = newFIFOStream(*streamFIFO, jsonlRecord{SchemaVersion: schema.Version, Version: jsonlVersion, Type: "run", Run: &result.RunInfo})
+		if err != nil {
+			fmt.Printf("Unable to stream to %s: %v\n", *streamFIFO, err)
+			return 1
+		}
+		defer stream.Close()
+	}
+
+	ctx, runSpan := tracer.Start(ctx, "run", trace.WithAttributes(
+		attribute.String("file", filename),
+		attribute.Int64("readsize", readSize),
+		attribute.String("cache", cache)))
+	defer runSpan.End()
+
+	var bg *backgroundMetadata
+	if *backgroundRate > 0 {
+		bg = startBackgroundMetadata(ctx, client, filename, *backgroundRate)
+	}
 
-	readSize := uint64(*readsize)
-	readCount := uint64(filesize) / readSize // this leaves off the end of the file, which is fine for this use.
+	b := &benchmark{
+		ctx:       ctx,
+		client:    client,
+		backend:   backend,
+		filename:  filename,
+		filesize:  filesize,
+		discovery: discovery,
+		result:    result,
+		jsonl:     jsonl,
+		stream:    stream,
+		startETag: etag,
+	}
+
+	var health *healthcheck
+	if *healthObject != "" {
+		progress := func() int64 {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			return b.totalBytes
+		}
+		emit := func(rec jsonlRecord) {
+			stream.send(rec)
+			if jsonl != nil {
+				jsonl.write(rec)
+			}
+		}
+		health, err = startHealthcheck(ctx, *healthObject, *healthInterval, progress, emit)
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			return 1
+		}
+	}
 
-	var i uint64
+	// With --state-file, save the progress of a sequential run so
+	// that it can be resumed.
+	todo := sched
+	var saver *progressSaver
+	if progress != nil {
+		b.restore(progress.Samples)
+		todo = progress.remaining(sched)
+		fmt.Printf("Resuming: %d reads done in earlier sessions, %d to go\n", len(progress.Samples), len(todo.Reads))
+	}
+	completed := false
+	if state != nil && sched.Pattern == "sequential" && *passes == 1 {
+		saver = startProgress(state, *stateFileName, key, b, progress)
+		defer func() { saver.finish(completed) }()
+	}
 
 	start := time.Now()
+	load := startClientLoad()
+	if *controlSocket != "" {
+		stop, err := serveControl(*controlSocket, b, start)
+		if err != nil {
+			fmt.Printf("Unable to listen on %s: %v\n", *controlSocket, err)
+			return 1
+		}
+		defer stop()
+	}
 
-	// Read from the file repeatedly, pretending that we're a HTTP server feeding video to a client.
-	for i = 0; i < readCount; i++ {
-		offset := readSize*i
-		err = readFrom(fs, filename, offset, readSize, readSize*readCount)
+	if *tuiFlag {
+		b.tui, err = startTUI(filename, filesize)
 		if err != nil {
 			panic(err)
 		}
+		defer b.tui.Close()
 	}
-	dur := time.Since(start)
-	fmt.Printf("Read %d bytes in %.3f seconds at %f Mbps\n", readSize*readCount, dur.Seconds(), float64(readSize*readCount*8)/dur.Seconds()/1000000)
+
+	var samples map[string][]*Sample
+	var passSamples [][]*Sample
+	for pass := 1; pass <= *passes && !b.isStopped(); pass++ {
+		if *passes > 1 {
+			fmt.Printf("Pass %d of %d\n", pass, *passes)
+		}
+		b.pass = pass
+		before := len(result.Samples)
+		samples, err = b.runSchedule(todo)
+		if errors.Is(err, errFullBody) {
+			return exitFullBody
+		}
+		if errors.Is(err, errStall) {
+			return exitStall
+		}
+		if err != nil {
+			panic(err)
+		}
+		passSamples = append(passSamples, result.Samples[before:])
+	}
+	completed = !b.isStopped()
+	b.tui.Close()
+	end := jsonlRecord{Type: "end", Event: &controlEvent{Time: time.Now()}}
+	stream.send(end)
+	if jsonl != nil {
+		if err := jsonl.write(end); err != nil {
+			panic(err)
+		}
+	}
+	if *reportPassDelta && len(passSamples) > 1 {
+		comparePasses(passSamples[0], passSamples[len(passSamples)-1], filesize)
+	}
+	if *pattern == "same-range" {
+		reportSameRange(samples["same-range"], samples["disjoint"], sched.Concurrency)
+	}
+	if *pattern == "open-storm" {
+		reportOpenStorm(result.Samples)
+	}
+
+	result.Paused = b.pausedFor()
+	dur := time.Since(start) - result.Paused
+	if progress != nil {
+		dur += progress.active()
+		result.Resumed = saver.resumeInfo(len(result.Samples) - len(progress.Samples))
+		result.Resumed.print()
+	}
+	if bg != nil {
+		bg.stop()
+	}
+	if health != nil {
+		result.Healthcheck = health.stop()
+	}
+	result.ClientLoad = load.stop()
+	if *netBaseline {
+		if ceiling == nil {
+			ceiling = &networkCeiling{}
+		}
+		if err := ceiling.measureBaseline(ctx, client, filename); err != nil {
+			fmt.Printf("WARNING: --net-baseline GET failed: %v\n", err)
+		}
+	}
+	if ceiling != nil {
+		ceiling.print()
+		ceiling.finish(b.totalBytes, dur)
+		result.Ceiling = ceiling
+	}
+	fmt.Printf("Read %s in %.3f seconds at %s (%s cache)%s\n", units.bytes(b.totalBytes), dur.Seconds(), units.rate(b.totalBytes, dur), cache, ceiling.describe(b.totalBytes, dur))
+	if sched.Sampling != nil {
+		reportSampled(sched.Sampling, result.Samples, filesize, b.totalBytes, dur)
+	}
+	if bg != nil {
+		fmt.Printf("Reads: %s\n", computeLatencyStats(b.latencies))
+		bg.report()
+	}
+	if result.Healthcheck != nil {
+		result.Healthcheck.print(start, filesize)
+	}
+	if *conditional {
+		b.cond.report(*mode)
+	}
+	fmt.Printf("SDK made %d attempts for %d requests; %d requests and %d of %d reads needed retries\n", attempts.attempts, attempts.operations, attempts.retried, b.retriedReads, len(b.asked))
+	reportResponseTiming(result.Samples)
+	reportBackpressure(result.Samples)
+	inflight.report()
+	requestLimit.report()
+	slowReads.report()
+	reportStalls(result.Samples)
+	if *byChunk {
+		var filer []chunkInfo
+		if result.Topology != nil {
+			filer = result.Topology.Chunks
+		}
+		if result.ChunkLatency = analyzeChunkLatency(result.Samples, filesize, filer); result.ChunkLatency != nil {
+			result.ChunkLatency.print()
+		}
+	}
+	reportOverdelivery(result.Samples)
+	if usesS3(*mode) {
+		reportPreflight(result.Samples, upstream.Requests(), filename)
+	}
+	if *mode == "s3fs" {
+		reportRequestSizes(upstream.Requests(), filename, filesize)
+	}
+	if *noSeek {
+		reportDiscarded(result.Samples)
+	}
+	if *verifyChecksums {
+		reportChecksums(result.Samples)
+	}
+	if *validateMP4 {
+		reportMP4(result.Samples)
+	}
+	if slow := slowestSample(result.Samples); slow != nil {
+		fmt.Printf("Slowest read: offset %d in %.3fs, read ID %s\n", slow.Offset, slow.Duration.Seconds(), slow.ReadID)
+	}
+
+	result.Bytes = b.totalBytes
+	result.ClientLoad.setBytes(result.Bytes)
+	result.Duration = dur
+	result.Mbps = mbps(b.totalBytes, dur)
+	result.Latency = computeLatencyStats(b.latencies)
+	if usesS3(*mode) {
+		result.Amplification = analyzeAmplification(upstream.Requests(), filename, filesize, b.asked)
+		result.Amplification.Overdelivered, _ = totalOverdelivery(result.Samples)
+	}
+	result.Connections = upstream.connections.Connections()
+	result.PeakBufferBytes = buffers.Peak()
+	fmt.Printf("Peak read buffer usage: %s\n", units.bytes(result.PeakBufferBytes))
+	result.ClientLoad.print()
+	printConnections(result.Connections)
+	if handles != nil {
+		result.Handles = handles.report()
+		result.Handles.print()
+	}
+	if socks != nil {
+		result.SOCKS = socks.report()
+		result.SOCKS.print()
+	}
+	if *mode != "localfs" {
+		result.ConnectionUse = analyzeConnectionUse(upstream.Requests(), result.Samples)
+		result.ConnectionUse.print()
+		result.Protocols = countProtocols(upstream.Requests())
+		reportProtocols(result.Protocols, result.Samples)
+		if result.Redirects = analyzeRedirects(upstream.Requests()); result.Redirects != nil {
+			result.Redirects.print()
+		}
+		if len(resolves) > 0 {
+			result.ConnectionsByAddress = connectionsByAddress(upstream.Requests())
+			reportConnectionsByAddress(result.ConnectionsByAddress)
+		}
+	}
+	if cancel.enabled() {
+		reportCancellation(upstream.Requests())
+	}
+	if bg != nil {
+		result.BackgroundMetadata = bg.samples
+	}
+	if result.ObjectChange != nil {
+		fmt.Printf("WARNING: the object changed during the run, so these results are contaminated\n")
+	}
+	status = checkAmplification(result.Amplification)
+	if baseline != nil {
+		compareBaseline(result, baseline)
+	}
+	if !*noFindings {
+		result.Findings = computeFindings(result)
+		printFindings(result.Findings)
+	}
+
+	if *topologyURL != "" {
+		result.Topology.End = fetchTopology(*topologyURL)
+	}
+	if *fetchLogs > 0 {
+		fetchServerLogs(ctx, result.Samples, *fetchLogs)
+	}
+
+	if *jsonOut != "" {
+		if err := writeJSON(*jsonOut, result); err != nil {
+			fmt.Printf("Unable to write %s: %v\n", *jsonOut, err)
+			return 1
+		}
+	}
+	if *pushgatewayURL != "" {
+		if err := pushResult(*pushgatewayURL, result, b.latencies); err != nil {
+			fmt.Printf("Unable to push to the Pushgateway: %v\n", err)
+			if *requirePush {
+				return 1
+			}
+		} else {
+			fmt.Printf("Pushed results to %s\n", *pushgatewayURL)
+		}
+	}
+	return status
+}
+
+// Print the amplification report, and return a non-zero exit status
+// if it's over the --fail-on-amplification threshold.
+func checkAmplification(report *amplificationReport) int {
+	if report == nil {
+		return 0
+	}
+	report.print()
+	if *failAmplification > 0 && report.Ratio() > *failAmplification {
+		fmt.Printf("Amplification %.2fx exceeds --fail-on-amplification=%.2f\n", report.Ratio(), *failAmplification)
+		return exitAmplification
+	}
+	return 0
 }
@@ -0,0 +1,115 @@
+package main
+
+// With --pattern=same-range, every worker reads the exact same byte
+// range over and over.  If the gateway caches chunks, or collapses
+// identical in-flight requests, the later reads should be noticeably
+// faster than the first one.  To tell caching apart from "the server
+// is just fast", we then repeat the run at the same concurrency with
+// each worker reading its own, disjoint ranges.
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Plan the same-range pattern and its disjoint-range comparison on
+// `concurrency` workers.
+func planSameRange(filesize int64, concurrency int) ([]scheduledRead, error) {
+	length := *rangeLength
+	if length == 0 {
+		length = *readsize
+	}
+	if length > filesize || *rangeOffset > filesize-length {
+		return nil, fmt.Errorf("--offset %d and --length %d don't fit in the %d byte object", *rangeOffset, length, filesize)
+	}
+
+	var reads []scheduledRead
+	for range *iterations {
+		for w := range concurrency {
+			reads = append(reads, scheduledRead{Label: "same-range", Worker: w, Offset: *rangeOffset, Size: length})
+		}
+	}
+
+	// Disjoint ranges are laid out in `length`-sized slots, skipping
+	// the slot that holds the same-range read.  If the file is too
+	// small to give every read its own slot, they wrap around.
+	slots := filesize / length
+	skip := *rangeOffset / length
+	if slots < int64(concurrency**iterations)+1 {
+		fmt.Printf("WARNING: the object only has room for %d disjoint %d byte ranges, so some will repeat\n", slots, length)
+	}
+	for i := range *iterations {
+		for w := range concurrency {
+			slot := int64(w**iterations + i)
+			if slots > 1 {
+				slot = (skip + 1 + slot%(slots-1)) % slots
+			}
+			reads = append(reads, scheduledRead{Label: "disjoint", Worker: w, Offset: slot * length, Size: length})
+		}
+	}
+
+	return reads, nil
+}
+
+// Print per-worker latency for the same-range reads, and compare them
+// with the disjoint reads that followed.
+func reportSameRange(same, disjoint []*Sample, workers int) {
+	byStart := func(a, b *Sample) int { return a.Start.Compare(b.Start) }
+	slices.SortFunc(same, byStart)
+
+	fmt.Printf("%-8s %12s %12s %12s\n", "worker", "first", "later p50", "later max")
+	for w := range workers {
+		var lat []time.Duration
+		for _, s := range same {
+			if s.Worker == w && s.Err == "" {
+				lat = append(lat, s.Duration)
+			}
+		}
+		if len(lat) == 0 {
+			fmt.Printf("%-8d %12s\n", w, "-")
+			continue
+		}
+		later := computeLatencyStats(lat[1:])
+		fmt.Printf("%-8d %12s %12s %12s\n", w, lat[0].Round(time.Microsecond), later.P50.Round(time.Microsecond), later.Max.Round(time.Microsecond))
+	}
+
+	// The very first request anywhere is the only one that can't
+	// have been helped by a cache.
+	var first time.Duration
+	var later, others []time.Duration
+	for _, s := range same {
+		if s.Err != "" {
+			continue
+		}
+		if first == 0 {
+			first = s.Duration
+		} else {
+			later = append(later, s.Duration)
+		}
+	}
+	for _, s := range disjoint {
+		if s.Err == "" {
+			others = append(others, s.Duration)
+		}
+	}
+	laterStats := computeLatencyStats(later)
+	otherStats := computeLatencyStats(others)
+
+	fmt.Printf("Same range: first read %s, later reads %s\n", first.Round(time.Microsecond), laterStats)
+	fmt.Printf("Disjoint:   %s\n", otherStats)
+
+	if laterStats.Count == 0 || otherStats.Count == 0 {
+		fmt.Printf("Not enough successful reads to compare\n")
+		return
+	}
+	ratio := float64(otherStats.P50) / float64(laterStats.P50)
+	switch {
+	case ratio >= 1.25:
+		fmt.Printf("Repeated reads of the same range were %.2fx faster than disjoint reads at p50; the server appears to cache or collapse identical requests\n", ratio)
+	case ratio <= 0.8:
+		fmt.Printf("Repeated reads of the same range were %.2fx slower than disjoint reads at p50; identical requests may be contending with each other\n", 1/ratio)
+	default:
+		fmt.Printf("Repeated reads of the same range were no faster than disjoint reads (%.2fx at p50); no evidence of caching\n", ratio)
+	}
+}
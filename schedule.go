@@ -0,0 +1,236 @@
+package main
+
+// The read schedule is computed up front from the flags, so that
+// --dry-run can show exactly what a run would do without sending a
+// single request, and --replay can execute a schedule that was
+// planned somewhere else.  Nothing in a schedule is random, so
+// planning and running with the same flags always produce the same
+// reads in the same order.  (--jitter is random, but its seed is
+// saved in the schedule; see pacing.go.)
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/scottlaird/s3test/schema"
+)
+
+// scheduledRead is one planned read.
+type scheduledRead struct {
+	Label  string `json:"label,omitempty"`
+	Worker int    `json:"worker"` // -1 for whichever worker is free next
+	Offset int64  `json:"offset"`
+	Size   int64  `json:"size"`
+
+	// When to start, after the start of the phase, in a timed
+	// schedule; see har.go.
+	At time.Duration `json:"atNs,omitempty"`
+}
+
+// schedule is everything a run is going to read.  This is the format
+// written by --plan and read by --replay.
+type schedule struct {
+	SchemaVersion string          `json:"schemaVersion,omitempty"` // set when it's written to a --plan file
+	File          string          `json:"file"`
+	FileSize      int64           `json:"fileSize"`
+	Pattern       string          `json:"pattern"`
+	Concurrency   int             `json:"concurrency"`
+	Pacing        *pacing         `json:"pacing,omitempty"`   // nil for back-to-back reads
+	Sampling      *sampling       `json:"sampling,omitempty"` // nil unless --sample; see sparse.go
+	Timed         bool            `json:"timed,omitempty"`    // reads start at their At, with --replay-speed
+	Reads         []scheduledRead `json:"reads"`
+}
+
+// Work out the reads for `filename` from the flags.
+func planSchedule(filename string, filesize int64) (*schedule, error) {
+	s := &schedule{
+		File:        filename,
+		FileSize:    filesize,
+		Pattern:     *pattern,
+		Concurrency: *concurrency,
+		Pacing:      pacingFromFlags(),
+	}
+
+	switch {
+	case *mode == "fullobject":
+		return fullObjectSchedule(filename, filesize), nil
+	case *pattern == "sequential":
+		// Read from the file repeatedly, pretending that we're a
+		// HTTP server feeding video to a client.
+		if len(explicitRanges) > 0 {
+			reads, err := planRanges(explicitRanges, filesize)
+			if err != nil {
+				return nil, err
+			}
+			s.Reads = reads
+			break
+		}
+		readSize := *readsize
+		if s.Sampling = samplingFromFlags(); s.Sampling != nil {
+			reads, err := planSample(s.Sampling, filesize, readSize)
+			if err != nil {
+				return nil, err
+			}
+			s.Reads = reads
+			break
+		}
+		readCount := filesize / readSize // this leaves off the end of the file, which is fine for this use.
+		if readCount == 0 && filesize > 0 {
+			// The whole file is smaller than one read.
+			s.Reads = append(s.Reads, scheduledRead{Worker: -1, Offset: 0, Size: filesize})
+		}
+		for i := int64(0); i < readCount; i++ {
+			s.Reads = append(s.Reads, scheduledRead{Worker: -1, Offset: readSize * i, Size: readSize})
+		}
+	case *pattern == "same-range":
+		reads, err := planSameRange(filesize, *concurrency)
+		if err != nil {
+			return nil, err
+		}
+		s.Reads = reads
+	case *pattern == "open-storm":
+		reads, err := planOpenStorm(filesize, *concurrency)
+		if err != nil {
+			return nil, err
+		}
+		s.Reads = reads
+	case *pattern == "zip-member":
+		s.Reads = planZipTail(filesize)
+	default:
+		return nil, fmt.Errorf("unknown --pattern %q; use sequential, same-range, open-storm, or zip-member", *pattern)
+	}
+
+	return s, nil
+}
+
+// Return a schedule that reads all of `filename` at once.
+func fullObjectSchedule(filename string, filesize int64) *schedule {
+	return &schedule{
+		File:        filename,
+		FileSize:    filesize,
+		Pattern:     "fullobject",
+		Concurrency: 1,
+		Reads:       []scheduledRead{{Worker: -1, Offset: 0, Size: filesize}},
+	}
+}
+
+// Read a schedule written by --plan.
+func loadSchedule(filename string) (*schedule, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	s := &schedule{}
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	if err := schema.Check(s.SchemaVersion); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	if s.Concurrency < 1 {
+		s.Concurrency = 1
+	}
+	return s, nil
+}
+
+// Make sure that the file and every read fit in an int64, which a
+// schedule that we didn't plan ourselves might not.
+func (s *schedule) check() error {
+	if err := checkRange(0, s.FileSize); err != nil {
+		return fmt.Errorf("the %d byte file is too big: %w", s.FileSize, err)
+	}
+	for i, r := range s.Reads {
+		if err := checkRange(r.Offset, r.Size); err != nil {
+			return fmt.Errorf("read %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// Return the planned reads as plain ranges.
+func (s *schedule) ranges() []readRange {
+	ranges := make([]readRange, len(s.Reads))
+	for i, r := range s.Reads {
+		ranges[i] = readRange{offset: r.Offset, size: r.Size}
+	}
+	return ranges
+}
+
+// Split the schedule into runs of consecutive reads with the same
+// label.  Each phase finishes before the next one starts.
+func (s *schedule) phases() [][]scheduledRead {
+	var phases [][]scheduledRead
+	for i, r := range s.Reads {
+		if i == 0 || r.Label != s.Reads[i-1].Label {
+			phases = append(phases, nil)
+		}
+		phases[len(phases)-1] = append(phases[len(phases)-1], r)
+	}
+	return phases
+}
+
+// Print the first `limit` reads of the schedule (all of them if
+// `limit` is 0), plus a count of the rest.
+func (s *schedule) print(limit int) {
+	fmt.Printf("Plan: %d reads of %s (%d bytes), pattern %s, concurrency %d\n", len(s.Reads), s.File, s.FileSize, s.Pattern, s.Concurrency)
+	if s.Pacing != nil {
+		fmt.Printf("Pacing: %s\n", s.Pacing)
+	}
+	if s.Sampling != nil {
+		fmt.Printf("Sampling: %s\n", s.Sampling)
+	}
+	if s.Timed {
+		fmt.Printf("Timing: each read starts when it did in the recording, at %gx speed\n", *replaySpeed)
+	}
+	fmt.Printf("%8s  %-12s %6s %14s %12s\n", "#", "label", "worker", "offset", "size")
+	for i, r := range s.Reads {
+		if limit > 0 && i >= limit {
+			fmt.Printf("... and %d more\n", len(s.Reads)-limit)
+			break
+		}
+		worker := "any"
+		if r.Worker >= 0 {
+			worker = fmt.Sprint(r.Worker)
+		}
+		at := ""
+		if s.Timed {
+			at = fmt.Sprintf("  at %.3fs", r.At.Seconds() / *replaySpeed)
+		}
+		fmt.Printf("%8d  %-12s %6s %14d %12d%s\n", i, r.Label, worker, r.Offset, r.Size, at)
+	}
+}
+
+// Return a readSource for one phase of a schedule.  Reads assigned to
+// a particular worker go to that worker, in order; the rest go to
+// whichever worker asks first.
+func scheduleSource(reads []scheduledRead, concurrency int) readSource {
+	var shared []readRange
+	perWorker := make([][]readRange, concurrency)
+	for _, r := range reads {
+		rr := readRange{offset: r.Offset, size: r.Size}
+		if r.Worker < 0 {
+			shared = append(shared, rr)
+		} else {
+			w := r.Worker % concurrency
+			perWorker[w] = append(perWorker[w], rr)
+		}
+	}
+
+	var next atomic.Int64
+	return func(worker int) (readRange, bool) {
+		// Each worker only touches its own queue, so this
+		// doesn't need a lock.
+		if q := perWorker[worker]; len(q) > 0 {
+			perWorker[worker] = q[1:]
+			return q[0], true
+		}
+		i := next.Add(1) - 1
+		if i >= int64(len(shared)) {
+			return readRange{}, false
+		}
+		return shared[i], true
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"slices"
+	"testing"
+)
+
+const tib = int64(1) << 40
+
+// Check that every read in `s` is inside the file and fits in an
+// int64.
+func checkReads(t *testing.T, s *schedule) {
+	t.Helper()
+	if err := s.check(); err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range s.Reads {
+		if r.Size <= 0 || r.Offset > s.FileSize-r.Size {
+			t.Errorf("read %d: %d bytes at %d isn't inside the %d byte file", i, r.Size, r.Offset, s.FileSize)
+		}
+	}
+}
+
+func TestPlanScheduleLargeFiles(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		filesize int64
+		readsize int64
+		want     int
+	}{
+		{"5 TiB", 5 * tib, 1 << 40, 5},
+		{"5 TiB and a bit", 5*tib + 12345, 1 << 40, 5},
+		{"just under the int64 limit", math.MaxInt64, 1 << 60, 7},
+		{"one read of the whole limit", math.MaxInt64, math.MaxInt64, 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			setFlag(t, readsize, tc.readsize)
+			s, err := planSchedule("big", tc.filesize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(s.Reads) != tc.want {
+				t.Fatalf("%d reads, want %d", len(s.Reads), tc.want)
+			}
+			checkReads(t, s)
+			last := s.Reads[len(s.Reads)-1]
+			if want := int64(tc.want-1) * tc.readsize; last.Offset != want {
+				t.Errorf("the last read is at %d, want %d", last.Offset, want)
+			}
+		})
+	}
+}
+
+func TestPlanSampleLargeFiles(t *testing.T) {
+	for _, filesize := range []int64{5 * tib, math.MaxInt64} {
+		for _, strategy := range []string{"uniform", "stratified", "random"} {
+			setFlag(t, readsize, 1<<18)
+			setFlag(t, sampleCount, 100)
+			setFlag(t, sampleStrategy, strategy)
+			setFlag(t, sampleSeed, 1)
+			s, err := planSchedule("big", filesize)
+			if err != nil {
+				t.Fatalf("%s at %d bytes: %v", strategy, filesize, err)
+			}
+			if len(s.Reads) != 100 {
+				t.Errorf("%s at %d bytes: %d reads, want 100", strategy, filesize, len(s.Reads))
+			}
+			checkReads(t, s)
+			if strategy != "stratified" {
+				continue
+			}
+			// Ten in each tenth, counted the way reportSampled
+			// counts them.
+			var tenths [10]int
+			for _, r := range s.Reads {
+				tenths[min(mulDiv(r.Offset, 10, filesize), 9)]++
+			}
+			if tenths != [10]int{10, 10, 10, 10, 10, 10, 10, 10, 10, 10} {
+				t.Errorf("stratified at %d bytes: %v reads in each tenth", filesize, tenths)
+			}
+		}
+	}
+}
+
+func TestPlanRangesAtTheLimit(t *testing.T) {
+	reads, err := planRanges(rangeList{{offset: math.MaxInt64 - 100, size: 100}}, math.MaxInt64)
+	if err != nil || len(reads) != 1 {
+		t.Fatalf("the last 100 bytes: %v, %v", reads, err)
+	}
+	if _, err := planRanges(rangeList{{offset: math.MaxInt64 - 100, size: 101}}, math.MaxInt64); err == nil {
+		t.Errorf("a range past the end worked")
+	}
+}
+
+func TestScheduleCheck(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		s    schedule
+		ok   bool
+	}{
+		{"5 TiB", schedule{FileSize: 5 * tib, Reads: []scheduledRead{{Offset: 5*tib - 1<<20, Size: 1 << 20}}}, true},
+		{"ending at the limit", schedule{FileSize: math.MaxInt64, Reads: []scheduledRead{{Offset: math.MaxInt64 - 10, Size: 10}}}, true},
+		{"past the limit", schedule{FileSize: math.MaxInt64, Reads: []scheduledRead{{Offset: math.MaxInt64 - 10, Size: 11}}}, false},
+		{"a negative offset", schedule{FileSize: 100, Reads: []scheduledRead{{Offset: -1, Size: 10}}}, false},
+		{"a negative size", schedule{FileSize: 100, Reads: []scheduledRead{{Offset: 10, Size: -10}}}, false},
+		{"a negative file size", schedule{FileSize: -1}, false},
+	} {
+		if err := tc.s.check(); (err == nil) != tc.ok {
+			t.Errorf("%s: check() = %v", tc.name, err)
+		}
+	}
+}
+
+func TestPlanScheduleSmallObjects(t *testing.T) {
+	setFlag(t, readsize, 256<<10)
+	for _, tc := range []struct {
+		name     string
+		filesize int64
+		want     []scheduledRead
+	}{
+		{"empty", 0, nil},
+		{"one byte", 1, []scheduledRead{{Worker: -1, Offset: 0, Size: 1}}},
+		{"smaller than a read", 100 << 10, []scheduledRead{{Worker: -1, Offset: 0, Size: 100 << 10}}},
+		{"exactly one read", 256 << 10, []scheduledRead{{Worker: -1, Offset: 0, Size: 256 << 10}}},
+		{"an exact multiple", 512 << 10, []scheduledRead{{Worker: -1, Offset: 0, Size: 256 << 10}, {Worker: -1, Offset: 256 << 10, Size: 256 << 10}}},
+		// The partial read at the end is left off.
+		{"a little over", 512<<10 + 1, []scheduledRead{{Worker: -1, Offset: 0, Size: 256 << 10}, {Worker: -1, Offset: 256 << 10, Size: 256 << 10}}},
+	} {
+		s, err := planSchedule("f", tc.filesize)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if !slices.Equal(s.Reads, tc.want) {
+			t.Errorf("%s: planned %+v, want %+v", tc.name, s.Reads, tc.want)
+		}
+	}
+}
+
+// Plan and read small objects from the fake server, the way a run
+// does.
+func TestReadSmallObjects(t *testing.T) {
+	setFlag(t, readsize, 256<<10)
+	setFlag(t, mode, "getobject")
+	objects := map[string][]byte{
+		"empty": {},
+		"small": bytes.Repeat([]byte("s"), 100<<10),
+		"exact": bytes.Repeat([]byte("e"), 512<<10),
+	}
+	client := fakeS3(t, objects)
+	backend, err := newBackend(*mode, client, defaultTarget(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range objects {
+		// A run stops here for the empty object.
+		discovery, err := discoverSize(context.Background(), nil, client, name, "head")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		filesize := discovery.Size
+		if filesize != int64(len(data)) {
+			t.Errorf("%s: the size is %d, want %d", name, filesize, len(data))
+		}
+		s, err := planSchedule(name, filesize)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		var total int64
+		for _, r := range s.Reads {
+			sample, err := readFrom(context.Background(), backend, name, r.Offset, r.Size, filesize)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if sample.Bytes != r.Size {
+				t.Errorf("%s: read %d bytes at %d, want %d", name, sample.Bytes, r.Offset, r.Size)
+			}
+			total += sample.Bytes
+		}
+		if total != filesize {
+			t.Errorf("%s: read %d bytes in all, want %d", name, total, filesize)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+// Package schema describes s3test's structured output, for scripts
+// that parse it and for s3test itself.  Every --json result
+// (including --diagnose's), every line of a --jsonl log or
+// --stream-fifo (including the ones fsrecord writes), every --plan and
+// --state-file, and every CSV file (--sweep-csv and `s3test analyze
+// --format=csv`) carries a schemaVersion of the form "MAJOR.MINOR".
+// With --target and --tenant, the --json result is an object holding
+// the version and a "targets" or "tenants" list; before 1.0 it was a
+// bare list.
+//
+// Adding a field bumps the minor version; a reader that understands
+// 1.0 can read 1.7 and ignore what it doesn't know.  Removing,
+// renaming, or changing the meaning of a field bumps the major
+// version, and readers should refuse a major version they don't
+// know, which is what Check is for.  Files written before there was a
+// schemaVersion are 1.0.
+//
+// The structs here are the v1 fields that scripts are expected to
+// rely on: the run's configuration, its summary, and each read.
+// s3test writes many more fields, under the same rules, that aren't
+// listed here.  Unmarshal a result into Result, or each line of a log
+// into Record.
+package schema
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The current version.  Keep Version in step with Major and Minor.
+const (
+	Major   = 1
+	Minor   = 1
+	Version = "1.1"
+)
+
+// Parse a schemaVersion.  An empty version is from before there was
+// one, which makes it 1.0.
+func Parse(v string) (major, minor int, err error) {
+	if v == "" {
+		return 1, 0, nil
+	}
+	a, b, ok := strings.Cut(v, ".")
+	if ok {
+		major, err = strconv.Atoi(a)
+	}
+	if ok && err == nil {
+		minor, err = strconv.Atoi(b)
+	}
+	if !ok || err != nil || major < 1 || minor < 0 {
+		return 0, 0, fmt.Errorf("bad schemaVersion %q", v)
+	}
+	return major, minor, nil
+}
+
+// VersionError is a schemaVersion that this package can't read.
+type VersionError struct {
+	Version string
+}
+
+func (e *VersionError) Error() string {
+	if _, _, err := Parse(e.Version); err != nil {
+		return err.Error()
+	}
+	return fmt.Sprintf("written with schema version %s, but this s3test only understands %d.x; use a newer s3test", e.Version, Major)
+}
+
+// Check returns a *VersionError if output with schemaVersion `v` can't
+// be read by this version of the package: if it's malformed, or from a
+// newer major version.
+func Check(v string) error {
+	major, _, err := Parse(v)
+	if err != nil || major > Major {
+		return &VersionError{Version: v}
+	}
+	return nil
+}
+
+// Latency summarizes read latencies.
+type Latency struct {
+	Count int           `json:"count"`
+	Min   time.Duration `json:"minNs"`
+	P50   time.Duration `json:"p50Ns"`
+	P90   time.Duration `json:"p90Ns"`
+	P99   time.Duration `json:"p99Ns"`
+	Max   time.Duration `json:"maxNs"`
+	// Since 1.1: the percentiles are from a sketch, and within 1%
+	// of the exact ones, because there were too many samples to sort.
+	Approximate bool `json:"approximate,omitempty"`
+}
+
+// Run describes a run's configuration.  It's the start of a --json
+// result, and the "run" record of a --jsonl log.
+type Run struct {
+	SchemaVersion string    `json:"schemaVersion"`
+	RunID         string    `json:"runId"`
+	Start         time.Time `json:"start"`
+	Endpoint      string    `json:"endpoint"`
+	Bucket        string    `json:"bucket"`
+	File          string    `json:"file"`
+	Mode          string    `json:"mode"`
+	Addressing    string    `json:"addressing"`
+	ReadSize      int64     `json:"readSize"`
+	FileSize      int64     `json:"fileSize"`
+	Pattern       string    `json:"pattern,omitempty"`
+	Concurrency   int       `json:"concurrency,omitempty"`
+	Cache         string    `json:"cache"`
+	ETag          string    `json:"etag,omitempty"`
+}
+
+// Sample is one read.
+type Sample struct {
+	Offset   int64         `json:"offset"`
+	Size     int64         `json:"size"`
+	Bytes    int64         `json:"bytes"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"durationNs"`
+	Err      string        `json:"error,omitempty"`
+	ErrPhase string        `json:"errorPhase,omitempty"`
+	File     string        `json:"file,omitempty"`
+	ReadID   string        `json:"readId"`
+	Worker   int           `json:"worker"`
+	Label    string        `json:"label,omitempty"`
+	Pass     int           `json:"pass,omitempty"`
+	Status   int           `json:"status,omitempty"`
+}
+
+// Result is a --json result.
+type Result struct {
+	Run
+	Bytes    int64         `json:"bytes"`
+	Duration time.Duration `json:"durationNs"` // not counting time spent paused
+	Paused   time.Duration `json:"pausedNs,omitempty"`
+	Mbps     float64       `json:"mbps"`
+	Errors   int           `json:"errors"`
+	Latency  Latency       `json:"latency"`
+	Samples  []*Sample     `json:"samples"`
+}
+
+// Record is one line of a --jsonl log: a "run" record, then "sample"
+// records, and an "end" record if the run finished.  Other types
+// ("pause", "resume", "health") may appear, and can be skipped.
+type Record struct {
+	SchemaVersion string  `json:"schemaVersion"`
+	Version       int     `json:"version"` // the major version, for readers from before schemaVersion
+	Type          string  `json:"type"`
+	Run           *Run    `json:"run,omitempty"`
+	Sample        *Sample `json:"sample,omitempty"`
+}
@@ -0,0 +1,152 @@
+package schema_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/scottlaird/s3test/schema"
+)
+
+func TestParse(t *testing.T) {
+	for _, tc := range []struct {
+		v            string
+		major, minor int
+		ok           bool
+	}{
+		{"", 1, 0, true},
+		{"1.0", 1, 0, true},
+		{"1.12", 1, 12, true},
+		{"2.0", 2, 0, true},
+		{"1", 0, 0, false},
+		{"0.9", 0, 0, false},
+		{"1.-1", 0, 0, false},
+		{"1.x", 0, 0, false},
+		{"v1.0", 0, 0, false},
+	} {
+		major, minor, err := schema.Parse(tc.v)
+		if (err == nil) != tc.ok || major != tc.major || minor != tc.minor {
+			t.Errorf("Parse(%q) = %d, %d, %v", tc.v, major, minor, err)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	for v, ok := range map[string]bool{"": true, "1.0": true, "1.99": true, "2.0": false, "banana": false} {
+		err := schema.Check(v)
+		var verr *schema.VersionError
+		if (err == nil) != ok || (err != nil && !errors.As(err, &verr)) {
+			t.Errorf("Check(%q) = %v", v, err)
+		}
+	}
+}
+
+// Golden results from each version, and from before there were
+// versions.  1.3 is a made-up future minor version with fields we
+// don't know about, which have to be ignored; 2.0 renamed a field,
+// and has to be refused.
+func TestResultFixtures(t *testing.T) {
+	for _, tc := range []struct {
+		file string
+		ok   bool
+	}{
+		{"testdata/result-pre.json", true},
+		{"testdata/result-1.0.json", true},
+		{"testdata/result-1.3.json", true},
+		{"testdata/result-2.0.json", false},
+	} {
+		b, err := os.ReadFile(tc.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var r schema.Result
+		if err := json.Unmarshal(b, &r); err != nil {
+			t.Fatalf("%s: %v", tc.file, err)
+		}
+		if err := schema.Check(r.SchemaVersion); (err == nil) != tc.ok {
+			t.Errorf("%s: Check(%q) = %v", tc.file, r.SchemaVersion, err)
+		}
+		if !tc.ok {
+			continue
+		}
+		if r.File != "small.bin" || r.ReadSize != 512<<10 || r.FileSize != 1<<20 || r.Bytes != 1<<20 {
+			t.Errorf("%s: read %s, %d bytes at a time, of %d, for %d", tc.file, r.File, r.ReadSize, r.FileSize, r.Bytes)
+		}
+		if r.Latency.Count != 2 || r.Latency.P50 <= 0 || r.Latency.Max < r.Latency.P50 {
+			t.Errorf("%s: latency %+v", tc.file, r.Latency)
+		}
+		if len(r.Samples) != 2 || r.Samples[1].Offset != 512<<10 || r.Samples[1].Bytes != 512<<10 || r.Samples[1].Duration <= 0 {
+			t.Errorf("%s: samples %+v", tc.file, r.Samples)
+		}
+	}
+}
+
+// Likewise for --jsonl logs, a line at a time.
+func TestRecordFixtures(t *testing.T) {
+	for _, tc := range []struct {
+		file  string
+		ok    bool
+		types []string
+	}{
+		{"testdata/log-pre.jsonl", true, []string{"run", "sample", "sample"}},
+		{"testdata/log-1.0.jsonl", true, []string{"run", "sample", "sample", "end"}},
+		{"testdata/log-1.3.jsonl", true, []string{"run", "sample", "checkpoint", "sample", "end"}},
+		{"testdata/log-2.0.jsonl", false, nil},
+	} {
+		f, err := os.Open(tc.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var types []string
+		var samples []*schema.Sample
+		var run *schema.Run
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var rec schema.Record
+			if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+				t.Fatalf("%s: %v", tc.file, err)
+			}
+			if err := schema.Check(rec.SchemaVersion); err != nil || rec.Version > schema.Major {
+				if tc.ok {
+					t.Errorf("%s: %q (version %d) refused: %v", tc.file, rec.SchemaVersion, rec.Version, err)
+				}
+				types = nil
+				break
+			}
+			types = append(types, rec.Type)
+			if rec.Run != nil {
+				run = rec.Run
+			}
+			if rec.Sample != nil {
+				samples = append(samples, rec.Sample)
+			}
+		}
+		f.Close()
+		if !tc.ok {
+			if types != nil {
+				t.Errorf("%s: read %q", tc.file, types)
+			}
+			continue
+		}
+		if len(types) != len(tc.types) {
+			t.Fatalf("%s: records %q, want %q", tc.file, types, tc.types)
+		}
+		for i := range types {
+			if types[i] != tc.types[i] {
+				t.Errorf("%s: records %q, want %q", tc.file, types, tc.types)
+				break
+			}
+		}
+		if run == nil || run.ReadSize != 512<<10 || run.Mode != "localfs" {
+			t.Errorf("%s: run %+v", tc.file, run)
+		}
+		for _, s := range samples {
+			if s.Bytes != 512<<10 || s.Start.Before(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)) {
+				t.Errorf("%s: sample %+v", tc.file, s)
+			}
+		}
+	}
+}
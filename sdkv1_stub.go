@@ -0,0 +1,12 @@
+//go:build !sdkv1
+
+package main
+
+// Without `-tags sdkv1`, --mode=getobject-v1 just explains how to get
+// it.
+
+import "errors"
+
+func newSDKV1Backend(t target, etag string) (Backend, error) {
+	return nil, errors.New("this s3test was built without --mode=getobject-v1; rebuild it with `go build -tags sdkv1`")
+}
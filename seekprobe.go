@@ -0,0 +1,96 @@
+package main
+
+// One suspicion about the s3fs ReadSeeker path is that Seek() itself
+// sends a GET, before Read() is ever called.  --seek-probe opens the
+// file through s3fs and performs a series of Seeks with no Reads in
+// between, and then a single Read, recording the HTTP requests that
+// each step sends.  SeekEnd is included because http.ServeContent uses
+// it to learn the size.
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/jszwec/s3fs/v2"
+)
+
+// seekProbeStep is one row of the --seek-probe table.
+type seekProbeStep struct {
+	name     string
+	requests []*recordedRequest
+	err      error
+}
+
+// Run the seek probe against `filename` and print the table.
+func runSeekProbe(fsys *s3fs.S3FS, filename string, filesize int64) error {
+	var steps []seekProbeStep
+	step := func(name string, op func() error) {
+		before := len(upstream.Requests())
+		err := op()
+		steps = append(steps, seekProbeStep{name: name, requests: upstream.Requests()[before:], err: err})
+	}
+
+	var f io.ReadSeeker
+	step("Open", func() error {
+		file, err := fsys.Open(filename)
+		if err != nil {
+			return err
+		}
+		rs, ok := file.(io.ReadSeeker)
+		if !ok {
+			return fmt.Errorf("s3fs file isn't an io.ReadSeeker")
+		}
+		f = rs
+		return nil
+	})
+	if f == nil {
+		return steps[0].err
+	}
+	defer f.(io.Closer).Close()
+
+	mid := filesize / 2
+	seek := func(offset int64, whence int) func() error {
+		return func() error {
+			_, err := f.Seek(offset, whence)
+			return err
+		}
+	}
+	step("Seek(0, SeekStart)", seek(0, io.SeekStart))
+	step(fmt.Sprintf("Seek(%d, SeekStart)", mid), seek(mid, io.SeekStart))
+	step("Seek(0, SeekEnd)", seek(0, io.SeekEnd))
+	step(fmt.Sprintf("Seek(%d, SeekStart)", mid), seek(mid, io.SeekStart))
+	step(fmt.Sprintf("Read(%d)", *readsize), func() error {
+		_, err := io.ReadFull(f, make([]byte, min(*readsize, filesize-mid)))
+		return err
+	})
+
+	// Bodies may still be draining after the step that started them,
+	// so count received bytes at the end.
+	fmt.Printf("%-24s %8s %16s %16s  %s\n", "operation", "requests", "bytes requested", "bytes received", "ranges")
+	for _, s := range steps {
+		var requested, received int64
+		var ranges []string
+		for _, r := range s.requests {
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				continue
+			}
+			if r.Method == http.MethodGet {
+				if br, ok := parseRangeHeader(r.Range, filesize); ok {
+					requested += rangeBytes(br)
+				}
+			}
+			received += r.Received()
+			desc := r.Method
+			if r.Range != "" {
+				desc += " " + r.Range
+			}
+			ranges = append(ranges, desc)
+		}
+		fmt.Printf("%-24s %8d %16d %16d  %v\n", s.name, len(s.requests), requested, received, ranges)
+		if s.err != nil {
+			fmt.Printf("  error: %v\n", s.err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package main
+
+// After a bad run, the next step is always grepping the filer's logs
+// for the slowest reads' timestamps.  --fetch-server-logs=N does that
+// for the N slowest reads: for each one, it asks a log source for the
+// lines from the read's time window (padded by --server-log-slack),
+// and attaches them to the read's sample in the JSON output.  If any
+// of the lines mention the read's ID from the correlation header,
+// only those are kept.
+//
+// The log source is either an HTTP URL (--server-log-url) or a shell
+// command (--server-log-command, usually ssh), with these variables:
+//
+//	{start} {end}          the window, in RFC 3339
+//	{startunix} {endunix}  the window, in Unix seconds
+//	{readid}               the read's correlation ID
+//
+// so, for example,
+//
+//	--server-log-command "ssh filer1 journalctl -u weed-filer -o short-iso --since @{startunix} --until @{endunix}"
+//
+// This runs after the benchmark is over, and failures are only
+// warnings.
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// How long to wait for each read's logs.
+	serverLogTimeout = 30 * time.Second
+
+	// Keep at most this many lines per read.
+	maxServerLogLines = 200
+)
+
+// Fetch the server logs for the `n` slowest successful reads in
+// `samples`, and attach them.
+func fetchServerLogs(ctx context.Context, samples []*Sample, n int) {
+	var slow []*Sample
+	for _, s := range samples {
+		if s.Err == "" {
+			slow = append(slow, s)
+		}
+	}
+	slices.SortFunc(slow, func(a, b *Sample) int { return cmp.Compare(b.Duration, a.Duration) })
+	slow = slow[:min(n, len(slow))]
+
+	fmt.Printf("Fetching server logs for the %d slowest reads\n", len(slow))
+	for _, s := range slow {
+		lines, err := serverLogLines(ctx, s)
+		if err != nil {
+			fmt.Printf("WARNING: unable to fetch server logs for the read at offset %d: %v\n", s.Offset, err)
+			continue
+		}
+		s.ServerLogs = matchReadID(lines, s.ReadID)
+		fmt.Printf("  offset %d (%.3fs, read ID %s): %d lines\n", s.Offset, s.Duration.Seconds(), s.ReadID, len(s.ServerLogs))
+	}
+}
+
+// Ask the log source for the lines around `s`.
+func serverLogLines(ctx context.Context, s *Sample) ([]string, error) {
+	start := s.Start.Add(-*serverLogSlack)
+	end := s.Start.Add(s.Duration + *serverLogSlack)
+	vars := map[string]string{
+		"start":     start.Format(time.RFC3339),
+		"end":       end.Format(time.RFC3339),
+		"startunix": strconv.FormatInt(start.Unix(), 10),
+		"endunix":   strconv.FormatInt(end.Unix()+1, 10),
+		"readid":    s.ReadID,
+	}
+	ctx, cancel := context.WithTimeout(ctx, serverLogTimeout)
+	defer cancel()
+
+	var out []byte
+	if *serverLogURL != "" {
+		for k, v := range vars {
+			vars[k] = url.QueryEscape(v)
+		}
+		u, err := expandTemplate(*serverLogURL, vars)
+		if err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := statusClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("%s: HTTP %d", u, resp.StatusCode)
+		}
+		if out, err = io.ReadAll(io.LimitReader(resp.Body, 16<<20)); err != nil {
+			return nil, err
+		}
+	} else {
+		command, err := expandTemplate(*serverLogCommand, vars)
+		if err != nil {
+			return nil, err
+		}
+		cmd := exec.CommandContext(ctx, "sh", "-c", command)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		if out, err = cmd.Output(); err != nil {
+			if msg := strings.TrimSpace(stderr.String()); msg != "" {
+				err = fmt.Errorf("%v: %s", err, msg)
+			}
+			return nil, fmt.Errorf("%s: %v", command, err)
+		}
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// Return the lines that mention `readID`, if any do, or else all of
+// them, keeping at most maxServerLogLines either way.
+func matchReadID(lines []string, readID string) []string {
+	var matched []string
+	if readID != "" {
+		for _, line := range lines {
+			if strings.Contains(line, readID) {
+				matched = append(matched, line)
+			}
+		}
+	}
+	if len(matched) == 0 {
+		matched = lines
+	}
+	return matched[:min(len(matched), maxServerLogLines)]
+}
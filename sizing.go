@@ -0,0 +1,116 @@
+package main
+
+// fs.Stat() can take seconds on an overloaded filer, and it warms the
+// filer's metadata caches, which pollutes --cold-cache measurements.
+// So there are a few ways of learning how big the file is:
+//
+//   - stat: fs.Stat() via s3fs (the default)
+//   - head: a HeadObject call via the SDK
+//   - get-range: a 0-0 ranged GetObject, reading the size from
+//     Content-Range.  This exercises a different server path than
+//     HEAD.
+//   - --filesize: just trust the user and don't ask the server.
+//     --no-stat goes further and skips fetching the ETag as well; see
+//     preflight.go.
+//
+// With --mode=localfs, we always just os.Stat() the file, and with
+// --mode=filer-grpc we ask the filer.
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/jszwec/s3fs/v2"
+)
+
+// sizeDiscovery records how we learned the file's size.
+type sizeDiscovery struct {
+	Method   string        `json:"method"`
+	Size     int64         `json:"size"`
+	Duration time.Duration `json:"durationNs"`
+}
+
+// Figure out how big `filename` is, using --filesize if it's set and
+// `method` otherwise.
+func discoverSize(ctx context.Context, fs *s3fs.S3FS, client *s3.Client, filename string, method string) (*sizeDiscovery, error) {
+	if *filesizeFlag >= 0 {
+		return &sizeDiscovery{Method: "flag", Size: *filesizeFlag}, nil
+	}
+	if *mode == "localfs" {
+		start := time.Now()
+		info, err := os.Stat(filename)
+		if err != nil {
+			return nil, err
+		}
+		return &sizeDiscovery{Method: "local stat", Size: info.Size(), Duration: time.Since(start)}, nil
+	}
+	if *mode == "filer-grpc" {
+		start := time.Now()
+		size, err := filerGRPCSize(ctx, *filerGRPCAddr, *filerBucketDir, *bucket, filename)
+		if err != nil {
+			return nil, err
+		}
+		return &sizeDiscovery{Method: "filer lookup", Size: size, Duration: time.Since(start)}, nil
+	}
+
+	d := &sizeDiscovery{Method: method}
+	start := time.Now()
+
+	switch method {
+	case "stat":
+		fileinfo, err := fs.Stat(filename)
+		if err != nil {
+			return nil, err
+		}
+		d.Size = fileinfo.Size()
+	case "head":
+		head, err := client.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket: bucket,
+			Key:    aws.String(filename),
+		})
+		if err != nil {
+			return nil, err
+		}
+		d.Size = aws.ToInt64(head.ContentLength)
+	case "get-range":
+		out, err := client.GetObject(ctx, &s3.GetObjectInput{
+			Bucket: bucket,
+			Key:    aws.String(filename),
+			Range:  aws.String("bytes=0-0"),
+		})
+		if err != nil {
+			return nil, err
+		}
+		out.Body.Close()
+		total, ok := contentRangeSize(aws.ToString(out.ContentRange))
+		if !ok {
+			return nil, fmt.Errorf("unable to get size from Content-Range %q", aws.ToString(out.ContentRange))
+		}
+		d.Size = total
+	default:
+		return nil, fmt.Errorf("unknown --size-from %q; use stat, head, or get-range", method)
+	}
+
+	d.Duration = time.Since(start)
+	return d, nil
+}
+
+// Return the total object size from a Content-Range header like
+// "bytes 0-0/1234".
+func contentRangeSize(header string) (int64, bool) {
+	_, total, found := strings.Cut(header, "/")
+	if !found || total == "*" {
+		return 0, false
+	}
+	size, err := strconv.ParseInt(total, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return size, true
+}
@@ -0,0 +1,104 @@
+package main
+
+// A long run at high concurrency can record tens of millions of
+// reads, and computeLatencyStats used to copy and sort every latency
+// to find three percentiles: hundreds of megabytes and several
+// seconds, at the end of the run, or every time someone asks a
+// --control-socket for its status.  So past exactPercentileLimit
+// latencies, we put them in a latencySketch instead, which takes one
+// pass and a few kilobytes, and whose percentiles are within
+// sketchAccuracy (1%) of the exact ones.  The count, min, and max are
+// still exact, and the summary and --json say when the percentiles
+// aren't.  --exact-percentiles sorts them all regardless.
+//
+// The sketch is a histogram with logarithmic buckets, in the style of
+// DDSketch: bucket i holds the latencies in (γ^(i-1), γ^i], where
+// γ = (1+α)/(1-α), and a percentile that falls in bucket i is reported
+// as 2γ^i/(γ+1), which is within a fraction α of anything in the
+// bucket.  Latencies are whole nanoseconds, so the buckets start at
+// 1ns, and an hour is around 1,450 buckets.
+
+import (
+	"math"
+	"time"
+)
+
+// Above this many latencies, computeLatencyStats uses a latencySketch
+// unless --exact-percentiles is set.
+const exactPercentileLimit = 1 << 20
+
+// The sketch's percentiles are within this fraction of the exact ones.
+const sketchAccuracy = 0.01
+
+var (
+	sketchGamma    = (1 + sketchAccuracy) / (1 - sketchAccuracy)
+	sketchLogGamma = math.Log(sketchGamma)
+)
+
+// latencySketch approximates the distribution of latencies added to
+// it.
+type latencySketch struct {
+	count    int
+	zero     int   // latencies of 0 or less
+	buckets  []int // see above
+	min, max time.Duration
+}
+
+// Add `d` to the sketch.
+func (s *latencySketch) add(d time.Duration) {
+	if s.count == 0 || d < s.min {
+		s.min = d
+	}
+	if s.count == 0 || d > s.max {
+		s.max = d
+	}
+	s.count++
+	if d <= 0 {
+		s.zero++
+		return
+	}
+	i := int(math.Ceil(math.Log(float64(d)) / sketchLogGamma))
+	if i >= len(s.buckets) {
+		s.buckets = append(s.buckets, make([]int, i+1-len(s.buckets))...)
+	}
+	s.buckets[i]++
+}
+
+// Return the p'th percentile, using the nearest-rank method like
+// percentile().
+func (s *latencySketch) percentile(p float64) time.Duration {
+	if s.count == 0 {
+		return 0
+	}
+	rank := max(0, min(int(p/100*float64(s.count)+0.999999)-1, s.count-1))
+	seen := s.zero
+	if rank < seen {
+		return s.min
+	}
+	for i, n := range s.buckets {
+		seen += n
+		if rank < seen {
+			d := time.Duration(2 * math.Pow(sketchGamma, float64(i)) / (sketchGamma + 1))
+			// The exact answer is in [min, max], so this can
+			// only make it closer.
+			return max(s.min, min(d, s.max))
+		}
+	}
+	return s.max
+}
+
+// Summarize the sketch.
+func (s *latencySketch) stats() latencyStats {
+	if s.count == 0 {
+		return latencyStats{}
+	}
+	return latencyStats{
+		Count:       s.count,
+		Min:         s.min,
+		P50:         s.percentile(50),
+		P90:         s.percentile(90),
+		P99:         s.percentile(99),
+		Max:         s.max,
+		Approximate: true,
+	}
+}
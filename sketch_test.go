@@ -0,0 +1,130 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Within sketchAccuracy of `exact`, give or take a nanosecond of
+// rounding.
+func closeEnough(got, exact time.Duration) bool {
+	return math.Abs(float64(got-exact)) <= sketchAccuracy*float64(exact)+1
+}
+
+// The sketch's percentiles are within sketchAccuracy of the exact ones
+// on distributions like the ones we see, and some we hope not to.
+func TestSketchAccuracy(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	const n = 200000
+	for _, tc := range []struct {
+		name string
+		next func() time.Duration
+	}{
+		{"uniform", func() time.Duration { return time.Duration(rng.Int64N(int64(time.Second))) }},
+		{"exponential", func() time.Duration { return time.Duration(rng.ExpFloat64() * float64(20*time.Millisecond)) }},
+		{"lognormal", func() time.Duration {
+			return time.Duration(math.Exp(rng.NormFloat64()*1.5) * float64(5*time.Millisecond))
+		}},
+		// Cache hits and misses.
+		{"bimodal", func() time.Duration {
+			if rng.IntN(10) == 0 {
+				return 300*time.Millisecond + time.Duration(rng.Int64N(int64(100*time.Millisecond)))
+			}
+			return time.Millisecond + time.Duration(rng.Int64N(int64(time.Millisecond)))
+		}},
+		// A few stalls a hundred times slower than the rest.
+		{"long tail", func() time.Duration {
+			if rng.IntN(200) == 0 {
+				return 10*time.Second + time.Duration(rng.Int64N(int64(50*time.Second)))
+			}
+			return time.Duration(rng.Int64N(int64(100 * time.Millisecond)))
+		}},
+		{"constant", func() time.Duration { return 42 * time.Millisecond }},
+		{"tiny", func() time.Duration { return time.Duration(rng.Int64N(5)) }},
+	} {
+		var s latencySketch
+		durations := make([]time.Duration, n)
+		for i := range durations {
+			durations[i] = tc.next()
+			s.add(durations[i])
+		}
+		slices.Sort(durations)
+		got := s.stats()
+		if got.Count != n || got.Min != durations[0] || got.Max != durations[n-1] || !got.Approximate {
+			t.Errorf("%s: count %d, min %v, max %v, approximate %v; want %d, %v, %v, true",
+				tc.name, got.Count, got.Min, got.Max, got.Approximate, n, durations[0], durations[n-1])
+		}
+		for _, p := range []float64{1, 10, 25, 50, 75, 90, 99, 99.9, 100} {
+			if got, exact := s.percentile(p), percentile(durations, p); !closeEnough(got, exact) {
+				t.Errorf("%s: p%g is %v, exact %v", tc.name, p, got, exact)
+			}
+		}
+	}
+}
+
+// A handful of latencies, including a zero.
+func TestSketchSmall(t *testing.T) {
+	var s latencySketch
+	if got := s.stats(); got != (latencyStats{}) {
+		t.Errorf("empty sketch: %+v", got)
+	}
+	for _, d := range []time.Duration{0, 3 * time.Millisecond, time.Millisecond, 2 * time.Millisecond} {
+		s.add(d)
+	}
+	for p, exact := range map[float64]time.Duration{0: 0, 25: 0, 50: time.Millisecond, 75: 2 * time.Millisecond, 100: 3 * time.Millisecond} {
+		if got := s.percentile(p); !closeEnough(got, exact) {
+			t.Errorf("p%g is %v, want %v", p, got, exact)
+		}
+	}
+}
+
+// computeLatencyStats sorts up to exactPercentileLimit latencies, uses
+// the sketch past that, and always sorts with --exact-percentiles.
+func TestComputeLatencyStatsSwitches(t *testing.T) {
+	rng := rand.New(rand.NewPCG(3, 4))
+	durations := make([]time.Duration, exactPercentileLimit+1)
+	for i := range durations {
+		durations[i] = time.Duration(rng.ExpFloat64() * float64(10*time.Millisecond))
+	}
+	sorted := slices.Sorted(slices.Values(durations))
+	exact := latencyStats{
+		Count: len(sorted),
+		Min:   sorted[0],
+		P50:   percentile(sorted, 50),
+		P90:   percentile(sorted, 90),
+		P99:   percentile(sorted, 99),
+		Max:   sorted[len(sorted)-1],
+	}
+
+	got := computeLatencyStats(durations)
+	if !got.Approximate || got.Count != exact.Count || got.Min != exact.Min || got.Max != exact.Max {
+		t.Errorf("%d latencies: %+v, want approximate stats like %+v", len(durations), got, exact)
+	}
+	for _, c := range []struct {
+		name       string
+		got, exact time.Duration
+	}{{"p50", got.P50, exact.P50}, {"p90", got.P90, exact.P90}, {"p99", got.P99, exact.P99}} {
+		if !closeEnough(c.got, c.exact) {
+			t.Errorf("%s is %v, exact %v", c.name, c.got, c.exact)
+		}
+	}
+	if !strings.Contains(got.String(), "within 1%") {
+		t.Errorf("the summary doesn't say the percentiles are approximate: %s", got)
+	}
+
+	setFlag(t, exactPercentiles, true)
+	if got := computeLatencyStats(durations); got != exact {
+		t.Errorf("--exact-percentiles: %+v, want %+v", got, exact)
+	}
+	setFlag(t, exactPercentiles, false)
+	if got := computeLatencyStats(durations[:exactPercentileLimit]); got.Approximate {
+		t.Errorf("%d latencies are approximate", exactPercentileLimit)
+	}
+	if slices.IsSorted(durations) {
+		t.Errorf("computeLatencyStats sorted its argument")
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+// When one read out of thousands takes 45 seconds, the one-line
+// summary doesn't say why, and turning on tracing for the whole run
+// to find out is a lot of noise.  --slow-read-threshold prints
+// everything we know about any read that takes longer than it, as soon
+// as it finishes: its phases, the SDK's attempts and retries, and for
+// every request it made, the Range sent, the connection and whether it
+// was reused, how long the headers and body took, and the response
+// headers.  That's what the server's maintainers will ask for.
+// --max-slow-dumps stops a completely melted server from filling the
+// disk with them.
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// slowReadLog counts slow reads and how many of them were dumped.
+type slowReadLog struct {
+	mu     sync.Mutex
+	slow   int
+	dumped int
+}
+
+var slowReads = &slowReadLog{}
+
+// Set to print the same record for every read, slow or not; see
+// `trace on` in repl.go.
+var traceReads atomic.Bool
+
+// Whether the transport should keep response headers for the dumps.
+func keepResponseHeaders() bool {
+	return *slowReadThreshold > 0 || traceReads.Load()
+}
+
+// Print a detailed record of `sample` if we're tracing every read, or
+// if it was slow enough and we haven't already printed too many.
+func (l *slowReadLog) check(sample *Sample, requests []*recordedRequest) {
+	if traceReads.Load() {
+		var out strings.Builder
+		fmt.Fprintf(&out, "Trace: %d of %d bytes at offset %d in %.3fs, read ID %s\n",
+			sample.Bytes, sample.Size, sample.Offset, sample.Duration.Seconds(), sample.ReadID)
+		writeReadDetail(&out, sample, requests)
+		fmt.Print(out.String())
+	}
+	if *slowReadThreshold <= 0 || sample.Duration < *slowReadThreshold {
+		return
+	}
+	l.mu.Lock()
+	l.slow++
+	dump := l.dumped < *maxSlowDumps
+	if dump {
+		l.dumped++
+	}
+	last := l.dumped == *maxSlowDumps
+	l.mu.Unlock()
+	if !dump {
+		return
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "Slow read: %d of %d bytes at offset %d in %.3fs, read ID %s (over --slow-read-threshold=%s)\n",
+		sample.Bytes, sample.Size, sample.Offset, sample.Duration.Seconds(), sample.ReadID, *slowReadThreshold)
+	writeReadDetail(&out, sample, requests)
+	if last {
+		fmt.Fprintf(&out, "Not printing any more slow reads (--max-slow-dumps=%d)\n", *maxSlowDumps)
+	}
+	fmt.Print(out.String())
+}
+
+// Write what we know about the read of `sample`, which made
+// `requests`.
+func writeReadDetail(out *strings.Builder, sample *Sample, requests []*recordedRequest) {
+	if sample.Err != "" {
+		fmt.Fprintf(out, "  error: %s\n", sample.Err)
+	}
+	if sample.GateWait > 0 {
+		fmt.Fprintf(out, "  waited %.3fs for --max-inflight-bytes first\n", sample.GateWait.Seconds())
+	}
+	if len(sample.Profiles) > 0 {
+		fmt.Fprintf(out, "  profiled to %s\n", strings.Join(sample.Profiles, " and "))
+	}
+	for _, p := range sample.Phases {
+		fmt.Fprintf(out, "  phase %s: %.3fs\n", p.Name, p.Duration.Seconds())
+	}
+	for _, op := range sample.Ops {
+		fmt.Fprintf(out, "  %s: %d attempts\n", op.Name, len(op.Attempts))
+		for i, a := range op.Attempts {
+			fmt.Fprintf(out, "    attempt %d: status %d in %.3fs", i+1, a.StatusCode, a.Duration.Seconds())
+			if a.RetryAfter > 0 {
+				fmt.Fprintf(out, ", Retry-After %s", a.RetryAfter)
+			}
+			if a.Err != "" {
+				fmt.Fprintf(out, ", error %s", a.Err)
+			}
+			fmt.Fprintf(out, "\n")
+		}
+	}
+	for i, req := range requests {
+		writeRequestDetail(out, i+1, req)
+	}
+}
+
+// Write what we know about one request made by a slow read.
+func writeRequestDetail(out *strings.Builder, n int, req *recordedRequest) {
+	rangeHeader := req.Range
+	if rangeHeader == "" {
+		rangeHeader = "none"
+	}
+	reused := "new"
+	if req.connReused {
+		reused = "reused"
+	}
+	fmt.Fprintf(out, "  request %d: %s %s, Range %s, status %d, conn %d (%s), %d bytes received\n",
+		n, req.Method, req.Path, rangeHeader, req.Status, req.connID, reused, req.Received())
+	headersAt, doneAt := req.timestamps()
+	if !headersAt.IsZero() {
+		fmt.Fprintf(out, "    headers after %.3fs", headersAt.Sub(req.Start).Seconds())
+		if !doneAt.IsZero() {
+			fmt.Fprintf(out, ", body took %.3fs", doneAt.Sub(headersAt).Seconds())
+		} else {
+			fmt.Fprintf(out, ", body unfinished after %.3fs", time.Since(headersAt).Seconds())
+		}
+		fmt.Fprintf(out, "\n")
+	}
+	names := make([]string, 0, len(req.header))
+	for name := range req.header {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		for _, v := range req.header[name] {
+			fmt.Fprintf(out, "    %s: %s\n", name, v)
+		}
+	}
+}
+
+// Print how many reads were slow, if we were looking.
+func (l *slowReadLog) report() {
+	if *slowReadThreshold <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Printf("Slow reads: %d took longer than %s, %d printed in full\n", l.slow, *slowReadThreshold, l.dumped)
+}
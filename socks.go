@@ -0,0 +1,336 @@
+package main
+
+// Some clusters are only reachable through a SOCKS5 jump host, and
+// running s3test on a box inside the network means doing without
+// the usual tools.  --socks5=[user:password@]host:port sends every
+// connection the benchmark's transport makes (and --mode=filer-grpc's
+// connection to the filer) through the proxy.  Hostnames go to the
+// proxy unresolved, since it's usually the only thing that can
+// resolve them.
+//
+// The proxy is an extra hop, so results through it shouldn't be
+// compared naively with direct ones: the banner, the results, and
+// analyze's comparability check all record it, and the summary breaks
+// each new connection's setup time into the connection to the proxy
+// and the proxy's handshake (which includes its own connection to the
+// endpoint).  TCP_INFO only sees the hop to the proxy.
+//
+// When a connection fails, the error says which hop it was: we
+// couldn't reach the proxy, or the proxy couldn't reach the endpoint.
+// The preflight check turns those into exit statuses 18 and 19.
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// socksProxy is the --socks5 proxy, and the setup times of the
+// connections made through it.
+type socksProxy struct {
+	Addr     string
+	User     string
+	password string
+
+	mu         sync.Mutex
+	connects   []time.Duration // to the proxy
+	handshakes []time.Duration // the SOCKS5 exchange, including the proxy's connection onward
+	failures   int
+}
+
+// socksReport goes in the results.
+type socksReport struct {
+	Proxy       string       `json:"proxy"`
+	Connections int          `json:"connections"`
+	Failures    int          `json:"failures,omitempty"`
+	Connect     latencyStats `json:"connect"`
+	Handshake   latencyStats `json:"handshake"`
+}
+
+// The --socks5 proxy, if any.
+var socks *socksProxy
+
+// Errors that say which hop failed.
+type (
+	// We couldn't connect to the proxy, or it doesn't speak SOCKS5
+	// (or accept our credentials).
+	socksProxyError struct {
+		proxy string
+		err   error
+	}
+	// The proxy couldn't (or wouldn't) connect to the target.
+	socksTargetError struct {
+		proxy, target string
+		reply         byte
+	}
+)
+
+func (e *socksProxyError) Error() string {
+	return fmt.Sprintf("can't use the SOCKS5 proxy %s: %v", e.proxy, e.err)
+}
+
+func (e *socksProxyError) Unwrap() error {
+	return e.err
+}
+
+// Reply codes from RFC 1928.
+var socksReplies = map[byte]string{
+	1: "general failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+func (e *socksTargetError) Error() string {
+	reason, ok := socksReplies[e.reply]
+	if !ok {
+		reason = fmt.Sprintf("reply code %d", e.reply)
+	}
+	return fmt.Sprintf("the SOCKS5 proxy %s can't reach %s: %s", e.proxy, e.target, reason)
+}
+
+// Parse --socks5's "[user:password@]host:port".
+func parseSOCKS5(s string) (*socksProxy, error) {
+	p := &socksProxy{Addr: s}
+	if i := strings.LastIndex(s, "@"); i >= 0 {
+		p.Addr = s[i+1:]
+		var ok bool
+		p.User, p.password, ok = strings.Cut(s[:i], ":")
+		if !ok || p.User == "" || len(p.User) > 255 || len(p.password) > 255 {
+			return nil, fmt.Errorf("want --socks5=user:password@host:port, with each up to 255 bytes")
+		}
+	}
+	if _, _, err := net.SplitHostPort(p.Addr); err != nil {
+		return nil, fmt.Errorf("bad --socks5 address %q: %v", p.Addr, err)
+	}
+	return p, nil
+}
+
+// Describe the proxy for the banner and the results, without the
+// credentials.
+func (p *socksProxy) String() string {
+	if p.User != "" {
+		return "socks5://" + p.Addr + " (authenticated)"
+	}
+	return "socks5://" + p.Addr
+}
+
+// Set up the transport for --socks5.  This goes before --resolve, so
+// that its overrides pick the address we ask the proxy for.
+func configureSOCKS5(spec string) error {
+	if spec == "" {
+		return nil
+	}
+	p, err := parseSOCKS5(spec)
+	if err != nil {
+		return err
+	}
+	inner, ok := upstream.inner.(*http.Transport)
+	if !ok {
+		return fmt.Errorf("can't apply --socks5 to a %T", upstream.inner)
+	}
+	t := inner.Clone()
+	dial := t.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return p.dial(ctx, dial, addr)
+	}
+	// The SDK's transport uses the environment's HTTP proxy, if
+	// any; we only want one proxy.
+	t.Proxy = nil
+	upstream.inner = t
+	socks = p
+	return nil
+}
+
+// Connect to `addr` through the proxy, which we connect to with
+// `dial`.
+func (p *socksProxy) dial(ctx context.Context, dial func(context.Context, string, string) (net.Conn, error), addr string) (net.Conn, error) {
+	start := time.Now()
+	conn, err := dial(ctx, "tcp", p.Addr)
+	if err != nil {
+		p.fail()
+		return nil, &socksProxyError{p.Addr, err}
+	}
+	connected := time.Now()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	// Closing the connection unblocks the handshake if ctx is
+	// cancelled.
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	err = p.handshake(conn, addr)
+	if !stop() && err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		conn.Close()
+		p.fail()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	p.mu.Lock()
+	p.connects = append(p.connects, connected.Sub(start))
+	p.handshakes = append(p.handshakes, time.Since(connected))
+	p.mu.Unlock()
+	return conn, nil
+}
+
+func (p *socksProxy) fail() {
+	p.mu.Lock()
+	p.failures++
+	p.mu.Unlock()
+}
+
+// Ask the proxy on `conn` to connect to `addr`, following RFC 1928,
+// and RFC 1929 for the username and password.
+func (p *socksProxy) handshake(conn net.Conn, addr string) error {
+	proxyErr := func(format string, args ...any) error {
+		return &socksProxyError{p.Addr, fmt.Errorf(format, args...)}
+	}
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := net.LookupPort("tcp", portStr)
+	if err != nil {
+		return err
+	}
+
+	methods := []byte{0x00} // no authentication
+	if p.User != "" {
+		methods = []byte{0x02} // username and password
+	}
+	if _, err := conn.Write(append([]byte{5, byte(len(methods))}, methods...)); err != nil {
+		return proxyErr("%v", err)
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return proxyErr("%v", err)
+	}
+	if reply[0] != 5 {
+		return proxyErr("it doesn't speak SOCKS5 (version %d)", reply[0])
+	}
+	switch reply[1] {
+	case 0x00:
+	case 0x02:
+		auth := []byte{1, byte(len(p.User))}
+		auth = append(auth, p.User...)
+		auth = append(auth, byte(len(p.password)))
+		auth = append(auth, p.password...)
+		if _, err := conn.Write(auth); err != nil {
+			return proxyErr("%v", err)
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return proxyErr("%v", err)
+		}
+		if reply[1] != 0 {
+			return proxyErr("it rejected the username and password in --socks5")
+		}
+	default:
+		return proxyErr("it won't accept our authentication; check the user:password@ in --socks5")
+	}
+
+	req := []byte{5, 1, 0} // CONNECT
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return fmt.Errorf("hostname %q is too long for SOCKS5", host)
+		}
+		req = append(req, 3, byte(len(host)))
+		req = append(req, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(append(req, 1), ip4...)
+	} else {
+		req = append(append(req, 4), ip...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	if _, err := conn.Write(req); err != nil {
+		return proxyErr("%v", err)
+	}
+
+	// The reply ends with the proxy's bound address, which we
+	// don't need.
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return proxyErr("%v", err)
+	}
+	if head[1] != 0 {
+		return &socksTargetError{p.Addr, addr, head[1]}
+	}
+	var n int
+	switch head[3] {
+	case 1:
+		n = 4
+	case 4:
+		n = 16
+	case 3:
+		l := make([]byte, 1)
+		if _, err := io.ReadFull(conn, l); err != nil {
+			return proxyErr("%v", err)
+		}
+		n = int(l[0])
+	default:
+		return proxyErr("unknown address type %d in its reply", head[3])
+	}
+	if _, err := io.ReadFull(conn, make([]byte, n+2)); err != nil {
+		return proxyErr("%v", err)
+	}
+	return nil
+}
+
+// Summarize the connections made through the proxy.
+func (p *socksProxy) report() *socksReport {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return &socksReport{
+		Proxy:       p.String(),
+		Connections: len(p.connects),
+		Failures:    p.failures,
+		Connect:     computeLatencyStats(p.connects),
+		Handshake:   computeLatencyStats(p.handshakes),
+	}
+}
+
+func (r *socksReport) print() {
+	fmt.Printf("Connections through SOCKS5 proxy %s: %d made, %d failed\n", r.Proxy, r.Connections, r.Failures)
+	if r.Connections > 0 {
+		fmt.Printf("  connecting to the proxy: %s\n", r.Connect)
+		fmt.Printf("  proxy handshake, including its connection onward: %s\n", r.Handshake)
+		fmt.Printf("  TCP stats above are for the hop to the proxy\n")
+	}
+}
+
+// Return the proxy's description, for the run's environment, or "".
+func socksDescription() string {
+	if socks == nil {
+		return ""
+	}
+	return socks.String()
+}
+
+// Return `proxy`, or "no proxy" if it's empty.
+func orDirect(proxy string) string {
+	if proxy == "" {
+		return "no proxy"
+	}
+	return proxy
+}
+
+// A gRPC dialer for --socks5, for --mode=filer-grpc.
+func socksGRPCDialer(ctx context.Context, addr string) (net.Conn, error) {
+	return socks.dial(ctx, (&net.Dialer{}).DialContext, addr)
+}
@@ -0,0 +1,138 @@
+package main
+
+// Reading every block of a 500 GB object takes too long to be a
+// routine benchmark.  --sample=N reads just N blocks of --readsize
+// bytes, spread across the whole file, and reports latency by which
+// tenth of the file each read came from, so we can still see whether
+// the start, the end, or some stretch in the middle is slow.
+//
+// --sample-strategy picks the blocks:
+//
+//   - uniform: evenly spaced, read in order
+//   - stratified: N/10 random blocks from each tenth of the file, read
+//     in order
+//   - random: N distinct random blocks, read in random order
+//
+// The random strategies use a seeded generator, and the seed is saved
+// in the schedule like --jitter's.  Since only a sliver of the file is
+// read, the throughput of a sampled run isn't sustained throughput,
+// and the summary says so loudly.
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"time"
+)
+
+// sampling records how a sparse schedule picked its reads.
+type sampling struct {
+	Count    int    `json:"count"`
+	Strategy string `json:"strategy"`
+	Seed     uint64 `json:"seed,omitempty"`
+}
+
+func (s *sampling) String() string {
+	if s.Seed != 0 {
+		return fmt.Sprintf("%d %s samples (seed %d)", s.Count, s.Strategy, s.Seed)
+	}
+	return fmt.Sprintf("%d %s samples", s.Count, s.Strategy)
+}
+
+// Return the sampling settings from the flags, or nil without --sample.
+func samplingFromFlags() *sampling {
+	if *sampleCount <= 0 {
+		return nil
+	}
+	s := &sampling{Count: *sampleCount, Strategy: *sampleStrategy, Seed: *sampleSeed}
+	if s.Strategy != "uniform" && s.Seed == 0 {
+		s.Seed = rand.Uint64()
+	}
+	return s
+}
+
+// Pick the blocks of `readSize` bytes to read from a `filesize` byte
+// file.
+func planSample(s *sampling, filesize, readSize int64) ([]scheduledRead, error) {
+	blocks := filesize / readSize
+	if int64(s.Count) > blocks {
+		return nil, fmt.Errorf("--sample=%d is more than the %d reads of --readsize=%d in the file; drop --sample to read all of it", s.Count, blocks, readSize)
+	}
+	n := int64(s.Count)
+	rng := rand.New(rand.NewPCG(s.Seed, 0))
+
+	var picked []int64
+	switch s.Strategy {
+	case "uniform":
+		// The middle block of each of n equal stretches.
+		for i := range n {
+			picked = append(picked, (2*i+1)*blocks/(2*n))
+		}
+	case "stratified":
+		// Spread the reads over the tenths as evenly as
+		// possible, then pick randomly within each.  A block
+		// belongs to the tenth its offset is in, the same way
+		// reportSampled counts them.
+		tenth := func(d int64) int64 {
+			// The first block at or past d tenths of the way
+			// through.  Rounding the offset up before
+			// dividing by readSize gives the same block, and
+			// d*(filesize/10) can't overflow like d*filesize.
+			off := d*(filesize/10) + (d*(filesize%10)+9)/10
+			return min(off/readSize+min(off%readSize, 1), blocks)
+		}
+		for d := range int64(10) {
+			lo, hi := tenth(d), tenth(d+1)
+			want := min((d+1)*n/10-d*n/10, hi-lo)
+			picked = append(picked, pickDistinct(rng, lo, hi, want)...)
+		}
+		slices.Sort(picked)
+	case "random":
+		picked = pickDistinct(rng, 0, blocks, n)
+	default:
+		return nil, fmt.Errorf("unknown --sample-strategy %q; use uniform, stratified, or random", s.Strategy)
+	}
+
+	reads := make([]scheduledRead, len(picked))
+	for i, b := range picked {
+		reads[i] = scheduledRead{Worker: -1, Offset: b * readSize, Size: readSize}
+	}
+	return reads, nil
+}
+
+// Return `n` distinct random numbers in [lo, hi), in random order.
+// Without allocating the whole range, since it can be millions of
+// blocks long.
+func pickDistinct(rng *rand.Rand, lo, hi, n int64) []int64 {
+	seen := make(map[int64]bool)
+	var picked []int64
+	for int64(len(picked)) < n {
+		b := lo + rng.Int64N(hi-lo)
+		if !seen[b] {
+			seen[b] = true
+			picked = append(picked, b)
+		}
+	}
+	return picked
+}
+
+// Print latency for each tenth of the file, and how little of it we
+// actually read.
+func reportSampled(s *sampling, samples []*Sample, filesize, bytes int64, dur time.Duration) {
+	var deciles [10][]time.Duration
+	errors := [10]int{}
+	for _, sample := range samples {
+		d := min(mulDiv(sample.Offset, 10, filesize), 9)
+		if sample.Err != "" {
+			errors[d]++
+			continue
+		}
+		deciles[d] = append(deciles[d], sample.Duration)
+	}
+	fmt.Printf("Latency by position in the file (%s):\n", s)
+	for d := range deciles {
+		fmt.Printf("  %3d%%-%3d%%: %s, %d errors\n", 10*d, 10*(d+1), computeLatencyStats(deciles[d]), errors[d])
+	}
+	fmt.Printf("NOTE: sampled only %s of the %s file (%.3f%%); %s is the rate for scattered reads, not sustained throughput\n",
+		units.bytes(bytes), units.bytes(filesize), 100*float64(bytes)/float64(filesize), units.rate(bytes, dur))
+}
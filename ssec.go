@@ -0,0 +1,99 @@
+package main
+
+// Buckets with customer-provided encryption keys (SSE-C) need the key
+// sent with every request for the object.  --sse-c-key or
+// --sse-c-key-file turns that on for the SDK-based modes, via a
+// middleware that fills in the SSE-C fields of each request, and the
+// response headers are checked to make sure the server actually used
+// our key.  s3fs can't be given per-request parameters, and the plain
+// HTTP modes don't go through the SDK, so they're rejected.
+//
+// The key itself is never printed or written to any output file.
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// sseCustomerKey is a base64-encoded AES-256 key and its MD5.
+type sseCustomerKey struct {
+	key    string
+	keyMD5 string
+}
+
+// The key from --sse-c-key or --sse-c-key-file, or nil.
+var sseC *sseCustomerKey
+
+// Load the SSE-C key from `b64`, or from `filename` if that's set.
+// The file may hold either the base64 key or the raw 32 bytes.
+func loadSSECKey(b64, filename string) (*sseCustomerKey, error) {
+	var raw []byte
+	if filename != "" {
+		b, err := os.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		if len(b) == 32 {
+			raw = b
+		} else if raw, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(b))); err != nil {
+			return nil, fmt.Errorf("%s doesn't contain a base64 or raw 32 byte key", filename)
+		}
+	} else {
+		var err error
+		if raw, err = base64.StdEncoding.DecodeString(b64); err != nil {
+			// Don't include err, it quotes the key.
+			return nil, fmt.Errorf("--sse-c-key isn't valid base64")
+		}
+	}
+	if len(raw) != 32 {
+		return nil, fmt.Errorf("the SSE-C key must be 32 bytes for AES256, not %d", len(raw))
+	}
+
+	sum := md5.Sum(raw)
+	return &sseCustomerKey{
+		key:    base64.StdEncoding.EncodeToString(raw),
+		keyMD5: base64.StdEncoding.EncodeToString(sum[:]),
+	}, nil
+}
+
+// Check that a response's SSE-C headers match our key.  Returns a
+// description of the problem, or "" if they match.
+func (k *sseCustomerKey) check(algorithm, keyMD5 *string) string {
+	if k == nil {
+		return ""
+	}
+	if aws.ToString(algorithm) != "AES256" || aws.ToString(keyMD5) != k.keyMD5 {
+		return fmt.Sprintf("SSE-C mismatch: server says algorithm %q, key MD5 %q; expected AES256, %q",
+			aws.ToString(algorithm), aws.ToString(keyMD5), k.keyMD5)
+	}
+	return ""
+}
+
+// SDK middleware that adds the SSE-C parameters to every object
+// request.  Use this in s3.Options.APIOptions.
+func addSSECustomerKey(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("SSECustomerKey",
+		func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+			alg, key, sum := aws.String("AES256"), aws.String(sseC.key), aws.String(sseC.keyMD5)
+			switch p := in.Parameters.(type) {
+			case *s3.GetObjectInput:
+				p.SSECustomerAlgorithm, p.SSECustomerKey, p.SSECustomerKeyMD5 = alg, key, sum
+			case *s3.HeadObjectInput:
+				p.SSECustomerAlgorithm, p.SSECustomerKey, p.SSECustomerKeyMD5 = alg, key, sum
+			case *s3.PutObjectInput:
+				p.SSECustomerAlgorithm, p.SSECustomerKey, p.SSECustomerKeyMD5 = alg, key, sum
+			case *s3.CopyObjectInput:
+				p.SSECustomerAlgorithm, p.SSECustomerKey, p.SSECustomerKeyMD5 = alg, key, sum
+				p.CopySourceSSECustomerAlgorithm, p.CopySourceSSECustomerKey, p.CopySourceSSECustomerKeyMD5 = alg, key, sum
+			}
+			return next.HandleInitialize(ctx, in)
+		}), middleware.Before)
+}
@@ -0,0 +1,109 @@
+package main
+
+// A stalled server can make Read() return (0, nil) over and over, and
+// the read loop would spin on it forever, or block in Read() with no
+// data arriving and never return at all.  Both look like "it's
+// returning data, just infinitely slowly", which is exactly the
+// failure we're trying to catch.  So each read is watched:
+// --max-zero-reads consecutive empty Reads, or --stall-timeout with no
+// new data, abort it as a stall.
+//
+// A stall is a read error like any other, and stops the run (with exit
+// status 4) unless --continue-on-stall is set, in which case it's
+// recorded and the run carries on.  Either way, every sample records
+// its longest wait for data, and the summary reports the stalls and
+// the longest wait.
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+var errStall = errors.New("read stalled")
+
+// stallWatch watches one read's progress.
+type stallWatch struct {
+	timer *time.Timer // nil without --stall-timeout
+
+	mu       sync.Mutex
+	timedOut bool
+	zeros    int
+	last     time.Time // when we last got data
+	longest  time.Duration
+}
+
+// Start watching a read from `f`, which is closed if no data arrives
+// for --stall-timeout, to unblock a Read that's waiting for it.
+func newStallWatch(f io.Closer) *stallWatch {
+	w := &stallWatch{last: time.Now()}
+	if *stallTimeout > 0 {
+		w.timer = time.AfterFunc(*stallTimeout, func() {
+			w.mu.Lock()
+			w.timedOut = true
+			w.mu.Unlock()
+			f.Close()
+		})
+	}
+	return w
+}
+
+// Note a Read that returned `n` bytes and `err`, and return the error
+// the read should fail with, if any.
+func (w *stallWatch) check(n int, err error) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := time.Now()
+	if w.timedOut {
+		w.longest = max(w.longest, now.Sub(w.last))
+		return fmt.Errorf("%w: no data for %v (--stall-timeout)", errStall, *stallTimeout)
+	}
+	if n > 0 {
+		w.longest = max(w.longest, now.Sub(w.last))
+		w.last = now
+		w.zeros = 0
+		if w.timer != nil {
+			w.timer.Reset(*stallTimeout)
+		}
+		return err
+	}
+	if err == nil {
+		w.zeros++
+		if *maxZeroReads > 0 && w.zeros >= *maxZeroReads {
+			w.longest = max(w.longest, now.Sub(w.last))
+			return fmt.Errorf("%w: %d Reads in a row returned no data (--max-zero-reads)", errStall, w.zeros)
+		}
+	}
+	return err
+}
+
+// Stop watching, and return the longest we waited for data.
+func (w *stallWatch) stop() time.Duration {
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.longest
+}
+
+// Print the stalls, and the longest any read waited for data.
+func reportStalls(samples []*Sample) {
+	var stalls int
+	var longest *Sample
+	for _, s := range samples {
+		if s.Stalled {
+			stalls++
+		}
+		if longest == nil || s.LongestWait > longest.LongestWait {
+			longest = s
+		}
+	}
+	if longest == nil {
+		return
+	}
+	fmt.Printf("Stalls: %d reads stalled\n", stalls)
+	fmt.Printf("Longest wait for data: %.3fs, in the read at offset %d (read ID %s)\n", longest.LongestWait.Seconds(), longest.Offset, longest.ReadID)
+}
@@ -0,0 +1,218 @@
+package main
+
+// Under heavy load I've seen fs.Stat on an object that nobody touched
+// come back with a smaller size, which sends all of the offset math
+// off the end of the file.  --stat-consistency hammers the object's
+// metadata instead of reading it: --stat-concurrency workers issue
+// --stat-rate calls a second between them for --stat-duration,
+// alternating s3fs's Stat (what --mode=s3fs does on every Open) and a
+// plain HeadObject.  Every distinct (size, ETag, mtime) that comes back
+// is recorded with a count and when it was first and last seen, and
+// anything other than a single answer that matches the size at the
+// start of the run is a finding.
+//
+// s3fs's Stat doesn't give us the ETag, so its answers are only
+// compared by size and mtime.
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/jszwec/s3fs/v2"
+)
+
+// statAnswer is one distinct answer to a metadata call.
+type statAnswer struct {
+	Op      string    `json:"op"` // Stat or HeadObject
+	Size    int64     `json:"size"`
+	ETag    string    `json:"etag,omitempty"`
+	ModTime time.Time `json:"modTime"`
+	Count   int       `json:"count"`
+	First   time.Time `json:"first"`
+	Last    time.Time `json:"last"`
+}
+
+// statConsistencyResult is the --json output of a --stat-consistency
+// run.
+type statConsistencyResult struct {
+	RunInfo
+	Rate     float64                 `json:"rate"`
+	Workers  int                     `json:"workers"`
+	Duration time.Duration           `json:"durationNs"`
+	Calls    int                     `json:"calls"`
+	Errors   int                     `json:"errors"`
+	Latency  map[string]latencyStats `json:"latency"` // by op
+	Answers  []*statAnswer           `json:"answers"`
+	Findings []finding               `json:"findings,omitempty"`
+}
+
+// statCollector collects the answers as they arrive.
+type statCollector struct {
+	mu        sync.Mutex
+	answers   []*statAnswer
+	latencies map[string][]time.Duration
+	calls     int
+	errors    int
+}
+
+func (c *statCollector) add(op string, size int64, etag string, modTime time.Time, start time.Time, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls++
+	if err != nil {
+		c.errors++
+		return
+	}
+	c.latencies[op] = append(c.latencies[op], time.Since(start))
+	seen := false
+	for _, a := range c.answers {
+		if a.Op != op {
+			continue
+		}
+		if a.Size == size && a.ETag == etag && a.ModTime.Equal(modTime) {
+			a.Count++
+			a.Last = start
+			return
+		}
+		seen = true
+	}
+	if seen {
+		fmt.Printf("%s: new answer at %s: %s\n", op, start.Format(time.RFC3339Nano), describeStat(size, etag, modTime))
+	}
+	c.answers = append(c.answers, &statAnswer{Op: op, Size: size, ETag: etag, ModTime: modTime, Count: 1, First: start, Last: start})
+}
+
+func describeStat(size int64, etag string, modTime time.Time) string {
+	return fmt.Sprintf("%s, modified %s", describeObject(size, etag), modTime.Format(time.RFC3339))
+}
+
+// Stat `filename` from --stat-concurrency workers for --stat-duration,
+// and report every answer we got.
+func runStatConsistency(ctx context.Context, client *s3.Client, filename string, result *statConsistencyResult) {
+	ctx, cancel := context.WithTimeout(unrecorded(withoutRecording(ctx)), *statDuration)
+	defer cancel()
+	fsys := s3fs.New(&contextClient{client: client, ctx: ctx}, *bucket)
+	c := &statCollector{latencies: map[string][]time.Duration{}}
+
+	fmt.Printf("Stat consistency: %.0f calls/s from %d workers for %v, alternating Stat and HeadObject\n", *statRate, *statWorkers, *statDuration)
+	ticks := make(chan int)
+	go func() {
+		defer close(ticks)
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / *statRate))
+		defer ticker.Stop()
+		for i := 0; ; i++ {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			select {
+			case ticks <- i:
+			case <-ctx.Done():
+				return
+			default:
+				// Every worker is busy; the server is slower
+				// than --stat-rate, so skip this tick.
+			}
+		}
+	}()
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for w := 0; w < *statWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range ticks {
+				t := time.Now()
+				if i%2 == 0 {
+					info, err := fsys.Stat(filename)
+					if ctx.Err() != nil {
+						return // stopped mid-call; don't count it
+					}
+					if err != nil {
+						c.add("Stat", 0, "", time.Time{}, t, err)
+						continue
+					}
+					c.add("Stat", info.Size(), "", info.ModTime(), t, nil)
+				} else {
+					head, err := client.HeadObject(ctx, &s3.HeadObjectInput{
+						Bucket: bucket,
+						Key:    aws.String(filename),
+					})
+					if ctx.Err() != nil {
+						return
+					}
+					if err != nil {
+						c.add("HeadObject", 0, "", time.Time{}, t, err)
+						continue
+					}
+					c.add("HeadObject", aws.ToInt64(head.ContentLength), aws.ToString(head.ETag), aws.ToTime(head.LastModified), t, nil)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	result.Rate, result.Workers, result.Duration = *statRate, *statWorkers, time.Since(start)
+	result.Calls, result.Errors, result.Answers = c.calls, c.errors, c.answers
+	result.Latency = map[string]latencyStats{}
+	for op, l := range c.latencies {
+		result.Latency[op] = computeLatencyStats(l)
+	}
+	result.print()
+	if !*noFindings {
+		result.Findings = result.findings()
+		printFindings(result.Findings)
+	}
+}
+
+func (r *statConsistencyResult) print() {
+	fmt.Printf("%d calls in %.3fs, %d errors\n", r.Calls, r.Duration.Seconds(), r.Errors)
+	for _, op := range []string{"Stat", "HeadObject"} {
+		if l, ok := r.Latency[op]; ok {
+			fmt.Printf("%s: %s\n", op, l)
+		}
+	}
+	fmt.Printf("Answers:\n")
+	for _, a := range r.Answers {
+		fmt.Printf("  %-10s %s: %d times, from %s to %s\n", a.Op, describeStat(a.Size, a.ETag, a.ModTime), a.Count,
+			a.First.Format("15:04:05.000"), a.Last.Format("15:04:05.000"))
+	}
+}
+
+// Everything that wasn't the one answer we expected.
+func (r *statConsistencyResult) findings() []finding {
+	var findings []finding
+	add := func(metric, format string, args ...any) {
+		findings = append(findings, finding{Text: fmt.Sprintf(format, args...), Metric: metric})
+	}
+	for _, op := range []string{"Stat", "HeadObject"} {
+		var answers []*statAnswer
+		for _, a := range r.Answers {
+			if a.Op == op {
+				answers = append(answers, a)
+			}
+		}
+		if len(answers) > 1 {
+			slices.SortFunc(answers, func(a, b *statAnswer) int { return b.Count - a.Count })
+			add("answers[]", "%s gave %d different answers for an object that shouldn't have changed; the usual one was %s (%d times), but it also said %s (%d times)",
+				op, len(answers), describeStat(answers[0].Size, answers[0].ETag, answers[0].ModTime), answers[0].Count,
+				describeStat(answers[1].Size, answers[1].ETag, answers[1].ModTime), answers[1].Count)
+		}
+		for _, a := range answers {
+			if a.Size != r.FileSize {
+				add("answers[].size", "%s said the object was %d bytes %d times, but it was %d bytes at the start of the run", op, a.Size, a.Count, r.FileSize)
+			}
+		}
+	}
+	if r.Errors > 0 {
+		add("errors", "%d of %d metadata calls failed", r.Errors, r.Calls)
+	}
+	return findings
+}
@@ -0,0 +1,94 @@
+package main
+
+// Re-Stating a multi-gigabyte file and regenerating the same schedule
+// on every run is wasteful, and the Stat warms the filer's caches,
+// which perturbs --cold-cache tests.  --state-file remembers the
+// object's size, ETag, and schedule between runs.  On the next run
+// we only check that the ETag still matches (with a HEAD, which
+// SeaweedFS answers from its metadata) before reusing them.
+//
+// It also holds the progress of a sequential run that stopped early,
+// for --resume (see resume.go).
+//
+// The file is plain indented JSON, and it's always safe to delete.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/scottlaird/s3test/schema"
+)
+
+// stateEntry is what we remember about one object and set of
+// schedule flags.
+type stateEntry struct {
+	Size     int64        `json:"size"`
+	ETag     string       `json:"etag"`
+	Schedule *schedule    `json:"schedule"`
+	Progress *runProgress `json:"progress,omitempty"` // of an unfinished sequential run
+}
+
+// stateFile holds entries keyed by stateKey().
+type stateFile struct {
+	SchemaVersion string                 `json:"schemaVersion"` // see the schema package
+	Entries       map[string]*stateEntry `json:"entries"`
+}
+
+// Load the state file, or return an empty one if it doesn't exist
+// yet.
+func loadState(filename string) (*stateFile, error) {
+	state := &stateFile{Entries: make(map[string]*stateEntry)}
+	b, err := os.ReadFile(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, state); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	if err := schema.Check(state.SchemaVersion); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	if state.Entries == nil {
+		state.Entries = make(map[string]*stateEntry)
+	}
+	return state, nil
+}
+
+// Return the key for `filename` with the current flags.  Everything
+// that changes the schedule has to be part of the key.
+func stateKey(filename string) string {
+	return fmt.Sprintf("%s/%s mode=%s pattern=%s readsize=%d concurrency=%d offset=%d length=%d iterations=%d",
+		*bucket, filename, *mode, *pattern, *readsize, *concurrency, *rangeOffset, *rangeLength, *iterations)
+}
+
+// Return the saved entry for `key`, if there is one and the object's
+// ETag hasn't changed since it was saved.
+func (s *stateFile) lookup(ctx context.Context, client *s3.Client, key, filename string) (*stateEntry, error) {
+	e, ok := s.Entries[key]
+	if !ok || e.Schedule == nil {
+		return nil, nil
+	}
+	etag, err := headETag(ctx, client, filename)
+	if err != nil {
+		return nil, err
+	}
+	if etag != e.ETag {
+		fmt.Printf("WARNING: %s has changed since it was saved in --state-file (ETag %s, was %s); regenerating\n", filename, etag, e.ETag)
+		return nil, nil
+	}
+	return e, nil
+}
+
+// Write the state file.
+func (s *stateFile) save(filename string) error {
+	s.SchemaVersion = schema.Version
+	return writeJSON(filename, s)
+}
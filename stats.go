@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/scottlaird/s3test/schema"
+)
+
+// latencyStats summarizes a set of durations.
+type latencyStats schema.Latency
+
+// Compute latency statistics for `durations`, which is left unsorted.
+// The percentiles are approximate if there are a lot of them; see
+// sketch.go.
+func computeLatencyStats(durations []time.Duration) latencyStats {
+	if len(durations) == 0 {
+		return latencyStats{}
+	}
+	if len(durations) > exactPercentileLimit && !*exactPercentiles {
+		var s latencySketch
+		for _, d := range durations {
+			s.add(d)
+		}
+		return s.stats()
+	}
+	sorted := slices.Clone(durations)
+	slices.Sort(sorted)
+
+	return latencyStats{
+		Count: len(sorted),
+		Min:   sorted[0],
+		P50:   percentile(sorted, 50),
+		P90:   percentile(sorted, 90),
+		P99:   percentile(sorted, 99),
+		Max:   sorted[len(sorted)-1],
+	}
+}
+
+// Return the p'th percentile of `sorted` using the nearest-rank
+// method.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(p/100*float64(len(sorted))+0.999999) - 1
+	return sorted[max(0, min(rank, len(sorted)-1))]
+}
+
+func (s latencyStats) String() string {
+	if s.Count == 0 {
+		return "no samples"
+	}
+	text := fmt.Sprintf("%d samples, min %.3fs p50 %.3fs p90 %.3fs p99 %.3fs max %.3fs",
+		s.Count, s.Min.Seconds(), s.P50.Seconds(), s.P90.Seconds(), s.P99.Seconds(), s.Max.Seconds())
+	if s.Approximate {
+		text += fmt.Sprintf(" (percentiles within %.0f%%)", 100*sketchAccuracy)
+	}
+	return text
+}
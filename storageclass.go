@@ -0,0 +1,133 @@
+package main
+
+// An object in an archive storage class, or one that SeaweedFS has
+// tiered off to remote storage (Backblaze, say) and not cached, reads
+// with bizarre latency that looks a lot like the range-read problems
+// this tool exists to find.  So during preflight we HEAD the object for
+// its storage class, restore status, and replication status, and with
+// --filer-url we also ask the filer whether the file lives on a remote
+// tier.  All of that goes in the banner and the results, and if the
+// object isn't somewhere hot, we refuse to run (exit status 20) unless
+// --allow-archive says that's what you meant to measure.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// objectStorage is where the object lives.
+type objectStorage struct {
+	StorageClass      string `json:"storageClass,omitempty"`
+	ArchiveStatus     string `json:"archiveStatus,omitempty"` // with S3 Intelligent-Tiering
+	Restore           string `json:"restore,omitempty"`       // the x-amz-restore header
+	ReplicationStatus string `json:"replicationStatus,omitempty"`
+	RemoteStorage     string `json:"remoteStorage,omitempty"` // the SeaweedFS remote tier, from the filer
+	RemoteCached      bool   `json:"remoteCached,omitempty"`  // whether the filer has a local copy
+	Cold              string `json:"cold,omitempty"`          // why we think reads won't come from hot storage
+}
+
+// Storage classes whose objects have to be restored before they can be
+// read at all.
+var archiveClasses = map[string]bool{
+	"GLACIER":      true,
+	"DEEP_ARCHIVE": true,
+}
+
+// HEAD `filename` for its storage class and friends, and with
+// `filerURL`, ask the filer about remote tiering.
+func checkStorage(ctx context.Context, client *s3.Client, filename, filerURL string) (*objectStorage, error) {
+	ctx, cancel := context.WithTimeout(unrecorded(withoutRecording(ctx)), preflightTimeout)
+	defer cancel()
+	head, err := client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: bucket,
+		Key:    aws.String(filename),
+	}, noRetries)
+	if err != nil {
+		return nil, err
+	}
+	s := &objectStorage{
+		StorageClass:      string(head.StorageClass),
+		ArchiveStatus:     string(head.ArchiveStatus),
+		Restore:           aws.ToString(head.Restore),
+		ReplicationStatus: string(head.ReplicationStatus),
+	}
+	if filerURL != "" {
+		s.checkRemote(filerURL, filename)
+	}
+	s.Cold = s.coldReason()
+	return s, nil
+}
+
+// Ask the filer whether `filename` is on a remote tier.  This is
+// best-effort, like the chunk list; see topology.go.
+func (s *objectStorage) checkRemote(filerURL, filename string) {
+	body, err := fetchJSON(filerEntryURL(filerURL, *filerBucketDir, *bucket, filename))
+	if err != nil {
+		fmt.Printf("WARNING: unable to ask the filer about remote storage: %v\n", err)
+		return
+	}
+	var entry struct {
+		Chunks []chunkInfo `json:"chunks"`
+		Remote *struct {
+			StorageName string `json:"storage_name"`
+		} `json:"remote"`
+	}
+	if err := json.Unmarshal(body, &entry); err != nil || entry.Remote == nil {
+		return
+	}
+	s.RemoteStorage = entry.Remote.StorageName
+	if s.RemoteStorage == "" {
+		s.RemoteStorage = "unnamed"
+	}
+	s.RemoteCached = len(entry.Chunks) > 0
+}
+
+// Describe why reads of the object won't come from hot storage, or
+// return "" if they should.
+func (s *objectStorage) coldReason() string {
+	restored := strings.Contains(s.Restore, `ongoing-request="false"`)
+	switch {
+	case archiveClasses[s.StorageClass] && !restored:
+		if s.Restore != "" {
+			return fmt.Sprintf("it's in the %s storage class, and its restore hasn't finished", s.StorageClass)
+		}
+		return fmt.Sprintf("it's in the %s storage class, and hasn't been restored", s.StorageClass)
+	case s.ArchiveStatus != "" && !restored:
+		return fmt.Sprintf("Intelligent-Tiering has moved it to %s", s.ArchiveStatus)
+	case s.RemoteStorage != "" && !s.RemoteCached:
+		return fmt.Sprintf("SeaweedFS has tiered it to the remote storage %q, with no local copy", s.RemoteStorage)
+	}
+	return ""
+}
+
+// Print a line for the banner.
+func (s *objectStorage) print() {
+	var parts []string
+	class := s.StorageClass
+	if class == "" {
+		class = "STANDARD (not given)"
+	}
+	parts = append(parts, "storage class "+class)
+	if s.ArchiveStatus != "" {
+		parts = append(parts, "archive status "+s.ArchiveStatus)
+	}
+	if s.Restore != "" {
+		parts = append(parts, "restore "+s.Restore)
+	}
+	if s.ReplicationStatus != "" {
+		parts = append(parts, "replication "+s.ReplicationStatus)
+	}
+	if s.RemoteStorage != "" {
+		cached := "not cached locally"
+		if s.RemoteCached {
+			cached = "cached locally"
+		}
+		parts = append(parts, fmt.Sprintf("SeaweedFS remote storage %s, %s", s.RemoteStorage, cached))
+	}
+	fmt.Printf("Object: %s\n", strings.Join(parts, ", "))
+}
@@ -0,0 +1,114 @@
+package main
+
+// --stream-fifo sends the same records as --jsonl to a named pipe, as
+// they happen, for live dashboards.  A slow or missing reader must
+// never slow down the benchmark, so records go through a buffered
+// channel to a goroutine that does the writing, and anything that
+// doesn't fit, or arrives while nobody's reading, is dropped and
+// counted.  Whenever a reader attaches, it gets the "run" record
+// first.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/scottlaird/s3test/schema"
+)
+
+// How many records can be waiting for the writer before we start
+// dropping them.
+const streamBuffer = 1024
+
+// fifoStream writes records to a FIFO without ever blocking the
+// caller.
+type fifoStream struct {
+	path    string
+	run     jsonlRecord
+	records chan jsonlRecord
+	done    chan struct{}
+	dropped atomic.Int64
+	sent    atomic.Int64
+}
+
+// Create the FIFO at `path` if it doesn't exist, and start writing
+// to it.  `run` is sent to each new reader first.
+func newFIFOStream(path string, run jsonlRecord) (*fifoStream, error) {
+	if err := makeFIFO(path); err != nil {
+		return nil, err
+	}
+	s := &fifoStream{
+		path:    path,
+		run:     run,
+		records: make(chan jsonlRecord, streamBuffer),
+		done:    make(chan struct{}),
+	}
+	go s.loop()
+	return s, nil
+}
+
+// Queue `rec`, or drop it if the queue is full.  Safe to call on a
+// nil stream.
+func (s *fifoStream) send(rec jsonlRecord) {
+	if s == nil {
+		return
+	}
+	rec.SchemaVersion, rec.Version = schema.Version, jsonlVersion
+	select {
+	case s.records <- rec:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+// Write records until the channel is closed, reopening the FIFO
+// whenever the reader goes away.
+func (s *fifoStream) loop() {
+	defer close(s.done)
+	var f *os.File
+	var enc *json.Encoder
+	lastTry := time.Time{}
+
+	for rec := range s.records {
+		if f == nil && time.Since(lastTry) > time.Second {
+			lastTry = time.Now()
+			var err error
+			f, err = openFIFO(s.path)
+			if err == nil {
+				enc = json.NewEncoder(f)
+				if err := enc.Encode(s.run); err != nil {
+					f.Close()
+					f = nil
+				}
+			}
+		}
+		if f == nil {
+			// Nobody's listening.
+			s.dropped.Add(1)
+			continue
+		}
+		if err := enc.Encode(rec); err != nil {
+			s.dropped.Add(1)
+			f.Close()
+			f = nil
+			continue
+		}
+		s.sent.Add(1)
+	}
+	if f != nil {
+		f.Close()
+	}
+}
+
+// Stop writing, and say how many records were dropped.
+func (s *fifoStream) Close() {
+	close(s.records)
+	<-s.done
+	fmt.Printf("Streamed %d records to %s, dropped %d\n", s.sent.Load(), s.path, s.dropped.Load())
+}
+
+// errNoFIFO is returned by makeFIFO on platforms without named pipes.
+var errNoFIFO = errors.New("named pipes aren't supported on this platform")
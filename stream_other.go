@@ -0,0 +1,13 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+func makeFIFO(path string) error {
+	return errNoFIFO
+}
+
+func openFIFO(path string) (*os.File, error) {
+	return nil, errNoFIFO
+}
@@ -0,0 +1,26 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// Create a FIFO at `path`, unless there's one there already.
+func makeFIFO(path string) error {
+	if info, err := os.Stat(path); err == nil {
+		if info.Mode()&os.ModeNamedPipe == 0 {
+			return fmt.Errorf("%s exists and isn't a FIFO", path)
+		}
+		return nil
+	}
+	return syscall.Mkfifo(path, 0o600)
+}
+
+// Open the FIFO for writing.  This fails immediately, rather than
+// waiting, if there's no reader.
+func openFIFO(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_WRONLY|syscall.O_NONBLOCK, 0)
+}
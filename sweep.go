@@ -0,0 +1,170 @@
+package main
+
+// How many simultaneous viewers can a node handle before latency gets
+// out of hand?  --concurrency-sweep runs the sequential pattern at each
+// concurrency level in turn, with a byte or time budget per level and
+// a cooldown in between, and prints throughput and latency for each
+// level, plus a CSV for plotting.
+//
+// Every level reads a fresh part of the file, picking up where the
+// previous level left off, so earlier levels don't warm the cache for
+// later ones.  If the file runs out, we wrap around and say so.
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/scottlaird/s3test/schema"
+)
+
+// sweepStep is one row of the sweep table.
+type sweepStep struct {
+	concurrency int
+	reads       int
+	errors      int
+	bytes       int64
+	duration    time.Duration
+	latency     latencyStats
+}
+
+// Parse a comma-separated list of concurrency levels.
+func parseSweepLevels(s string) ([]int, error) {
+	var levels []int
+	for _, f := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("bad --concurrency-sweep level %q", f)
+		}
+		levels = append(levels, n)
+	}
+	return levels, nil
+}
+
+// Return a readSource that hands out consecutive --readsize reads
+// starting at slot `*cursor`, until `budget` bytes have been handed out
+// or `deadline` has passed.  A zero budget or deadline is unlimited.
+func sweepSource(cursor *int64, slots int64, budget int64, deadline time.Time, wrapped *bool) readSource {
+	var mu sync.Mutex
+	var handedOut int64
+	size := *readsize
+	return func(worker int) (readRange, bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		if budget > 0 && handedOut >= budget {
+			return readRange{}, false
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return readRange{}, false
+		}
+		if *cursor >= slots {
+			*cursor = 0
+			*wrapped = true
+		}
+		r := readRange{offset: *cursor * size, size: size}
+		*cursor++
+		handedOut += size
+		return r, true
+	}
+}
+
+// Run the sweep and print the results.
+func runConcurrencySweep(b *benchmark, levels []int) error {
+	size := *readsize
+	slots := b.filesize / size
+	if slots == 0 {
+		return fmt.Errorf("--readsize %d is bigger than the %d byte file", size, b.filesize)
+	}
+
+	var steps []sweepStep
+	var cursor int64
+	var wrapped bool
+	for i, c := range levels {
+		if i > 0 && *sweepCooldown > 0 {
+			time.Sleep(*sweepCooldown)
+		}
+		fmt.Printf("Sweep: concurrency %d\n", c)
+
+		var deadline time.Time
+		if *sweepDuration > 0 {
+			deadline = time.Now().Add(*sweepDuration)
+		}
+		next := sweepSource(&cursor, slots, *sweepBytes, deadline, &wrapped)
+
+		start := time.Now()
+		samples, err := b.execute(fmt.Sprintf("concurrency-%d", c), c, next)
+		if err != nil {
+			return err
+		}
+		step := sweepStep{concurrency: c, duration: time.Since(start)}
+		var lat []time.Duration
+		for _, s := range samples {
+			step.reads++
+			if s.Err != "" {
+				step.errors++
+				continue
+			}
+			step.bytes += s.Bytes
+			lat = append(lat, s.Duration)
+		}
+		step.latency = computeLatencyStats(lat)
+		steps = append(steps, step)
+		if b.isStopped() {
+			break
+		}
+	}
+
+	if wrapped {
+		fmt.Printf("WARNING: the file wasn't big enough for every level to read fresh data, so later levels may have hit the cache\n")
+	}
+	fmt.Printf("%11s %8s %7s %12s %10s %12s %10s %10s %10s\n", "concurrency", "reads", "errors", "bytes", "seconds", units.rateUnit(), "p50", "p90", "max")
+	for _, s := range steps {
+		fmt.Printf("%11d %8d %7d %12d %10.3f %12.3f %10.3f %10.3f %10.3f\n", s.concurrency, s.reads, s.errors, s.bytes, s.duration.Seconds(),
+			units.rateValue(s.bytes, s.duration), s.latency.P50.Seconds(), s.latency.P90.Seconds(), s.latency.Max.Seconds())
+	}
+
+	best := 0
+	for _, s := range steps {
+		if s.latency.Count > 0 && s.latency.P90 <= *sweepSLO {
+			best = max(best, s.concurrency)
+		}
+	}
+	if best > 0 {
+		fmt.Printf("Highest concurrency with p90 under %s: %d\n", *sweepSLO, best)
+	} else {
+		fmt.Printf("No level had p90 under %s\n", *sweepSLO)
+	}
+
+	if *sweepCSV != "" {
+		return writeSweepCSV(*sweepCSV, steps)
+	}
+	return nil
+}
+
+// Write the sweep table as CSV.
+func writeSweepCSV(filename string, steps []sweepStep) error {
+	return writeFileAtomic(filename, func(f io.Writer) error {
+		w := csv.NewWriter(f)
+		w.Write([]string{"concurrency", "reads", "errors", "bytes", "seconds", "mbps", "p50_seconds", "p90_seconds", "max_seconds", "schema_version"})
+		for _, s := range steps {
+			w.Write([]string{
+				strconv.Itoa(s.concurrency),
+				strconv.Itoa(s.reads),
+				strconv.Itoa(s.errors),
+				strconv.FormatInt(s.bytes, 10),
+				strconv.FormatFloat(s.duration.Seconds(), 'f', 6, 64),
+				strconv.FormatFloat(mbps(s.bytes, s.duration), 'f', 3, 64),
+				strconv.FormatFloat(s.latency.P50.Seconds(), 'f', 6, 64),
+				strconv.FormatFloat(s.latency.P90.Seconds(), 'f', 6, 64),
+				strconv.FormatFloat(s.latency.Max.Seconds(), 'f', 6, 64),
+				schema.Version,
+			})
+		}
+		w.Flush()
+		return w.Error()
+	})
+}
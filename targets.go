@@ -0,0 +1,415 @@
+package main
+
+// To show that the amplification is SeaweedFS's doing rather than the
+// workload's, it helps to run exactly the same reads against a copy
+// of the content on another server, like MinIO.  Each --target names
+// one server as "name=endpoint,bucket[,region]"; the schedule is
+// planned against the first one, and then run against each of them
+// in turn (or all at once with --parallel-targets).  Before trusting
+// the comparison, we check that every target's copy is the same size
+// and, with --target-hash, that the first --readsize bytes match.
+//
+// Servers like AWS and MinIO need their own credentials, addressing
+// style, and TLS settings, so a target can also carry key=value
+// options after the region:
+//
+//	creds=default|profile:NAME|env:PREFIX|anonymous
+//	path-style=true|false
+//	insecure=true   (don't verify the server's certificate)
+//	ca=FILE         (trust the PEM certificates in FILE)
+//
+// env:PREFIX reads PREFIX_ACCESS_KEY_ID, PREFIX_SECRET_ACCESS_KEY, and
+// optionally PREFIX_SESSION_TOKEN, so secrets never have to go on the
+// command line.  Every target's credentials and certificates are
+// checked before anything is read, and only the credential source is
+// ever printed or saved, never the credentials.
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// target is one S3 endpoint and bucket to read from.
+type target struct {
+	Name     string `json:"name"`
+	Endpoint string `json:"endpoint"`
+	Bucket   string `json:"bucket"`
+	Region   string `json:"region"`
+
+	// Per-target settings; see the top of this file.  PathStyle
+	// is nil to use --path-style.
+	Credentials string `json:"credentials,omitempty"`
+	PathStyle   *bool  `json:"pathStyle,omitempty"`
+	Insecure    bool   `json:"insecure,omitempty"`
+	CAFile      string `json:"caFile,omitempty"`
+
+	// Set by prepareTargets.
+	config *aws.Config
+	client *http.Client
+}
+
+// Return the target described by --endpoint, --bucket, and --region,
+// or the first --target, which the schedule is planned against.
+func defaultTarget() target {
+	if len(targets) > 0 {
+		return targets[0]
+	}
+	return target{Name: "default", Endpoint: *endpoint, Bucket: *bucket, Region: *region}
+}
+
+// Whether to use path-style addressing with `t`.
+func (t target) pathStyle() bool {
+	if t.PathStyle != nil {
+		return *t.PathStyle
+	}
+	return *pathStyle
+}
+
+// Return the HTTP client for `t`'s requests.
+func (t target) httpClient() *http.Client {
+	if t.client != nil {
+		return t.client
+	}
+	return httpClient
+}
+
+// targetList is a repeatable --target flag.
+type targetList []target
+
+func (l *targetList) String() string {
+	var parts []string
+	for _, t := range *l {
+		parts = append(parts, fmt.Sprintf("%s=%s,%s,%s", t.Name, t.Endpoint, t.Bucket, t.Region))
+	}
+	return strings.Join(parts, " ")
+}
+
+// Parse "name=endpoint,bucket[,region][,key=value...]".  The region
+// defaults to --region.
+func (l *targetList) Set(s string) error {
+	name, rest, found := strings.Cut(s, "=")
+	if !found || name == "" {
+		return fmt.Errorf("want name=endpoint,bucket[,region][,key=value...], not %q", s)
+	}
+	parts := strings.Split(rest, ",")
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("want name=endpoint,bucket[,region][,key=value...], not %q", s)
+	}
+	for _, t := range *l {
+		if t.Name == name {
+			return fmt.Errorf("target %q is given twice", name)
+		}
+	}
+	t := target{Name: name, Endpoint: parts[0], Bucket: parts[1], Region: *region}
+	options := parts[2:]
+	if len(options) > 0 && !strings.Contains(options[0], "=") {
+		t.Region = options[0]
+		options = options[1:]
+	}
+	for _, o := range options {
+		key, value, found := strings.Cut(o, "=")
+		if !found {
+			return fmt.Errorf("target %s: want key=value, not %q", name, o)
+		}
+		switch key {
+		case "creds":
+			kind, arg, _ := strings.Cut(value, ":")
+			if !(value == "default" || value == "anonymous" || (kind == "profile" || kind == "env") && arg != "") {
+				return fmt.Errorf("target %s: creds must be default, profile:NAME, env:PREFIX, or anonymous, not %q", name, value)
+			}
+			t.Credentials = value
+		case "path-style":
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("target %s: bad path-style %q", name, value)
+			}
+			t.PathStyle = &b
+		case "insecure":
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("target %s: bad insecure %q", name, value)
+			}
+			t.Insecure = b
+		case "ca":
+			t.CAFile = value
+		default:
+			return fmt.Errorf("target %s: unknown option %q; use creds, path-style, insecure, or ca", name, key)
+		}
+	}
+	*l = append(*l, t)
+	return nil
+}
+
+// Load every target's AWS config and TLS settings, and check that its
+// credentials work, so that a problem with the last target shows up
+// before the first one is benchmarked.
+func prepareTargets(ctx context.Context, targets []target) error {
+	for i := range targets {
+		t := &targets[i]
+		opts := []func(*config.LoadOptions) error{config.WithRegion(t.Region)}
+		kind, arg, _ := strings.Cut(t.Credentials, ":")
+		switch kind {
+		case "profile":
+			opts = append(opts, config.WithSharedConfigProfile(arg))
+		case "env":
+			creds, err := envCredentials(arg)
+			if err != nil {
+				return fmt.Errorf("target %s: %w", t.Name, err)
+			}
+			opts = append(opts, config.WithCredentialsProvider(creds))
+		case "anonymous":
+			opts = append(opts, config.WithCredentialsProvider(aws.AnonymousCredentials{}))
+		}
+		cfg, err := config.LoadDefaultConfig(ctx, opts...)
+		if err != nil {
+			return fmt.Errorf("target %s: %w", t.Name, err)
+		}
+		if kind != "anonymous" {
+			if _, err := cfg.Credentials.Retrieve(ctx); err != nil {
+				return fmt.Errorf("target %s: unable to get credentials from %s: %w", t.Name, t.credentialSource(), err)
+			}
+		}
+		t.config = &cfg
+
+		if t.Insecure || t.CAFile != "" {
+			if t.client, err = tlsClient(t.Insecure, t.CAFile); err != nil {
+				return fmt.Errorf("target %s: %w", t.Name, err)
+			}
+		}
+		fmt.Printf("Target %s: %s, %s, credentials from %s\n", t.Name, t.Endpoint, addressingStyleOf(t.pathStyle()), t.credentialSource())
+	}
+	return nil
+}
+
+// Describe where `t`'s credentials come from, without the
+// credentials themselves.
+func (t target) credentialSource() string {
+	if t.Credentials == "" {
+		return "default"
+	}
+	return t.Credentials
+}
+
+// Return static credentials from PREFIX_ACCESS_KEY_ID and friends.
+func envCredentials(prefix string) (aws.CredentialsProvider, error) {
+	id, secret := os.Getenv(prefix+"_ACCESS_KEY_ID"), os.Getenv(prefix+"_SECRET_ACCESS_KEY")
+	if id == "" || secret == "" {
+		return nil, fmt.Errorf("creds=env:%s needs %s_ACCESS_KEY_ID and %s_SECRET_ACCESS_KEY", prefix, prefix, prefix)
+	}
+	token := os.Getenv(prefix + "_SESSION_TOKEN")
+	return aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+		return aws.Credentials{AccessKeyID: id, SecretAccessKey: secret, SessionToken: token, Source: "env:" + prefix}, nil
+	}), nil
+}
+
+// Return a client with its own TLS settings that still goes through a
+// recording transport, so per-read collection keeps working.
+func tlsClient(insecure bool, caFile string) (*http.Client, error) {
+	inner, ok := upstream.inner.(*http.Transport)
+	if !ok {
+		return nil, fmt.Errorf("can't change TLS settings on a %T", upstream.inner)
+	}
+	t := inner.Clone()
+	if t.TLSClientConfig == nil {
+		t.TLSClientConfig = &tls.Config{}
+	}
+	t.TLSClientConfig.InsecureSkipVerify = insecure
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificates in %s", caFile)
+		}
+		t.TLSClientConfig.RootCAs = pool
+	}
+	return &http.Client{Transport: &recordingTransport{inner: t}, CheckRedirect: checkRedirect}, nil
+}
+
+// targetsResult is what --json gets with --target.
+type targetsResult struct {
+	SchemaVersion string          `json:"schemaVersion"` // see the schema package
+	Targets       []*targetResult `json:"targets"`
+}
+
+// targetResult is the outcome of running the schedule against one
+// target.
+type targetResult struct {
+	Target    target        `json:"target"`
+	ETag      string        `json:"etag"`
+	Reads     int           `json:"reads"`
+	Errors    int           `json:"errors"`
+	Bytes     int64         `json:"bytes"`
+	Duration  time.Duration `json:"durationNs"`
+	Latency   latencyStats  `json:"latency"`
+	Samples   []*Sample     `json:"samples"`
+	firstHash []byte
+}
+
+// Check that every target has the same object, filling in each
+// result's ETag.  Different servers compute ETags differently, so a
+// mismatch is only a warning; sizes and hashes have to match.
+func checkTargets(ctx context.Context, clients []*s3.Client, results []*targetResult, filename string, filesize int64, hashSize int64) error {
+	for i, r := range results {
+		head, err := clients[i].HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(r.Target.Bucket),
+			Key:    aws.String(filename),
+		})
+		if err != nil {
+			return fmt.Errorf("target %s: %w", r.Target.Name, err)
+		}
+		if size := aws.ToInt64(head.ContentLength); size != filesize {
+			return fmt.Errorf("target %s has a %d byte %s, but %s's is %d bytes", r.Target.Name, size, filename, results[0].Target.Name, filesize)
+		}
+		r.ETag = aws.ToString(head.ETag)
+		if r.ETag != results[0].ETag {
+			fmt.Printf("WARNING: target %s has ETag %s, but %s has %s\n", r.Target.Name, r.ETag, results[0].Target.Name, results[0].ETag)
+		}
+
+		if hashSize == 0 {
+			continue
+		}
+		out, err := clients[i].GetObject(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(r.Target.Bucket),
+			Key:    aws.String(filename),
+			Range:  aws.String(rangeHeader(0, hashSize)),
+		})
+		if err != nil {
+			return fmt.Errorf("target %s: %w", r.Target.Name, err)
+		}
+		h := sha256.New()
+		_, err = io.Copy(h, out.Body)
+		out.Body.Close()
+		if err != nil {
+			return fmt.Errorf("target %s: %w", r.Target.Name, err)
+		}
+		r.firstHash = h.Sum(nil)
+		if !bytes.Equal(r.firstHash, results[0].firstHash) {
+			return fmt.Errorf("the first %d bytes of %s differ between %s and %s", hashSize, filename, r.Target.Name, results[0].Target.Name)
+		}
+	}
+	return nil
+}
+
+// Run `sched` against each of `targets`, and print a comparison.
+func runTargets(ctx context.Context, targets []target, filename string, filesize int64, sched *schedule, parallel, hash bool) ([]*targetResult, error) {
+	clients := make([]*s3.Client, len(targets))
+	results := make([]*targetResult, len(targets))
+	for i, t := range targets {
+		_, client, err := connectTo(ctx, t)
+		if err != nil {
+			return nil, err
+		}
+		clients[i] = client
+		results[i] = &targetResult{Target: t}
+	}
+
+	var hashSize int64
+	if hash {
+		hashSize = min(*readsize, filesize)
+	}
+	if err := checkTargets(ctx, clients, results, filename, filesize, hashSize); err != nil {
+		return nil, err
+	}
+
+	run := func(i int) error {
+		backend, err := newBackend(*mode, clients[i], targets[i], "")
+		if err != nil {
+			return err
+		}
+		b := &benchmark{
+			ctx:      ctx,
+			client:   clients[i],
+			backend:  backend,
+			filename: filename,
+			filesize: filesize,
+			result:   &Result{},
+		}
+		fmt.Printf("Target %s: reading %s from %s\n", targets[i].Name, filename, targets[i].Endpoint)
+		start := time.Now()
+		if _, err := b.runSchedule(sched); err != nil {
+			return fmt.Errorf("target %s: %w", targets[i].Name, err)
+		}
+		r := results[i]
+		r.Duration = time.Since(start)
+		r.Bytes = b.totalBytes
+		r.Latency = computeLatencyStats(b.latencies)
+		r.Samples = b.result.Samples
+		r.Reads = len(r.Samples)
+		for _, s := range r.Samples {
+			if s.Err != "" {
+				r.Errors++
+			}
+		}
+		return nil
+	}
+
+	if parallel {
+		var wg sync.WaitGroup
+		errs := make([]error, len(targets))
+		for i := range targets {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs[i] = run(i)
+			}()
+		}
+		wg.Wait()
+		for _, err := range errs {
+			if err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		for i := range targets {
+			if err := run(i); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	printTargets(results)
+	return results, nil
+}
+
+// Print one line per target, and then how each target compares with
+// each of the ones before it.
+func printTargets(results []*targetResult) {
+	fmt.Printf("%-12s %8s %7s %12s %10s %10s %10s %10s\n", "target", "reads", "errors", units.rateUnit(), "p50", "p90", "p99", "max")
+	for _, r := range results {
+		fmt.Printf("%-12s %8d %7d %12.3f %10.3f %10.3f %10.3f %10.3f\n", r.Target.Name, r.Reads, r.Errors,
+			units.rateValue(r.Bytes, r.Duration), r.Latency.P50.Seconds(), r.Latency.P90.Seconds(), r.Latency.P99.Seconds(), r.Latency.Max.Seconds())
+	}
+
+	ratio := func(a, b float64) string {
+		if b == 0 {
+			return "n/a"
+		}
+		return fmt.Sprintf("%.2fx", a/b)
+	}
+	for i, a := range results {
+		for _, b := range results[i+1:] {
+			fmt.Printf("%s vs %s: throughput %s, p50 %s, p90 %s\n", b.Target.Name, a.Target.Name,
+				ratio(mbps(b.Bytes, b.Duration), mbps(a.Bytes, a.Duration)),
+				ratio(b.Latency.P50.Seconds(), a.Latency.P50.Seconds()),
+				ratio(b.Latency.P90.Seconds(), a.Latency.P90.Seconds()))
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+// Client-side Mbps mixes up server latency with network trouble.  On
+// Linux, we read TCP_INFO from each connection's socket after every
+// response body is closed, which tells us the RTT, how many segments
+// we had to retransmit, how many arrived out of order (our best hint
+// of loss on the server->client path), and the kernel's delivery
+// rate estimate.  macOS has a smaller equivalent.  Elsewhere,
+// readTCPInfo always fails and the summary says so.
+//
+// The platform-specific parts live in tcpinfo_*.go.
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// tcpInfo is the subset of TCP_INFO that we care about.
+type tcpInfo struct {
+	RTT           time.Duration `json:"rttNs"`
+	MinRTT        time.Duration `json:"minRttNs"`
+	Retransmits   uint32        `json:"retransmits"`
+	OutOfOrder    uint32        `json:"outOfOrder"`
+	DeliveryRate  uint64        `json:"deliveryRate"` // bytes/second
+	BytesReceived uint64        `json:"bytesReceived"`
+}
+
+// connectionStats aggregates the TCP_INFO samples from one
+// connection.  The counters in TCP_INFO are cumulative, so the last
+// sample has the totals.
+type connectionStats struct {
+	ID       int           `json:"id"`
+	Remote   string        `json:"remote"`
+	Requests int           `json:"requests"`
+	RTTMin   time.Duration `json:"rttMinNs"`
+	RTTMax   time.Duration `json:"rttMaxNs"`
+	Last     tcpInfo       `json:"last"`
+}
+
+// connectionTracker keeps a connectionStats for every connection
+// that we've seen a response on.
+type connectionTracker struct {
+	mu    sync.Mutex
+	conns map[net.Conn]*connectionStats
+	order []*connectionStats
+}
+
+// Sample TCP_INFO from `conn`, which has the ID `id` from the
+// recording transport, after a request on it has finished.
+func (t *connectionTracker) sample(conn net.Conn, id int) {
+	if tc, ok := conn.(*tls.Conn); ok {
+		conn = tc.NetConn()
+	}
+	info, ok := readTCPInfo(conn)
+	if !ok {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c, ok := t.conns[conn]
+	if !ok {
+		if t.conns == nil {
+			t.conns = make(map[net.Conn]*connectionStats)
+		}
+		c = &connectionStats{ID: id, Remote: conn.RemoteAddr().String(), RTTMin: info.RTT}
+		t.conns[conn] = c
+		t.order = append(t.order, c)
+	}
+	c.Requests++
+	c.RTTMin = min(c.RTTMin, info.RTT)
+	c.RTTMax = max(c.RTTMax, info.RTT)
+	c.Last = *info
+}
+
+// Return a copy of the per-connection stats.
+func (t *connectionTracker) Connections() []connectionStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make([]connectionStats, len(t.order))
+	for i, c := range t.order {
+		out[i] = *c
+	}
+	return out
+}
+
+// Print a summary line plus one line per connection.
+func printConnections(conns []connectionStats) {
+	if tcpInfoSource == "" {
+		fmt.Printf("TCP: socket statistics aren't available on %s\n", runtime.GOOS)
+		return
+	}
+	if len(conns) == 0 {
+		return
+	}
+	var retrans, ooo uint32
+	for _, c := range conns {
+		retrans += c.Last.Retransmits
+		ooo += c.Last.OutOfOrder
+	}
+	fmt.Printf("TCP: %d connections, %d segments retransmitted, %d received out of order\n", len(conns), retrans, ooo)
+	for _, c := range conns {
+		fmt.Printf("  conn %d to %s: %d requests, rtt %s-%s (min %s), %d retransmits, %d out of order, delivery rate %s, %d bytes received\n",
+			c.ID, c.Remote, c.Requests, c.RTTMin, c.RTTMax, c.Last.MinRTT, c.Last.Retransmits, c.Last.OutOfOrder,
+			units.rate(int64(c.Last.DeliveryRate), time.Second), c.Last.BytesReceived)
+	}
+}
@@ -0,0 +1,43 @@
+//go:build darwin
+
+package main
+
+import (
+	"net"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// macOS has TCP_CONNECTION_INFO instead, which has no minimum RTT or
+// delivery rate, and counts out-of-order data in bytes rather than
+// packets, so those are left at zero.
+const tcpInfoSource = "TCP_CONNECTION_INFO"
+
+// Read TCP_CONNECTION_INFO from `conn`'s socket.
+func readTCPInfo(conn net.Conn) (*tcpInfo, bool) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return nil, false
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return nil, false
+	}
+
+	var ti *unix.TCPConnectionInfo
+	var serr error
+	err = raw.Control(func(fd uintptr) {
+		ti, serr = unix.GetsockoptTCPConnectionInfo(int(fd), unix.IPPROTO_TCP, unix.TCP_CONNECTION_INFO)
+	})
+	if err != nil || serr != nil {
+		return nil, false
+	}
+
+	return &tcpInfo{
+		RTT:           time.Duration(ti.Srtt) * time.Millisecond,
+		Retransmits:   uint32(ti.Txretransmitpackets),
+		BytesReceived: ti.Rxbytes,
+	}, true
+}
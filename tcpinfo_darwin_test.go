@@ -0,0 +1,32 @@
+//go:build darwin
+
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestReadTCPInfo(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, ok := readTCPInfo(conn); !ok {
+		t.Errorf("readTCPInfo() failed on a TCP connection")
+	}
+
+	// A net.Pipe has no socket to ask.
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	if info, ok := readTCPInfo(a); ok {
+		t.Errorf("readTCPInfo(pipe) = %+v", info)
+	}
+}
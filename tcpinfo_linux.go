@@ -0,0 +1,43 @@
+//go:build linux
+
+package main
+
+import (
+	"net"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const tcpInfoSource = "TCP_INFO"
+
+// Read TCP_INFO from `conn`'s socket.
+func readTCPInfo(conn net.Conn) (*tcpInfo, bool) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return nil, false
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return nil, false
+	}
+
+	var ti *unix.TCPInfo
+	var serr error
+	err = raw.Control(func(fd uintptr) {
+		ti, serr = unix.GetsockoptTCPInfo(int(fd), unix.IPPROTO_TCP, unix.TCP_INFO)
+	})
+	if err != nil || serr != nil {
+		return nil, false
+	}
+
+	return &tcpInfo{
+		RTT:           time.Duration(ti.Rtt) * time.Microsecond,
+		MinRTT:        time.Duration(ti.Min_rtt) * time.Microsecond,
+		Retransmits:   ti.Total_retrans,
+		OutOfOrder:    ti.Rcv_ooopack,
+		DeliveryRate:  ti.Delivery_rate,
+		BytesReceived: ti.Bytes_received,
+	}, true
+}
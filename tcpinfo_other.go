@@ -0,0 +1,12 @@
+//go:build !linux && !darwin
+
+package main
+
+import "net"
+
+// There's no socket statistics support on this platform.
+const tcpInfoSource = ""
+
+func readTCPInfo(conn net.Conn) (*tcpInfo, bool) {
+	return nil, false
+}
@@ -0,0 +1,27 @@
+//go:build !linux && !darwin
+
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestTCPInfoUnavailable(t *testing.T) {
+	if tcpInfoSource != "" {
+		t.Errorf("tcpInfoSource = %q", tcpInfoSource)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if info, ok := readTCPInfo(conn); ok || info != nil {
+		t.Errorf("readTCPInfo() = %+v, %v", info, ok)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+// Output filenames can contain {variables}, so that a sweep of runs
+// doesn't overwrite its own results, e.g.
+//
+//	--json 'results-{date}-{bucket}-{readsize}-{runid}.json'
+//
+// --output-dir is prepended to every generated file.
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+var templateVar = regexp.MustCompile(`\{([^{}]*)\}`)
+
+// Return the values for the template variables.
+func outputVars(runID, filename string, now time.Time) map[string]string {
+	host := *endpoint
+	if u, err := url.Parse(*endpoint); err == nil && u.Host != "" {
+		host = u.Hostname()
+	}
+	return map[string]string{
+		"date":     now.Format("20060102"),
+		"time":     now.Format("150405"),
+		"host":     host,
+		"bucket":   *bucket,
+		"file":     path.Base(filename),
+		"readsize": strconv.FormatInt(*readsize, 10),
+		"pattern":  *pattern,
+		"mode":     *mode,
+		"runid":    runID,
+	}
+}
+
+// Expand the {variables} in `tmpl` from `vars`.  Unknown variables
+// are an error.
+func expandTemplate(tmpl string, vars map[string]string) (string, error) {
+	var err error
+	out := templateVar.ReplaceAllStringFunc(tmpl, func(m string) string {
+		name := m[1 : len(m)-1]
+		v, ok := vars[name]
+		if !ok && err == nil {
+			err = fmt.Errorf("unknown variable %s in %q", m, tmpl)
+		}
+		return v
+	})
+	return out, err
+}
+
+// Expand `tmpl` and put it under --output-dir, creating the directory
+// if needed.
+func outputPath(tmpl string, vars map[string]string) (string, error) {
+	name, err := expandTemplate(tmpl, vars)
+	if err != nil {
+		return "", err
+	}
+	if *outputDir == "" {
+		return name, nil
+	}
+	if err := os.MkdirAll(*outputDir, 0o755); err != nil {
+		return "", err
+	}
+	return filepath.Join(*outputDir, name), nil
+}
@@ -0,0 +1,389 @@
+package main
+
+// The cluster serves several apps at once, and the incidents look like
+// this: video streaming melts the filer, and everyone else's small
+// reads starve.  A single homogeneous pattern can't show that, so
+// --tenant describes a mix of workloads to run side by side:
+//
+//	--tenant video=stream,viewers=4,file=big.mp4
+//	--tenant thumbs=random,rate=50,readsize=65536,objects=20,start=20s
+//
+// A "stream" tenant has `viewers` workers each reading a file
+// sequentially, back to back, as fast as they can.  A "random" tenant
+// starts `rate` reads a second of one --readsize chunk at a random
+// place in one of its objects, whether or not the earlier ones have
+// finished; with no `file`, it uploads `objects` generated objects of
+// `objectsize` bytes under --tenant-prefix first, and deletes them at
+// the end.  Each tenant can start `start` after the others, which is
+// how to see what one tenant ramping up does to the rest: the
+// fairness summary compares each tenant's latency before everyone was
+// running with its latency afterward.
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	mrand "math/rand/v2"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// tenant is one workload in a --tenant mix.
+type tenant struct {
+	Name       string        `json:"name"`
+	Kind       string        `json:"kind"` // "stream" or "random"
+	File       string        `json:"file,omitempty"`
+	ReadSize   int64         `json:"readSize"` // 0 for --readsize
+	Start      time.Duration `json:"startNs,omitempty"`
+	Viewers    int           `json:"viewers,omitempty"`    // stream
+	Rate       float64       `json:"rate,omitempty"`       // random, reads per second
+	Workers    int           `json:"workers,omitempty"`    // random, the most reads in flight at once
+	Objects    int           `json:"objects,omitempty"`    // random, when generating objects
+	ObjectSize int64         `json:"objectSize,omitempty"` // random, when generating objects
+}
+
+// tenantList is a repeatable --tenant flag.
+type tenantList []tenant
+
+func (l *tenantList) String() string {
+	var names []string
+	for _, t := range *l {
+		names = append(names, t.Name+"="+t.Kind)
+	}
+	return strings.Join(names, " ")
+}
+
+// Parse "name=stream,key=value,..." or "name=random,key=value,...".
+func (l *tenantList) Set(s string) error {
+	name, rest, found := strings.Cut(s, "=")
+	if !found || name == "" {
+		return fmt.Errorf("want name=stream|random[,key=value...], not %q", s)
+	}
+	for _, t := range *l {
+		if t.Name == name {
+			return fmt.Errorf("tenant %q is given twice", name)
+		}
+	}
+	parts := strings.Split(rest, ",")
+	t := tenant{Name: name, Kind: parts[0]}
+	switch t.Kind {
+	case "stream":
+		t.Viewers = 1
+	case "random":
+		t.Rate = 10
+		t.Workers = 16
+		t.Objects = 20
+		t.ObjectSize = 1 << 20
+	default:
+		return fmt.Errorf("tenant %q: kind must be stream or random, not %q", name, t.Kind)
+	}
+
+	for _, kv := range parts[1:] {
+		key, value, _ := strings.Cut(kv, "=")
+		var err error
+		switch {
+		case key == "file":
+			t.File = value
+		case key == "readsize":
+			t.ReadSize, err = parseOffset(value)
+		case key == "start":
+			t.Start, err = time.ParseDuration(value)
+		case key == "viewers" && t.Kind == "stream":
+			t.Viewers, err = strconv.Atoi(value)
+		case key == "rate" && t.Kind == "random":
+			t.Rate, err = strconv.ParseFloat(value, 64)
+		case key == "workers" && t.Kind == "random":
+			t.Workers, err = strconv.Atoi(value)
+		case key == "objects" && t.Kind == "random":
+			t.Objects, err = strconv.Atoi(value)
+		case key == "objectsize" && t.Kind == "random":
+			t.ObjectSize, err = parseOffset(value)
+		default:
+			return fmt.Errorf("tenant %q: unknown %s setting %q", name, t.Kind, key)
+		}
+		if err != nil {
+			return fmt.Errorf("tenant %q: bad %s: %v", name, key, err)
+		}
+	}
+
+	switch {
+	case t.Start < 0:
+		return fmt.Errorf("tenant %q: start can't be negative", name)
+	case t.Kind == "stream" && t.Viewers < 1:
+		return fmt.Errorf("tenant %q: viewers must be at least 1", name)
+	case t.Kind == "random" && (t.Rate <= 0 || t.Workers < 1):
+		return fmt.Errorf("tenant %q: rate must be positive and workers at least 1", name)
+	case t.Kind == "random" && t.File == "" && (t.Objects < 1 || t.ObjectSize == 0):
+		return fmt.Errorf("tenant %q: needs at least 1 object, of at least 1 byte", name)
+	}
+	*l = append(*l, t)
+	return nil
+}
+
+// tenantObject is an object a tenant reads from.
+type tenantObject struct {
+	key  string
+	size int64
+}
+
+// tenantsResult is what --json gets with --tenant.
+type tenantsResult struct {
+	SchemaVersion string          `json:"schemaVersion"` // see the schema package
+	Tenants       []*tenantResult `json:"tenants"`
+}
+
+// tenantResult is what happened to one tenant.
+type tenantResult struct {
+	Tenant   tenant        `json:"tenant"`
+	Reads    int           `json:"reads"`
+	Errors   int           `json:"errors"`
+	Dropped  int           `json:"dropped,omitempty"` // random reads that couldn't start because every worker was busy
+	Bytes    int64         `json:"bytes"`
+	Duration time.Duration `json:"durationNs"`
+	Latency  latencyStats  `json:"latency"`
+
+	// Before and after every tenant was running, if they didn't all
+	// start at once.
+	Alone    *latencyStats `json:"alone,omitempty"`
+	Together *latencyStats `json:"together,omitempty"`
+
+	Samples []*Sample `json:"samples"`
+
+	objects []tenantObject
+	uploads []string // generated objects to delete afterward
+	mu      sync.Mutex
+}
+
+func (r *tenantResult) add(s *Sample) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s.Label = r.Tenant.Name
+	r.Samples = append(r.Samples, s)
+}
+
+// Find or create the objects each tenant reads.
+func prepareTenant(ctx context.Context, client *s3.Client, r *tenantResult, prefix, runID, defaultFile string, defaultSize int64) error {
+	if r.Tenant.ReadSize == 0 {
+		r.Tenant.ReadSize = *readsize
+	}
+	t := r.Tenant
+	file := t.File
+	if file == "" && t.Kind == "stream" {
+		file = defaultFile
+	}
+	if file != "" {
+		size := defaultSize
+		if file != defaultFile {
+			head, err := client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: bucket, Key: aws.String(file)})
+			if err != nil {
+				return fmt.Errorf("tenant %s: %w", t.Name, err)
+			}
+			size = aws.ToInt64(head.ContentLength)
+		}
+		if size < t.ReadSize {
+			return fmt.Errorf("tenant %s: %s is only %d bytes, less than readsize %d", t.Name, file, size, t.ReadSize)
+		}
+		r.objects = []tenantObject{{key: file, size: size}}
+		return nil
+	}
+
+	if t.ObjectSize < t.ReadSize {
+		return fmt.Errorf("tenant %s: objectsize %d is less than readsize %d", t.Name, t.ObjectSize, t.ReadSize)
+	}
+	fmt.Printf("Tenant %s: uploading %d generated %d byte objects under %s%s/%s/\n", t.Name, t.Objects, t.ObjectSize, prefix, runID, t.Name)
+	for i := range t.Objects {
+		key := fmt.Sprintf("%s%s/%s/%d", prefix, runID, t.Name, i)
+		seed := make([]byte, 8)
+		rand.Read(seed)
+		_, err := client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:        bucket,
+			Key:           aws.String(key),
+			Body:          newGeneratedReader(binary.LittleEndian.Uint64(seed), t.ObjectSize),
+			ContentLength: aws.Int64(t.ObjectSize),
+		})
+		if err != nil {
+			return fmt.Errorf("tenant %s: unable to upload %s: %w", t.Name, key, err)
+		}
+		r.uploads = append(r.uploads, key)
+		r.objects = append(r.objects, tenantObject{key: key, size: t.ObjectSize})
+	}
+	return nil
+}
+
+// Run a stream tenant until `deadline`: each viewer reads sequentially
+// from its own starting point, spread across the file so the viewers
+// aren't all reading the same chunks.
+func runStreamTenant(ctx context.Context, backend Backend, r *tenantResult, deadline time.Time) {
+	t := r.Tenant
+	obj := r.objects[0]
+	slots := obj.size / t.ReadSize
+	var wg sync.WaitGroup
+	for v := range t.Viewers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slot := mulDiv(int64(v), slots, int64(t.Viewers))
+			for time.Now().Before(deadline) && production.check() == nil {
+				sample, _ := readFrom(ctx, backend, obj.key, slot*t.ReadSize, t.ReadSize, obj.size)
+				sample.Worker = v
+				r.add(sample)
+				slot = (slot + 1) % slots
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Run a random tenant until `deadline`.  Reads start at the tenant's
+// rate whether or not the earlier ones have finished, like requests
+// from independent users, up to `workers` at once.  Reads that can't
+// start because every worker is busy are dropped and counted.
+func runRandomTenant(ctx context.Context, backend Backend, r *tenantResult, deadline time.Time) {
+	type read struct {
+		object tenantObject
+		offset int64
+	}
+	t := r.Tenant
+	reads := make(chan read)
+	var wg sync.WaitGroup
+	for w := range t.Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for rd := range reads {
+				sample, _ := readFrom(ctx, backend, rd.object.key, rd.offset, t.ReadSize, rd.object.size)
+				sample.Worker = w
+				r.add(sample)
+			}
+		}()
+	}
+
+	interval := time.Duration(float64(time.Second) / t.Rate)
+	next := time.Now()
+	for next.Before(deadline) && production.check() == nil {
+		time.Sleep(time.Until(next))
+		obj := r.objects[mrand.IntN(len(r.objects))]
+		select {
+		case reads <- read{object: obj, offset: mrand.Int64N(obj.size/t.ReadSize) * t.ReadSize}:
+		default:
+			r.mu.Lock()
+			r.Dropped++
+			r.mu.Unlock()
+		}
+		next = next.Add(interval)
+	}
+	close(reads)
+	wg.Wait()
+}
+
+// Run every tenant at once for `duration`, and print how they fared.
+func runTenants(ctx context.Context, client *s3.Client, backend Backend, tenants []tenant, duration time.Duration, prefix, runID, filename string, filesize int64) ([]*tenantResult, error) {
+	results := make([]*tenantResult, len(tenants))
+	var lastStart time.Duration
+	for i, t := range tenants {
+		results[i] = &tenantResult{Tenant: t}
+		lastStart = max(lastStart, t.Start)
+	}
+	if lastStart >= duration {
+		return nil, fmt.Errorf("--tenant-duration %v is over before the last tenant starts at %v", duration, lastStart)
+	}
+
+	defer func() {
+		for _, r := range results {
+			for _, key := range r.uploads {
+				_, err := client.DeleteObject(exemptFromLimits(ctx), &s3.DeleteObjectInput{Bucket: bucket, Key: aws.String(key)})
+				if err != nil {
+					fmt.Printf("Unable to delete tenant object %q: %v\n", key, err)
+				}
+			}
+		}
+	}()
+	for _, r := range results {
+		if err := prepareTenant(ctx, client, r, prefix, runID, filename, filesize); err != nil {
+			return nil, err
+		}
+	}
+
+	start := time.Now()
+	deadline := start.Add(duration)
+	var wg sync.WaitGroup
+	for _, r := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			time.Sleep(r.Tenant.Start)
+			fmt.Printf("Tenant %s: starting\n", r.Tenant.Name)
+			began := time.Now()
+			if r.Tenant.Kind == "stream" {
+				runStreamTenant(ctx, backend, r, deadline)
+			} else {
+				runRandomTenant(ctx, backend, r, deadline)
+			}
+			r.Duration = time.Since(began)
+		}()
+	}
+	wg.Wait()
+
+	allRunning := start.Add(lastStart)
+	for _, r := range results {
+		var all, alone, together []time.Duration
+		for _, s := range r.Samples {
+			r.Reads++
+			if s.Err != "" {
+				r.Errors++
+				continue
+			}
+			r.Bytes += s.Bytes
+			all = append(all, s.Duration)
+			if s.Start.Before(allRunning) {
+				alone = append(alone, s.Duration)
+			} else {
+				together = append(together, s.Duration)
+			}
+		}
+		r.Latency = computeLatencyStats(all)
+		if len(alone) > 0 && len(together) > 0 {
+			a, t := computeLatencyStats(alone), computeLatencyStats(together)
+			r.Alone, r.Together = &a, &t
+		}
+	}
+	printTenants(results, lastStart)
+	return results, nil
+}
+
+// Print one line per tenant, and then how each tenant that was running
+// before the last one started was affected by it.
+func printTenants(results []*tenantResult, lastStart time.Duration) {
+	fmt.Printf("%-12s %-7s %8s %7s %8s %12s %10s %10s %10s\n", "tenant", "kind", "reads", "errors", "dropped", units.rateUnit(), "p50", "p90", "p99")
+	for _, r := range results {
+		fmt.Printf("%-12s %-7s %8d %7d %8d %12.3f %10.3f %10.3f %10.3f\n", r.Tenant.Name, r.Tenant.Kind, r.Reads, r.Errors, r.Dropped,
+			units.rateValue(r.Bytes, r.Duration), r.Latency.P50.Seconds(), r.Latency.P90.Seconds(), r.Latency.P99.Seconds())
+	}
+
+	if lastStart == 0 {
+		fmt.Printf("Every tenant started at once; give some of them a start= delay to see how the others degrade when they ramp up\n")
+		return
+	}
+	ratio := func(a, b time.Duration) string {
+		if b == 0 {
+			return "n/a"
+		}
+		return fmt.Sprintf("%.2fx", a.Seconds()/b.Seconds())
+	}
+	fmt.Printf("Fairness: latency before and after every tenant was running (from %v in)\n", lastStart)
+	for _, r := range results {
+		if r.Alone == nil {
+			continue
+		}
+		fmt.Printf("  %s: p50 %.3fs -> %.3fs (%s), p90 %.3fs -> %.3fs (%s), p99 %.3fs -> %.3fs (%s)\n", r.Tenant.Name,
+			r.Alone.P50.Seconds(), r.Together.P50.Seconds(), ratio(r.Together.P50, r.Alone.P50),
+			r.Alone.P90.Seconds(), r.Together.P90.Seconds(), ratio(r.Together.P90, r.Alone.P90),
+			r.Alone.P99.Seconds(), r.Together.P99.Seconds(), ratio(r.Together.P99, r.Alone.P99))
+	}
+}
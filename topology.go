@@ -0,0 +1,102 @@
+package main
+
+// Results are hard to interpret later without knowing what the
+// cluster looked like at the time: how many volume servers there
+// were, and where the file's chunks lived.  With --topology-url
+// (the SeaweedFS master's /dir/status, say, or /cluster/status), we
+// save the cluster's status JSON at the start and end of the run.
+// With --filer-url, we also save the file's chunk list from the
+// filer's metadata API, so slow offsets can be matched up with the
+// volumes they're stored on.  These are best-effort: failures are
+// warnings, not errors.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// topologySnapshot is the cluster state around a run, as raw JSON
+// from SeaweedFS.
+type topologySnapshot struct {
+	URL    string          `json:"url,omitempty"`
+	Start  json.RawMessage `json:"start,omitempty"`
+	End    json.RawMessage `json:"end,omitempty"`
+	Entry  json.RawMessage `json:"entry,omitempty"` // from the filer, with the chunk list
+	Chunks []chunkInfo     `json:"chunks,omitempty"`
+}
+
+// chunkInfo is the part of a filer chunk that we care about.
+type chunkInfo struct {
+	FileID string `json:"file_id"`
+	Offset int64  `json:"offset"`
+	Size   int64  `json:"size"`
+}
+
+// This doesn't go through `upstream`, so it isn't counted as part of
+// the benchmark's traffic.
+var statusClient = &http.Client{Timeout: 10 * time.Second}
+
+// Fetch `u` and return its body, which must be JSON.
+func fetchJSON(u string) (json.RawMessage, error) {
+	resp, err := statusClient.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: HTTP %d", u, resp.StatusCode)
+	}
+	if !json.Valid(body) {
+		return nil, fmt.Errorf("%s didn't return JSON", u)
+	}
+	return body, nil
+}
+
+// Fetch the cluster status, warning if we can't.
+func fetchTopology(u string) json.RawMessage {
+	body, err := fetchJSON(u)
+	if err != nil {
+		fmt.Printf("WARNING: unable to fetch topology: %v\n", err)
+		return nil
+	}
+	return body
+}
+
+// Return the URL of `key`'s filer entry, which lives under `bucketDir`
+// on the filer at `filerURL`.
+func filerEntryURL(filerURL, bucketDir, bucket, key string) string {
+	return strings.TrimSuffix(filerURL, "/") + "/" + strings.Trim(bucketDir, "/") + "/" + url.PathEscape(bucket) + "/" + escapeKey(key) + "?metadata=true"
+}
+
+// Fetch `key`'s filer entry, and pull out its chunks.
+func fetchChunks(filerURL, bucketDir, bucket, key string) (json.RawMessage, []chunkInfo) {
+	body, err := fetchJSON(filerEntryURL(filerURL, bucketDir, bucket, key))
+	if err != nil {
+		fmt.Printf("WARNING: unable to fetch the file's chunk list: %v\n", err)
+		return nil, nil
+	}
+	var entry struct {
+		Chunks []chunkInfo `json:"chunks"`
+	}
+	if err := json.Unmarshal(body, &entry); err != nil {
+		fmt.Printf("WARNING: unable to parse the file's chunk list: %v\n", err)
+	}
+	return body, entry.Chunks
+}
+
+// Print a line per chunk.
+func printChunks(chunks []chunkInfo) {
+	fmt.Printf("File has %d chunks:\n", len(chunks))
+	for _, c := range chunks {
+		fmt.Printf("  offset %d, %d bytes, file ID %s\n", c.Offset, c.Size, c.FileID)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+// Optional OpenTelemetry tracing.  With --otlp-endpoint set, each run
+// gets a root span, each readFrom() gets a child span, and the
+// Open/Seek/drain phases get their own spans below that.  The trace
+// context is sent to the server as a W3C `traceparent` header so that
+// SeaweedFS's own traces can be joined with ours.
+//
+// With --otlp-endpoint unset, `tracer` is a no-op and the propagation
+// middleware isn't installed at all.
+
+import (
+	"context"
+
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+var (
+	tracer         trace.Tracer = noop.NewTracerProvider().Tracer("")
+	tracingEnabled bool
+)
+
+// Start exporting traces to `endpoint`.  The returned function
+// flushes and shuts down the exporter.
+func setupTracing(ctx context.Context, endpoint string) (func(context.Context) error, error) {
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName("s3test"))),
+	)
+	tracer = provider.Tracer("github.com/scottlaird/s3test")
+	tracingEnabled = true
+
+	return provider.Shutdown, nil
+}
+
+// Start a span for one phase of a read.  The returned function ends
+// the span.
+func startPhase(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, func()) {
+	ctx, span := tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+	return ctx, func() { span.End() }
+}
+
+// SDK middleware that adds a `traceparent` header to every request.
+// Use this in s3.Options.APIOptions.
+func propagateTrace(stack *middleware.Stack) error {
+	return stack.Build.Add(middleware.BuildMiddlewareFunc("PropagateTrace",
+		func(ctx context.Context, in middleware.BuildInput, next middleware.BuildHandler) (middleware.BuildOutput, middleware.Metadata, error) {
+			if req, ok := in.Request.(*smithyhttp.Request); ok {
+				propagation.TraceContext{}.Inject(ctx, propagation.HeaderCarrier(req.Header))
+			}
+			return next.HandleBuild(ctx, in)
+		}), middleware.After)
+}
@@ -0,0 +1,250 @@
+package main
+
+// Every HTTP request that we send, whether from s3fs, the SDK, or
+// the http backends, goes through `upstream`, which records what was
+// asked for and how much actually came back.  That lets us compare
+// what the client stack *requested* with what the benchmark wanted.
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+)
+
+// recordedRequest is one HTTP request seen by the recording
+// transport.
+type recordedRequest struct {
+	Method string
+	Host   string
+	Path   string
+	Range  string
+	Status int
+	Proto  string // like HTTP/1.1 or HTTP/2.0; see httpversion.go
+	Start  time.Time
+
+	// The response's Content-Length, or -1 if it didn't have one.
+	contentLength int64
+
+	// Bytes of response body actually read by the client.
+	received atomic.Int64
+
+	// The connection the request went out on, for TCP_INFO, its
+	// ID (or -1 if we never got one), and whether it had been
+	// used before.
+	conn       net.Conn
+	connID     int
+	connReused bool
+
+	// Set if the body was closed before EOF, along with the
+	// socket's received byte counter when we got the connection
+	// and when we closed the body.
+	cancelled          bool
+	wireStart, wireEnd int64
+	wireMeasured       bool
+
+	// Set if the response was bigger than the range asked for;
+	// see fullbody.go.
+	fullBody bool
+
+	// When the response headers arrived, and when the body hit
+	// EOF or was closed; see responsetiming.go.
+	mu        sync.Mutex
+	headersAt time.Time
+	doneAt    time.Time
+
+	// The response headers, kept only for --slow-read-threshold.
+	header http.Header
+
+	// Where a redirect response pointed, and for the request that
+	// followed one, the request that was redirected; see
+	// redirect.go.
+	Location       string
+	redirectedFrom *recordedRequest
+}
+
+func (r *recordedRequest) Received() int64 {
+	return r.received.Load()
+}
+
+func (r *recordedRequest) timestamps() (headersAt, doneAt time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.headersAt, r.doneAt
+}
+
+// Note that the body is finished, if it wasn't already.
+func (r *recordedRequest) done() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.doneAt.IsZero() {
+		r.doneAt = time.Now()
+	}
+}
+
+// recordingTransport is an http.RoundTripper that remembers every
+// request sent through it.
+type recordingTransport struct {
+	inner http.RoundTripper
+
+	mu       sync.Mutex
+	requests []*recordedRequest
+
+	connections connectionTracker
+	connIDs     map[net.Conn]int
+}
+
+var (
+	upstream   = &recordingTransport{inner: awshttp.NewBuildableClient().GetTransport()}
+	httpClient = &http.Client{Transport: upstream, CheckRedirect: checkRedirect}
+)
+
+type unrecordedKey struct{}
+type recordedRequestKey struct{}
+
+// Return a context whose requests the transport doesn't record, for
+// checks that shouldn't count toward the run's requests.
+func unrecorded(ctx context.Context) context.Context {
+	return context.WithValue(ctx, unrecordedKey{}, true)
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	waitForRequest(req.Context())
+	// Cleaning up after ourselves isn't part of the run, and isn't
+	// held to its production limits.
+	if req.Context().Value(limitExemptKey{}) != nil {
+		return t.inner.RoundTrip(req)
+	}
+	if err := production.check(); err != nil {
+		return nil, err
+	}
+	if req.Context().Value(unrecordedKey{}) != nil {
+		return t.inner.RoundTrip(req)
+	}
+	rec := &recordedRequest{
+		Method: req.Method,
+		Host:   req.URL.Host,
+		Path:   req.URL.Path,
+		Range:  req.Header.Get("Range"),
+		Start:  time.Now(),
+		connID: -1,
+	}
+	if req.Response != nil && req.Response.Request != nil {
+		// net/http is following a redirect.
+		rec.redirectedFrom, _ = req.Response.Request.Context().Value(recordedRequestKey{}).(*recordedRequest)
+	}
+	t.mu.Lock()
+	t.requests = append(t.requests, rec)
+	t.mu.Unlock()
+
+	ctx := context.WithValue(req.Context(), recordedRequestKey{}, rec)
+	req = req.WithContext(httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			rec.conn = info.Conn
+			rec.connID = t.connID(info.Conn)
+			rec.connReused = info.Reused
+			if c, ok := req.Context().Value(connectionSetKey{}).(*connectionSet); ok {
+				c.add(rec.connID)
+			}
+			if cancel.enabled() {
+				rec.wireStart, rec.wireMeasured = socketBytesReceived(info.Conn)
+			}
+		},
+	}))
+
+	resp, err := t.inner.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	rec.mu.Lock()
+	rec.headersAt = time.Now()
+	rec.mu.Unlock()
+	if r, ok := req.Context().Value(responseSetKey{}).(*responseSet); ok {
+		r.add(rec)
+	}
+	rec.Status = resp.StatusCode
+	rec.Proto = resp.Proto
+	rec.contentLength = resp.ContentLength
+	if resp.StatusCode >= 300 && resp.StatusCode < 400 {
+		rec.Location = resp.Header.Get("Location")
+	}
+	if keepResponseHeaders() {
+		rec.header = resp.Header.Clone()
+	}
+	if f, bad := checkRangedResponse(req, resp); bad {
+		rec.fullBody = true
+		noteFullBody(req, resp, f)
+	}
+	resp.Body = &countingBody{ReadCloser: resp.Body, rec: rec, tracker: &t.connections}
+	return resp, nil
+}
+
+// Return the ID for `conn`, numbering connections in the order we
+// first see them.
+func (t *recordingTransport) connID(conn net.Conn) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	id, ok := t.connIDs[conn]
+	if !ok {
+		if t.connIDs == nil {
+			t.connIDs = make(map[net.Conn]int)
+		}
+		id = len(t.connIDs)
+		t.connIDs[conn] = id
+	}
+	return id
+}
+
+// Return a copy of the requests recorded so far.
+func (t *recordingTransport) Requests() []*recordedRequest {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]*recordedRequest(nil), t.requests...)
+}
+
+type countingBody struct {
+	io.ReadCloser
+	rec     *recordedRequest
+	tracker *connectionTracker
+	closed  bool
+	eof     bool
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.rec.received.Add(int64(n))
+	if perr := production.read(int64(n)); perr != nil {
+		err = perr
+	}
+	if err == io.EOF {
+		b.eof = true
+		b.rec.done()
+	}
+	return n, err
+}
+
+func (b *countingBody) Close() error {
+	if !b.closed && !b.eof && cancel.enabled() {
+		// This has to happen before the transport closes
+		// the connection out from under us.
+		b.rec.cancelled = true
+		ok := false
+		if b.rec.conn != nil {
+			b.rec.wireEnd, ok = socketBytesReceived(b.rec.conn)
+		}
+		b.rec.wireMeasured = b.rec.wireMeasured && ok
+	}
+	b.rec.done()
+	err := b.ReadCloser.Close()
+	if !b.closed && b.rec.conn != nil {
+		b.tracker.sample(b.rec.conn, b.rec.connID)
+	}
+	b.closed = true
+	return err
+}
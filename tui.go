@@ -0,0 +1,270 @@
+package main
+
+// With --concurrency 16, one line per read scrolls by far too fast to
+// follow.  --tui draws a full-screen view instead, with a row for each
+// worker showing what it's reading right now, how long that read has
+// been in flight, and how fast its recent reads were.  It's just
+// plain ANSI escapes, redrawn a few times a second.
+//
+// The TUI is purely cosmetic: --json, --jsonl, and --stream-fifo get
+// exactly what they would without it.  The usual per-read output is
+// captured while the TUI is up, shown in a small log pane at the
+// bottom, and printed normally once the run is over.
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// How often to redraw the screen.
+const tuiRefresh = 250 * time.Millisecond
+
+// How many recent reads each worker's throughput covers.
+const tuiRecentReads = 5
+
+// tuiWorker is what one worker is up to.
+type tuiWorker struct {
+	busy    bool
+	offset  int64
+	size    int64
+	started time.Time
+	reads   int
+	recent  []*Sample // the last few finished reads
+}
+
+// Return the worker's throughput over its recent reads.
+func (w *tuiWorker) rate() string {
+	var bytes int64
+	var dur time.Duration
+	for _, s := range w.recent {
+		bytes += s.Bytes
+		dur += s.Duration
+	}
+	if dur == 0 {
+		return "-"
+	}
+	return units.rate(bytes, dur)
+}
+
+type tui struct {
+	term     *os.File // the real stdout
+	filename string
+	filesize int64
+
+	// Everything printed while the TUI is up goes to `pipe`
+	// instead of the terminal.
+	pipe     *os.File
+	captured bytes.Buffer
+	logLines []string
+
+	mu      sync.Mutex
+	start   time.Time
+	workers []tuiWorker
+	reads   int
+	errors  int
+	bytes   int64
+
+	done    chan struct{}
+	stopped sync.WaitGroup
+	closed  bool
+	signals chan os.Signal
+}
+
+// Is `f` a terminal?
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Take over the terminal for a run reading `filename`.  Returns nil if
+// stdout isn't a terminal, in which case the run should just use the
+// normal output.  Close() must be called to restore the terminal.
+func startTUI(filename string, filesize int64) (*tui, error) {
+	if !isTerminal(os.Stdout) {
+		fmt.Printf("--tui: stdout isn't a terminal, so using the normal output\n")
+		return nil, nil
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+
+	t := &tui{
+		term:     os.Stdout,
+		filename: filename,
+		filesize: filesize,
+		pipe:     w,
+		start:    time.Now(),
+		done:     make(chan struct{}),
+		signals:  make(chan os.Signal, 1),
+	}
+	os.Stdout = w
+
+	// Enter the alternate screen and hide the cursor.
+	fmt.Fprint(t.term, "\x1b[?1049h\x1b[?25l")
+
+	t.stopped.Add(2)
+	go t.capture(r)
+	go t.refresh()
+
+	// A ^C would otherwise leave the terminal on the alternate
+	// screen with no cursor.
+	signal.Notify(t.signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		if _, ok := <-t.signals; ok {
+			t.Close()
+			os.Exit(130)
+		}
+	}()
+	return t, nil
+}
+
+// Collect everything printed while the TUI is up.
+func (t *tui) capture(r io.ReadCloser) {
+	defer t.stopped.Done()
+	defer r.Close()
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		t.mu.Lock()
+		t.captured.WriteString(line + "\n")
+		t.logLines = append(t.logLines, line)
+		if len(t.logLines) > 100 {
+			t.logLines = t.logLines[len(t.logLines)-100:]
+		}
+		t.mu.Unlock()
+	}
+}
+
+func (t *tui) refresh() {
+	defer t.stopped.Done()
+	ticker := time.NewTicker(tuiRefresh)
+	defer ticker.Stop()
+	for {
+		t.draw()
+		select {
+		case <-t.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Note that `worker` has started reading `r`.
+func (t *tui) begin(worker int, r readRange) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for len(t.workers) <= worker {
+		t.workers = append(t.workers, tuiWorker{})
+	}
+	w := &t.workers[worker]
+	w.busy = true
+	w.offset = r.offset
+	w.size = r.size
+	w.started = time.Now()
+}
+
+// Note that `worker` has finished a read.
+func (t *tui) end(worker int, sample *Sample) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	w := &t.workers[worker]
+	w.busy = false
+	w.reads++
+	t.reads++
+	if sample.Err != "" {
+		t.errors++
+		return
+	}
+	t.bytes += sample.Bytes
+	w.recent = append(w.recent, sample)
+	if len(w.recent) > tuiRecentReads {
+		w.recent = w.recent[1:]
+	}
+}
+
+// Redraw the whole screen.
+func (t *tui) draw() {
+	width, height := terminalSize(t.term)
+
+	t.mu.Lock()
+	elapsed := time.Since(t.start)
+	lines := []string{
+		fmt.Sprintf("s3test: %s (%d bytes) via %s, %.1fs elapsed", t.filename, t.filesize, *mode, elapsed.Seconds()),
+		fmt.Sprintf("%d reads, %d errors, %s at %s", t.reads, t.errors, units.bytes(t.bytes), units.rate(t.bytes, elapsed)),
+		"",
+		fmt.Sprintf("%6s %15s %12s %10s %6s %s", "worker", "offset", "size", "in flight", "reads", "recent rate"),
+	}
+	for i := range t.workers {
+		w := &t.workers[i]
+		if !w.busy {
+			lines = append(lines, fmt.Sprintf("%6d %15s %12s %10s %6d %s", i, "idle", "", "", w.reads, w.rate()))
+			continue
+		}
+		pct := percentOf(w.offset, t.filesize)
+		lines = append(lines, fmt.Sprintf("%6d %15d %12d %9.3fs %6d %s  (%.1f%%)", i, w.offset, w.size, time.Since(w.started).Seconds(), w.reads, w.rate(), pct))
+	}
+	lines = append(lines, "")
+
+	// Fill what's left of the screen with the most recent output.
+	if room := height - len(lines) - 1; room > 0 {
+		log := t.logLines[max(len(t.logLines)-room, 0):]
+		lines = append(lines, log...)
+	}
+	t.mu.Unlock()
+
+	var frame strings.Builder
+	frame.WriteString("\x1b[H")
+	for i, line := range lines {
+		if i >= height-1 {
+			break
+		}
+		if len(line) > width {
+			line = line[:width]
+		}
+		frame.WriteString(line)
+		frame.WriteString("\x1b[K\n")
+	}
+	frame.WriteString("\x1b[J")
+	t.term.WriteString(frame.String())
+}
+
+// Put the terminal back the way it was, and print everything that was
+// captured while the TUI was up.  It's safe to call this more than
+// once, or on a nil *tui.
+func (t *tui) Close() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return
+	}
+	t.closed = true
+	t.mu.Unlock()
+
+	signal.Stop(t.signals)
+	close(t.signals)
+	os.Stdout = t.term
+	t.pipe.Close()
+	close(t.done)
+	t.stopped.Wait()
+
+	fmt.Fprint(t.term, "\x1b[?25h\x1b[?1049l")
+	t.term.Write(t.captured.Bytes())
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// We don't know how to ask, so assume the traditional size.
+func terminalSize(f *os.File) (width, height int) {
+	return 80, 24
+}
@@ -0,0 +1,115 @@
+package main
+
+// Results get pasted into issues by people who think in Mbps, MB/s,
+// or GiB, so every human-readable rate and size goes through the
+// helpers here, and --units/--si/--iec pick how they're shown.  The
+// JSON, JSONL, and CSV outputs always use raw bytes, nanoseconds, and
+// (for compatibility) decimal megabits per second.
+//
+// Go's fmt doesn't know about locales, so numbers always come out
+// with a '.' decimal point and no thousands separators, whatever
+// LANG says.
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// outputUnits controls human-readable formatting.
+type outputUnits struct {
+	bits bool // rates in bits/second rather than bytes/second
+	iec  bool // powers of 1024 rather than 1000
+}
+
+// units is set from --units, --si, and --iec by parseUnits.
+var units = outputUnits{bits: true}
+
+// Set `units` from the command-line flags.
+func parseUnits(name string, si, iec bool) error {
+	if si && iec {
+		return fmt.Errorf("--si and --iec can't both be set")
+	}
+	switch name {
+	case "bits":
+		units.bits = true
+	case "bytes":
+		units.bits = false
+	default:
+		return fmt.Errorf("unknown --units %q; use bits or bytes", name)
+	}
+	units.iec = iec
+	return nil
+}
+
+// Return the multiplier for each prefix step.
+func (u outputUnits) base() float64 {
+	if u.iec {
+		return 1024
+	}
+	return 1000
+}
+
+// Return `n` scaled down to a sensible prefix, and the prefix itself,
+// like "M" or "Mi".
+func (u outputUnits) scale(n float64) (float64, string) {
+	prefixes := []string{"", "k", "M", "G", "T", "P"}
+	if u.iec {
+		prefixes = []string{"", "Ki", "Mi", "Gi", "Ti", "Pi"}
+	}
+	i := 0
+	for n >= u.base() && i < len(prefixes)-1 {
+		n /= u.base()
+		i++
+	}
+	return n, prefixes[i]
+}
+
+// Return the unit for rates in rateValue, like "Mbps" or "MiB/s".
+// Rates in tables are always in mega/mebi units so that columns
+// line up.
+func (u outputUnits) rateUnit() string {
+	prefix := "M"
+	if u.iec {
+		prefix = "Mi"
+	}
+	if u.bits {
+		return prefix + "bps"
+	}
+	return prefix + "B/s"
+}
+
+// Return the rate for reading `bytes` in `d`, in rateUnit() units,
+// or 0 if no time has passed.
+func (u outputUnits) rateValue(bytes int64, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	n := float64(bytes) / d.Seconds()
+	if u.bits {
+		n *= 8
+	}
+	return n / (u.base() * u.base())
+}
+
+// Format the rate for reading `bytes` in `d`, like "68.200 Mbps".
+func (u outputUnits) rate(bytes int64, d time.Duration) string {
+	return strconv.FormatFloat(u.rateValue(bytes, d), 'f', 3, 64) + " " + u.rateUnit()
+}
+
+// Format a byte count for people, like "40.30 MB (40304640 bytes)".
+// Small counts are just "512 bytes".
+func (u outputUnits) bytes(n int64) string {
+	v, prefix := u.scale(float64(n))
+	if prefix == "" {
+		return strconv.FormatInt(n, 10) + " bytes"
+	}
+	return strconv.FormatFloat(v, 'f', 2, 64) + " " + prefix + "B (" + strconv.FormatInt(n, 10) + " bytes)"
+}
+
+// Throughput in decimal megabits per second, or 0 if no time has
+// passed.  This is what goes into machine-readable output, whatever
+// --units says.
+func mbps(bytes int64, d time.Duration) float64 {
+	return outputUnits{bits: true}.rateValue(bytes, d)
+}
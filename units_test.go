@@ -0,0 +1,102 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+var (
+	si  = outputUnits{}
+	iec = outputUnits{iec: true}
+)
+
+func TestUnitsBytes(t *testing.T) {
+	for _, tc := range []struct {
+		u    outputUnits
+		n    int64
+		want string
+	}{
+		{si, 0, "0 bytes"},
+		{si, 999, "999 bytes"},
+		{si, 1000, "1.00 kB (1000 bytes)"},
+		{si, 999999, "1000.00 kB (999999 bytes)"},
+		{si, 1000000, "1.00 MB (1000000 bytes)"},
+		{si, 40304640, "40.30 MB (40304640 bytes)"},
+		{si, 5 << 40, "5.50 TB (5497558138880 bytes)"},
+		{si, 1e15, "1.00 PB (1000000000000000 bytes)"},
+		// There's no prefix past P.
+		{si, math.MaxInt64, "9223.37 PB (9223372036854775807 bytes)"},
+		{iec, 0, "0 bytes"},
+		{iec, 1000, "1000 bytes"},
+		{iec, 1023, "1023 bytes"},
+		{iec, 1024, "1.00 KiB (1024 bytes)"},
+		{iec, 1 << 20, "1.00 MiB (1048576 bytes)"},
+		{iec, 1<<30 - 1, "1024.00 MiB (1073741823 bytes)"},
+		{iec, 5 << 40, "5.00 TiB (5497558138880 bytes)"},
+		{iec, math.MaxInt64, "8192.00 PiB (9223372036854775807 bytes)"},
+	} {
+		if got := tc.u.bytes(tc.n); got != tc.want {
+			t.Errorf("%+v.bytes(%d) = %q, want %q", tc.u, tc.n, got, tc.want)
+		}
+	}
+}
+
+func TestUnitsRate(t *testing.T) {
+	for _, tc := range []struct {
+		u     outputUnits
+		bytes int64
+		d     time.Duration
+		want  string
+	}{
+		{outputUnits{bits: true}, 1000000, time.Second, "8.000 Mbps"},
+		{outputUnits{bits: true}, 8525000, time.Second, "68.200 Mbps"},
+		{outputUnits{bits: true, iec: true}, 1 << 20, time.Second, "8.000 Mibps"},
+		{si, 1000000, time.Second, "1.000 MB/s"},
+		{si, 1000000, 2 * time.Second, "0.500 MB/s"},
+		{iec, 1 << 20, time.Second, "1.000 MiB/s"},
+		{si, 0, time.Second, "0.000 MB/s"},
+		{si, 1000000, 0, "0.000 MB/s"},
+		{si, 1000000, -time.Second, "0.000 MB/s"},
+		{si, 5 << 40, time.Hour, "1527.099 MB/s"},
+	} {
+		if got := tc.u.rate(tc.bytes, tc.d); got != tc.want {
+			t.Errorf("%+v.rate(%d, %v) = %q, want %q", tc.u, tc.bytes, tc.d, got, tc.want)
+		}
+	}
+}
+
+func TestMbps(t *testing.T) {
+	// Always decimal megabits, whatever --units says.
+	setFlag(t, &units, outputUnits{iec: true})
+	if got := mbps(1000000, time.Second); got != 8 {
+		t.Errorf("mbps(1000000, 1s) = %g, want 8", got)
+	}
+	if got := mbps(1000000, 0); got != 0 {
+		t.Errorf("mbps(1000000, 0) = %g, want 0", got)
+	}
+}
+
+func TestParseUnits(t *testing.T) {
+	setFlag(t, &units, units)
+	for _, tc := range []struct {
+		name    string
+		si, iec bool
+		want    outputUnits
+		ok      bool
+	}{
+		{"bits", false, false, outputUnits{bits: true}, true},
+		{"bytes", false, false, outputUnits{}, true},
+		{"bytes", false, true, outputUnits{iec: true}, true},
+		{"bits", true, false, outputUnits{bits: true}, true},
+		{"bits", true, true, outputUnits{}, false},
+		{"nibbles", false, false, outputUnits{}, false},
+	} {
+		err := parseUnits(tc.name, tc.si, tc.iec)
+		if (err == nil) != tc.ok {
+			t.Errorf("parseUnits(%q, %v, %v) = %v", tc.name, tc.si, tc.iec, err)
+		} else if tc.ok && units != tc.want {
+			t.Errorf("parseUnits(%q, %v, %v) set %+v, want %+v", tc.name, tc.si, tc.iec, units, tc.want)
+		}
+	}
+}
@@ -0,0 +1,404 @@
+package main
+
+// A bad flag used to show up as a panic, or worse, as nonsense: a
+// negative --readsize wrapped around to an enormous offset, and
+// --readsize 0 divides by zero while planning.  So we check the flags
+// up front, and report everything that's wrong with them at once
+// instead of making people fix them one at a time.
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Check the flags, on their own and against each other, and return a
+// description of each problem.  Nothing here talks to the server.
+func validateFlags() []string {
+	var problems []string
+	bad := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if *readsize <= 0 {
+		bad("--readsize must be positive, not %d", *readsize)
+	} else if mem := physicalMemory(); mem > 0 && *memoryPolicy != "stream" && *drainFlag != "discard" {
+		// Each worker holds a whole read in memory.
+		workers := int64(max(*concurrency, 1))
+		if *readsize > mem/workers {
+			need := *readsize * workers
+			bad("--readsize=%d with --concurrency=%d needs %s of read buffers, but this machine only has %s; use --memory-policy=stream with --max-memory", *readsize, *concurrency, units.bytes(need), units.bytes(mem))
+		}
+	}
+	if !slices.Contains(drainStrategies, *drainFlag) {
+		bad("unknown --drain %q; use %s", *drainFlag, strings.Join(drainStrategies, ", "))
+	} else if *drainFlag == "discard" && (*validateMP4 || *verifyChecksums) {
+		bad("--drain=discard keeps none of the data, so it can't be combined with --validate-mp4 or --verify-checksums")
+	}
+	if *concurrency < 1 {
+		bad("--concurrency must be at least 1, not %d", *concurrency)
+	}
+	if *pattern != "sequential" && *pattern != "same-range" && *pattern != "open-storm" && *pattern != "zip-member" {
+		bad("unknown --pattern %q; use sequential, same-range, open-storm, or zip-member", *pattern)
+	}
+	if *pattern == "same-range" || *pattern == "open-storm" {
+		if *iterations < 1 {
+			bad("--iterations must be at least 1, not %d", *iterations)
+		}
+		if err := checkRange(*rangeOffset, *rangeLength); err != nil {
+			bad("--offset=%d with --length=%d: %v", *rangeOffset, *rangeLength, err)
+		}
+	}
+	for _, f := range []struct {
+		name string
+		v    int64
+	}{{"sweep-bytes", *sweepBytes}, {"max-memory", *maxMemory}, {"max-inflight-bytes", *maxInflight}, {"s3fs-part-size", *s3fsPartSize}, {"interference-large", *interferenceLarge}, {"interference-small", *interferenceSmall}} {
+		if f.v < 0 {
+			bad("--%s can't be negative, not %d", f.name, f.v)
+		}
+	}
+	if *readInterval < 0 {
+		bad("--read-interval can't be negative")
+	}
+	if *jitter < 0 || *jitter > 1 {
+		bad("--jitter must be between 0 and 1, not %g", *jitter)
+	} else if *jitter > 0 && *readInterval <= 0 {
+		bad("--jitter needs --read-interval, since back-to-back reads have no gaps to jitter")
+	}
+	if *maxZeroReads < 0 {
+		bad("--max-zero-reads can't be negative")
+	}
+	if *stallTimeout < 0 {
+		bad("--stall-timeout can't be negative")
+	}
+	if *passes < 1 || (*reportPassDelta && *passes < 2) {
+		bad("--passes must be at least 1, or at least 2 with --report-pass-delta")
+	}
+	if *onChange != "abort" && *onChange != "clamp" {
+		bad("unknown --on-change %q; use abort or clamp", *onChange)
+	}
+	if *dryRunLimit < 0 {
+		bad("--dry-run-limit can't be negative")
+	}
+	if *chunkSize <= 0 {
+		bad("--chunk-size must be positive, not %d", *chunkSize)
+	}
+	if *bisectProbes < 1 {
+		bad("--probes must be at least 1, not %d", *bisectProbes)
+	}
+	if *coalesceMax <= 0 {
+		bad("--coalesce-max must be positive, not %d", *coalesceMax)
+	}
+
+	if usesS3(*mode) || *mode == "filer-grpc" {
+		if *bucket == "" {
+			bad("--bucket can't be empty")
+		}
+	}
+	if usesS3(*mode) && len(targets) == 0 {
+		if err := checkURL("--endpoint", *endpoint); err != nil {
+			bad("%v", err)
+		}
+	}
+	if *pushgatewayURL != "" {
+		if err := checkURL("--pushgateway-url", *pushgatewayURL); err != nil {
+			bad("%v", err)
+		}
+	} else if *pushHistogram || *requirePush {
+		bad("--push-per-read-histogram and --require-push need --pushgateway-url")
+	}
+	if *healthInterval <= 0 {
+		bad("--healthcheck-interval must be positive")
+	}
+	if *webhookURL != "" {
+		if err := checkURL("--webhook-url", *webhookURL); err != nil {
+			bad("%v", err)
+		}
+	}
+	if *hookTimeout <= 0 {
+		bad("--hook-timeout must be positive")
+	}
+	if _, err := parseAlertTemplate(*alertTemplate); err != nil {
+		bad("bad --alert-template: %v", err)
+	}
+	if *sampleCount > 0 {
+		if *pattern != "sequential" || *mode == "fullobject" {
+			bad("--sample only works with --pattern=sequential, and not with --mode=fullobject")
+		}
+		if *sampleStrategy != "uniform" && *sampleStrategy != "stratified" && *sampleStrategy != "random" {
+			bad("unknown --sample-strategy %q; use uniform, stratified, or random", *sampleStrategy)
+		}
+	}
+	if len(explicitRanges) > 0 && (*pattern != "sequential" || *mode == "fullobject" || *sampleCount > 0) {
+		bad("--range only works with --pattern=sequential, and not with --mode=fullobject or --sample")
+	}
+	if *directIO && *mode != "localfs" {
+		bad("--direct-io only works with --mode=localfs")
+	}
+	if *replaySpeed <= 0 {
+		bad("--replay-speed must be positive, not %g", *replaySpeed)
+	}
+	if *maxSlowDumps < 0 {
+		bad("--max-slow-dumps can't be negative, not %d", *maxSlowDumps)
+	}
+	if *noStat {
+		if *filesizeFlag < 0 {
+			bad("--no-stat needs --filesize, since it won't ask the server how big the file is")
+		}
+		if *conditional || *stateFileName != "" || *replayResultFile != "" || *verifyChecksums || *backgroundRate > 0 || *mutateDuring || len(targets) > 0 || len(tenants) > 0 {
+			bad("--no-stat can't be combined with --conditional, --state-file, --replay-result, --verify-checksums, --background-metadata, --mutate-during-run, --target, or --tenant, which all HEAD the object")
+		}
+	}
+	if *maxRPS < 0 || math.IsNaN(*maxRPS) || math.IsInf(*maxRPS, 0) {
+		bad("--max-rps must be a positive number, or 0 for no limit, not %g", *maxRPS)
+	}
+	if *burst < 1 {
+		bad("--burst must be at least 1, not %d", *burst)
+	}
+	if *rotateSize < 0 || *rotateInterval < 0 || *maxRotated < 0 {
+		bad("--rotate-size, --rotate-interval, and --max-rotated-files can't be negative")
+	}
+	if (*rotateSize > 0 || *rotateInterval > 0 || *maxRotated > 0) && *jsonlOut == "" {
+		bad("--rotate-size, --rotate-interval, and --max-rotated-files need --jsonl")
+	}
+	if *maxRotated > 0 && *rotateSize == 0 && *rotateInterval == 0 {
+		bad("--max-rotated-files needs --rotate-size or --rotate-interval")
+	}
+	if *maxRedirects < 0 {
+		bad("--max-redirects can't be negative, not %d", *maxRedirects)
+	}
+	if *linkSpeed < 0 || math.IsNaN(*linkSpeed) || math.IsInf(*linkSpeed, 0) {
+		bad("--link-speed must be a positive number of Mbps, not %g", *linkSpeed)
+	}
+	if *netBaseline && !usesS3(*mode) {
+		bad("--net-baseline needs a --mode that reads through the S3 endpoint")
+	}
+	if *maxRPS > 0 && *mode == "localfs" {
+		bad("--max-rps limits HTTP requests, and --mode=localfs doesn't make any")
+	}
+	if *fetchLogs < 0 {
+		bad("--fetch-server-logs can't be negative, not %d", *fetchLogs)
+	}
+	if *fetchLogs > 0 && (*serverLogURL == "") == (*serverLogCommand == "") {
+		bad("--fetch-server-logs needs exactly one of --server-log-url or --server-log-command")
+	}
+	if *serverLogURL != "" {
+		if err := checkURL("--server-log-url", *serverLogURL); err != nil {
+			bad("%v", err)
+		}
+	}
+	if *profileSlowReads {
+		if *slowReadThreshold <= 0 {
+			bad("--profile-slow-reads needs --slow-read-threshold, to say which reads are slow")
+		}
+		if *maxProfiles < 1 || *profileDuration <= 0 {
+			bad("--max-profiles and --profile-duration must be positive")
+		}
+	}
+	if *reuseHandle {
+		if (*mode != "s3fs" && *mode != "localfs") || *s3fsPartSize > 0 || *noSeek || *checkPosition {
+			bad("--reuse-handle needs --mode=s3fs or localfs, and can't be combined with --s3fs-part-size, --no-seek, or --check-position")
+		}
+		if *handlePoolSize < 0 {
+			bad("--handle-pool can't be negative, not %d", *handlePoolSize)
+		}
+	}
+	if *httpVersion != "" && *httpVersion != "1.1" && *httpVersion != "2" {
+		bad("unknown --http-version %q; use 1.1 or 2", *httpVersion)
+	}
+
+	// Flags that don't work together.  A --replay or --replay-result
+	// has already set --pattern and --concurrency from its schedule.
+	replaying := *replay != "" || *replayResultFile != ""
+	if *replay != "" && *replayResultFile != "" {
+		bad("use --replay or --replay-result, not both")
+	}
+	if *replayHAR != "" && (replaying || *stateFileName != "" || len(explicitRanges) > 0 || *mode == "fullobject") {
+		bad("--replay-har can't be combined with --replay, --replay-result, --state-file, --range, or --mode=fullobject")
+	}
+	if *diagnose && (*jsonlOut != "" || *planOut != "") {
+		bad("--diagnose writes its report to --json; it can't be combined with --jsonl or --plan")
+	}
+	if *conditional && *mode == "s3fs" {
+		bad("--conditional needs --mode=getobject, http, or presigned; s3fs picks its own If-Match on Seek()")
+	}
+	if *mode == "fullobject" && (*compareCov || *pattern != "sequential" || *coalesce >= 0) {
+		bad("--mode=fullobject can't be combined with --compare-coverage, --pattern, or --coalesce")
+	}
+	if *cancelAfter != "" && *mode != "getobject" && *mode != "http" && *mode != "presigned" {
+		bad("--cancel-after only works with --mode=getobject, http, or presigned")
+	}
+	if *verifyChecksums && *mode != "getobject" && *mode != "fullobject" && *mode != "http" {
+		bad("--verify-checksums only works with --mode=getobject, fullobject, or http")
+	}
+	if *checkPosition && *mode != "s3fs" && *mode != "localfs" {
+		bad("--check-position only works with --mode=s3fs or --mode=localfs")
+	}
+	if *noSeek && ((*mode != "s3fs" && *mode != "localfs") || *checkPosition) {
+		bad("--no-seek only works with --mode=s3fs or --mode=localfs, and not with --check-position")
+	}
+	if (*s3fsPartSize > 0 || *s3fsPartSweep != "") && (*mode != "s3fs" || *noSeek || *checkPosition) {
+		bad("--s3fs-part-size and --s3fs-part-size-sweep only work with --mode=s3fs, and not with --no-seek or --check-position")
+	}
+	if *healthObject != "" && !usesS3(*mode) {
+		bad("--healthcheck-object needs an S3 --mode, not localfs or filer-grpc")
+	}
+	if *mode == "localfs" {
+		if *coldCache || *conditional || *compareCov || *coalesce >= 0 || *backgroundRate > 0 || *seekProbe || *stateFileName != "" {
+			bad("--mode=localfs can't be combined with --cold-cache, --conditional, --compare-coverage, --coalesce, --background-metadata, --seek-probe, or --state-file")
+		}
+		if *directIO {
+			if err := checkDirectIO(); err != nil {
+				bad("%v", err)
+			}
+		}
+	}
+	if *mode == "filer-grpc" {
+		if err := checkFilerGRPC(); err != nil {
+			bad("%v", err)
+		}
+		if *filerGRPCAddr == "" {
+			bad("--mode=filer-grpc needs --filer-grpc-addr")
+		}
+		if *coldCache || *conditional || *compareCov || *coalesce >= 0 || *backgroundRate > 0 || *seekProbe || *stateFileName != "" {
+			bad("--mode=filer-grpc can't be combined with --cold-cache, --conditional, --compare-coverage, --coalesce, --background-metadata, --seek-probe, or --state-file")
+		}
+	}
+	if (*sseCKey != "" || *sseCKeyFile != "") && *mode != "getobject" && *mode != "fullobject" {
+		bad("SSE-C needs --mode=getobject or fullobject; s3fs can't pass per-request encryption keys, and the http modes don't use the SDK")
+	}
+	if (*pattern == "same-range" || *pattern == "open-storm") && (*coalesce >= 0 || *mutateDuring) {
+		bad("--pattern=%s can't be combined with --coalesce or --mutate-during-run", *pattern)
+	}
+	if *pattern == "zip-member" && (*mode == "fullobject" || *coalesce >= 0 || *mutateDuring || *compareCov || *concurrencySweep != "" || *targetP90 > 0 || len(targets) > 0 || len(tenants) > 0 || replaying || *passes > 1) {
+		bad("--pattern=zip-member can't be combined with --mode=fullobject, --coalesce, --mutate-during-run, --compare-coverage, --concurrency-sweep, --target-p90, --target, --tenant, --replay, or --passes")
+	}
+	if *resume && (*stateFileName == "" || *refreshState || *pattern != "sequential" || *mode == "fullobject" || *passes > 1 || replaying) {
+		bad("--resume needs --state-file, and only works for sequential runs, without --refresh-state, --passes, --replay, or --mode=fullobject")
+	}
+	if *validateMP4 && *pattern == "zip-member" {
+		bad("--validate-mp4 can't be combined with --pattern=zip-member")
+	}
+	if *concurrencySweep != "" {
+		if *pattern != "sequential" || *mode == "fullobject" || *coalesce >= 0 || *mutateDuring {
+			bad("--concurrency-sweep only works with the sequential pattern, without --coalesce or --mutate-during-run")
+		}
+		if *sweepBytes == 0 && *sweepDuration == 0 {
+			bad("--concurrency-sweep needs --sweep-bytes or --sweep-duration, or it would never finish")
+		}
+	}
+	if *targetP90 > 0 {
+		if *pattern != "sequential" || *mode == "fullobject" || *coalesce >= 0 || *mutateDuring || *concurrencySweep != "" {
+			bad("--target-p90 only works with the sequential pattern, without --coalesce, --mutate-during-run, or --concurrency-sweep")
+		}
+		if *adaptiveWindow <= 0 || *adaptiveMax < 1 {
+			bad("--adaptive-window must be positive and --adaptive-max at least 1")
+		}
+	}
+	if len(targets) > 0 && (!usesS3(*mode) || *coldCache || *conditional || *compareCov || *coalesce >= 0 || *concurrencySweep != "" || *bisect || *seekProbe || *mutateDuring || *backgroundRate > 0) {
+		bad("--target can't be combined with --mode=localfs or filer-grpc, --cold-cache, --conditional, --compare-coverage, --coalesce, --concurrency-sweep, --bisect, --seek-probe, --mutate-during-run, or --background-metadata")
+	}
+	if len(tenants) > 0 {
+		if !usesS3(*mode) || *mode == "fullobject" || len(targets) > 0 || *coldCache || *compareCov || *coalesce >= 0 || *concurrencySweep != "" || *bisect || *seekProbe || *mutateDuring || *targetP90 > 0 {
+			bad("--tenant can't be combined with --mode=localfs, filer-grpc, or fullobject, --target, --cold-cache, --compare-coverage, --coalesce, --concurrency-sweep, --bisect, --seek-probe, --mutate-during-run, or --target-p90")
+		}
+		if *tenantDuration <= 0 {
+			bad("--tenant-duration must be positive")
+		}
+	}
+	if *interference {
+		if !usesS3(*mode) || *mode == "fullobject" || len(targets) > 0 || len(tenants) > 0 || *coldCache || *compareCov || *coalesce >= 0 || *concurrencySweep != "" || *bisect || *seekProbe || *mutateDuring || *targetP90 > 0 {
+			bad("--interference can't be combined with --mode=localfs, filer-grpc, or fullobject, --target, --tenant, --cold-cache, --compare-coverage, --coalesce, --concurrency-sweep, --bisect, --seek-probe, --mutate-during-run, or --target-p90")
+		}
+		if *interferenceWin <= 0 || *interferenceLarge == 0 || *interferenceSmall == 0 || *interferenceView < 1 || *interferenceRate <= 0 {
+			bad("--interference-window, -large, -small, -viewers, and -rate must all be positive")
+		}
+	}
+	if *statConsistency {
+		if !usesS3(*mode) || len(targets) > 0 || len(tenants) > 0 || *interference || *coldCache || *compareCov || *coalesce >= 0 || *concurrencySweep != "" || *bisect || *seekProbe || *mutateDuring || *targetP90 > 0 {
+			bad("--stat-consistency can't be combined with --mode=localfs or filer-grpc, --target, --tenant, --interference, --cold-cache, --compare-coverage, --coalesce, --concurrency-sweep, --bisect, --seek-probe, --mutate-during-run, or --target-p90")
+		}
+		if *statRate <= 0 || *statWorkers < 1 || *statDuration <= 0 {
+			bad("--stat-rate, --stat-concurrency, and --stat-duration must all be positive")
+		}
+	}
+	if *mutateDuring && (!*conditional || !*coldCache) {
+		// We're only willing to overwrite our own copy.
+		bad("--mutate-during-run requires --conditional and --cold-cache")
+	}
+	return problems
+}
+
+// Make sure that the value of flag `name` looks like an HTTP URL.
+func checkURL(name, value string) error {
+	if value == "" {
+		return fmt.Errorf("%s can't be empty", name)
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return fmt.Errorf("%s %q isn't a URL: %v", name, value, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%s %q should look like http://host:port or https://host", name, value)
+	}
+	return nil
+}
+
+// Print the problems found by validateFlags.
+func printFlagProblems(problems []string) {
+	if len(problems) == 1 {
+		fmt.Printf("Invalid flag: %s\n", problems[0])
+		return
+	}
+	fmt.Printf("Invalid flags:\n  %s\n", strings.Join(problems, "\n  "))
+}
+
+// Offsets and sizes are int64 throughout, since that's what the APIs
+// underneath take (Seek, Content-Length, the SDK's Range arithmetic),
+// so a read has to fit under math.MaxInt64, end and all.  A 400 GB
+// object is nowhere near that, but a hand-edited --plan or a corrupt
+// --state-file can be.
+
+// Make sure that `size` bytes at `offset` can be read, without
+// overflowing an int64 anywhere along the way.
+func checkRange(offset, size int64) error {
+	if offset < 0 || size < 0 {
+		return fmt.Errorf("%d bytes at offset %d is negative", size, offset)
+	}
+	if size > math.MaxInt64-offset {
+		return fmt.Errorf("%d bytes at offset %d goes past the largest possible offset, %d", size, offset, int64(math.MaxInt64))
+	}
+	return nil
+}
+
+// Parse an offset or a byte count, which can't be negative.
+func parseOffset(s string) (int64, error) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err == nil && n < 0 {
+		err = fmt.Errorf("%q is negative", s)
+	}
+	return n, err
+}
+
+// Return `offset` as a percentage of `total`, without the overflow of
+// doing it in integers.
+func percentOf(offset, total int64) float64 {
+	return 100 * float64(offset) / float64(max(total, 1))
+}
+
+// Return a*b/c, rounded down, without overflowing in a*b, for scaling
+// an offset to a bucket or a bucket to an offset.  None of them can be
+// negative, and c has to be positive.  If the result doesn't fit, it's
+// math.MaxInt64.
+func mulDiv(a, b, c int64) int64 {
+	hi, lo := bits.Mul64(uint64(a), uint64(b))
+	if hi >= uint64(c) {
+		return math.MaxInt64
+	}
+	q, _ := bits.Div64(hi, lo, uint64(c))
+	return int64(min(q, math.MaxInt64))
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestValidateFlags(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		set  func(t *testing.T)
+		want []string // a substring of each problem, in order
+	}{
+		{"defaults", func(t *testing.T) {}, nil},
+		{"zero readsize", func(t *testing.T) { setFlag(t, readsize, 0) }, []string{"--readsize must be positive, not 0"}},
+		{"negative readsize", func(t *testing.T) { setFlag(t, readsize, -1) }, []string{"--readsize must be positive, not -1"}},
+		{"zero concurrency", func(t *testing.T) { setFlag(t, concurrency, 0) }, []string{"--concurrency must be at least 1"}},
+		{"unknown pattern", func(t *testing.T) { setFlag(t, pattern, "zigzag") }, []string{`unknown --pattern "zigzag"`}},
+		{"unknown drain", func(t *testing.T) { setFlag(t, drainFlag, "sponge") }, []string{`unknown --drain "sponge"`}},
+		{"discard with checksums", func(t *testing.T) {
+			setFlag(t, mode, "getobject")
+			setFlag(t, drainFlag, "discard")
+			setFlag(t, verifyChecksums, true)
+		}, []string{"--drain=discard keeps none of the data"}},
+		{"discard with MP4 validation", func(t *testing.T) {
+			setFlag(t, drainFlag, "discard")
+			setFlag(t, validateMP4, true)
+		}, []string{"--drain=discard keeps none of the data"}},
+		{"jitter without an interval", func(t *testing.T) { setFlag(t, jitter, 0.5) }, []string{"--jitter needs --read-interval"}},
+		{"empty bucket", func(t *testing.T) { setFlag(t, bucket, "") }, []string{"--bucket can't be empty"}},
+		{"empty endpoint", func(t *testing.T) { setFlag(t, endpoint, "") }, []string{"--endpoint can't be empty"}},
+		{"endpoint without a scheme", func(t *testing.T) { setFlag(t, endpoint, "s3.example.com:8333") }, []string{"should look like http://host:port"}},
+		{"bad endpoint", func(t *testing.T) { setFlag(t, endpoint, "http://[::1") }, []string{"isn't a URL"}},
+		{"localfs needs no bucket or endpoint", func(t *testing.T) {
+			setFlag(t, mode, "localfs")
+			setFlag(t, bucket, "")
+			setFlag(t, endpoint, "")
+		}, nil},
+		{"negative byte counts", func(t *testing.T) {
+			setFlag(t, maxMemory, -1)
+			setFlag(t, interferenceSmall, -2)
+		}, []string{"--max-memory can't be negative, not -1", "--interference-small can't be negative, not -2"}},
+		{"same-range past the largest offset", func(t *testing.T) {
+			setFlag(t, pattern, "same-range")
+			setFlag(t, rangeOffset, math.MaxInt64-10)
+			setFlag(t, rangeLength, 11)
+		}, []string{"goes past the largest possible offset"}},
+		{"same-range at a negative offset", func(t *testing.T) {
+			setFlag(t, pattern, "same-range")
+			setFlag(t, rangeOffset, -1)
+		}, []string{"is negative"}},
+		{"conditional with s3fs", func(t *testing.T) {
+			setFlag(t, mode, "s3fs")
+			setFlag(t, conditional, true)
+		}, []string{"--conditional needs --mode=getobject"}},
+		{"both replays", func(t *testing.T) {
+			setFlag(t, replay, "plan.json")
+			setFlag(t, replayResultFile, "result.json")
+		}, []string{"use --replay or --replay-result, not both"}},
+		{"zip-member with a replay", func(t *testing.T) {
+			setFlag(t, pattern, "zip-member")
+			setFlag(t, replay, "plan.json")
+		}, []string{"--pattern=zip-member can't be combined"}},
+		{"a sweep that never ends", func(t *testing.T) {
+			setFlag(t, concurrencySweep, "1,2,4")
+			setFlag(t, sweepDuration, 0)
+		}, []string{"--concurrency-sweep needs --sweep-bytes or --sweep-duration"}},
+		{"localfs with S3-only flags", func(t *testing.T) {
+			setFlag(t, mode, "localfs")
+			setFlag(t, coldCache, true)
+			setFlag(t, healthObject, "health.txt")
+		}, []string{"--healthcheck-object needs an S3 --mode", "--mode=localfs can't be combined"}},
+		{"diagnose with a log", func(t *testing.T) {
+			setFlag(t, diagnose, true)
+			setFlag(t, jsonlOut, "run.jsonl")
+		}, []string{"--diagnose writes its report to --json"}},
+		{"mutating without a private copy", func(t *testing.T) {
+			setFlag(t, mode, "getobject")
+			setFlag(t, mutateDuring, true)
+		}, []string{"--mutate-during-run requires --conditional and --cold-cache"}},
+		{"every problem at once", func(t *testing.T) {
+			setFlag(t, readsize, 0)
+			setFlag(t, concurrency, 0)
+			setFlag(t, bucket, "")
+			setFlag(t, endpoint, "")
+			setFlag(t, cancelAfter, "50%")
+			setFlag(t, tenantDuration, 0)
+			setFlag(t, &tenants, tenantList{{Name: "a"}})
+		}, []string{"--readsize", "--concurrency", "--bucket", "--endpoint", "--cancel-after", "--tenant-duration"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.set(t)
+			got := validateFlags()
+			if len(got) != len(tc.want) {
+				t.Fatalf("got %d problems, want %d: %q", len(got), len(tc.want), got)
+			}
+			for i, want := range tc.want {
+				if !strings.Contains(got[i], want) {
+					t.Errorf("problem %d = %q, want %q", i, got[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	for value, ok := range map[string]bool{
+		"http://127.0.0.1:8333":  true,
+		"https://s3.example.com": true,
+		"":                       false,
+		"ftp://s3.example.com":   false,
+		"http://":                false,
+		"s3.example.com":         false,
+	} {
+		if err := checkURL("--endpoint", value); (err == nil) != ok {
+			t.Errorf("checkURL(%q) = %v", value, err)
+		}
+	}
+}
+
+func TestCheckRange(t *testing.T) {
+	for _, tc := range []struct {
+		offset, size int64
+		ok           bool
+	}{
+		{0, 0, true},
+		{0, math.MaxInt64, true},
+		{math.MaxInt64, 0, true},
+		{math.MaxInt64 - 1, 1, true},
+		{math.MaxInt64 - 1, 2, false},
+		{math.MaxInt64, 1, false},
+		{5 << 40, 1 << 20, true},
+		{-1, 1, false},
+		{0, -1, false},
+	} {
+		if err := checkRange(tc.offset, tc.size); (err == nil) != tc.ok {
+			t.Errorf("checkRange(%d, %d) = %v", tc.offset, tc.size, err)
+		}
+	}
+}
+
+func TestPercentOf(t *testing.T) {
+	for _, tc := range []struct {
+		offset, total int64
+		want          float64
+	}{
+		{0, 0, 0},
+		{0, 100, 0},
+		{50, 100, 50},
+		{5 << 39, 5 << 40, 50},
+		// 100*offset would overflow in integers.
+		{math.MaxInt64 / 2, math.MaxInt64, 50},
+		{math.MaxInt64, math.MaxInt64, 100},
+	} {
+		if got := percentOf(tc.offset, tc.total); math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("percentOf(%d, %d) = %g, want %g", tc.offset, tc.total, got, tc.want)
+		}
+	}
+}
+
+func TestMulDiv(t *testing.T) {
+	for _, tc := range []struct {
+		a, b, c, want int64
+	}{
+		{0, 10, 7, 0},
+		{3, 10, 7, 4},
+		{5 << 40, 10, 5 << 40, 10},
+		{math.MaxInt64 - 1, 10, math.MaxInt64, 9},
+		{9, math.MaxInt64, 10, 8301034833169298226},
+		{math.MaxInt64, math.MaxInt64, math.MaxInt64, math.MaxInt64},
+		{math.MaxInt64, 2, 1, math.MaxInt64}, // doesn't fit
+	} {
+		if got := mulDiv(tc.a, tc.b, tc.c); got != tc.want {
+			t.Errorf("mulDiv(%d, %d, %d) = %d, want %d", tc.a, tc.b, tc.c, got, tc.want)
+		}
+	}
+}
+
+func TestParseOffset(t *testing.T) {
+	for s, want := range map[string]int64{"0": 0, "4096": 4096, "9223372036854775807": math.MaxInt64} {
+		if got, err := parseOffset(s); err != nil || got != want {
+			t.Errorf("parseOffset(%q) = %d, %v", s, got, err)
+		}
+	}
+	for _, s := range []string{"", "-1", "9223372036854775808", "1k"} {
+		if _, err := parseOffset(s); err == nil {
+			t.Errorf("parseOffset(%q) worked", s)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+// Caddy sometimes serves single files out of big zip archives in S3,
+// which makes a nasty pattern for SeaweedFS: a read of the tail of the
+// object to find the central directory, maybe a read of the directory
+// itself, then a small, precise read somewhere in the middle.
+// --pattern=zip-member does the same thing to a real zip file: it
+// finds the end of central directory record in the last 64 KiB,
+// reads the central directory if it wasn't in the tail, picks --member
+// (or a random member), reads its local file header, and then reads
+// the member's data in --readsize chunks, timing each step.
+//
+// The later reads depend on what the earlier ones find, so the plan
+// only covers the tail read.  archive/zip would read the directory a
+// few KiB at a time, so we parse just enough of the format ourselves;
+// anything that doesn't parse is reported as a corrupt archive.
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"strings"
+	"time"
+)
+
+const (
+	zipEOCDSignature          = 0x06054b50
+	zip64EOCDLocatorSignature = 0x07064b50
+	zip64EOCDSignature        = 0x06064b50
+	zipCentralSignature       = 0x02014b50
+	zipLocalSignature         = 0x04034b50
+
+	zipEOCDLen         = 22
+	zip64EOCDLocLen    = 20
+	zip64EOCDLen       = 56
+	zipCentralLen      = 46
+	zipLocalLen        = 30
+	zipMaxCommentLen   = 65535
+	zipTailLen         = zipEOCDLen + zipMaxCommentLen
+	zip64ExtraID       = 0x0001
+	zipMaxUint32Marker = 0xffffffff
+)
+
+var errBadZip = errors.New("not a zip file, or a corrupt one")
+
+// zipMember is an entry in the central directory.
+type zipMember struct {
+	Name             string `json:"name"`
+	Method           uint16 `json:"method"`
+	CompressedSize   int64  `json:"compressedSize"`
+	UncompressedSize int64  `json:"uncompressedSize"`
+	HeaderOffset     int64  `json:"headerOffset"`
+	DataOffset       int64  `json:"dataOffset"` // filled in from the local header
+}
+
+// zipStep is one timed read made while finding the member.
+type zipStep struct {
+	Name     string        `json:"name"`
+	Offset   int64         `json:"offset"`
+	Size     int64         `json:"size"`
+	Duration time.Duration `json:"durationNs"`
+}
+
+// zipMemberResult is what --json gets for --pattern=zip-member.
+type zipMemberResult struct {
+	RunInfo
+	Member  zipMember     `json:"member"`
+	Steps   []zipStep     `json:"steps"`
+	Bytes   int64         `json:"bytes"`
+	Elapsed time.Duration `json:"elapsedNs"` // from the first request to the end of the member
+	Latency latencyStats  `json:"latency"`
+	Samples []*Sample     `json:"samples"`
+}
+
+// Return the schedule for --pattern=zip-member: just the tail read,
+// since everything after that depends on what's in it.
+func planZipTail(filesize int64) []scheduledRead {
+	size := min(filesize, zipTailLen)
+	return []scheduledRead{{Label: "zip-tail", Worker: -1, Offset: filesize - size, Size: size}}
+}
+
+// Read `size` bytes at `offset` into memory, timing it as `name`.
+func readZipRange(ctx context.Context, backend Backend, filename string, name string, offset, size int64, steps *[]zipStep) ([]byte, error) {
+	start := time.Now()
+	sample := &Sample{Offset: offset, Size: size, Start: start}
+	f, err := backend.Open(ctx, filename, offset, size, sample)
+	if err != nil {
+		return nil, fmt.Errorf("reading the %s: %w", name, err)
+	}
+	defer f.Close()
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(f, buf); err != nil {
+		return nil, fmt.Errorf("reading the %s: %w", name, err)
+	}
+	d := time.Since(start)
+	*steps = append(*steps, zipStep{Name: name, Offset: offset, Size: size, Duration: d})
+	fmt.Printf("Zip %s: read %d bytes at offset %d in %.3fs\n", name, size, offset, d.Seconds())
+	return buf, nil
+}
+
+// Find the central directory from the tail of the archive, which
+// starts at `tailOffset`.  Returns its offset, size, and entry count.
+func parseZipTail(tail []byte, tailOffset int64) (offset, size, entries int64, err error) {
+	eocd := -1
+	for i := len(tail) - zipEOCDLen; i >= 0; i-- {
+		if binary.LittleEndian.Uint32(tail[i:]) == zipEOCDSignature {
+			eocd = i
+			break
+		}
+	}
+	if eocd < 0 {
+		return 0, 0, 0, fmt.Errorf("%w: no end of central directory record in the last %d bytes", errBadZip, len(tail))
+	}
+	rec := tail[eocd:]
+	entries = int64(binary.LittleEndian.Uint16(rec[10:]))
+	size = int64(binary.LittleEndian.Uint32(rec[12:]))
+	offset = int64(binary.LittleEndian.Uint32(rec[16:]))
+
+	if entries == 0xffff || size == zipMaxUint32Marker || offset == zipMaxUint32Marker {
+		// Zip64: the real values are in another record, which the
+		// locator just before this one points to.
+		loc := eocd - zip64EOCDLocLen
+		if loc < 0 || binary.LittleEndian.Uint32(tail[loc:]) != zip64EOCDLocatorSignature {
+			return 0, 0, 0, fmt.Errorf("%w: zip64 archive without a zip64 end of central directory locator", errBadZip)
+		}
+		recOffset := int64(binary.LittleEndian.Uint64(tail[loc+8:]))
+		if recOffset < tailOffset || recOffset-tailOffset+zip64EOCDLen > int64(len(tail)) {
+			return 0, 0, 0, fmt.Errorf("%w: zip64 end of central directory record at %d isn't in the tail", errBadZip, recOffset)
+		}
+		rec = tail[recOffset-tailOffset:]
+		if binary.LittleEndian.Uint32(rec) != zip64EOCDSignature {
+			return 0, 0, 0, fmt.Errorf("%w: bad zip64 end of central directory record", errBadZip)
+		}
+		// These are unsigned, so anything past math.MaxInt64
+		// comes out negative, and is rejected below.
+		entries = int64(binary.LittleEndian.Uint64(rec[32:]))
+		size = int64(binary.LittleEndian.Uint64(rec[40:]))
+		offset = int64(binary.LittleEndian.Uint64(rec[48:]))
+	}
+	if offset < 0 || size < 0 || offset > tailOffset+int64(eocd) || size > tailOffset+int64(eocd)-offset {
+		return 0, 0, 0, fmt.Errorf("%w: the central directory (%d bytes at %d) runs past its end record", errBadZip, size, offset)
+	}
+	return offset, size, entries, nil
+}
+
+// Parse the central directory's entries.
+func parseZipDirectory(dir []byte, filesize int64) ([]zipMember, error) {
+	var members []zipMember
+	for len(dir) > 0 {
+		if len(dir) < zipCentralLen || binary.LittleEndian.Uint32(dir) != zipCentralSignature {
+			return nil, fmt.Errorf("%w: bad central directory entry after %d members", errBadZip, len(members))
+		}
+		nameLen := int(binary.LittleEndian.Uint16(dir[28:]))
+		extraLen := int(binary.LittleEndian.Uint16(dir[30:]))
+		commentLen := int(binary.LittleEndian.Uint16(dir[32:]))
+		end := zipCentralLen + nameLen + extraLen + commentLen
+		if end > len(dir) {
+			return nil, fmt.Errorf("%w: central directory entry %d runs off the end", errBadZip, len(members))
+		}
+		m := zipMember{
+			Name:             string(dir[zipCentralLen : zipCentralLen+nameLen]),
+			Method:           binary.LittleEndian.Uint16(dir[10:]),
+			CompressedSize:   int64(binary.LittleEndian.Uint32(dir[20:])),
+			UncompressedSize: int64(binary.LittleEndian.Uint32(dir[24:])),
+			HeaderOffset:     int64(binary.LittleEndian.Uint32(dir[42:])),
+		}
+		zip64Extra(&m, dir[zipCentralLen+nameLen:zipCentralLen+nameLen+extraLen])
+		if m.HeaderOffset < 0 || m.CompressedSize < 0 || m.HeaderOffset > filesize || m.CompressedSize > filesize-m.HeaderOffset {
+			return nil, fmt.Errorf("%w: member %q claims %d bytes at %d, past the end of the %d byte file", errBadZip, m.Name, m.CompressedSize, m.HeaderOffset, filesize)
+		}
+		members = append(members, m)
+		dir = dir[end:]
+	}
+	return members, nil
+}
+
+// Fill in the sizes and offset from a zip64 extra field, which only
+// has the ones that didn't fit in 32 bits, in this order.
+func zip64Extra(m *zipMember, extra []byte) {
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra)
+		n := int(binary.LittleEndian.Uint16(extra[2:]))
+		if 4+n > len(extra) {
+			return
+		}
+		field := extra[4 : 4+n]
+		extra = extra[4+n:]
+		if id != zip64ExtraID {
+			continue
+		}
+		for _, v := range []*int64{&m.UncompressedSize, &m.CompressedSize, &m.HeaderOffset} {
+			if *v != zipMaxUint32Marker {
+				continue
+			}
+			if len(field) < 8 {
+				return
+			}
+			*v = int64(binary.LittleEndian.Uint64(field))
+			field = field[8:]
+		}
+	}
+}
+
+// Pick the member named `name`, or a random file if it's empty.
+func pickZipMember(members []zipMember, name string) (zipMember, error) {
+	if name != "" {
+		for _, m := range members {
+			if m.Name == name {
+				return m, nil
+			}
+		}
+		return zipMember{}, fmt.Errorf("the archive has no member %q", name)
+	}
+	var files []zipMember
+	for _, m := range members {
+		if !strings.HasSuffix(m.Name, "/") && m.CompressedSize > 0 {
+			files = append(files, m)
+		}
+	}
+	if len(files) == 0 {
+		return zipMember{}, fmt.Errorf("the archive has no non-empty files")
+	}
+	return files[rand.IntN(len(files))], nil
+}
+
+// Run --pattern=zip-member and print each step.
+func runZipMember(b *benchmark, result *zipMemberResult) error {
+	start := time.Now()
+	tail := planZipTail(b.filesize)[0]
+	buf, err := readZipRange(b.ctx, b.backend, b.filename, "tail", tail.Offset, tail.Size, &result.Steps)
+	if err != nil {
+		return err
+	}
+	dirOffset, dirSize, entries, err := parseZipTail(buf, tail.Offset)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Zip central directory: %d entries, %d bytes at offset %d\n", entries, dirSize, dirOffset)
+
+	var dir []byte
+	if dirOffset >= tail.Offset {
+		fmt.Printf("Zip central directory: already in the tail\n")
+		dir = buf[dirOffset-tail.Offset : dirOffset-tail.Offset+dirSize]
+	} else if dir, err = readZipRange(b.ctx, b.backend, b.filename, "central directory", dirOffset, dirSize, &result.Steps); err != nil {
+		return err
+	}
+	members, err := parseZipDirectory(dir, b.filesize)
+	if err != nil {
+		return err
+	}
+	if int64(len(members)) != entries {
+		fmt.Printf("WARNING: the end record says %d entries, but the central directory has %d\n", entries, len(members))
+	}
+	m, err := pickZipMember(members, *zipMemberName)
+	if err != nil {
+		return err
+	}
+
+	header, err := readZipRange(b.ctx, b.backend, b.filename, "local header", m.HeaderOffset, min(zipLocalLen, b.filesize-m.HeaderOffset), &result.Steps)
+	if err != nil {
+		return err
+	}
+	if len(header) < zipLocalLen || binary.LittleEndian.Uint32(header) != zipLocalSignature {
+		return fmt.Errorf("%w: no local file header for %q at offset %d", errBadZip, m.Name, m.HeaderOffset)
+	}
+	m.DataOffset = m.HeaderOffset + zipLocalLen + int64(binary.LittleEndian.Uint16(header[26:])) + int64(binary.LittleEndian.Uint16(header[28:]))
+	if m.DataOffset > b.filesize || m.CompressedSize > b.filesize-m.DataOffset {
+		return fmt.Errorf("%w: member %q's data runs past the end of the file", errBadZip, m.Name)
+	}
+	result.Member = m
+	fmt.Printf("Zip member %q: %d bytes (%d uncompressed, method %d) at offset %d\n", m.Name, m.CompressedSize, m.UncompressedSize, m.Method, m.DataOffset)
+
+	var reads []scheduledRead
+	size := *readsize
+	for off := int64(0); off < m.CompressedSize; off += size {
+		reads = append(reads, scheduledRead{Label: "zip-member", Worker: -1, Offset: m.DataOffset + off, Size: min(size, m.CompressedSize-off)})
+	}
+	memberStart := time.Now()
+	samples, err := b.execute("zip-member", *concurrency, scheduleSource(reads, *concurrency))
+	if err != nil {
+		return err
+	}
+	result.Elapsed = time.Since(start)
+	d := time.Since(memberStart)
+	result.Steps = append(result.Steps, zipStep{Name: "member", Offset: m.DataOffset, Size: m.CompressedSize, Duration: d})
+	result.Samples = samples
+	result.Bytes = b.totalBytes
+	result.Latency = computeLatencyStats(b.latencies)
+
+	fmt.Printf("Zip member data: %s in %.3fs at %s%s\n", units.bytes(b.totalBytes), d.Seconds(), units.rate(b.totalBytes, d), ceiling.describe(b.totalBytes, d))
+	fmt.Printf("Reads: %s\n", result.Latency)
+	fmt.Printf("Zip total: %.3fs from the first request to the end of the member, %d requests before the data\n", result.Elapsed.Seconds(), len(result.Steps)-1)
+	return nil
+}